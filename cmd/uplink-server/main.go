@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"crypto/rand"
+	"crypto/tls"
 	"encoding/hex"
 	"flag"
 	"fmt"
@@ -10,18 +11,34 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
 	"syscall"
 	"time"
 
 	"gopkg.in/yaml.v2"
 
+	"github.com/librescoot/uplink-server/internal/access"
+	"github.com/librescoot/uplink-server/internal/alerts"
 	"github.com/librescoot/uplink-server/internal/auth"
+	"github.com/librescoot/uplink-server/internal/batch"
+	"github.com/librescoot/uplink-server/internal/certs"
+	"github.com/librescoot/uplink-server/internal/commands"
+	"github.com/librescoot/uplink-server/internal/geofence"
 	"github.com/librescoot/uplink-server/internal/handlers"
+	"github.com/librescoot/uplink-server/internal/lockout"
+	"github.com/librescoot/uplink-server/internal/metrics"
 	"github.com/librescoot/uplink-server/internal/models"
+	"github.com/librescoot/uplink-server/internal/mqtt"
+	"github.com/librescoot/uplink-server/internal/protocol"
 	"github.com/librescoot/uplink-server/internal/registry"
+	"github.com/librescoot/uplink-server/internal/scheduler"
 	"github.com/librescoot/uplink-server/internal/session"
 	"github.com/librescoot/uplink-server/internal/storage"
 	"github.com/librescoot/uplink-server/internal/store"
+	"github.com/librescoot/uplink-server/internal/trips"
+	"github.com/librescoot/uplink-server/internal/webhooks"
 	"github.com/librescoot/uplink-server/internal/webui"
 )
 
@@ -37,6 +54,15 @@ func main() {
 		case "init":
 			initConfigCommand()
 			return
+		case "migrate":
+			migrateCommand()
+			return
+		case "user":
+			userCommand()
+			return
+		case "ca":
+			caCommand()
+			return
 		}
 	}
 
@@ -61,23 +87,74 @@ func main() {
 	if err != nil {
 		log.Fatalf("Failed to load config: %v", err)
 	}
+	if err := config.Server.TLS.Validate(); err != nil {
+		log.Fatalf("Invalid TLS config: %v", err)
+	}
+
+	// Durable persistence: telemetry history, events, command history/queue,
+	// login sessions.
+	db, err := store.Connect(config.Storage.Type, config.Storage.GetPath(), config.Storage.DSN)
+	if err != nil {
+		log.Fatalf("Failed to open persistence store: %v", err)
+	}
+	defer db.Close()
+	applied, err := db.Migrate()
+	for _, m := range applied {
+		log.Printf("[Store] Applied migration %d: %s", m.Version, m.Name)
+	}
+	if err != nil {
+		log.Fatalf("Failed to migrate persistence store: %v", err)
+	}
+	log.Printf("Persistence store: %s", db.Backend())
 
 	// Initialize components
 	authenticator := auth.NewAuthenticator(config)
 	scooterRegistry := registry.New(config, *configPath, authenticator)
-	sessions := session.New(24 * time.Hour)
+	sessions, err := session.New(session.Config{
+		IdleTimeout:     config.Auth.Sessions.GetIdleTimeout(),
+		AbsoluteTimeout: config.Auth.Sessions.GetAbsoluteTimeout(),
+	}, db)
+	if err != nil {
+		log.Fatalf("Failed to load sessions: %v", err)
+	}
+	policy, err := access.New(config.Auth, sessions, authenticator.Groups)
+	if err != nil {
+		log.Fatalf("Invalid auth config: %v", err)
+	}
+	scooterRegistry.SetUsers(policy)
+	if n, err := scooterRegistry.MigratePasswords(); err != nil {
+		log.Printf("Warning: failed to hash plaintext passwords in %s: %v", *configPath, err)
+	} else if n > 0 {
+		log.Printf("Hashed %d plaintext password(s) in %s", n, *configPath)
+	}
+	if n, err := scooterRegistry.MigrateTokens(); err != nil {
+		log.Printf("Warning: failed to hash plaintext scooter tokens in %s: %v", *configPath, err)
+	} else if n > 0 {
+		log.Printf("Hashed %d plaintext scooter token(s) in %s", n, *configPath)
+	}
 	connMgr := storage.NewConnectionManager(config.Server.MaxConnections)
 	responseStore := storage.NewResponseStore(1 * time.Hour)
 	stateStore := storage.NewStateStore("data/state.json")
 	eventStore := storage.NewEventStore(1000, "data/events.jsonl") // Keep last 1000 events per scooter
 
-	// Durable persistence: telemetry history, events, command history/queue.
-	db, err := store.Open("data/uplink.db")
-	if err != nil {
-		log.Fatalf("Failed to open persistence store: %v", err)
+	// Metrics are created before any background writer starts so the store's
+	// write observer is in place first.
+	var promMetrics *metrics.Metrics
+	if config.Metrics.Enabled {
+		promMetrics = metrics.New(connMgr, db, config.Metrics.StatePaths)
+		db.ObserveWrites(promMetrics.ObserveWrite)
 	}
-	defer db.Close()
 	startStoreSweepers(db)
+	startRollupWorker(db)
+
+	retention, err := buildRetention(config.Retention)
+	if err != nil {
+		log.Fatalf("Invalid retention config: %v", err)
+	}
+	pruner := store.NewPruner(db, retention)
+	pruner.Start(config.Retention.GetInterval())
+
+	trips.NewEngine(db, trips.DefaultConfig()).Start(time.Minute)
 
 	// Start stats logger
 	connMgr.StartStatsLogger(config.Logging.GetStatsInterval())
@@ -89,13 +166,119 @@ func main() {
 		responseStore,
 		stateStore,
 		eventStore,
-		db,
+		db.Storage(),
 		config.Server.GetKeepaliveInterval(),
 		config.Server.MessageRateLimit,
 		config.Server.GetIdleTimeout(),
 	)
+	wsHandler.SetCommandPolicy(handlers.CommandPolicy{
+		AckTimeout:     config.Commands.GetAckTimeout(),
+		Retries:        config.Commands.Retries,
+		Idempotent:     handlers.IdempotentSet(config.Commands.Idempotent),
+		DeliveryWindow: config.Commands.GetDeliveryWindow(),
+		RunTimeout:     config.Commands.GetRunTimeout(),
+	})
+	catalog, err := commands.New(catalogCommands(config.Commands.Catalog))
+	if err != nil {
+		log.Fatalf("Invalid command catalog: %v", err)
+	}
+	wsHandler.SetCatalog(catalog)
 
-	apiHandler := handlers.NewAPIHandler(wsHandler, connMgr, responseStore, stateStore, eventStore, db, scooterRegistry, sessions, config.Auth.Users, config.Auth.APIKey)
+	if promMetrics != nil {
+		wsHandler.OnMessage(promMetrics.ObserveMessage)
+		wsHandler.OnTelemetry(promMetrics.ObserveState)
+		wsHandler.OnCommandResult(promMetrics.ObserveCommandResult)
+	}
+
+	apiHandler := handlers.NewAPIHandler(wsHandler, connMgr, responseStore, stateStore, eventStore, db, scooterRegistry, sessions, policy)
+	apiHandler.SetPruner(pruner)
+
+	lockoutCfg := config.Auth.Lockout
+	guard := lockout.New(lockout.Config{
+		MaxFailures:          lockoutCfg.GetMaxFailures(),
+		MaxIPFailures:        lockoutCfg.GetMaxIPFailures(),
+		MaxScooterIPFailures: lockoutCfg.MaxScooterIPFailures,
+		Duration:             lockoutCfg.GetDuration(),
+		MaxDuration:          lockoutCfg.GetMaxDuration(),
+		Window:               lockoutCfg.GetWindow(),
+		TrustProxy:           lockoutCfg.TrustProxy,
+	}, db, authenticator.Exists, wsHandler.RecordEvent)
+	guard.Start()
+	wsHandler.SetLockout(guard)
+	apiHandler.SetLockout(guard)
+
+	fences, err := geofence.NewMonitor(db, wsHandler.RecordEvent)
+	if err != nil {
+		log.Fatalf("Failed to load geofences: %v", err)
+	}
+	fences.SetGroupResolver(authenticator.Groups)
+	wsHandler.OnTelemetry(fences.Check)
+	apiHandler.SetGeofences(fences)
+
+	alertEngine, err := alerts.NewEngine(db, stateStore, connMgr, eventStore, alertRules(config.Alerts.Rules))
+	if err != nil {
+		log.Fatalf("Failed to load alert rules: %v", err)
+	}
+	wsHandler.OnTelemetry(alertEngine.ObserveState)
+	alertEngine.Start(config.Alerts.GetInterval())
+	apiHandler.SetAlerts(alertEngine)
+
+	dispatcher, err := webhooks.NewDispatcher(db, webhookSubscriptions(config.Webhooks))
+	if err != nil {
+		log.Fatalf("Invalid webhook config: %v", err)
+	}
+	wsHandler.OnCommandResult(func(scooterID string, resp *protocol.CommandResponse) {
+		dispatcher.PublishCommandResult(scooterID, resp.RequestID, resp.Status, resp.Result, resp.Error)
+	})
+	dispatcher.Start(stateStore, eventStore, connMgr)
+	apiHandler.SetWebhooks(dispatcher)
+
+	targets := &scheduler.Resolver{
+		Fleet: func() []string {
+			list := authenticator.List()
+			ids := make([]string, len(list))
+			for i, sc := range list {
+				ids[i] = sc.Identifier
+			}
+			return ids
+		},
+		States: stateStore,
+		Groups: authenticator.Groups,
+		Tags:   authenticator.Tags,
+	}
+	apiHandler.SetTargets(targets)
+	jobs := scheduler.New(db, wsHandler, catalog, targets)
+	apiHandler.SetScheduler(jobs)
+
+	batches := batch.New(db, wsHandler, catalog, targets, config.Commands.BatchConcurrency)
+	wsHandler.OnCommandResult(batches.ObserveCommandResult)
+	if err := batches.Start(); err != nil {
+		log.Fatalf("Failed to resume command batches: %v", err)
+	}
+	apiHandler.SetBatches(batches)
+
+	var bridge *mqtt.Bridge
+	if config.MQTT.Broker != "" {
+		principal, err := policy.Grant("mqtt", config.MQTT.Grant)
+		if err != nil {
+			log.Fatalf("Invalid MQTT config: %v", err)
+		}
+		bridge, err = mqtt.New(mqttConfig(config.MQTT, principal), wsHandler)
+		if err != nil {
+			log.Fatalf("Invalid MQTT config: %v", err)
+		}
+		bridge.SetAuditLog(db)
+		wsHandler.OnTelemetry(bridge.ObserveState)
+		wsHandler.OnCommandResult(bridge.CommandResult)
+		if err := bridge.Start(stateStore, eventStore, connMgr); err != nil {
+			log.Fatalf("Failed to connect to MQTT broker: %v", err)
+		}
+		log.Printf("MQTT bridge: %s", config.MQTT.Broker)
+	}
+
+	// All command result hooks are registered; start timing out commands.
+	wsHandler.StartCommandSweeper()
+	jobs.Start()
 
 	// Setup routes
 	if config.Server.EnableWebUI {
@@ -106,7 +289,8 @@ func main() {
 		http.Handle("/", uiHandler) // serves index.html, /css, /js, /images from embedded assets
 
 		// WebSocket for web UI real-time updates
-		webUIHandler := handlers.NewWebUIHandler(stateStore, eventStore, connMgr, authenticator, sessions, config.Auth.APIKey)
+		webUIHandler := handlers.NewWebUIHandler(stateStore, eventStore, connMgr, authenticator, policy)
+		webUIHandler.SetBatches(batches)
 		http.HandleFunc("/ws/web", webUIHandler.HandleWebConnection)
 
 		log.Printf("Web UI enabled at /")
@@ -116,11 +300,51 @@ func main() {
 	http.HandleFunc("/ws", wsHandler.HandleConnection)
 	http.HandleFunc("/api/commands", apiHandler.HandleCommands)
 	http.HandleFunc("/api/commands/", apiHandler.HandleCommandResponse)
+	http.HandleFunc("/api/commands/catalog", apiHandler.HandleCommandCatalog)
 	http.HandleFunc("/api/scooters", apiHandler.HandleScooters)
 	http.HandleFunc("/api/scooters/", apiHandler.HandleScooterDetail)
 	http.HandleFunc("/api/registry", apiHandler.HandleRegistry)
+	http.HandleFunc("/api/registry/", apiHandler.HandleRegistryScooter)
+	http.HandleFunc("/api/config", apiHandler.HandleConfigPush)
 	http.HandleFunc("/api/login", apiHandler.HandleLogin)
 	http.HandleFunc("/api/logout", apiHandler.HandleLogout)
+	http.HandleFunc("/api/me", apiHandler.HandleMe)
+	http.HandleFunc("/api/users", apiHandler.HandleUsers)
+	http.HandleFunc("/api/users/", apiHandler.HandleUser)
+	http.HandleFunc("/api/auth/failures", apiHandler.HandleAuthFailures)
+	http.HandleFunc("/api/auth/lockouts", apiHandler.HandleLockouts)
+	http.HandleFunc("/api/audit", apiHandler.HandleAudit)
+	http.HandleFunc("/api/sessions", apiHandler.HandleSessions)
+	http.HandleFunc("/api/sessions/", apiHandler.HandleSession)
+	http.HandleFunc("/api/trips/", apiHandler.HandleTrip)
+	http.HandleFunc("/api/retention", apiHandler.HandleRetention)
+	http.HandleFunc("/api/retention/prune", apiHandler.HandleRetentionPrune)
+	http.HandleFunc("/api/geofences", apiHandler.HandleGeofences)
+	http.HandleFunc("/api/geofences/", apiHandler.HandleGeofence)
+	http.HandleFunc("/api/alerts", apiHandler.HandleAlerts)
+	http.HandleFunc("/api/alerts/", apiHandler.HandleAlertDetail)
+	http.HandleFunc("/api/webhooks", apiHandler.HandleWebhooks)
+	http.HandleFunc("/api/schedules", apiHandler.HandleSchedules)
+	http.HandleFunc("/api/schedules/", apiHandler.HandleSchedule)
+	http.HandleFunc("/api/batches", apiHandler.HandleBatches)
+	http.HandleFunc("/api/batches/", apiHandler.HandleBatch)
+	if promMetrics != nil {
+		http.Handle("/metrics", promMetrics.Handler(config.Metrics.Token))
+	}
+
+	// TLS applies to every listener; the files are rechecked periodically so
+	// renewed certificates are picked up without a restart.
+	var tlsConfig *tls.Config
+	stopTLS := make(chan struct{})
+	if config.Server.TLS.Enabled() {
+		reloader, err := certs.NewReloader(config.Server.TLS.CertFile, config.Server.TLS.KeyFile, config.Server.TLS.ClientCAFile)
+		if err != nil {
+			log.Fatalf("Failed to load TLS certificate: %v", err)
+		}
+		go reloader.Watch(10*time.Second, stopTLS)
+		tlsConfig = reloader.TLSConfig()
+		log.Printf("TLS enabled (scooter auth: %s)", config.Server.TLS.GetScooterAuth())
+	}
 
 	// Start server
 	wsAddr := fmt.Sprintf(":%d", config.Server.WSPort)
@@ -130,6 +354,9 @@ func main() {
 		log.Printf("  Web UI WebSocket: /ws/web")
 	}
 	log.Printf("  REST API endpoints: /api/commands, /api/scooters")
+	if promMetrics != nil {
+		log.Printf("  Prometheus metrics: /metrics")
+	}
 	log.Printf("Keepalive interval: %s", config.Server.KeepaliveInterval)
 	if config.Server.MaxConnections > 0 {
 		log.Printf("Max connections: %d", config.Server.MaxConnections)
@@ -142,7 +369,28 @@ func main() {
 	}
 	log.Printf("Configured scooters: %d", len(config.Auth.Tokens))
 
-	server := &http.Server{Addr: wsAddr}
+	server := &http.Server{Addr: wsAddr, TLSConfig: tlsConfig}
+	servers := []*http.Server{server}
+
+	// Server-Sent Events stream on its own port, for dashboards behind proxies
+	// that drop WebSocket upgrades.
+	if config.Server.SSEPort > 0 {
+		sseHandler := handlers.NewSSEHandler(stateStore, eventStore, connMgr, authenticator, policy)
+		sseMux := http.NewServeMux()
+		sseMux.HandleFunc("/api/stream", sseHandler.HandleStream)
+		servers = append(servers, serveAux("SSE stream", config.Server.SSEPort, sseMux, tlsConfig))
+	}
+
+	// HTTP long-poll transport for scooters that cannot hold a WebSocket.
+	if config.Server.LPPort > 0 {
+		lpHandler := handlers.NewLongPollHandler(wsHandler, config.Server.GetLPTimeout())
+		lpMux := http.NewServeMux()
+		lpMux.HandleFunc("/lp/auth", lpHandler.HandleAuth)
+		lpMux.HandleFunc("/lp/messages", lpHandler.HandleMessages)
+		lpMux.HandleFunc("/lp/poll", lpHandler.HandlePoll)
+		lpMux.HandleFunc("/lp/close", lpHandler.HandleClose)
+		servers = append(servers, serveAux("Long-poll transport", config.Server.LPPort, lpMux, tlsConfig))
+	}
 
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
@@ -152,32 +400,207 @@ func main() {
 		log.Printf("Received signal %v, shutting down...", sig)
 		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 		defer cancel()
-		if err := server.Shutdown(ctx); err != nil {
-			log.Printf("Shutdown error: %v", err)
+		for _, srv := range servers {
+			if err := srv.Shutdown(ctx); err != nil {
+				log.Printf("Shutdown error: %v", err)
+			}
+		}
+		close(stopTLS)
+		jobs.Stop()
+		batches.Stop()
+		if bridge != nil {
+			bridge.Stop()
 		}
 	}()
 
-	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+	if err := listen(server); err != http.ErrServerClosed {
 		log.Fatalf("Server error: %v", err)
 	}
 	log.Printf("Server stopped")
 }
 
-// telemetryRetention is how long persisted telemetry history is kept.
-const telemetryRetention = 30 * 24 * time.Hour
+// serveAux starts an additional listener on port in the background. The
+// returned server is shut down together with the main one.
+func serveAux(name string, port int, handler http.Handler, tlsConfig *tls.Config) *http.Server {
+	srv := &http.Server{Addr: fmt.Sprintf(":%d", port), Handler: handler, TLSConfig: tlsConfig}
+	go func() {
+		log.Printf("%s listening on %s", name, srv.Addr)
+		if err := listen(srv); err != http.ErrServerClosed {
+			log.Fatalf("%s server error: %v", name, err)
+		}
+	}()
+	return srv
+}
+
+// listen serves srv over TLS if it has a TLS config. The certificate comes
+// from the config, so no files are passed.
+func listen(srv *http.Server) error {
+	if srv.TLSConfig != nil {
+		return srv.ListenAndServeTLS("", "")
+	}
+	return srv.ListenAndServe()
+}
+
+// Default retention when the config leaves a duration empty. Events and
+// command history are kept forever unless configured.
+var defaultRetention = models.RetentionPolicy{
+	Telemetry: "30d",
+	Rollups:   "365d",
+	Events:    "0",
+	Commands:  "0",
+}
+
+// alertRules converts config-file alert rules for the alert engine.
+func alertRules(cfg []models.AlertRuleConfig) []alerts.Rule {
+	rules := make([]alerts.Rule, len(cfg))
+	for i, c := range cfg {
+		rules[i] = alerts.Rule{
+			Name:       c.Name,
+			Type:       c.Type,
+			Condition:  c.Condition,
+			Event:      c.Event,
+			OfflineFor: c.OfflineFor,
+			For:        c.For,
+			ClearFor:   c.ClearFor,
+			Severity:   c.Severity,
+			Scooters:   c.Scooters,
+		}
+	}
+	return rules
+}
+
+// webhookSubscriptions converts config-file webhooks for the dispatcher.
+func webhookSubscriptions(cfg []models.WebhookConfig) []webhooks.Subscription {
+	subs := make([]webhooks.Subscription, len(cfg))
+	for i, c := range cfg {
+		subs[i] = webhooks.Subscription{
+			Name:       c.Name,
+			URL:        c.URL,
+			Secret:     c.Secret,
+			Types:      c.Types,
+			Scooters:   c.Scooters,
+			Events:     c.Events,
+			StatePaths: c.StatePaths,
+		}
+	}
+	return subs
+}
+
+// mqttConfig converts the config-file MQTT settings for the bridge.
+func mqttConfig(c models.MQTTConfig, principal *access.Principal) mqtt.Config {
+	return mqtt.Config{
+		Broker:      c.Broker,
+		ClientID:    c.ClientID,
+		Username:    c.Username,
+		Password:    c.Password,
+		TopicPrefix: c.TopicPrefix,
+		QoS:         byte(c.QoS),
+		ReadOnly:    c.ReadOnly,
+		QueueTTL:    c.GetQueueTTL(),
+		Principal:   principal,
+	}
+}
+
+// catalogCommands converts config-file catalog entries for the command
+// catalog.
+func catalogCommands(cfg []models.CommandSpecConfig) []commands.Command {
+	cmds := make([]commands.Command, 0, len(cfg))
+	for _, c := range cfg {
+		props := make(map[string]*commands.Schema, len(c.Params))
+		var required []string
+		for name, p := range c.Params {
+			props[name] = &commands.Schema{
+				Type:        p.Type,
+				Description: p.Description,
+				Enum:        p.Enum,
+				Minimum:     p.Minimum,
+				Maximum:     p.Maximum,
+				MaxLength:   p.MaxLength,
+				Default:     p.Default,
+			}
+			if p.Required {
+				required = append(required, name)
+			}
+		}
+		sort.Strings(required)
+		cmds = append(cmds, commands.Command{
+			Name:        c.Name,
+			Label:       c.Label,
+			Group:       c.Group,
+			Description: c.Description,
+			Params:      commands.Object(props, required...),
+			Queueable:   c.Queueable,
+			Confirm:     c.Confirm,
+			MinVersion:  c.MinVersion,
+			Role:        c.Role,
+			Variant:     c.Variant,
+		})
+	}
+	return cmds
+}
+
+// buildRetention resolves the retention config into a store policy, merging
+// per-scooter overrides over the defaults.
+func buildRetention(cfg models.RetentionConfig) (store.Retention, error) {
+	fleet := mergeRetentionPolicy(cfg.RetentionPolicy, defaultRetention)
+	base, err := resolveRetentionPolicy(fleet, fleet)
+	if err != nil {
+		return store.Retention{}, err
+	}
+	ret := store.Retention{Default: base, Scooters: make(map[string]store.RetentionPolicy)}
+	for id, override := range cfg.Scooters {
+		p, err := resolveRetentionPolicy(override, fleet)
+		if err != nil {
+			return store.Retention{}, fmt.Errorf("scooter %s: %w", id, err)
+		}
+		ret.Scooters[id] = p
+	}
+	return ret, nil
+}
 
-// startStoreSweepers periodically prunes old telemetry and expires stale queued
-// commands.
+// mergeRetentionPolicy fills empty fields of p from fallback.
+func mergeRetentionPolicy(p, fallback models.RetentionPolicy) models.RetentionPolicy {
+	if p.Telemetry == "" {
+		p.Telemetry = fallback.Telemetry
+	}
+	if p.Rollups == "" {
+		p.Rollups = fallback.Rollups
+	}
+	if p.Events == "" {
+		p.Events = fallback.Events
+	}
+	if p.Commands == "" {
+		p.Commands = fallback.Commands
+	}
+	return p
+}
+
+func resolveRetentionPolicy(p, fallback models.RetentionPolicy) (store.RetentionPolicy, error) {
+	p = mergeRetentionPolicy(p, fallback)
+	var out store.RetentionPolicy
+	var err error
+	if out.Telemetry, err = models.ParseRetention(p.Telemetry); err != nil {
+		return out, fmt.Errorf("retention.telemetry: %w", err)
+	}
+	if out.Rollups, err = models.ParseRetention(p.Rollups); err != nil {
+		return out, fmt.Errorf("retention.rollups: %w", err)
+	}
+	if out.Events, err = models.ParseRetention(p.Events); err != nil {
+		return out, fmt.Errorf("retention.events: %w", err)
+	}
+	if out.Commands, err = models.ParseRetention(p.Commands); err != nil {
+		return out, fmt.Errorf("retention.commands: %w", err)
+	}
+	return out, nil
+}
+
+// startStoreSweepers periodically expires stale queued commands. Age-based
+// pruning is handled by the retention pruner.
 func startStoreSweepers(db *store.Store) {
 	go func() {
 		ticker := time.NewTicker(time.Hour)
 		defer ticker.Stop()
 		for range ticker.C {
-			if n, err := db.PruneTelemetryBefore(time.Now().Add(-telemetryRetention)); err != nil {
-				log.Printf("[Store] Telemetry prune error: %v", err)
-			} else if n > 0 {
-				log.Printf("[Store] Pruned %d old telemetry rows", n)
-			}
 			if n, err := db.ExpireStale(); err != nil {
 				log.Printf("[Store] Command expiry error: %v", err)
 			} else if n > 0 {
@@ -187,6 +610,31 @@ func startStoreSweepers(db *store.Store) {
 	}()
 }
 
+// rollupInterval is how often new telemetry is folded into the rollup tiers.
+const rollupInterval = 30 * time.Second
+
+// startRollupWorker keeps the downsampled telemetry tiers up to date.
+func startRollupWorker(db *store.Store) {
+	const batch = 5000
+	go func() {
+		ticker := time.NewTicker(rollupInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			// Drain the backlog in batches (large after an upgrade).
+			for {
+				n, err := db.RollupTelemetry(time.Now().Add(-store.SettleDelay), batch)
+				if err != nil {
+					log.Printf("[Store] Telemetry rollup error: %v", err)
+					break
+				}
+				if n < batch {
+					break
+				}
+			}
+		}
+	}()
+}
+
 func loadConfig(path string) (*models.Config, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
@@ -253,9 +701,9 @@ func addClientCommand() {
 		os.Exit(1)
 	}
 
-	// Add new client to config
+	// Add new client to config; only the token's hash is stored
 	config.Auth.Tokens[*identifier] = models.ScooterConfig{
-		Token: token,
+		Token: auth.HashToken(token),
 		Name:  *name,
 	}
 
@@ -315,6 +763,314 @@ func initConfigCommand() {
 	fmt.Printf("Add clients from the web UI (top-right +) or: ./uplink-server add-client -identifier <id> -config %s\n", *configPath)
 }
 
+// migrateCommand handles the migrate subcommand:
+//
+//	uplink-server migrate [status|up] [-config config.yml] [-dry-run]
+func migrateCommand() {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	configPath := fs.String("config", "config.yml", "Path to server configuration file")
+	dryRun := fs.Bool("dry-run", false, "Print pending migrations without applying them")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: uplink-server migrate [status|up] [flags]")
+		fs.PrintDefaults()
+	}
+
+	// The action may come before or after the flags.
+	args := os.Args[2:]
+	action := "status"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		action, args = args[0], args[1:]
+	}
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		action = fs.Arg(0)
+	}
+
+	config, err := loadConfig(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+		os.Exit(1)
+	}
+	db, err := store.Connect(config.Storage.Type, config.Storage.GetPath(), config.Storage.DSN)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening store: %v\n", err)
+		os.Exit(1)
+	}
+	defer db.Close()
+
+	switch action {
+	case "status":
+		status, err := db.MigrationStatus()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading migration status: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Backend: %s\n\n", db.Backend())
+		for _, m := range status {
+			applied := "pending"
+			if m.AppliedAt != nil {
+				applied = "applied " + m.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("  %4d  %-40s %s\n", m.Version, m.Name, applied)
+		}
+
+	case "up":
+		pending, err := db.PendingMigrations()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading migration status: %v\n", err)
+			os.Exit(1)
+		}
+		if len(pending) == 0 {
+			fmt.Printf("%s schema is up to date\n", db.Backend())
+			return
+		}
+		if *dryRun {
+			for _, m := range pending {
+				fmt.Printf("-- %d: %s\n%s\n\n", m.Version, m.Name, strings.TrimSpace(m.SQL))
+			}
+			fmt.Printf("%d pending migration(s); dry run, nothing applied\n", len(pending))
+			return
+		}
+		applied, err := db.Migrate()
+		for _, m := range applied {
+			fmt.Printf("✓ Applied %d: %s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+	default:
+		fmt.Fprintf(os.Stderr, "Error: unknown migrate action %q\n", action)
+		fs.Usage()
+		os.Exit(1)
+	}
+}
+
+// userCommand handles the user subcommand, which manages web-UI users in the
+// config file:
+//
+//	uplink-server user list [-config config.yml]
+//	uplink-server user add -name alice [-role viewer] [-scooters ID,…] [-groups G,…] [-password …]
+//	uplink-server user passwd -name alice [-password …]
+//	uplink-server user remove -name alice
+//
+// Without -password a random one is generated and printed. A running server
+// keeps its own copy of the users, so stop it first or use /api/users.
+func userCommand() {
+	fs := flag.NewFlagSet("user", flag.ExitOnError)
+	configPath := fs.String("config", "config.yml", "Path to server configuration file")
+	name := fs.String("name", "", "User name")
+	password := fs.String("password", "", "Password (generated if empty)")
+	role := fs.String("role", "", "Role: viewer (default), operator or admin")
+	scooters := fs.String("scooters", "", "Comma-separated scooter IDs the user is limited to")
+	groups := fs.String("groups", "", "Comma-separated groups the user is limited to")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: uplink-server user [list|add|passwd|remove] [flags]")
+		fs.PrintDefaults()
+	}
+
+	args := os.Args[2:]
+	action := "list"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		action, args = args[0], args[1:]
+	}
+	fs.Parse(args)
+
+	config, err := loadConfig(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+		os.Exit(1)
+	}
+	policy, err := access.New(config.Auth, nil, nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: invalid auth config: %v\n", err)
+		os.Exit(1)
+	}
+	reg := registry.New(config, *configPath, auth.NewAuthenticator(config))
+	reg.SetUsers(policy)
+	if action != "list" {
+		if *name == "" {
+			fmt.Fprintln(os.Stderr, "Error: -name is required")
+			fs.Usage()
+			os.Exit(1)
+		}
+		// The config is about to be rewritten; never write plaintext
+		// passwords or tokens back.
+		if _, err := reg.MigratePasswords(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error hashing passwords: %v\n", err)
+			os.Exit(1)
+		}
+		if _, err := reg.MigrateTokens(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error hashing scooter tokens: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	generated := false
+	if (action == "add" || action == "passwd") && *password == "" {
+		if *password, err = generateSecureToken(12); err != nil {
+			fmt.Fprintf(os.Stderr, "Error generating password: %v\n", err)
+			os.Exit(1)
+		}
+		generated = true
+	}
+
+	switch action {
+	case "list":
+		for _, u := range reg.Users() {
+			scope := "all scooters"
+			if !u.Fleet() {
+				var parts []string
+				if len(u.Scooters) > 0 {
+					parts = append(parts, "scooters "+strings.Join(u.Scooters, ","))
+				}
+				if len(u.Groups) > 0 {
+					parts = append(parts, "groups "+strings.Join(u.Groups, ","))
+				}
+				scope = strings.Join(parts, "; ")
+			}
+			fmt.Printf("  %-20s %-9s %s\n", u.Name, u.Role, scope)
+		}
+		return
+	case "add":
+		_, err = reg.AddUser(*name, *password, models.Grant{Role: *role, Scooters: splitList(*scooters), Groups: splitList(*groups)})
+	case "passwd":
+		_, err = reg.UpdateUser(*name, registry.UserUpdate{Password: password})
+	case "remove":
+		err = reg.RemoveUser(*name)
+	default:
+		fmt.Fprintf(os.Stderr, "Error: unknown user action %q\n", action)
+		fs.Usage()
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	switch action {
+	case "add":
+		fmt.Printf("✓ Added user '%s' to %s\n", *name, *configPath)
+	case "passwd":
+		fmt.Printf("✓ Changed the password of '%s' in %s\n", *name, *configPath)
+	case "remove":
+		fmt.Printf("✓ Removed user '%s' from %s\n", *name, *configPath)
+	}
+	if generated {
+		fmt.Printf("  Password: %s\n", *password)
+	}
+}
+
+// splitList splits a comma-separated flag value, dropping empty entries.
+func splitList(s string) []string {
+	var out []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// caCommand handles the ca subcommand, a minimal CA for scooter client
+// certificates:
+//
+//	uplink-server ca init [-dir ca] [-cn "uplink scooter CA"] [-days 3650]
+//	uplink-server ca issue -identifier ID [-dir ca] [-days 825] [-out .]
+//
+// init writes ca.crt and ca.key; point server.tls.client_ca_file at ca.crt.
+// issue writes ID.crt and ID.key for the scooter.
+func caCommand() {
+	fs := flag.NewFlagSet("ca", flag.ExitOnError)
+	dir := fs.String("dir", "ca", "Directory holding ca.crt and ca.key")
+	cn := fs.String("cn", "uplink scooter CA", "Common name of a new CA")
+	identifier := fs.String("identifier", "", "Scooter identifier to issue a certificate for")
+	days := fs.Int("days", 0, "Validity in days (default 3650 for the CA, 825 for scooters)")
+	out := fs.String("out", ".", "Directory to write the scooter certificate and key to")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: uplink-server ca [init|issue] [flags]")
+		fs.PrintDefaults()
+	}
+
+	args := os.Args[2:]
+	action := ""
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		action, args = args[0], args[1:]
+	}
+	fs.Parse(args)
+
+	caCert := filepath.Join(*dir, "ca.crt")
+	caKey := filepath.Join(*dir, "ca.key")
+	validity := func(defaultDays int) time.Duration {
+		if *days > 0 {
+			return time.Duration(*days) * 24 * time.Hour
+		}
+		return time.Duration(defaultDays) * 24 * time.Hour
+	}
+
+	switch action {
+	case "init":
+		if _, err := os.Stat(caKey); err == nil {
+			fmt.Fprintf(os.Stderr, "Error: %s already exists\n", caKey)
+			os.Exit(1)
+		}
+		_, certPEM, keyPEM, err := certs.NewCA(*cn, validity(3650))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating CA: %v\n", err)
+			os.Exit(1)
+		}
+		if err := writePEMPair(*dir, caCert, caKey, certPEM, keyPEM); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("✓ Created CA '%s' in %s\n", *cn, *dir)
+		fmt.Printf("  Set server.tls.client_ca_file to %s\n", caCert)
+		fmt.Printf("  Keep %s private\n", caKey)
+
+	case "issue":
+		if *identifier == "" {
+			fmt.Fprintln(os.Stderr, "Error: -identifier is required")
+			fs.Usage()
+			os.Exit(1)
+		}
+		ca, err := certs.LoadCA(caCert, caKey)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading CA: %v\n", err)
+			os.Exit(1)
+		}
+		certPEM, keyPEM, err := ca.Issue(*identifier, validity(825))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error issuing certificate: %v\n", err)
+			os.Exit(1)
+		}
+		certFile := filepath.Join(*out, *identifier+".crt")
+		keyFile := filepath.Join(*out, *identifier+".key")
+		if err := writePEMPair(*out, certFile, keyFile, certPEM, keyPEM); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("✓ Issued a certificate for '%s'\n", *identifier)
+		fmt.Printf("  Certificate: %s\n", certFile)
+		fmt.Printf("  Key:         %s\n", keyFile)
+
+	default:
+		fs.Usage()
+		os.Exit(1)
+	}
+}
+
+// writePEMPair writes a certificate and its private key into dir, keeping the
+// key readable by the owner only.
+func writePEMPair(dir, certFile, keyFile string, certPEM, keyPEM []byte) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		return err
+	}
+	return os.WriteFile(certFile, certPEM, 0644)
+}
+
 // generatedCredentials holds the secrets minted for a fresh config.
 type generatedCredentials struct {
 	APIKey   string
@@ -333,6 +1089,10 @@ func createDefaultConfig(path string) (generatedCredentials, error) {
 	if err != nil {
 		return generatedCredentials{}, fmt.Errorf("generate password: %w", err)
 	}
+	hash, err := access.HashPassword(password)
+	if err != nil {
+		return generatedCredentials{}, fmt.Errorf("hash password: %w", err)
+	}
 	const username = "admin"
 
 	config := &models.Config{
@@ -347,10 +1107,13 @@ func createDefaultConfig(path string) (generatedCredentials, error) {
 		Auth: models.AuthConfig{
 			APIKey: apiKey,
 			Tokens: make(map[string]models.ScooterConfig),
-			Users:  map[string]string{username: password},
+			Users: map[string]models.UserConfig{
+				username: {Password: hash, Grant: models.Grant{Role: access.RoleAdmin}},
+			},
 		},
 		Storage: models.StorageConfig{
-			Type: "memory",
+			Type: "sqlite",
+			Path: "data/uplink.db",
 		},
 		Logging: models.LoggingConfig{
 			Level:         "info",
@@ -364,8 +1127,8 @@ func createDefaultConfig(path string) (generatedCredentials, error) {
 	return generatedCredentials{APIKey: apiKey, Username: username, Password: password}, nil
 }
 
-// printCredentials prints the generated secrets once, prominently. They are
-// also stored in the config file, but shown here so they are visible in logs.
+// printCredentials prints the generated secrets once, prominently. The
+// password is only stored hashed, so this is the one chance to note it.
 func printCredentials(path string, creds generatedCredentials) {
 	fmt.Printf("\n================ uplink-server credentials ================\n")
 	fmt.Printf("  Config:   %s\n", path)
@@ -0,0 +1,290 @@
+// Package access decides who may do what. Credentials — the shared API key,
+// named API keys and login sessions — resolve to a Principal with a role
+// (viewer, operator or admin) and an optional scope of scooters and groups.
+// Viewers read, operators also send commands, admins also change the fleet
+// and the server's configuration.
+package access
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+
+	"github.com/librescoot/uplink-server/internal/commands"
+	"github.com/librescoot/uplink-server/internal/models"
+	"github.com/librescoot/uplink-server/internal/session"
+)
+
+// Roles, from least to most privileged. They are the roles of the command
+// catalog.
+const (
+	RoleViewer   = commands.RoleViewer
+	RoleOperator = commands.RoleOperator
+	RoleAdmin    = commands.RoleAdmin
+)
+
+var rank = map[string]int{RoleViewer: 1, RoleOperator: 2, RoleAdmin: 3}
+
+// ValidRole reports whether role is viewer, operator or admin.
+func ValidRole(role string) bool {
+	return rank[role] > 0
+}
+
+// APIKeyName is the principal name of the shared API key.
+const APIKeyName = "api-key"
+
+// GroupResolver returns the groups a scooter belongs to.
+type GroupResolver func(scooterID string) []string
+
+// Principal is an authenticated user or API key.
+type Principal struct {
+	Name     string   `json:"name"`
+	Role     string   `json:"role"`
+	Scooters []string `json:"scooters,omitempty"`
+	Groups   []string `json:"groups,omitempty"`
+
+	groupsOf GroupResolver
+}
+
+// Allows reports whether the principal's role is at least role.
+func (p *Principal) Allows(role string) bool {
+	return rank[p.Role] >= rank[role]
+}
+
+// Fleet reports whether the principal may access every scooter.
+func (p *Principal) Fleet() bool {
+	return len(p.Scooters) == 0 && len(p.Groups) == 0
+}
+
+// CanAccess reports whether the scooter is within the principal's scope.
+func (p *Principal) CanAccess(scooterID string) bool {
+	if p.Fleet() || slices.Contains(p.Scooters, scooterID) {
+		return true
+	}
+	if p.groupsOf == nil || len(p.Groups) == 0 {
+		return false
+	}
+	for _, g := range p.groupsOf(scooterID) {
+		if slices.Contains(p.Groups, g) {
+			return true
+		}
+	}
+	return false
+}
+
+// Equal reports whether two principals are the same caller with the same
+// role and scope.
+func (p *Principal) Equal(o *Principal) bool {
+	return p.Name == o.Name && p.Role == o.Role &&
+		slices.Equal(p.Scooters, o.Scooters) && slices.Equal(p.Groups, o.Groups)
+}
+
+// Policy resolves credentials to principals. Users may change at runtime;
+// API keys are fixed by the config.
+type Policy struct {
+	apiKey   string
+	keys     []apiKey
+	sessions *session.Store // may be nil
+	groupsOf GroupResolver
+
+	mu    sync.RWMutex
+	users map[string]user
+}
+
+type apiKey struct {
+	key       string
+	principal *Principal
+}
+
+type user struct {
+	cfg       models.UserConfig
+	principal *Principal
+}
+
+// New builds a policy from the auth config. groupsOf resolves group scopes
+// and may be nil, in which case group scopes match no scooter.
+func New(cfg models.AuthConfig, sessions *session.Store, groupsOf GroupResolver) (*Policy, error) {
+	p := &Policy{
+		apiKey:   cfg.APIKey,
+		users:    make(map[string]user, len(cfg.Users)),
+		sessions: sessions,
+		groupsOf: groupsOf,
+	}
+	names := make(map[string]bool)
+	for _, k := range cfg.APIKeys {
+		switch {
+		case k.Name == "" || k.Key == "":
+			return nil, fmt.Errorf("api key %q: name and key are required", k.Name)
+		case names[k.Name]:
+			return nil, fmt.Errorf("api key %q: duplicate name", k.Name)
+		case k.Key == cfg.APIKey:
+			return nil, fmt.Errorf("api key %q: same key as auth.api_key", k.Name)
+		}
+		for _, other := range p.keys {
+			if other.key == k.Key {
+				return nil, fmt.Errorf("api key %q: same key as %q", k.Name, other.principal.Name)
+			}
+		}
+		principal, err := p.principal(k.Name, k.Grant)
+		if err != nil {
+			return nil, fmt.Errorf("api key %q: %w", k.Name, err)
+		}
+		names[k.Name] = true
+		p.keys = append(p.keys, apiKey{key: k.Key, principal: principal})
+	}
+	for name, u := range cfg.Users {
+		if err := p.SetUser(name, u); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+func (p *Policy) principal(name string, g models.Grant) (*Principal, error) {
+	role := g.Role
+	if role == "" {
+		role = RoleViewer
+	}
+	if !ValidRole(role) {
+		return nil, fmt.Errorf("role must be %s, %s or %s", RoleViewer, RoleOperator, RoleAdmin)
+	}
+	return &Principal{Name: name, Role: role, Scooters: g.Scooters, Groups: g.Groups, groupsOf: p.groupsOf}, nil
+}
+
+// Authenticate returns the principal for an API key or session token. A
+// session's use renews its idle timeout.
+func (p *Policy) Authenticate(credential string) (*Principal, bool) {
+	return p.authenticate(credential, true)
+}
+
+// Recheck returns the current principal for a credential that already
+// authenticated, without renewing a session. Long-lived streams use it to
+// notice revoked or expired sessions, removed users and changed roles.
+func (p *Policy) Recheck(credential string) (*Principal, bool) {
+	return p.authenticate(credential, false)
+}
+
+func (p *Policy) authenticate(credential string, renew bool) (*Principal, bool) {
+	if credential == "" {
+		return nil, false
+	}
+	if p.apiKey != "" && subtle.ConstantTimeCompare([]byte(credential), []byte(p.apiKey)) == 1 {
+		return &Principal{Name: APIKeyName, Role: RoleAdmin}, true
+	}
+	for _, k := range p.keys {
+		if subtle.ConstantTimeCompare([]byte(credential), []byte(k.key)) == 1 {
+			return k.principal, true
+		}
+	}
+	if p.sessions != nil {
+		if name, ok := p.sessionUser(credential, renew); ok {
+			p.mu.RLock()
+			u, ok := p.users[name]
+			p.mu.RUnlock()
+			if ok {
+				return u.principal, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// sessionUser returns the username of a session token, renewing the session
+// if asked to.
+func (p *Policy) sessionUser(token string, renew bool) (string, bool) {
+	if renew {
+		return p.sessions.Validate(token)
+	}
+	s, ok := p.sessions.Get(token)
+	return s.Username, ok
+}
+
+// Grant returns the principal of a grant given to a server component, such
+// as the MQTT bridge. The role defaults to viewer.
+func (p *Policy) Grant(name string, g models.Grant) (*Principal, error) {
+	return p.principal(name, g)
+}
+
+// User returns the principal of a configured user.
+func (p *Policy) User(name string) (*Principal, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	u, ok := p.users[name]
+	return u.principal, ok
+}
+
+// Users returns the principals of all users, sorted by name.
+func (p *Policy) Users() []*Principal {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	list := make([]*Principal, 0, len(p.users))
+	for _, u := range p.users {
+		list = append(list, u.principal)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// UserConfig returns a user's config, including its password hash.
+func (p *Policy) UserConfig(name string) (models.UserConfig, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	u, ok := p.users[name]
+	return u.cfg, ok
+}
+
+// SetUser adds or replaces a user. The password is stored as given; callers
+// hash it first.
+func (p *Policy) SetUser(name string, cfg models.UserConfig) error {
+	if name == "" {
+		return fmt.Errorf("user name is required")
+	}
+	principal, err := p.principal(name, cfg.Grant)
+	if err != nil {
+		return fmt.Errorf("user %q: %w", name, err)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.users[name] = user{cfg: cfg, principal: principal}
+	return nil
+}
+
+// RemoveUser removes a user. Its sessions stop authenticating.
+func (p *Policy) RemoveUser(name string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, ok := p.users[name]
+	delete(p.users, name)
+	return ok
+}
+
+// Snapshot returns the users' configs, for persisting.
+func (p *Policy) Snapshot() map[string]models.UserConfig {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	out := make(map[string]models.UserConfig, len(p.users))
+	for name, u := range p.users {
+		out[name] = u.cfg
+	}
+	return out
+}
+
+// PasswordLogin reports whether any users are configured.
+func (p *Policy) PasswordLogin() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.sessions != nil && len(p.users) > 0
+}
+
+// CheckPassword validates credentials against the configured users. It
+// always verifies a hash to avoid leaking which usernames exist via timing.
+func (p *Policy) CheckPassword(username, password string) bool {
+	u, ok := p.UserConfig(username)
+	if !ok {
+		VerifyPassword(dummyPasswordHash(), password)
+		return false
+	}
+	return VerifyPassword(u.Password, password)
+}
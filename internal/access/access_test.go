@@ -0,0 +1,188 @@
+package access
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/librescoot/uplink-server/internal/models"
+	"github.com/librescoot/uplink-server/internal/session"
+)
+
+func testGroups(id string) []string {
+	if id == "S2" {
+		return []string{"depot-a"}
+	}
+	return nil
+}
+
+func newTestPolicy(t *testing.T, sessions *session.Store) *Policy {
+	t.Helper()
+	p, err := New(models.AuthConfig{
+		APIKey: "shared",
+		APIKeys: []models.APIKeyConfig{
+			{Name: "dashboard", Key: "dash-key"},
+			{Name: "ops", Key: "ops-key", Grant: models.Grant{Role: RoleOperator, Groups: []string{"depot-a"}}},
+		},
+		Users: map[string]models.UserConfig{
+			"admin":    {Password: "secret", Grant: models.Grant{Role: RoleAdmin}},
+			"customer": {Password: "pw", Grant: models.Grant{Scooters: []string{"S1"}}},
+		},
+	}, sessions, testGroups)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestNewRejectsBadKeys(t *testing.T) {
+	bad := []models.AuthConfig{
+		{APIKeys: []models.APIKeyConfig{{Name: "a"}}},
+		{APIKeys: []models.APIKeyConfig{{Name: "a", Key: "1"}, {Name: "a", Key: "2"}}},
+		{APIKeys: []models.APIKeyConfig{{Name: "a", Key: "1"}, {Name: "b", Key: "1"}}},
+		{APIKey: "1", APIKeys: []models.APIKeyConfig{{Name: "a", Key: "1"}}},
+		{APIKeys: []models.APIKeyConfig{{Name: "a", Key: "1", Grant: models.Grant{Role: "root"}}}},
+		{Users: map[string]models.UserConfig{"u": {Grant: models.Grant{Role: "owner"}}}},
+	}
+	for i, cfg := range bad {
+		if _, err := New(cfg, nil, nil); err == nil {
+			t.Errorf("case %d: expected error", i)
+		}
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	sessions, err := session.New(session.Config{IdleTimeout: time.Hour}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := newTestPolicy(t, sessions)
+
+	if got, ok := p.Authenticate("shared"); !ok || got.Name != APIKeyName || got.Role != RoleAdmin || !got.Fleet() {
+		t.Errorf("shared key = %+v, %t", got, ok)
+	}
+	if got, ok := p.Authenticate("dash-key"); !ok || got.Name != "dashboard" || got.Role != RoleViewer {
+		t.Errorf("named key = %+v, %t", got, ok)
+	}
+	token, _, err := sessions.Create("customer", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := p.Authenticate(token); !ok || got.Name != "customer" {
+		t.Errorf("session = %+v, %t", got, ok)
+	}
+	for _, cred := range []string{"", "wrong", "dash-ke"} {
+		if _, ok := p.Authenticate(cred); ok {
+			t.Errorf("%q authenticated", cred)
+		}
+	}
+
+	// Sessions of users no longer configured are refused.
+	token, _, _ = sessions.Create("gone", "", "")
+	if _, ok := p.Authenticate(token); ok {
+		t.Error("session of unknown user authenticated")
+	}
+}
+
+func TestRecheck(t *testing.T) {
+	sessions, err := session.New(session.Config{IdleTimeout: time.Hour}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := newTestPolicy(t, sessions)
+	token, _, _ := sessions.Create("customer", "", "")
+	was, _ := p.Authenticate(token)
+
+	if got, ok := p.Recheck(token); !ok || !got.Equal(was) {
+		t.Errorf("recheck = %+v, %t", got, ok)
+	}
+	shared, _ := p.Authenticate("shared")
+	if got, _ := p.Recheck("shared"); !got.Equal(shared) {
+		t.Error("shared key changed")
+	}
+
+	// A narrowed scope is a different principal; a revoked session none.
+	p.SetUser("customer", models.UserConfig{Password: "pw", Grant: models.Grant{Scooters: []string{"S2"}}})
+	if got, ok := p.Recheck(token); !ok || got.Equal(was) {
+		t.Errorf("changed user = %+v, %t", got, ok)
+	}
+	sessions.RevokeUser("customer")
+	if _, ok := p.Recheck(token); ok {
+		t.Error("revoked session rechecked")
+	}
+}
+
+func TestScope(t *testing.T) {
+	p := newTestPolicy(t, nil)
+
+	customer, _ := p.User("customer")
+	if customer.Fleet() || !customer.CanAccess("S1") || customer.CanAccess("S2") {
+		t.Errorf("customer scope = %+v", customer)
+	}
+	if customer.Allows(RoleOperator) || !customer.Allows(RoleViewer) {
+		t.Errorf("customer role = %s", customer.Role)
+	}
+
+	ops, _ := p.Authenticate("ops-key")
+	if !ops.CanAccess("S2") || ops.CanAccess("S1") {
+		t.Errorf("group scope = %+v", ops)
+	}
+	if !ops.Allows(RoleOperator) || ops.Allows(RoleAdmin) {
+		t.Errorf("operator role = %s", ops.Role)
+	}
+
+	admin, _ := p.User("admin")
+	if !admin.Fleet() || !admin.CanAccess("anything") || !admin.Allows(RoleAdmin) {
+		t.Errorf("admin = %+v", admin)
+	}
+}
+
+func TestCheckPassword(t *testing.T) {
+	sessions, err := session.New(session.Config{IdleTimeout: time.Hour}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := newTestPolicy(t, sessions)
+
+	if !p.PasswordLogin() {
+		t.Error("password login disabled")
+	}
+	if !p.CheckPassword("admin", "secret") {
+		t.Error("valid password rejected")
+	}
+	if p.CheckPassword("admin", "pw") || p.CheckPassword("nobody", "secret") {
+		t.Error("invalid credentials accepted")
+	}
+}
+
+func TestPasswordHash(t *testing.T) {
+	hash, err := HashPassword("hunter22")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsHash(hash) || !VerifyPassword(hash, "hunter22") || VerifyPassword(hash, "hunter23") {
+		t.Errorf("argon2id hash %q does not verify", hash)
+	}
+	if again, _ := HashPassword("hunter22"); again == hash {
+		t.Error("hashes are not salted")
+	}
+
+	b, err := bcrypt.GenerateFromPassword([]byte("hunter22"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsHash(string(b)) || !VerifyPassword(string(b), "hunter22") || VerifyPassword(string(b), "x") {
+		t.Error("bcrypt hash does not verify")
+	}
+
+	// Plaintext from configs that predate hashing.
+	if IsHash("hunter22") || !VerifyPassword("hunter22", "hunter22") || VerifyPassword("hunter22", "") {
+		t.Error("plaintext comparison")
+	}
+	for _, bad := range []string{"$argon2id$", "$argon2id$v=19$m=1,t=1,p=0$AA$AA", "$argon2id$v=18$m=8,t=1,p=1$AA$AA"} {
+		if VerifyPassword(bad, "") {
+			t.Errorf("malformed hash %q verified", bad)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package alerts
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/librescoot/uplink-server/internal/storage"
+	"github.com/librescoot/uplink-server/internal/store"
+)
+
+func TestCompile(t *testing.T) {
+	good := []Rule{
+		{Name: "low", Type: TypeState, Condition: "battery:0.charge < 15", For: "5m"},
+		{Name: "alarm", Type: TypeState, Condition: `vehicle.state == "alarm"`},
+		{Name: "tamper", Type: TypeEvent, Event: "tamper"},
+		{Name: "gone", Type: TypeOffline, OfflineFor: "2h", Severity: SeverityCritical},
+	}
+	for _, r := range good {
+		if err := r.Compile(); err != nil {
+			t.Errorf("%s: %v", r.Name, err)
+		}
+	}
+	bad := []Rule{
+		{Type: TypeState, Condition: "a.b < 1"},
+		{Name: "x", Type: "threshold"},
+		{Name: "x", Type: TypeState, Condition: "battery:0.charge"},
+		{Name: "x", Type: TypeEvent},
+		{Name: "x", Type: TypeOffline},
+		{Name: "x", Type: TypeEvent, Event: "e", For: "soon"},
+		{Name: "x", Type: TypeEvent, Event: "e", Severity: "page"},
+	}
+	for i, r := range bad {
+		if err := r.Compile(); err == nil {
+			t.Errorf("case %d: expected error", i)
+		}
+	}
+}
+
+func TestEvalState(t *testing.T) {
+	state := map[string]any{
+		"battery:0": map[string]any{"charge": "12"},
+		"vehicle":   map[string]any{"state": "alarm"},
+	}
+	tests := []struct {
+		cond string
+		want bool
+	}{
+		{"battery:0.charge < 15", true},
+		{"battery:0.charge >= 15", false},
+		{"battery:0.charge == 12.0", true},
+		{`vehicle.state == "alarm"`, true},
+		{"vehicle.state != parked", true},
+		{"vehicle.state < parked", false},
+		{"battery:1.charge < 15", false},
+	}
+	for _, tt := range tests {
+		r := Rule{Name: "t", Type: TypeState, Condition: tt.cond}
+		if err := r.Compile(); err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := r.evalState(state); got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.cond, got, tt.want)
+		}
+	}
+}
+
+type fixture struct {
+	db     *store.Store
+	states *storage.StateStore
+	conns  *storage.ConnectionManager
+	events *storage.EventStore
+}
+
+func newFixture(t *testing.T) *fixture {
+	t.Helper()
+	db, err := store.Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return &fixture{db, storage.NewStateStore(""), storage.NewConnectionManager(0), storage.NewEventStore(10, "")}
+}
+
+func (f *fixture) engine(t *testing.T, rules ...Rule) *Engine {
+	t.Helper()
+	e, err := NewEngine(f.db, f.states, f.conns, f.events, rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func (f *fixture) alerts(t *testing.T, status string) []store.Alert {
+	t.Helper()
+	list, err := f.db.ListAlerts(status, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return list
+}
+
+func charge(v string) map[string]any {
+	return map[string]any{"battery:0": map[string]any{"charge": v}}
+}
+
+func TestStateRuleLifecycle(t *testing.T) {
+	f := newFixture(t)
+	e := f.engine(t, Rule{Name: "low", Type: TypeState, Condition: "battery:0.charge < 15", For: "5m", ClearFor: "1m"})
+
+	e.ObserveState("VIN1", charge("12"), time.Now())
+	if n := len(f.alerts(t, "")); n != 0 {
+		t.Fatalf("fired before For elapsed: %d alerts", n)
+	}
+	e.Evaluate(time.Now().Add(6 * time.Minute))
+	firing := f.alerts(t, store.AlertFiring)
+	if len(firing) != 1 || firing[0].Value != "12" || firing[0].Severity != SeverityWarning {
+		t.Fatalf("firing = %+v", firing)
+	}
+
+	// A restarted engine picks up the firing alert instead of duplicating it.
+	e = f.engine(t, Rule{Name: "low", Type: TypeState, Condition: "battery:0.charge < 15", For: "5m", ClearFor: "1m"})
+	e.ObserveState("VIN1", charge("11"), time.Now())
+	e.Evaluate(time.Now().Add(10 * time.Minute))
+	if n := len(f.alerts(t, "")); n != 1 {
+		t.Fatalf("got %d alerts after restart, want 1", n)
+	}
+
+	// Recovery must last ClearFor before the alert resolves.
+	e.ObserveState("VIN1", charge("80"), time.Now())
+	if n := len(f.alerts(t, store.AlertFiring)); n != 1 {
+		t.Fatal("resolved before ClearFor elapsed")
+	}
+	e.Evaluate(time.Now().Add(2 * time.Minute))
+	resolved := f.alerts(t, store.AlertResolved)
+	if len(resolved) != 1 || resolved[0].ResolvedAt == nil {
+		t.Fatalf("resolved = %+v", resolved)
+	}
+}
+
+func TestEventRule(t *testing.T) {
+	f := newFixture(t)
+	e := f.engine(t, Rule{Name: "tamper", Type: TypeEvent, Event: "tamper", Scooters: []string{"VIN1"}})
+
+	now := time.Now()
+	e.ObserveEvent("VIN2", "tamper", now)
+	e.ObserveEvent("VIN1", "boot", now)
+	e.ObserveEvent("VIN1", "tamper", now)
+	e.ObserveEvent("VIN1", "tamper", now.Add(time.Minute))
+	if n := len(f.alerts(t, store.AlertFiring)); n != 1 {
+		t.Fatalf("got %d firing alerts, want 1", n)
+	}
+	e.Evaluate(now.Add(30 * time.Minute))
+	if n := len(f.alerts(t, store.AlertFiring)); n != 1 {
+		t.Fatal("event alert resolved early")
+	}
+	e.Evaluate(now.Add(2 * time.Hour))
+	if n := len(f.alerts(t, store.AlertResolved)); n != 1 {
+		t.Fatal("event alert did not resolve after clear_for")
+	}
+}
+
+func TestOfflineRule(t *testing.T) {
+	f := newFixture(t)
+	f.states.UpdateState("VIN1", map[string]any{})
+	e := f.engine(t, Rule{Name: "gone", Type: TypeOffline, OfflineFor: "2h"})
+
+	now := time.Now()
+	e.Evaluate(now.Add(time.Hour))
+	if n := len(f.alerts(t, "")); n != 0 {
+		t.Fatalf("fired after 1h offline")
+	}
+	e.Evaluate(now.Add(3 * time.Hour))
+	if n := len(f.alerts(t, store.AlertFiring)); n != 1 {
+		t.Fatalf("got %d firing alerts after 3h offline, want 1", n)
+	}
+	e.ObserveConnection("VIN1", true, now.Add(3*time.Hour))
+	if n := len(f.alerts(t, store.AlertResolved)); n != 1 {
+		t.Fatal("offline alert did not resolve on reconnect")
+	}
+}
+
+func TestAPIRules(t *testing.T) {
+	f := newFixture(t)
+	e := f.engine(t, Rule{Name: "cfg", Type: TypeEvent, Event: "x"})
+
+	if err := e.SaveRule(Rule{Name: "cfg", Type: TypeEvent, Event: "y"}); err != ErrConfigRule {
+		t.Errorf("overriding config rule: %v", err)
+	}
+	if err := e.SaveRule(Rule{Name: "alarm", Type: TypeState, Condition: `vehicle.state == "alarm"`, Severity: SeverityCritical}); err != nil {
+		t.Fatal(err)
+	}
+	e.ObserveState("VIN1", map[string]any{"vehicle": map[string]any{"state": "alarm"}}, time.Now())
+	if n := len(f.alerts(t, store.AlertFiring)); n != 1 {
+		t.Fatalf("got %d firing alerts, want 1", n)
+	}
+
+	// Rules persist across restarts; deleting one resolves its alerts.
+	e = f.engine(t, Rule{Name: "cfg", Type: TypeEvent, Event: "x"})
+	if rules := e.Rules(); len(rules) != 2 || rules[1].Source != SourceAPI {
+		t.Fatalf("rules = %+v", rules)
+	}
+	if ok, err := e.DeleteRule("alarm"); !ok || err != nil {
+		t.Fatalf("delete: %v %v", ok, err)
+	}
+	if n := len(f.alerts(t, store.AlertResolved)); n != 1 {
+		t.Error("alert of deleted rule not resolved")
+	}
+	if _, err := e.DeleteRule("cfg"); err != ErrConfigRule {
+		t.Errorf("deleting config rule: %v", err)
+	}
+
+	alert := f.alerts(t, "")[0]
+	acked, ok, err := f.db.AckAlert(alert.ID, "alice", time.Now())
+	if err != nil || !ok || acked.AckedBy != "alice" || acked.AckedAt == nil {
+		t.Errorf("ack = %+v, %v, %v", acked, ok, err)
+	}
+}
@@ -0,0 +1,416 @@
+package alerts
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/librescoot/uplink-server/internal/storage"
+	"github.com/librescoot/uplink-server/internal/store"
+)
+
+// ErrConfigRule is returned when changing a rule defined in the config file.
+var ErrConfigRule = errors.New("rule is defined in the config file")
+
+// key identifies one rule/scooter pair.
+type key struct{ rule, scooter string }
+
+// observation is the last evaluated state condition for a rule/scooter pair.
+type observation struct {
+	active bool
+	value  string
+}
+
+// Engine evaluates alert rules. State rules are evaluated on every telemetry
+// update (ObserveState) and re-checked periodically so For/ClearFor elapse
+// without new telemetry; event and connectivity changes arrive through the
+// store subscriptions started by Start.
+type Engine struct {
+	db     *store.Store
+	states *storage.StateStore
+	conns  *storage.ConnectionManager
+	events *storage.EventStore
+
+	mu           sync.Mutex
+	configRules  []*Rule
+	rules        []*Rule
+	observed     map[key]observation
+	pending      map[key]time.Time // condition true since, not yet firing
+	clearing     map[key]time.Time // condition false since, still firing
+	firing       map[key]*store.Alert
+	lastEvent    map[key]time.Time
+	offlineSince map[string]time.Time
+}
+
+// NewEngine creates an engine with the given config-file rules plus the
+// API-defined rules and firing alerts stored in db.
+func NewEngine(db *store.Store, states *storage.StateStore, conns *storage.ConnectionManager, events *storage.EventStore, configRules []Rule) (*Engine, error) {
+	e := &Engine{
+		db:           db,
+		states:       states,
+		conns:        conns,
+		events:       events,
+		observed:     make(map[key]observation),
+		pending:      make(map[key]time.Time),
+		clearing:     make(map[key]time.Time),
+		firing:       make(map[key]*store.Alert),
+		lastEvent:    make(map[key]time.Time),
+		offlineSince: make(map[string]time.Time),
+	}
+	seen := make(map[string]bool)
+	for i := range configRules {
+		r := configRules[i]
+		r.Source = SourceConfig
+		if err := r.Compile(); err != nil {
+			return nil, fmt.Errorf("alert rule %q: %w", r.Name, err)
+		}
+		if seen[r.Name] {
+			return nil, fmt.Errorf("duplicate alert rule %q", r.Name)
+		}
+		seen[r.Name] = true
+		e.configRules = append(e.configRules, &r)
+	}
+	if err := e.reloadRules(); err != nil {
+		return nil, err
+	}
+
+	firing, err := db.FiringAlerts()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	for i := range firing {
+		a := &firing[i]
+		if e.rule(a.Rule) == nil {
+			if err := db.ResolveAlert(a.ID, now); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		k := key{a.Rule, a.ScooterID}
+		e.firing[k] = a
+		e.lastEvent[k] = a.FiredAt
+	}
+	return e, nil
+}
+
+// Start subscribes to events and connection changes and re-evaluates all
+// rules every interval in the background.
+func (e *Engine) Start(interval time.Duration) {
+	evCh, _ := e.events.Subscribe()
+	connCh, _ := e.conns.Subscribe()
+	go func() {
+		for ev := range evCh {
+			e.ObserveEvent(ev.ScooterID, ev.Event, time.Now())
+		}
+	}()
+	go func() {
+		for ev := range connCh {
+			e.ObserveConnection(ev.Identifier, ev.Type == "online", time.Now())
+		}
+	}()
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			e.Evaluate(time.Now())
+		}
+	}()
+}
+
+// Rules returns all rules, config-file rules first.
+func (e *Engine) Rules() []Rule {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	out := make([]Rule, len(e.rules))
+	for i, r := range e.rules {
+		out[i] = *r
+	}
+	return out
+}
+
+// SaveRule creates or replaces an API-defined rule.
+func (e *Engine) SaveRule(r Rule) error {
+	r.Source = SourceAPI
+	if err := r.Compile(); err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, c := range e.configRules {
+		if c.Name == r.Name {
+			return ErrConfigRule
+		}
+	}
+	def, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if err := e.db.SaveAlertRule(r.Name, string(def)); err != nil {
+		return err
+	}
+	return e.reloadRulesLocked(time.Now())
+}
+
+// DeleteRule removes an API-defined rule and resolves its firing alerts. It
+// returns false if no such rule exists.
+func (e *Engine) DeleteRule(name string) (bool, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, c := range e.configRules {
+		if c.Name == name {
+			return false, ErrConfigRule
+		}
+	}
+	ok, err := e.db.DeleteAlertRule(name)
+	if err != nil || !ok {
+		return ok, err
+	}
+	return true, e.reloadRulesLocked(time.Now())
+}
+
+func (e *Engine) reloadRules() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.reloadRulesLocked(time.Now())
+}
+
+// reloadRulesLocked rebuilds the rule list from config and store, dropping
+// evaluation state and resolving alerts of rules that no longer exist.
+func (e *Engine) reloadRulesLocked(now time.Time) error {
+	records, err := e.db.AlertRules()
+	if err != nil {
+		return err
+	}
+	rules := append([]*Rule(nil), e.configRules...)
+	for _, rec := range records {
+		var r Rule
+		if err := json.Unmarshal([]byte(rec.Definition), &r); err != nil {
+			log.Printf("[Alerts] Skipping unreadable rule %q: %v", rec.Name, err)
+			continue
+		}
+		r.Source = SourceAPI
+		if err := r.Compile(); err != nil {
+			log.Printf("[Alerts] Skipping invalid rule %q: %v", rec.Name, err)
+			continue
+		}
+		rules = append(rules, &r)
+	}
+	e.rules = rules
+
+	for k := range e.observed {
+		if e.rule(k.rule) == nil {
+			delete(e.observed, k)
+		}
+	}
+	for k := range e.pending {
+		if e.rule(k.rule) == nil {
+			delete(e.pending, k)
+		}
+	}
+	for k := range e.clearing {
+		if e.rule(k.rule) == nil {
+			delete(e.clearing, k)
+		}
+	}
+	for k := range e.firing {
+		if e.rule(k.rule) == nil {
+			e.resolve(k, now)
+		}
+	}
+	return nil
+}
+
+// rule looks up a rule by name. Callers hold e.mu (or are constructing e).
+func (e *Engine) rule(name string) *Rule {
+	for _, r := range e.rules {
+		if r.Name == name {
+			return r
+		}
+	}
+	return nil
+}
+
+// ObserveState evaluates state rules against a scooter's merged state. Its
+// signature matches the WebSocket handler's telemetry hook.
+func (e *Engine) ObserveState(scooterID string, state map[string]any, _ time.Time) {
+	now := time.Now()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, r := range e.rules {
+		if r.Type != TypeState || !r.appliesTo(scooterID) {
+			continue
+		}
+		active, value := r.evalState(state)
+		k := key{r.Name, scooterID}
+		e.observed[k] = observation{active, value}
+		e.update(r, k, active, value, now)
+	}
+}
+
+// ObserveEvent fires event rules matching a scooter event.
+func (e *Engine) ObserveEvent(scooterID, event string, now time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, r := range e.rules {
+		if r.Type != TypeEvent || r.Event != event || !r.appliesTo(scooterID) {
+			continue
+		}
+		k := key{r.Name, scooterID}
+		e.lastEvent[k] = now
+		e.update(r, k, true, event, now)
+	}
+}
+
+// ObserveConnection records a scooter going online or offline.
+func (e *Engine) ObserveConnection(scooterID string, online bool, now time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !online {
+		e.offlineSince[scooterID] = now
+		return
+	}
+	delete(e.offlineSince, scooterID)
+	for _, r := range e.rules {
+		if r.Type == TypeOffline && r.appliesTo(scooterID) {
+			e.update(r, key{r.Name, scooterID}, false, "", now)
+		}
+	}
+}
+
+// Evaluate re-checks every rule as of now: pending and clearing durations,
+// event alert expiry and offline durations.
+func (e *Engine) Evaluate(now time.Time) {
+	// Scooters seen before, with when they were last heard from; read before
+	// taking e.mu so the state store's lock is never nested inside ours.
+	lastSeen := make(map[string]time.Time)
+	for id := range e.states.GetAllStates() {
+		if _, connected := e.conns.GetConnection(id); connected {
+			continue
+		}
+		if st, ok := e.states.GetState(id); ok {
+			lastSeen[id] = st.LastUpdated
+		}
+	}
+	connected := make(map[string]bool)
+	for _, c := range e.conns.GetAllConnections() {
+		connected[c.Identifier] = true
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for k, obs := range e.observed {
+		if r := e.rule(k.rule); r != nil {
+			e.update(r, k, obs.active, obs.value, now)
+		}
+	}
+	for k := range e.firing {
+		r := e.rule(k.rule)
+		if r != nil && r.Type == TypeEvent && now.Sub(e.lastEvent[k]) >= r.clearFor {
+			e.resolve(k, now)
+		}
+	}
+
+	offline := make(map[string]time.Time)
+	for id, t := range lastSeen {
+		offline[id] = t
+	}
+	for id, t := range e.offlineSince {
+		if !connected[id] {
+			offline[id] = t
+		}
+	}
+	for _, r := range e.rules {
+		if r.Type != TypeOffline {
+			continue
+		}
+		for id := range connected {
+			if r.appliesTo(id) {
+				e.update(r, key{r.Name, id}, false, "", now)
+			}
+		}
+		for id, since := range offline {
+			if r.appliesTo(id) {
+				d := now.Sub(since)
+				e.update(r, key{r.Name, id}, d >= r.offlineFor, d.Truncate(time.Second).String(), now)
+			}
+		}
+	}
+}
+
+// update advances the lifecycle of one rule/scooter pair.
+func (e *Engine) update(r *Rule, k key, active bool, value string, now time.Time) {
+	if active {
+		delete(e.clearing, k)
+		if _, ok := e.firing[k]; ok {
+			return
+		}
+		since, ok := e.pending[k]
+		if !ok {
+			since = now
+			e.pending[k] = now
+		}
+		if now.Sub(since) >= r.forDur {
+			e.fire(r, k, value, now)
+		}
+		return
+	}
+
+	delete(e.pending, k)
+	if _, ok := e.firing[k]; !ok || r.Type == TypeEvent {
+		return
+	}
+	since, ok := e.clearing[k]
+	if !ok {
+		since = now
+		e.clearing[k] = now
+	}
+	if now.Sub(since) >= r.clearFor {
+		e.resolve(k, now)
+	}
+}
+
+func (e *Engine) fire(r *Rule, k key, value string, now time.Time) {
+	a := &store.Alert{
+		Rule:      r.Name,
+		ScooterID: k.scooter,
+		Severity:  r.Severity,
+		Status:    store.AlertFiring,
+		Message:   r.message(),
+		Value:     value,
+		FiredAt:   now,
+	}
+	if err := e.db.InsertAlert(a); err != nil {
+		log.Printf("[Alerts] Failed to record alert %s for %s: %v", r.Name, k.scooter, err)
+		return
+	}
+	delete(e.pending, k)
+	e.firing[k] = a
+	log.Printf("[Alerts] FIRING %s (%s) for %s: %s", r.Name, r.Severity, k.scooter, a.Message)
+}
+
+func (e *Engine) resolve(k key, now time.Time) {
+	a := e.firing[k]
+	if err := e.db.ResolveAlert(a.ID, now); err != nil {
+		log.Printf("[Alerts] Failed to resolve alert %d: %v", a.ID, err)
+		return
+	}
+	delete(e.firing, k)
+	delete(e.clearing, k)
+	log.Printf("[Alerts] RESOLVED %s for %s", k.rule, k.scooter)
+}
+
+func (r *Rule) message() string {
+	switch r.Type {
+	case TypeState:
+		return r.Condition
+	case TypeEvent:
+		return "event " + r.Event
+	case TypeOffline:
+		return "offline for more than " + r.OfflineFor
+	}
+	return ""
+}
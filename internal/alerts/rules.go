@@ -0,0 +1,216 @@
+// Package alerts evaluates alert rules against scooter state, events and
+// connectivity and keeps a firing/resolved lifecycle in the store.
+package alerts
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/librescoot/uplink-server/internal/storage"
+)
+
+// Rule types.
+const (
+	TypeState   = "state"   // condition on a state path, e.g. battery:0.charge < 15
+	TypeEvent   = "event"   // a named scooter event
+	TypeOffline = "offline" // disconnected for longer than OfflineFor
+)
+
+// Severities.
+const (
+	SeverityInfo     = "info"
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+)
+
+// Rule sources.
+const (
+	SourceConfig = "config"
+	SourceAPI    = "api"
+)
+
+// defaultEventClear is how long an event alert stays firing after the last
+// matching event when the rule sets no clear_for.
+const defaultEventClear = time.Hour
+
+// Rule defines when an alert fires. For and ClearFor provide hysteresis: the
+// condition must hold for For before the alert fires and be false for
+// ClearFor before it resolves. Event alerts fire on each matching event and
+// resolve once no further event arrived for ClearFor (default 1h).
+type Rule struct {
+	Name       string   `json:"name"`
+	Type       string   `json:"type"`
+	Condition  string   `json:"condition,omitempty"`   // state: "<path> <op> <value>"
+	Event      string   `json:"event,omitempty"`       // event: event name
+	OfflineFor string   `json:"offline_for,omitempty"` // offline: e.g. "2h"
+	For        string   `json:"for,omitempty"`
+	ClearFor   string   `json:"clear_for,omitempty"`
+	Severity   string   `json:"severity"`
+	Scooters   []string `json:"scooters,omitempty"` // empty means all scooters
+	Source     string   `json:"source,omitempty"`
+
+	cond       Condition
+	offlineFor time.Duration
+	forDur     time.Duration
+	clearFor   time.Duration
+}
+
+// conditionRe splits "<path> <op> <value>".
+var conditionRe = regexp.MustCompile(`^\s*(\S+?)\s*(==|!=|<=|>=|<|>)\s*(.+?)\s*$`)
+
+// Compile validates r and prepares it for evaluation.
+func (r *Rule) Compile() error {
+	if r.Name == "" {
+		return errors.New("name is required")
+	}
+	switch r.Severity {
+	case "":
+		r.Severity = SeverityWarning
+	case SeverityInfo, SeverityWarning, SeverityCritical:
+	default:
+		return fmt.Errorf("severity must be %s, %s or %s", SeverityInfo, SeverityWarning, SeverityCritical)
+	}
+
+	var err error
+	if r.forDur, err = parseDuration("for", r.For); err != nil {
+		return err
+	}
+	if r.clearFor, err = parseDuration("clear_for", r.ClearFor); err != nil {
+		return err
+	}
+
+	switch r.Type {
+	case TypeState:
+		if r.cond, err = ParseCondition(r.Condition); err != nil {
+			return err
+		}
+	case TypeEvent:
+		if r.Event == "" {
+			return errors.New("event is required for event rules")
+		}
+		if r.ClearFor == "" {
+			r.clearFor = defaultEventClear
+		}
+	case TypeOffline:
+		if r.offlineFor, err = parseDuration("offline_for", r.OfflineFor); err != nil {
+			return err
+		}
+		if r.offlineFor <= 0 {
+			return errors.New("offline_for is required for offline rules")
+		}
+	default:
+		return fmt.Errorf("type must be %s, %s or %s", TypeState, TypeEvent, TypeOffline)
+	}
+	return nil
+}
+
+func parseDuration(field, v string) (time.Duration, error) {
+	if v == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid %s %q", field, v)
+	}
+	return d, nil
+}
+
+// appliesTo reports whether the rule covers the scooter.
+func (r *Rule) appliesTo(scooterID string) bool {
+	if len(r.Scooters) == 0 {
+		return true
+	}
+	for _, id := range r.Scooters {
+		if id == scooterID {
+			return true
+		}
+	}
+	return false
+}
+
+// evalState evaluates a state rule. It returns whether the condition holds
+// and the observed value; a missing path never matches.
+func (r *Rule) evalState(state map[string]any) (bool, string) {
+	v, ok := storage.Lookup(state, r.cond.path)
+	if !ok {
+		return false, ""
+	}
+	return r.cond.Match(v)
+}
+
+// Condition compares the state leaf at a dotted path with a value, as in
+// "battery:0.charge < 15". Other packages use it to select scooters by state.
+type Condition struct {
+	path  string
+	op    string
+	value string
+}
+
+// ParseCondition parses "<path> <op> <value>"; ops are == != < <= > >=.
+func ParseCondition(s string) (Condition, error) {
+	m := conditionRe.FindStringSubmatch(s)
+	if m == nil {
+		return Condition{}, errors.New(`condition must look like "battery:0.charge < 15"`)
+	}
+	c := Condition{path: m[1], op: m[2], value: m[3]}
+	if unquoted, err := strconv.Unquote(c.value); err == nil {
+		c.value = unquoted
+	}
+	return c, nil
+}
+
+// Path returns the dotted state path the condition reads.
+func (c Condition) Path() string {
+	return c.path
+}
+
+// Match applies the condition to a state leaf. It returns whether the
+// condition holds and the observed value as a string.
+func (c Condition) Match(v any) (bool, string) {
+	var observed string
+	switch v := v.(type) {
+	case string:
+		observed = v
+	case float64:
+		observed = strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		observed = strconv.FormatBool(v)
+	default:
+		return false, ""
+	}
+	return compare(observed, c.op, c.value), observed
+}
+
+// compare applies op numerically when both sides are numbers, otherwise as
+// strings (where only == and != match).
+func compare(a, op, b string) bool {
+	x, errA := strconv.ParseFloat(a, 64)
+	y, errB := strconv.ParseFloat(b, 64)
+	if errA == nil && errB == nil {
+		switch op {
+		case "==":
+			return x == y
+		case "!=":
+			return x != y
+		case "<":
+			return x < y
+		case "<=":
+			return x <= y
+		case ">":
+			return x > y
+		case ">=":
+			return x >= y
+		}
+		return false
+	}
+	switch op {
+	case "==":
+		return a == b
+	case "!=":
+		return a != b
+	}
+	return false
+}
@@ -1,16 +1,51 @@
 package auth
 
 import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/hex"
 	"fmt"
+	"slices"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/librescoot/uplink-server/internal/models"
 )
 
+// tokenHashPrefix marks a hashed scooter token. Tokens are long random
+// strings, so a single SHA-256 is enough to keep them out of the config.
+const tokenHashPrefix = "sha256:"
+
+// HashToken returns the hash of a scooter token as stored in the config.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return tokenHashPrefix + hex.EncodeToString(sum[:])
+}
+
+// IsTokenHash reports whether a stored token is hashed.
+func IsTokenHash(s string) bool {
+	return strings.HasPrefix(s, tokenHashPrefix)
+}
+
+// matchToken compares a presented token with a stored one, hashed or (from
+// configs that predate hashing) plaintext, in constant time.
+func matchToken(stored, token string) bool {
+	if stored == "" {
+		return false
+	}
+	if IsTokenHash(stored) {
+		token = HashToken(token)
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(stored)) == 1
+}
+
 // Authenticator handles scooter authentication
 type Authenticator struct {
 	mu     sync.RWMutex
 	tokens map[string]models.ScooterConfig // identifier -> config
+	mode   string                          // server.tls.scooter_auth
 }
 
 // NewAuthenticator creates a new authenticator
@@ -20,7 +55,56 @@ func NewAuthenticator(config *models.Config) *Authenticator {
 	}
 	return &Authenticator{
 		tokens: config.Auth.Tokens,
+		mode:   config.Server.TLS.GetScooterAuth(),
+	}
+}
+
+// CertIdentities returns the scooter identifiers a client certificate
+// names: its CN and DNS SANs.
+func CertIdentities(cert *x509.Certificate) []string {
+	var ids []string
+	if cert.Subject.CommonName != "" {
+		ids = append(ids, cert.Subject.CommonName)
+	}
+	return append(ids, cert.DNSNames...)
+}
+
+// AuthenticateScooter authenticates a connecting scooter by its token and/or
+// its verified client certificate (nil if none), as the scooter auth mode
+// requires, and returns its identifier. A certificate must name the
+// identifier, which may be left empty to take it from the certificate.
+func (a *Authenticator) AuthenticateScooter(identifier, token string, cert *x509.Certificate) (string, error) {
+	if cert != nil {
+		ids := CertIdentities(cert)
+		if identifier == "" && len(ids) > 0 {
+			identifier = ids[0]
+		}
+		if !slices.Contains(ids, identifier) {
+			return "", fmt.Errorf("client certificate does not name identifier: %s", identifier)
+		}
+		if !a.Exists(identifier) {
+			return "", fmt.Errorf("unknown identifier: %s", identifier)
+		}
+	}
+	switch a.mode {
+	case models.ScooterAuthCert:
+		if cert == nil {
+			return "", fmt.Errorf("client certificate required for identifier: %s", identifier)
+		}
+		return identifier, nil
+	case models.ScooterAuthCertOrToken:
+		if cert != nil {
+			return identifier, nil
+		}
+	case models.ScooterAuthCertAndToken:
+		if cert == nil {
+			return "", fmt.Errorf("client certificate required for identifier: %s", identifier)
+		}
 	}
+	if err := a.Authenticate(identifier, token); err != nil {
+		return "", err
+	}
+	return identifier, nil
 }
 
 // Authenticate validates a scooter's credentials
@@ -33,11 +117,13 @@ func (a *Authenticator) Authenticate(identifier, token string) error {
 		return fmt.Errorf("unknown identifier: %s", identifier)
 	}
 
-	if token != scooterConfig.Token {
-		return fmt.Errorf("invalid token for identifier: %s", identifier)
+	if matchToken(scooterConfig.Token, token) {
+		return nil
 	}
-
-	return nil
+	if time.Now().Before(scooterConfig.PreviousTokenExpires) && matchToken(scooterConfig.PreviousToken, token) {
+		return nil
+	}
+	return fmt.Errorf("invalid token for identifier: %s", identifier)
 }
 
 // GetName returns the human-friendly name for a scooter, or empty string if not set
@@ -56,11 +142,88 @@ func (a *Authenticator) AddToken(identifier, token string) {
 	a.Add(identifier, token, "")
 }
 
-// Add registers or updates a scooter's token and name.
+// Add registers or updates a scooter's token and name. Only the token's hash
+// is kept.
 func (a *Authenticator) Add(identifier, token, name string) {
 	a.mu.Lock()
 	defer a.mu.Unlock()
-	a.tokens[identifier] = models.ScooterConfig{Token: token, Name: name}
+	a.tokens[identifier] = models.ScooterConfig{Token: HashToken(token), Name: name}
+}
+
+// Rotate replaces a scooter's token. The current one keeps working until
+// previousUntil (not at all if it is zero), replacing any earlier previous
+// token. It returns the scooter's config before the change, for Restore,
+// and false if the identifier is unknown.
+func (a *Authenticator) Rotate(identifier, token string, previousUntil time.Time) (models.ScooterConfig, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	old, ok := a.tokens[identifier]
+	if !ok {
+		return models.ScooterConfig{}, false
+	}
+	cfg := old
+	cfg.Token = HashToken(token)
+	cfg.PreviousToken, cfg.PreviousTokenExpires = "", time.Time{}
+	if !previousUntil.IsZero() {
+		cfg.PreviousToken, cfg.PreviousTokenExpires = old.Token, previousUntil.UTC()
+		if !IsTokenHash(cfg.PreviousToken) {
+			cfg.PreviousToken = HashToken(cfg.PreviousToken)
+		}
+	}
+	a.tokens[identifier] = cfg
+	return old, true
+}
+
+// Restore puts back a scooter's config as returned by Rotate.
+func (a *Authenticator) Restore(identifier string, cfg models.ScooterConfig) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.tokens[identifier] = cfg
+}
+
+// HashTokens replaces plaintext tokens with their hashes and returns how
+// many there were.
+func (a *Authenticator) HashTokens() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	n := 0
+	for id, cfg := range a.tokens {
+		if cfg.Token == "" || IsTokenHash(cfg.Token) {
+			continue
+		}
+		cfg.Token = HashToken(cfg.Token)
+		a.tokens[id] = cfg
+		n++
+	}
+	return n
+}
+
+// Update replaces a registered scooter's name, groups and tags, keeping its
+// token. It returns false if the identifier is unknown.
+func (a *Authenticator) Update(identifier, name string, groups, tags []string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	cfg, ok := a.tokens[identifier]
+	if !ok {
+		return false
+	}
+	cfg.Name, cfg.Groups, cfg.Tags = name, groups, tags
+	a.tokens[identifier] = cfg
+	return true
+}
+
+// Groups returns the groups a scooter belongs to.
+func (a *Authenticator) Groups(identifier string) []string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.tokens[identifier].Groups
+}
+
+// Tags returns a scooter's tags.
+func (a *Authenticator) Tags(identifier string) []string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.tokens[identifier].Tags
 }
 
 // RemoveToken removes a token
@@ -80,8 +243,10 @@ func (a *Authenticator) Exists(identifier string) bool {
 
 // ScooterInfo is a registered scooter without its secret token.
 type ScooterInfo struct {
-	Identifier string `json:"identifier"`
-	Name       string `json:"name,omitempty"`
+	Identifier string   `json:"identifier"`
+	Name       string   `json:"name,omitempty"`
+	Groups     []string `json:"groups,omitempty"`
+	Tags       []string `json:"tags,omitempty"`
 }
 
 // List returns all registered scooters (without tokens).
@@ -90,17 +255,37 @@ func (a *Authenticator) List() []ScooterInfo {
 	defer a.mu.RUnlock()
 	out := make([]ScooterInfo, 0, len(a.tokens))
 	for id, cfg := range a.tokens {
-		out = append(out, ScooterInfo{Identifier: id, Name: cfg.Name})
+		out = append(out, scooterInfo(id, cfg))
 	}
 	return out
 }
 
-// Snapshot returns a copy of the token map for persistence.
+// Get returns a registered scooter (without its token).
+func (a *Authenticator) Get(identifier string) (ScooterInfo, bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	cfg, ok := a.tokens[identifier]
+	if !ok {
+		return ScooterInfo{}, false
+	}
+	return scooterInfo(identifier, cfg), true
+}
+
+func scooterInfo(identifier string, cfg models.ScooterConfig) ScooterInfo {
+	return ScooterInfo{Identifier: identifier, Name: cfg.Name, Groups: cfg.Groups, Tags: cfg.Tags}
+}
+
+// Snapshot returns a copy of the token map for persistence, without
+// previous tokens whose grace period has ended.
 func (a *Authenticator) Snapshot() map[string]models.ScooterConfig {
 	a.mu.RLock()
 	defer a.mu.RUnlock()
+	now := time.Now()
 	out := make(map[string]models.ScooterConfig, len(a.tokens))
 	for id, cfg := range a.tokens {
+		if !now.Before(cfg.PreviousTokenExpires) {
+			cfg.PreviousToken, cfg.PreviousTokenExpires = "", time.Time{}
+		}
 		out[id] = cfg
 	}
 	return out
@@ -1,8 +1,11 @@
 package auth
 
 import (
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/librescoot/uplink-server/internal/models"
 )
@@ -70,6 +73,129 @@ func TestAddRemoveToken(t *testing.T) {
 	}
 }
 
+func TestUpdate(t *testing.T) {
+	a := newTestAuthenticator()
+
+	if !a.Update("scooter-1", "Renamed", []string{"depot-a"}, []string{"rev3"}) {
+		t.Fatal("expected update of known scooter")
+	}
+	if err := a.Authenticate("scooter-1", "token-1"); err != nil {
+		t.Fatalf("token lost on update: %v", err)
+	}
+	info, ok := a.Get("scooter-1")
+	if !ok || info.Name != "Renamed" || info.Groups[0] != "depot-a" || info.Tags[0] != "rev3" {
+		t.Fatalf("unexpected info %+v", info)
+	}
+	if g := a.Groups("scooter-1"); len(g) != 1 || g[0] != "depot-a" {
+		t.Fatalf("unexpected groups %v", g)
+	}
+	if tags := a.Tags("scooter-2"); tags != nil {
+		t.Fatalf("expected no tags, got %v", tags)
+	}
+
+	if a.Update("nonexistent", "", nil, nil) {
+		t.Fatal("expected update of unknown scooter to fail")
+	}
+}
+
+func TestHashTokens(t *testing.T) {
+	a := newTestAuthenticator()
+	if n := a.HashTokens(); n != 2 {
+		t.Fatalf("hashed %d tokens, want 2", n)
+	}
+	if n := a.HashTokens(); n != 0 {
+		t.Fatalf("hashed %d tokens twice", n)
+	}
+	stored := a.Snapshot()["scooter-1"].Token
+	if stored != HashToken("token-1") || !IsTokenHash(stored) {
+		t.Fatalf("stored token %q", stored)
+	}
+	if err := a.Authenticate("scooter-1", "token-1"); err != nil {
+		t.Fatalf("hashed token rejected: %v", err)
+	}
+	if err := a.Authenticate("scooter-1", stored); err == nil {
+		t.Fatal("hash accepted as token")
+	}
+}
+
+func TestRotate(t *testing.T) {
+	a := newTestAuthenticator()
+
+	old, ok := a.Rotate("scooter-1", "token-new", time.Now().Add(time.Hour))
+	if !ok || old.Token != "token-1" {
+		t.Fatalf("rotate = %+v, %t", old, ok)
+	}
+	for _, tok := range []string{"token-new", "token-1"} {
+		if err := a.Authenticate("scooter-1", tok); err != nil {
+			t.Errorf("%s rejected during grace period: %v", tok, err)
+		}
+	}
+	if cfg := a.Snapshot()["scooter-1"]; cfg.PreviousToken != HashToken("token-1") || cfg.Name != "Test Scooter" {
+		t.Errorf("snapshot = %+v", cfg)
+	}
+
+	// Without a grace period, or once it has passed, the old token is refused.
+	a.Rotate("scooter-2", "token-2b", time.Time{})
+	if err := a.Authenticate("scooter-2", "token-2"); err == nil {
+		t.Error("old token accepted without grace period")
+	}
+	a.Rotate("scooter-1", "token-newer", time.Now().Add(-time.Second))
+	if err := a.Authenticate("scooter-1", "token-new"); err == nil {
+		t.Error("old token accepted after grace period")
+	}
+	if cfg := a.Snapshot()["scooter-1"]; cfg.PreviousToken != "" {
+		t.Errorf("expired previous token persisted: %+v", cfg)
+	}
+
+	a.Restore("scooter-1", old)
+	if err := a.Authenticate("scooter-1", "token-1"); err != nil {
+		t.Errorf("restored token rejected: %v", err)
+	}
+	if _, ok := a.Rotate("nonexistent", "x", time.Time{}); ok {
+		t.Error("rotated unknown scooter")
+	}
+}
+
+func TestAuthenticateScooter(t *testing.T) {
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "scooter-1"}, DNSNames: []string{"scooter-1"}}
+	other := &x509.Certificate{Subject: pkix.Name{CommonName: "scooter-2"}}
+	unknown := &x509.Certificate{Subject: pkix.Name{CommonName: "stranger"}}
+
+	cases := []struct {
+		mode       string
+		identifier string
+		token      string
+		cert       *x509.Certificate
+		ok         bool
+	}{
+		{models.ScooterAuthToken, "scooter-1", "token-1", nil, true},
+		{models.ScooterAuthToken, "scooter-1", "", cert, false},
+		{models.ScooterAuthCert, "scooter-1", "", cert, true},
+		{models.ScooterAuthCert, "", "", cert, true},
+		{models.ScooterAuthCert, "scooter-1", "token-1", nil, false},
+		{models.ScooterAuthCert, "scooter-1", "", other, false},
+		{models.ScooterAuthCert, "", "", unknown, false},
+		{models.ScooterAuthCertOrToken, "scooter-1", "", cert, true},
+		{models.ScooterAuthCertOrToken, "scooter-1", "token-1", nil, true},
+		{models.ScooterAuthCertOrToken, "scooter-1", "wrong", nil, false},
+		{models.ScooterAuthCertAndToken, "scooter-1", "token-1", cert, true},
+		{models.ScooterAuthCertAndToken, "scooter-1", "wrong", cert, false},
+		{models.ScooterAuthCertAndToken, "scooter-1", "token-1", nil, false},
+	}
+	for i, c := range cases {
+		a := newTestAuthenticator()
+		a.mode = c.mode
+		id, err := a.AuthenticateScooter(c.identifier, c.token, c.cert)
+		if ok := err == nil; ok != c.ok {
+			t.Errorf("case %d (%s): err = %v, want ok %t", i, c.mode, err, c.ok)
+			continue
+		}
+		if c.ok && id != "scooter-1" {
+			t.Errorf("case %d: identifier = %q", i, id)
+		}
+	}
+}
+
 func TestAuthenticate_Concurrent(t *testing.T) {
 	a := newTestAuthenticator()
 	var wg sync.WaitGroup
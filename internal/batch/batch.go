@@ -0,0 +1,333 @@
+// Package batch sends one command to many scooters at once: a list of
+// scooters, groups, or every scooter whose state matches a condition. Each
+// batch is persisted with an item per scooter, and its aggregate progress
+// (pending, sent, queued, success, failed, ...) follows the item commands to
+// their outcomes and is published to subscribers as it changes.
+package batch
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"sync"
+	"time"
+
+	"github.com/librescoot/uplink-server/internal/commands"
+	"github.com/librescoot/uplink-server/internal/protocol"
+	"github.com/librescoot/uplink-server/internal/scheduler"
+	"github.com/librescoot/uplink-server/internal/store"
+)
+
+// DefaultConcurrency is how many commands of a batch are dispatched at once
+// unless configured otherwise.
+const DefaultConcurrency = 8
+
+// defaultQueueTTL applies to queued commands of batches without a ttl.
+const defaultQueueTTL = time.Hour
+
+// refreshInterval is how often batches with new command results are
+// recounted. Every recountEvery-th refresh recounts all unfinished batches,
+// which catches changes no result hook reports (queued commands delivered
+// or expired, acks).
+const (
+	refreshInterval = time.Second
+	recountEvery    = 10
+)
+
+// Request describes a batch. Targets are resolved like a schedule's: the
+// listed scooters plus members of the listed groups and carriers of the
+// listed tags, or the whole fleet if All is set, narrowed by condition.
+type Request struct {
+	Command     string         `json:"command"`
+	Params      map[string]any `json:"params,omitempty"`
+	Scooters    []string       `json:"scooters,omitempty"`
+	Groups      []string       `json:"groups,omitempty"`
+	Tags        []string       `json:"tags,omitempty"`
+	All         bool           `json:"all,omitempty"`
+	Condition   string         `json:"condition,omitempty"`
+	Queue       bool           `json:"queue"`       // queue for offline scooters if the command allows it
+	TTL         string         `json:"ttl"`         // lifetime of queued commands
+	Concurrency int            `json:"concurrency"` // dispatches in flight; 0 or more than the server limit means the limit
+}
+
+// Manager creates batches, dispatches their commands and tracks progress.
+type Manager struct {
+	db          *store.Store
+	sender      scheduler.Sender
+	catalog     *commands.Catalog
+	resolver    *scheduler.Resolver
+	concurrency int
+
+	mu          sync.Mutex
+	active      map[int64]*store.Batch // last published progress of unfinished batches
+	dirty       bool                   // a command result arrived since the last refresh
+	subscribers map[int]chan *store.Batch
+	nextSubID   int
+
+	stopCh  chan struct{}
+	stopped sync.Once
+}
+
+// New creates a manager dispatching at most concurrency commands at once per
+// batch (DefaultConcurrency if <= 0). Call Start before creating batches.
+func New(db *store.Store, sender scheduler.Sender, catalog *commands.Catalog, resolver *scheduler.Resolver, concurrency int) *Manager {
+	if concurrency <= 0 {
+		concurrency = DefaultConcurrency
+	}
+	return &Manager{
+		db:          db,
+		sender:      sender,
+		catalog:     catalog,
+		resolver:    resolver,
+		concurrency: concurrency,
+		active:      make(map[int64]*store.Batch),
+		subscribers: make(map[int]chan *store.Batch),
+		stopCh:      make(chan struct{}),
+	}
+}
+
+// Start resumes tracking of unfinished batches and publishes progress until
+// Stop is called. Items a previous run did not get to dispatch are failed.
+func (m *Manager) Start() error {
+	if n, err := m.db.FailPendingBatchItems("interrupted by server restart"); err != nil {
+		return err
+	} else if n > 0 {
+		log.Printf("[Batch] Failed %d undispatched items of interrupted batches", n)
+	}
+	ids, err := m.db.UnfinishedBatches()
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		b, ok, err := m.db.GetBatch(id)
+		if err != nil {
+			return err
+		}
+		if ok {
+			m.active[id] = b
+		}
+	}
+
+	go func() {
+		ticker := time.NewTicker(refreshInterval)
+		defer ticker.Stop()
+		for tick := 1; ; tick++ {
+			select {
+			case <-ticker.C:
+				m.refresh(tick%recountEvery == 0)
+			case <-m.stopCh:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// Stop stops publishing progress. Dispatches in flight run to completion.
+func (m *Manager) Stop() {
+	m.stopped.Do(func() { close(m.stopCh) })
+}
+
+// Check validates a request and resolves its targets.
+func (m *Manager) Check(req *Request) ([]string, error) {
+	if err := m.catalog.Validate(req.Command, req.Params); err != nil {
+		return nil, err
+	}
+	if req.Queue && !m.catalog.Queueable(req.Command) {
+		return nil, fmt.Errorf("%s may not be queued for offline scooters", req.Command)
+	}
+	if req.TTL != "" {
+		if d, err := time.ParseDuration(req.TTL); err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid ttl %q", req.TTL)
+		}
+	}
+	if req.Concurrency < 0 {
+		return nil, errors.New("concurrency must not be negative")
+	}
+	targets, err := m.resolver.Resolve(req.All, req.Scooters, req.Groups, req.Tags, req.Condition)
+	if err != nil {
+		return nil, err
+	}
+	if len(targets) == 0 {
+		return nil, errors.New("no scooters match the targets")
+	}
+	return targets, nil
+}
+
+// Create records a batch for targets (see Check) and dispatches its
+// commands in the background. The returned batch has every item pending.
+func (m *Manager) Create(req *Request, targets []string, createdBy string) (*store.Batch, error) {
+	b := &store.Batch{
+		Command:   req.Command,
+		Params:    req.Params,
+		Scooters:  req.Scooters,
+		Groups:    req.Groups,
+		Tags:      req.Tags,
+		All:       req.All,
+		Condition: req.Condition,
+		Queue:     req.Queue,
+		TTL:       req.TTL,
+		CreatedBy: createdBy,
+	}
+	if err := m.db.CreateBatch(b, targets); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	m.active[b.ID] = b
+	m.mu.Unlock()
+	m.publish(b)
+
+	concurrency := m.concurrency
+	if req.Concurrency > 0 && req.Concurrency < concurrency {
+		concurrency = req.Concurrency
+	}
+	ttl := defaultQueueTTL
+	if d, err := time.ParseDuration(req.TTL); err == nil && d > 0 {
+		ttl = d
+	}
+	log.Printf("[Batch] Batch %d: %s to %d scooters (concurrency %d, queue=%t)", b.ID, b.Command, len(targets), concurrency, b.Queue)
+	go m.dispatch(*b, targets, concurrency, ttl)
+	return b, nil
+}
+
+// dispatch sends b's command to each target, at most concurrency at a time.
+func (m *Manager) dispatch(b store.Batch, targets []string, concurrency int, ttl time.Duration) {
+	ids := make(chan string)
+	var wg sync.WaitGroup
+	for range min(concurrency, len(targets)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := range ids {
+				m.deliver(&b, id, ttl)
+			}
+		}()
+	}
+	for _, id := range targets {
+		ids <- id
+	}
+	close(ids)
+	wg.Wait()
+	m.markDirty()
+	log.Printf("[Batch] Batch %d dispatched", b.ID)
+}
+
+func (m *Manager) deliver(b *store.Batch, scooterID string, ttl time.Duration) {
+	requestID, queued, err := m.sender.DispatchCommand(scooterID, b.Command, b.Params, b.Queue, ttl)
+	status, errMsg := store.StatusSent, ""
+	switch {
+	case err != nil:
+		status, errMsg = store.StatusFailed, err.Error()
+	case queued:
+		status = store.StatusQueued
+	}
+	if err := m.db.SetBatchItem(b.ID, scooterID, requestID, status, errMsg); err != nil {
+		log.Printf("[Batch] Failed to record item %s of batch %d: %v", scooterID, b.ID, err)
+	}
+	m.markDirty()
+
+	// The command is audited as sent by the batch on behalf of its creator.
+	outcome := store.AuditSuccess
+	if errMsg != "" {
+		outcome = store.AuditFailure
+	}
+	params := map[string]any{
+		"command":    b.Command,
+		"params":     b.Params,
+		"queue":      b.Queue,
+		"request_id": requestID,
+		"created_by": b.CreatedBy,
+	}
+	if err := m.db.AuditCommand(fmt.Sprintf("batch:%d", b.ID), scooterID, outcome, params, errMsg); err != nil {
+		log.Printf("[Batch] Failed to audit item %s of batch %d: %v", scooterID, b.ID, err)
+	}
+}
+
+// ObserveCommandResult is a command result hook; it schedules a recount of
+// unfinished batches.
+func (m *Manager) ObserveCommandResult(scooterID string, resp *protocol.CommandResponse) {
+	m.markDirty()
+}
+
+func (m *Manager) markDirty() {
+	m.mu.Lock()
+	m.dirty = true
+	m.mu.Unlock()
+}
+
+// refresh recounts unfinished batches if a result arrived (or all is set)
+// and publishes those whose counts changed. Finished batches are dropped.
+func (m *Manager) refresh(all bool) {
+	m.mu.Lock()
+	if !m.dirty && !all {
+		m.mu.Unlock()
+		return
+	}
+	m.dirty = false
+	ids := make([]int64, 0, len(m.active))
+	for id := range m.active {
+		ids = append(ids, id)
+	}
+	m.mu.Unlock()
+
+	for _, id := range ids {
+		counts, done, err := m.db.BatchCounts(id)
+		if err != nil {
+			log.Printf("[Batch] Failed to count batch %d: %v", id, err)
+			continue
+		}
+		m.mu.Lock()
+		prev, ok := m.active[id]
+		if !ok || maps.Equal(prev.Counts, counts) && prev.Done == done {
+			m.mu.Unlock()
+			continue
+		}
+		b := *prev
+		b.Counts, b.Done = counts, done
+		if done {
+			delete(m.active, id)
+		} else {
+			m.active[id] = &b
+		}
+		m.mu.Unlock()
+
+		m.publish(&b)
+		if done {
+			log.Printf("[Batch] Batch %d finished: %v", id, counts)
+		}
+	}
+}
+
+// Subscribe returns a channel receiving a batch whenever its progress
+// changes, and an ID for Unsubscribe. Slow subscribers miss updates.
+func (m *Manager) Subscribe() (<-chan *store.Batch, int) {
+	ch := make(chan *store.Batch, 16)
+	m.mu.Lock()
+	id := m.nextSubID
+	m.nextSubID++
+	m.subscribers[id] = ch
+	m.mu.Unlock()
+	return ch, id
+}
+
+// Unsubscribe removes a subscriber and closes its channel.
+func (m *Manager) Unsubscribe(id int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if ch, ok := m.subscribers[id]; ok {
+		close(ch)
+		delete(m.subscribers, id)
+	}
+}
+
+func (m *Manager) publish(b *store.Batch) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, ch := range m.subscribers {
+		select {
+		case ch <- b:
+		default:
+		}
+	}
+}
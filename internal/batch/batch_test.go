@@ -0,0 +1,148 @@
+package batch
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/librescoot/uplink-server/internal/commands"
+	"github.com/librescoot/uplink-server/internal/scheduler"
+	"github.com/librescoot/uplink-server/internal/store"
+)
+
+// fakeSender delivers to online scooters and queues for the rest, tracking
+// how many dispatches overlap.
+type fakeSender struct {
+	online map[string]bool
+
+	mu            sync.Mutex
+	inFlight, max int
+	sent          []string
+}
+
+func (f *fakeSender) DispatchCommand(id, command string, params map[string]any, queue bool, ttl time.Duration) (string, bool, error) {
+	f.mu.Lock()
+	f.inFlight++
+	f.max = max(f.max, f.inFlight)
+	f.sent = append(f.sent, id)
+	f.mu.Unlock()
+	time.Sleep(5 * time.Millisecond)
+	f.mu.Lock()
+	f.inFlight--
+	f.mu.Unlock()
+
+	switch {
+	case f.online[id]:
+		return "req-" + id, false, nil
+	case queue:
+		return "queued-" + id, true, nil
+	}
+	return "", false, errors.New("scooter not connected")
+}
+
+func newTestManager(t *testing.T, fleet []string, concurrency int) (*Manager, *store.Store, *fakeSender) {
+	t.Helper()
+	db, err := store.Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	sender := &fakeSender{online: map[string]bool{"A": true}}
+	m := New(db, sender, commands.Default(), &scheduler.Resolver{Fleet: func() []string { return fleet }}, concurrency)
+	return m, db, sender
+}
+
+func TestCheck(t *testing.T) {
+	m, _, _ := newTestManager(t, []string{"A", "B"}, 0)
+
+	bad := []Request{
+		{Command: "lokc", All: true},
+		{Command: "honk", All: true, Params: map[string]any{"duration": "long"}},
+		{Command: "unlock", All: true, Queue: true},
+		{Command: "lock", All: true, TTL: "-1h"},
+		{Command: "lock", All: true, Concurrency: -1},
+		{Command: "lock", All: true, Condition: "vehicle.state ~ parked"},
+		{Command: "lock", All: true, Condition: "vehicle.state == parked"}, // no state known
+		{Command: "lock"}, // no targets
+	}
+	for i, req := range bad {
+		if _, err := m.Check(&req); err == nil {
+			t.Errorf("case %d: expected error", i)
+		}
+	}
+
+	targets, err := m.Check(&Request{Command: "lock", All: true})
+	if err != nil || len(targets) != 2 {
+		t.Errorf("whole fleet = %v, %v", targets, err)
+	}
+}
+
+func TestDispatch(t *testing.T) {
+	fleet := []string{"A", "B", "C", "D", "E", "F", "G", "H"}
+	m, db, sender := newTestManager(t, fleet, 3)
+	if err := m.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop()
+	updates, id := m.Subscribe()
+	defer m.Unsubscribe(id)
+
+	req := &Request{Command: "lock", Queue: true, Scooters: []string{"A", "B"}}
+	targets, err := m.Check(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := m.Create(req, targets, "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := <-updates; got.ID != b.ID || got.Counts[store.BatchPending] != 2 {
+		t.Fatalf("first update = %+v", got)
+	}
+
+	select {
+	case got := <-updates:
+		if got.Counts[store.StatusSent] != 1 || got.Counts[store.StatusQueued] != 1 || got.Done {
+			t.Errorf("progress = %+v", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no progress published")
+	}
+
+	// The whole fleet, at most three at a time; G and H are not queued.
+	req = &Request{Command: "lock", All: true, Concurrency: 10}
+	targets, _ = m.Check(req)
+	b, err = m.Create(req, targets, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got, _, _ := db.GetBatch(b.ID)
+		if got.Counts[store.BatchPending] == 0 {
+			if got.Counts[store.StatusSent] != 1 || got.Counts[store.StatusFailed] != 7 || got.Done {
+				t.Errorf("final counts = %v", got.Counts)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("batch not dispatched: %+v", got)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	sender.mu.Lock()
+	defer sender.mu.Unlock()
+	if sender.max > 3 {
+		t.Errorf("%d dispatches in flight, limit 3", sender.max)
+	}
+	if len(sender.sent) != 10 {
+		t.Errorf("sent %d commands, want 10", len(sender.sent))
+	}
+	entries, _ := db.QueryAudit(store.AuditFilter{Actor: fmt.Sprintf("batch:%d", b.ID), Outcome: store.AuditFailure})
+	if len(entries) != 7 || entries[0].Action != "command.send" || entries[0].Error == "" {
+		t.Errorf("audit = %+v", entries)
+	}
+}
@@ -0,0 +1,189 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCA creates a CA in dir and returns it with the path of its certificate.
+func writeCA(t *testing.T, dir, name string) (*CA, string) {
+	t.Helper()
+	ca, certPEM, keyPEM, err := NewCA(name, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile := filepath.Join(dir, name+".crt")
+	keyFile := filepath.Join(dir, name+".key")
+	if err := os.WriteFile(certFile, certPEM, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadCA(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.Cert.Equal(ca.Cert) {
+		t.Fatal("loaded CA differs")
+	}
+	return loaded, certFile
+}
+
+func writePair(t *testing.T, dir, name string, certPEM, keyPEM []byte) (string, string) {
+	t.Helper()
+	certFile := filepath.Join(dir, name+".crt")
+	keyFile := filepath.Join(dir, name+".key")
+	if err := os.WriteFile(certFile, certPEM, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestIssue(t *testing.T) {
+	ca, _ := writeCA(t, t.TempDir(), "ca")
+	certPEM, keyPEM, err := ca.Issue("WUNU2S3B7MZ000147", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := pair.Leaf
+	if cert.Subject.CommonName != "WUNU2S3B7MZ000147" || len(cert.DNSNames) != 1 || cert.DNSNames[0] != "WUNU2S3B7MZ000147" {
+		t.Errorf("subject = %s, SANs = %v", cert.Subject, cert.DNSNames)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Cert)
+	opts := x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}
+	if _, err := cert.Verify(opts); err != nil {
+		t.Errorf("issued certificate does not verify: %v", err)
+	}
+	opts.KeyUsages = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	if _, err := cert.Verify(opts); err == nil {
+		t.Error("client certificate verified for server auth")
+	}
+
+	if _, _, err := ca.Issue("", time.Hour); err == nil {
+		t.Error("issued certificate without identifier")
+	}
+}
+
+// handshake connects a client with the given certificate (if any) to a
+// server using r and returns the server's view of the connection.
+func handshake(t *testing.T, r *Reloader, client *tls.Certificate) (tls.ConnectionState, error) {
+	t.Helper()
+	c, s := net.Pipe()
+	defer c.Close()
+	defer s.Close()
+
+	// The test server certificate comes from Issue and so is only good for
+	// client auth; this test is about the server's side of the handshake.
+	cfg := &tls.Config{InsecureSkipVerify: true}
+	if client != nil {
+		// Send the certificate even if the server doesn't list its issuer.
+		cfg.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return client, nil
+		}
+	}
+	go func() {
+		// Read until the server hangs up so its alerts don't block on the pipe.
+		io.Copy(io.Discard, tls.Client(c, cfg))
+	}()
+	server := tls.Server(s, r.TLSConfig())
+	err := server.Handshake()
+	return server.ConnectionState(), err
+}
+
+func TestReloader(t *testing.T) {
+	dir := t.TempDir()
+	ca, caFile := writeCA(t, dir, "ca")
+
+	serverCert := func() []byte {
+		certPEM, keyPEM, err := ca.Issue("localhost", time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		writePair(t, dir, "server", certPEM, keyPEM)
+		return certPEM
+	}
+	first := serverCert()
+	certFile, keyFile := filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key")
+
+	r, err := NewReloader(certFile, keyFile, caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientPEM, clientKey, err := ca.Issue("S1", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := tls.X509KeyPair(clientPEM, clientKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state, err := handshake(t, r, &client)
+	if err != nil {
+		t.Fatalf("handshake: %v", err)
+	}
+	if len(state.VerifiedChains) == 0 || state.VerifiedChains[0][0].Subject.CommonName != "S1" {
+		t.Errorf("client certificate not verified: %+v", state.PeerCertificates)
+	}
+
+	state, err = handshake(t, r, nil)
+	if err != nil {
+		t.Fatalf("handshake without client certificate: %v", err)
+	}
+	if len(state.VerifiedChains) != 0 {
+		t.Error("verified chain without client certificate")
+	}
+
+	// A certificate from another CA is refused.
+	otherCA, _ := writeCA(t, t.TempDir(), "other")
+	otherPEM, otherKey, _ := otherCA.Issue("S1", time.Hour)
+	other, _ := tls.X509KeyPair(otherPEM, otherKey)
+	if _, err := handshake(t, r, &other); err == nil {
+		t.Error("foreign client certificate accepted")
+	}
+
+	if reloaded, err := r.Reload(); err != nil || reloaded {
+		t.Errorf("Reload without changes = %t, %v", reloaded, err)
+	}
+	second := serverCert()
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, future, future)
+	if reloaded, err := r.Reload(); err != nil || !reloaded {
+		t.Fatalf("Reload after change = %t, %v", reloaded, err)
+	}
+	if string(first) == string(second) {
+		t.Fatal("issued the same certificate twice")
+	}
+	r.mu.RLock()
+	leaf := r.cert.Leaf
+	r.mu.RUnlock()
+	if leaf == nil || string(encodeCert(leaf.Raw)) != string(second) {
+		t.Error("reloaded certificate not in use")
+	}
+
+	// A broken file keeps the previous certificate.
+	os.WriteFile(certFile, []byte("garbage"), 0644)
+	os.Chtimes(certFile, future.Add(time.Minute), future.Add(time.Minute))
+	if _, err := r.Reload(); err == nil {
+		t.Error("reloaded a broken certificate")
+	}
+	if _, err := handshake(t, r, &client); err != nil {
+		t.Errorf("handshake after failed reload: %v", err)
+	}
+}
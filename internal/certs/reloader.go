@@ -0,0 +1,155 @@
+// Package certs provides the server's TLS configuration, reloaded when its
+// files change, and a minimal CA for issuing scooter client certificates.
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Reloader serves a certificate, key and optional client CA bundle from disk
+// and picks up changes to them without a restart, so renewed certificates
+// take effect on the next handshake.
+type Reloader struct {
+	certFile, keyFile, caFile string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	pool    *x509.CertPool // client CAs; nil without mTLS
+	modTime time.Time      // newest mtime of the loaded files
+}
+
+// NewReloader loads the files. caFile may be empty to disable client
+// certificates.
+func NewReloader(certFile, keyFile, caFile string) (*Reloader, error) {
+	r := &Reloader{certFile: certFile, keyFile: keyFile, caFile: caFile}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *Reloader) files() []string {
+	files := []string{r.certFile, r.keyFile}
+	if r.caFile != "" {
+		files = append(files, r.caFile)
+	}
+	return files
+}
+
+// newestModTime returns the latest modification time of the files.
+func (r *Reloader) newestModTime() (time.Time, error) {
+	var newest time.Time
+	for _, f := range r.files() {
+		info, err := os.Stat(f)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(newest) {
+			newest = info.ModTime()
+		}
+	}
+	return newest, nil
+}
+
+func (r *Reloader) load() error {
+	modTime, err := r.newestModTime()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("load certificate: %w", err)
+	}
+	var pool *x509.CertPool
+	if r.caFile != "" {
+		pem, err := os.ReadFile(r.caFile)
+		if err != nil {
+			return fmt.Errorf("read client CA: %w", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates in client CA file %s", r.caFile)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cert, r.pool, r.modTime = &cert, pool, modTime
+	return nil
+}
+
+// Reload reloads the files if any of them changed since the last load. A
+// failed reload keeps the previous certificate in use.
+func (r *Reloader) Reload() (bool, error) {
+	modTime, err := r.newestModTime()
+	if err != nil {
+		return false, err
+	}
+	r.mu.RLock()
+	changed := !modTime.Equal(r.modTime)
+	r.mu.RUnlock()
+	if !changed {
+		return false, nil
+	}
+	if err := r.load(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Watch checks the files for changes every interval until stop is closed.
+func (r *Reloader) Watch(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			switch reloaded, err := r.Reload(); {
+			case err != nil:
+				log.Printf("[TLS] Reload failed, keeping the current certificate: %v", err)
+			case reloaded:
+				log.Printf("[TLS] Reloaded %s", r.certFile)
+			}
+		}
+	}
+}
+
+// TLSConfig returns a server config that uses the current files for every
+// handshake. Client certificates are verified if presented but not
+// required, since browsers share the listeners with scooters; scooter
+// authentication decides whether one is needed.
+func (r *Reloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// Unused during handshakes, but tells http.Server.ListenAndServeTLS
+		// that no files need loading.
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			r.mu.RLock()
+			defer r.mu.RUnlock()
+			return r.cert, nil
+		},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			r.mu.RLock()
+			defer r.mu.RUnlock()
+			cfg := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*r.cert},
+				// WebSocket upgrades need HTTP/1.1.
+				NextProtos: []string{"http/1.1"},
+			}
+			if r.pool != nil {
+				cfg.ClientAuth = tls.VerifyClientCertIfGiven
+				cfg.ClientCAs = r.pool
+			}
+			return cfg, nil
+		},
+	}
+}
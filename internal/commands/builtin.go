@@ -0,0 +1,44 @@
+package commands
+
+// Builtin returns the commands understood by librescoot's uplink-service, in
+// the order the web UI shows them.
+func Builtin() []Command {
+	return []Command{
+		{Name: "lock", Label: "Lock", Group: "Access", Queueable: true, Quick: true, Variant: "primary"},
+		// Unlocking or opening the seatbox later than asked for could leave
+		// the scooter open with nobody next to it.
+		{Name: "unlock", Label: "Unlock", Group: "Access", Quick: true},
+		{Name: "open_seatbox", Label: "Open Seatbox", Group: "Access", Quick: true},
+		{Name: "lock_hibernate", Label: "Lock + Hibernate", Group: "Access", Queueable: true},
+		{Name: "force_lock", Label: "Force Lock", Group: "Access", Role: RoleAdmin, Variant: "danger"},
+		{Name: "handlebar_lock", Label: "Handlebar Lock", Group: "Access", Queueable: true},
+		{Name: "handlebar_unlock", Label: "Handlebar Unlock", Group: "Access", Queueable: true},
+
+		{Name: "blinker_left", Label: "Blinker ←", Group: "Lights", Queueable: true},
+		{Name: "blinker_right", Label: "Blinker →", Group: "Lights", Queueable: true},
+		{Name: "blinker_both", Label: "Blinker ⚠", Group: "Lights", Queueable: true},
+		{Name: "blinker_off", Label: "Blinker Off", Group: "Lights", Queueable: true},
+		{Name: "dashboard_on", Label: "Dashboard On", Group: "Lights", Queueable: true},
+		{Name: "dashboard_off", Label: "Dashboard Off", Group: "Lights", Queueable: true},
+
+		{Name: "alarm_arm", Label: "Arm", Group: "Alarm", Queueable: true},
+		{Name: "alarm_disarm", Label: "Disarm", Group: "Alarm", Queueable: true},
+		{Name: "alarm_stop", Label: "Stop", Group: "Alarm", Queueable: true},
+		{Name: "alarm_enable", Label: "Enable", Group: "Alarm", Queueable: true},
+		{Name: "alarm_disable", Label: "Disable", Group: "Alarm", Queueable: true, Role: RoleAdmin},
+
+		{Name: "hibernate", Label: "Hibernate", Group: "Power", Queueable: true, Confirm: "Put scooter into hibernate mode?"},
+		{Name: "hibernate_manual", Label: "Hibernate (manual)", Group: "Power", Queueable: true},
+		{Name: "reboot", Label: "Reboot", Group: "Power", Queueable: true, Role: RoleAdmin, Variant: "danger", Confirm: "Reboot the scooter?"},
+		{Name: "engine_on", Label: "Engine On", Group: "Power", Queueable: true, Role: RoleAdmin},
+		{Name: "engine_off", Label: "Engine Off", Group: "Power", Queueable: true, Role: RoleAdmin},
+
+		{Name: "get_state", Label: "Get State", Group: "Diagnostics", Queueable: true, Role: RoleViewer, Quick: true},
+		{Name: "ping", Label: "Ping", Group: "Diagnostics", Queueable: true, Role: RoleViewer},
+		{Name: "honk", Label: "Honk", Group: "Diagnostics", Queueable: true, Params: Object(map[string]*Schema{
+			"duration": {Type: TypeInteger, Description: "Horn duration in milliseconds", Minimum: ptr(1.0), Maximum: ptr(10000.0), Default: 500},
+		})},
+	}
+}
+
+func ptr[T any](v T) *T { return &v }
@@ -0,0 +1,237 @@
+// Package commands describes the commands scooters understand: their
+// parameters, whether they may be queued for offline scooters, whether the UI
+// asks for confirmation, the client version that introduced them and the role
+// needed to send them. Requests are validated against the catalog before
+// anything is sent, so typos fail at the API instead of on the scooter.
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Roles, from least to most privileged.
+const (
+	RoleViewer   = "viewer"
+	RoleOperator = "operator"
+	RoleAdmin    = "admin"
+)
+
+// Command describes one command.
+type Command struct {
+	Name        string  `json:"name"`
+	Label       string  `json:"label"`
+	Group       string  `json:"group"`
+	Description string  `json:"description,omitempty"`
+	Params      *Schema `json:"params"`
+	Queueable   bool    `json:"queueable"`             // may be delivered late to an offline scooter
+	Confirm     string  `json:"confirm,omitempty"`     // prompt shown before sending; empty means no confirmation
+	MinVersion  string  `json:"min_version,omitempty"` // oldest client version that understands it
+	Role        string  `json:"role"`                  // role needed to send it
+	Quick       bool    `json:"quick,omitempty"`       // shown as a quick action in the UI
+	Variant     string  `json:"variant,omitempty"`     // UI button style: "primary" or "danger"
+}
+
+// ErrUnknownCommand is returned for commands missing from the catalog.
+var ErrUnknownCommand = errors.New("unknown command")
+
+// ParamError reports parameters that do not match a command's schema.
+type ParamError struct {
+	Command string
+	Err     error
+}
+
+func (e *ParamError) Error() string {
+	return fmt.Sprintf("invalid params for %s: %v", e.Command, e.Err)
+}
+
+func (e *ParamError) Unwrap() error { return e.Err }
+
+// VersionError reports a command the scooter's client is too old to run.
+type VersionError struct {
+	Command    string
+	MinVersion string
+	Version    string
+}
+
+func (e *VersionError) Error() string {
+	return fmt.Sprintf("%s requires client version %s or later (scooter runs %s)", e.Command, e.MinVersion, e.Version)
+}
+
+// IsRejection reports whether err is a catalog error: an unknown command,
+// invalid params or a client that is too old.
+func IsRejection(err error) bool {
+	var (
+		paramErr   *ParamError
+		versionErr *VersionError
+	)
+	return errors.Is(err, ErrUnknownCommand) || errors.As(err, &paramErr) || errors.As(err, &versionErr)
+}
+
+// Catalog is an ordered, read-only set of commands.
+type Catalog struct {
+	commands []Command
+	byName   map[string]int
+}
+
+var nameRe = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// New builds a catalog from the built-in commands plus extra, which replace
+// built-ins of the same name or are appended in order.
+func New(extra []Command) (*Catalog, error) {
+	c := &Catalog{byName: make(map[string]int)}
+	for _, cmd := range append(Builtin(), extra...) {
+		if err := cmd.check(); err != nil {
+			return nil, fmt.Errorf("command %q: %w", cmd.Name, err)
+		}
+		if i, ok := c.byName[cmd.Name]; ok {
+			c.commands[i] = cmd
+			continue
+		}
+		c.byName[cmd.Name] = len(c.commands)
+		c.commands = append(c.commands, cmd)
+	}
+	return c, nil
+}
+
+// Default returns a catalog of the built-in commands.
+func Default() *Catalog {
+	c, err := New(nil)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// check validates cmd and fills in defaults.
+func (cmd *Command) check() error {
+	if !nameRe.MatchString(cmd.Name) {
+		return errors.New("name must be lower case letters, digits and underscores")
+	}
+	if cmd.Label == "" {
+		cmd.Label = cmd.Name
+	}
+	if cmd.Group == "" {
+		cmd.Group = "Other"
+	}
+	switch cmd.Role {
+	case "":
+		cmd.Role = RoleOperator
+	case RoleViewer, RoleOperator, RoleAdmin:
+	default:
+		return fmt.Errorf("role must be %s, %s or %s", RoleViewer, RoleOperator, RoleAdmin)
+	}
+	if cmd.MinVersion != "" {
+		if _, ok := parseVersion(cmd.MinVersion); !ok {
+			return fmt.Errorf("invalid min_version %q", cmd.MinVersion)
+		}
+	}
+	if cmd.Params == nil {
+		cmd.Params = NoParams()
+	}
+	if cmd.Params.Type != TypeObject {
+		return errors.New("params must be an object schema")
+	}
+	return cmd.Params.check("params")
+}
+
+// Commands returns the catalog in display order.
+func (c *Catalog) Commands() []Command {
+	return append([]Command(nil), c.commands...)
+}
+
+// Lookup returns the named command.
+func (c *Catalog) Lookup(name string) (Command, bool) {
+	i, ok := c.byName[name]
+	if !ok {
+		return Command{}, false
+	}
+	return c.commands[i], true
+}
+
+// Queueable reports whether a command may be queued for later delivery.
+// Unknown commands are not.
+func (c *Catalog) Queueable(name string) bool {
+	cmd, ok := c.Lookup(name)
+	return ok && cmd.Queueable
+}
+
+// Validate checks that a command exists and its params match its schema. It
+// returns ErrUnknownCommand (wrapped) or a *ParamError.
+func (c *Catalog) Validate(name string, params map[string]any) error {
+	cmd, ok := c.Lookup(name)
+	if !ok {
+		return fmt.Errorf("%w %q", ErrUnknownCommand, name)
+	}
+	if params == nil {
+		params = map[string]any{}
+	}
+	if err := cmd.Params.validate(params); err != nil {
+		return &ParamError{Command: name, Err: err}
+	}
+	return nil
+}
+
+// CheckVersion returns a *VersionError if a client at version is known to be
+// too old for the command. Unknown or unparseable versions pass, since the
+// scooter will report unsupported commands itself.
+func (c *Catalog) CheckVersion(name, version string) error {
+	cmd, ok := c.Lookup(name)
+	if !ok || cmd.MinVersion == "" {
+		return nil
+	}
+	have, ok := parseVersion(version)
+	if !ok {
+		return nil
+	}
+	want, _ := parseVersion(cmd.MinVersion)
+	if compareVersions(have, want) < 0 {
+		return &VersionError{Command: name, MinVersion: cmd.MinVersion, Version: version}
+	}
+	return nil
+}
+
+// parseVersion parses "1.2.3", "v1.2" or "1.2.3-rc1+abc" into its numeric
+// components; pre-release and build suffixes are ignored.
+func parseVersion(v string) ([]int, bool) {
+	v = strings.TrimPrefix(strings.TrimSpace(v), "v")
+	if i := strings.IndexAny(v, "-+ "); i >= 0 {
+		v = v[:i]
+	}
+	if v == "" {
+		return nil, false
+	}
+	parts := strings.Split(v, ".")
+	nums := make([]int, len(parts))
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return nil, false
+		}
+		nums[i] = n
+	}
+	return nums, true
+}
+
+// compareVersions compares parsed versions, treating missing components as 0.
+func compareVersions(a, b []int) int {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var x, y int
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
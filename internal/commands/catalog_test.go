@@ -0,0 +1,113 @@
+package commands
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	c, err := New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		cmd    string
+		params map[string]any
+		ok     bool
+	}{
+		{"lock", nil, true},
+		{"lock", map[string]any{}, true},
+		{"lock", map[string]any{"force": true}, false},
+		{"honk", map[string]any{"duration": 500.0}, true},
+		{"honk", map[string]any{"duration": 0.5}, false},
+		{"honk", map[string]any{"duration": 60000.0}, false},
+		{"honk", map[string]any{"duration": "500"}, false},
+		{"honk", map[string]any{"durration": 500.0}, false},
+	}
+	for _, tt := range tests {
+		err := c.Validate(tt.cmd, tt.params)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s %v: err = %v", tt.cmd, tt.params, err)
+		}
+		var perr *ParamError
+		if err != nil && !errors.As(err, &perr) {
+			t.Errorf("%s %v: not a ParamError: %v", tt.cmd, tt.params, err)
+		}
+	}
+	if err := c.Validate("blinker_lefft", nil); !errors.Is(err, ErrUnknownCommand) {
+		t.Errorf("typo: err = %v", err)
+	}
+	if err := c.Validate("honk", map[string]any{"duration": 60000.0}); err == nil || err.Error() != "invalid params for honk: duration must be at most 10000" {
+		t.Errorf("message: %v", err)
+	}
+}
+
+func TestNewExtends(t *testing.T) {
+	c, err := New([]Command{
+		{Name: "unlock", Label: "Unlock", Group: "Access", Confirm: "Really?"},
+		{Name: "navigate", Params: Object(map[string]*Schema{
+			"lat":  {Type: TypeNumber, Minimum: ptr(-90.0), Maximum: ptr(90.0)},
+			"lng":  {Type: TypeNumber, Minimum: ptr(-180.0), Maximum: ptr(180.0)},
+			"mode": {Type: TypeString, Enum: []any{"fast", "eco"}},
+		}, "lat", "lng"), MinVersion: "1.4"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlock, _ := c.Lookup("unlock")
+	if unlock.Confirm != "Really?" || unlock.Role != RoleOperator || c.Queueable("unlock") {
+		t.Errorf("override: %+v", unlock)
+	}
+	all := c.Commands()
+	if last := all[len(all)-1]; last.Name != "navigate" || last.Group != "Other" || last.Label != "navigate" {
+		t.Errorf("appended command: %+v", last)
+	}
+	if len(all) != len(Builtin())+1 {
+		t.Errorf("got %d commands", len(all))
+	}
+	if err := c.Validate("navigate", map[string]any{"lat": 52.5}); err == nil {
+		t.Error("missing required param accepted")
+	}
+	if err := c.Validate("navigate", map[string]any{"lat": 52.5, "lng": 13.4, "mode": "eco"}); err != nil {
+		t.Error(err)
+	}
+	if err := c.Validate("navigate", map[string]any{"lat": 52.5, "lng": 13.4, "mode": "slow"}); err == nil {
+		t.Error("value outside enum accepted")
+	}
+
+	for _, bad := range []Command{
+		{Name: "Bad-Name"},
+		{Name: "x", Role: "root"},
+		{Name: "x", MinVersion: "latest"},
+		{Name: "x", Params: &Schema{Type: TypeString}},
+		{Name: "x", Params: Object(nil, "missing")},
+		{Name: "x", Params: Object(map[string]*Schema{"n": {Type: TypeInteger, Enum: []any{"a"}}})},
+	} {
+		if _, err := New([]Command{bad}); err == nil {
+			t.Errorf("%+v: expected error", bad)
+		}
+	}
+}
+
+func TestCheckVersion(t *testing.T) {
+	c, err := New([]Command{{Name: "navigate", MinVersion: "1.4"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for version, ok := range map[string]bool{
+		"1.3.9":       false,
+		"v1.4":        true,
+		"1.4.0-rc1":   true,
+		"1.10.0":      true,
+		"":            true, // unknown
+		"nightly-abc": true,
+	} {
+		err := c.CheckVersion("navigate", version)
+		if (err == nil) != ok {
+			t.Errorf("%q: err = %v", version, err)
+		}
+	}
+	if err := c.CheckVersion("lock", "0.1"); err != nil {
+		t.Errorf("no min version: %v", err)
+	}
+}
@@ -0,0 +1,200 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Schema types.
+const (
+	TypeObject  = "object"
+	TypeString  = "string"
+	TypeInteger = "integer"
+	TypeNumber  = "number"
+	TypeBoolean = "boolean"
+)
+
+// Schema is the subset of JSON Schema used to describe command parameters.
+// Objects are closed unless AdditionalProperties is set, so misspelt
+// parameters are rejected rather than ignored by the scooter.
+type Schema struct {
+	Type                 string             `json:"type"`
+	Description          string             `json:"description,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *bool              `json:"additionalProperties,omitempty"`
+	Enum                 []any              `json:"enum,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	Default              any                `json:"default,omitempty"`
+}
+
+// NoParams returns the schema of a command that takes no parameters.
+func NoParams() *Schema {
+	return Object(nil)
+}
+
+// Object returns a closed object schema with the given properties.
+func Object(props map[string]*Schema, required ...string) *Schema {
+	closed := false
+	return &Schema{Type: TypeObject, Properties: props, Required: required, AdditionalProperties: &closed}
+}
+
+// check verifies that s is a usable schema.
+func (s *Schema) check(path string) error {
+	switch s.Type {
+	case TypeObject:
+		for _, name := range s.Required {
+			if s.Properties[name] == nil {
+				return fmt.Errorf("%s: required property %q is not defined", path, name)
+			}
+		}
+		for name, prop := range s.Properties {
+			if prop == nil {
+				return fmt.Errorf("%s.%s: missing schema", path, name)
+			}
+			if err := prop.check(path + "." + name); err != nil {
+				return err
+			}
+		}
+	case TypeString, TypeInteger, TypeNumber, TypeBoolean:
+		if len(s.Properties) > 0 || len(s.Required) > 0 {
+			return fmt.Errorf("%s: properties are only allowed on objects", path)
+		}
+	default:
+		return fmt.Errorf("%s: unsupported type %q", path, s.Type)
+	}
+	if s.Minimum != nil && s.Maximum != nil && *s.Minimum > *s.Maximum {
+		return fmt.Errorf("%s: minimum is greater than maximum", path)
+	}
+	for i, v := range s.Enum {
+		if f, ok := toFloat(v); ok {
+			s.Enum[i], v = f, f
+		}
+		if err := s.validate(v); err != nil {
+			return fmt.Errorf("%s: enum value %v: %w", path, v, err)
+		}
+	}
+	return nil
+}
+
+// validate checks a decoded JSON value against s.
+func (s *Schema) validate(v any) error {
+	switch s.Type {
+	case TypeObject:
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return errors.New("must be an object")
+		}
+		for _, name := range s.Required {
+			if _, ok := obj[name]; !ok {
+				return &fieldError{name, errors.New("is required")}
+			}
+		}
+		names := make([]string, 0, len(obj))
+		for name := range obj {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			prop := s.Properties[name]
+			if prop == nil {
+				if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+					return &fieldError{name, errors.New("is not a known parameter")}
+				}
+				continue
+			}
+			if err := prop.validate(obj[name]); err != nil {
+				return &fieldError{name, err}
+			}
+		}
+		return nil
+	case TypeString:
+		str, ok := v.(string)
+		if !ok {
+			return errors.New("must be a string")
+		}
+		if s.MaxLength != nil && len([]rune(str)) > *s.MaxLength {
+			return fmt.Errorf("must be at most %d characters", *s.MaxLength)
+		}
+	case TypeInteger, TypeNumber:
+		n, ok := v.(float64)
+		if !ok {
+			return fmt.Errorf("must be a %s", s.Type)
+		}
+		if s.Type == TypeInteger && n != math.Trunc(n) {
+			return errors.New("must be an integer")
+		}
+		if s.Minimum != nil && n < *s.Minimum {
+			return fmt.Errorf("must be at least %v", *s.Minimum)
+		}
+		if s.Maximum != nil && n > *s.Maximum {
+			return fmt.Errorf("must be at most %v", *s.Maximum)
+		}
+	case TypeBoolean:
+		if _, ok := v.(bool); !ok {
+			return errors.New("must be a boolean")
+		}
+	}
+	if len(s.Enum) > 0 && !inEnum(s.Enum, v) {
+		return fmt.Errorf("must be one of %s", formatEnum(s.Enum))
+	}
+	return nil
+}
+
+// fieldError prefixes a nested validation error with the property name.
+type fieldError struct {
+	name string
+	err  error
+}
+
+func (e *fieldError) Error() string {
+	var inner *fieldError
+	if errors.As(e.err, &inner) {
+		return e.name + "." + e.err.Error()
+	}
+	return e.name + " " + e.err.Error()
+}
+
+func (e *fieldError) Unwrap() error { return e.err }
+
+// inEnum reports whether v equals one of the allowed values. Numbers compare
+// by value, since config files yield ints where JSON yields float64.
+func inEnum(enum []any, v any) bool {
+	for _, e := range enum {
+		if a, ok := toFloat(e); ok {
+			if b, ok := toFloat(v); ok && a == b {
+				return true
+			}
+			continue
+		}
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
+
+func toFloat(v any) (float64, bool) {
+	switch x := v.(type) {
+	case float64:
+		return x, true
+	case int:
+		return float64(x), true
+	case int64:
+		return float64(x), true
+	}
+	return 0, false
+}
+
+func formatEnum(enum []any) string {
+	parts := make([]string, len(enum))
+	for i, e := range enum {
+		parts[i] = fmt.Sprint(e)
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,112 @@
+// Package export writes scooter tracks as GPX, GeoJSON or KML. Writers are
+// fed one point at a time so tracks can be streamed straight from the store
+// to an HTTP response.
+package export
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/librescoot/uplink-server/internal/store"
+)
+
+// Supported formats.
+const (
+	FormatGPX     = "gpx"
+	FormatGeoJSON = "geojson"
+	FormatKML     = "kml"
+)
+
+// Meta describes the exported track.
+type Meta struct {
+	Name      string // track name, e.g. the scooter's display name
+	ScooterID string
+	TripID    int64 // 0 when the track is not a trip
+	From      time.Time
+	To        time.Time
+}
+
+// Writer serialises a track point by point. Begin must be called first and
+// End last; End completes the document even when no points were written.
+type Writer interface {
+	Begin(meta Meta) error
+	Point(p store.TrackPoint) error
+	End() error
+}
+
+// NewWriter returns a writer for format, or an error for unknown formats.
+func NewWriter(format string, w io.Writer) (Writer, error) {
+	switch format {
+	case FormatGPX:
+		return &gpxWriter{w: w}, nil
+	case FormatGeoJSON:
+		return &geoJSONWriter{w: w}, nil
+	case FormatKML:
+		return &kmlWriter{w: w}, nil
+	default:
+		return nil, fmt.Errorf("unknown export format %q (want gpx, geojson or kml)", format)
+	}
+}
+
+// ValidFormat reports whether format is supported.
+func ValidFormat(format string) bool {
+	switch format {
+	case FormatGPX, FormatGeoJSON, FormatKML:
+		return true
+	}
+	return false
+}
+
+// ContentType returns the MIME type for format.
+func ContentType(format string) string {
+	switch format {
+	case FormatGPX:
+		return "application/gpx+xml"
+	case FormatGeoJSON:
+		return "application/geo+json"
+	case FormatKML:
+		return "application/vnd.google-earth.kml+xml"
+	default:
+		return "application/octet-stream"
+	}
+}
+
+// Filename suggests a download name for a track in format.
+func Filename(meta Meta, format string) string {
+	base := sanitize(meta.ScooterID)
+	if meta.TripID != 0 {
+		base = fmt.Sprintf("%s-trip-%d", base, meta.TripID)
+	} else {
+		base = fmt.Sprintf("%s-%s", base, meta.From.UTC().Format("20060102T1504"))
+	}
+	return base + "." + format
+}
+
+func sanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// title is the display name of a track.
+func (m Meta) title() string {
+	name := m.Name
+	if name == "" {
+		name = m.ScooterID
+	}
+	if m.TripID != 0 {
+		return fmt.Sprintf("%s trip %d", name, m.TripID)
+	}
+	return name
+}
+
+// kmhToMS converts km/h to m/s.
+func kmhToMS(kmh float64) float64 { return kmh / 3.6 }
+
+const timeFormat = "2006-01-02T15:04:05.000Z"
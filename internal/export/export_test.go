@@ -0,0 +1,177 @@
+package export
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/librescoot/uplink-server/internal/store"
+)
+
+func samplePoints() []store.TrackPoint {
+	base := time.Date(2026, 5, 1, 10, 0, 0, 0, time.UTC)
+	speed := 18.0
+	return []store.TrackPoint{
+		{Timestamp: base, Lat: 52.52, Lng: 13.405, State: "ready-to-drive"},
+		{Timestamp: base.Add(5 * time.Second), Lat: 52.5205, Lng: 13.406, Speed: &speed, State: "ready-to-drive"},
+		{Timestamp: base.Add(10 * time.Second), Lat: 52.521, Lng: 13.407, State: "parked <&>"},
+	}
+}
+
+func render(t *testing.T, format string, points []store.TrackPoint) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := NewWriter(format, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta := Meta{ScooterID: "VIN1", TripID: 7}
+	if len(points) > 0 {
+		meta.From, meta.To = points[0].Timestamp, points[len(points)-1].Timestamp
+	}
+	if err := w.Begin(meta); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range points {
+		if err := w.Point(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.End(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestGPX(t *testing.T) {
+	out := render(t, FormatGPX, samplePoints())
+
+	var doc struct {
+		Trk struct {
+			Name string `xml:"name"`
+			Seg  struct {
+				Points []struct {
+					Lat   float64 `xml:"lat,attr"`
+					Lon   float64 `xml:"lon,attr"`
+					Time  string  `xml:"time"`
+					Type  string  `xml:"type"`
+					Speed string  `xml:"extensions>TrackPointExtension>speed"`
+				} `xml:"trkpt"`
+			} `xml:"trkseg"`
+		} `xml:"trk"`
+	}
+	if err := xml.Unmarshal(out, &doc); err != nil {
+		t.Fatalf("invalid GPX: %v\n%s", err, out)
+	}
+	pts := doc.Trk.Seg.Points
+	if len(pts) != 3 {
+		t.Fatalf("got %d trkpts, want 3", len(pts))
+	}
+	if pts[1].Speed != "5.00" {
+		t.Errorf("speed = %q, want 5.00 m/s", pts[1].Speed)
+	}
+	if pts[0].Speed != "" || pts[0].Time != "2026-05-01T10:00:00.000Z" {
+		t.Errorf("first point = %+v", pts[0])
+	}
+	if pts[2].Type != "parked <&>" {
+		t.Errorf("type = %q", pts[2].Type)
+	}
+	if doc.Trk.Name != "VIN1 trip 7" {
+		t.Errorf("name = %q", doc.Trk.Name)
+	}
+}
+
+func TestGeoJSON(t *testing.T) {
+	var fc struct {
+		Type     string `json:"type"`
+		Features []struct {
+			Geometry struct {
+				Type        string          `json:"type"`
+				Coordinates json.RawMessage `json:"coordinates"`
+			} `json:"geometry"`
+			Properties map[string]any `json:"properties"`
+		} `json:"features"`
+	}
+	out := render(t, FormatGeoJSON, samplePoints())
+	if err := json.Unmarshal(out, &fc); err != nil {
+		t.Fatalf("invalid GeoJSON: %v\n%s", err, out)
+	}
+	if fc.Type != "FeatureCollection" || len(fc.Features) != 4 {
+		t.Fatalf("got %s with %d features, want 4", fc.Type, len(fc.Features))
+	}
+	if fc.Features[1].Properties["speed"] != 18.0 || fc.Features[1].Properties["state"] != "ready-to-drive" {
+		t.Errorf("point properties = %v", fc.Features[1].Properties)
+	}
+	line := fc.Features[3]
+	if line.Geometry.Type != "LineString" || line.Properties["trip_id"] != 7.0 {
+		t.Errorf("last feature = %+v", line)
+	}
+	var coords [][2]float64
+	if err := json.Unmarshal(line.Geometry.Coordinates, &coords); err != nil || len(coords) != 3 || coords[0] != [2]float64{13.405, 52.52} {
+		t.Errorf("line coordinates = %v (%v)", coords, err)
+	}
+
+	// Empty and single-point tracks stay valid and have no LineString.
+	for _, pts := range [][]store.TrackPoint{nil, samplePoints()[:1]} {
+		fc.Features = nil
+		out := render(t, FormatGeoJSON, pts)
+		if err := json.Unmarshal(out, &fc); err != nil {
+			t.Fatalf("invalid GeoJSON for %d points: %v\n%s", len(pts), err, out)
+		}
+		if len(fc.Features) != len(pts) {
+			t.Errorf("%d points: got %d features", len(pts), len(fc.Features))
+		}
+	}
+}
+
+func TestKML(t *testing.T) {
+	out := render(t, FormatKML, samplePoints())
+	var doc struct {
+		Document struct {
+			Folder struct {
+				Placemarks []struct {
+					When string `xml:"TimeStamp>when"`
+					Data []struct {
+						Name  string `xml:"name,attr"`
+						Value string `xml:"value"`
+					} `xml:"ExtendedData>Data"`
+				} `xml:"Placemark"`
+			} `xml:"Folder"`
+			Track struct {
+				Coordinates string `xml:"LineString>coordinates"`
+			} `xml:"Placemark"`
+		} `xml:"Document"`
+	}
+	if err := xml.Unmarshal(out, &doc); err != nil {
+		t.Fatalf("invalid KML: %v\n%s", err, out)
+	}
+	pms := doc.Document.Folder.Placemarks
+	if len(pms) != 3 || pms[1].When != "2026-05-01T10:00:05.000Z" || len(pms[1].Data) != 2 {
+		t.Fatalf("placemarks = %+v", pms)
+	}
+	if got := strings.Fields(doc.Document.Track.Coordinates); len(got) != 3 || got[0] != "13.4050000,52.5200000" {
+		t.Errorf("line coordinates = %v", got)
+	}
+}
+
+func TestNewWriterUnknownFormat(t *testing.T) {
+	if _, err := NewWriter("csv", &bytes.Buffer{}); err == nil {
+		t.Error("expected error for csv")
+	}
+	if ValidFormat("csv") || !ValidFormat(FormatKML) {
+		t.Error("ValidFormat mismatch")
+	}
+}
+
+func TestFilename(t *testing.T) {
+	from := time.Date(2026, 5, 1, 10, 0, 0, 0, time.UTC)
+	if got := Filename(Meta{ScooterID: "VIN 1/x", From: from}, FormatGPX); got != "VIN_1_x-20260501T1000.gpx" {
+		t.Errorf("got %q", got)
+	}
+	if got := Filename(Meta{ScooterID: "VIN1", TripID: 3}, FormatKML); got != "VIN1-trip-3.kml" {
+		t.Errorf("got %q", got)
+	}
+}
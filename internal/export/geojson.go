@@ -0,0 +1,83 @@
+package export
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/librescoot/uplink-server/internal/store"
+)
+
+// geoJSONWriter writes a FeatureCollection with one Point feature per fix
+// (carrying time, speed and state) followed by a LineString of the whole
+// track (omitted below two fixes, where it would be invalid). Points are
+// streamed; the LineString coordinates are kept in memory as compact
+// [lng, lat] pairs until End.
+type geoJSONWriter struct {
+	w      io.Writer
+	bw     *bufio.Writer
+	meta   Meta
+	coords [][2]float64
+}
+
+type geoJSONPointProps struct {
+	Time  string   `json:"time"`
+	Speed *float64 `json:"speed,omitempty"`
+	State string   `json:"state,omitempty"`
+}
+
+func (g *geoJSONWriter) Begin(meta Meta) error {
+	g.bw = bufio.NewWriter(g.w)
+	g.meta = meta
+	_, err := fmt.Fprint(g.bw, `{"type":"FeatureCollection","features":[`+"\n")
+	return err
+}
+
+func (g *geoJSONWriter) Point(p store.TrackPoint) error {
+	props, err := json.Marshal(geoJSONPointProps{
+		Time:  p.Timestamp.UTC().Format(timeFormat),
+		Speed: p.Speed,
+		State: p.State,
+	})
+	if err != nil {
+		return err
+	}
+	if len(g.coords) > 0 {
+		fmt.Fprint(g.bw, ",\n")
+	}
+	g.coords = append(g.coords, [2]float64{p.Lng, p.Lat})
+	_, err = fmt.Fprintf(g.bw, `{"type":"Feature","geometry":{"type":"Point","coordinates":[%.7f,%.7f]},"properties":%s}`,
+		p.Lng, p.Lat, props)
+	return err
+}
+
+func (g *geoJSONWriter) End() error {
+	if len(g.coords) < 2 {
+		fmt.Fprint(g.bw, "\n]}\n")
+		return g.bw.Flush()
+	}
+	props := map[string]any{
+		"name":       g.meta.title(),
+		"scooter_id": g.meta.ScooterID,
+		"from":       g.meta.From.UTC().Format(timeFormat),
+		"to":         g.meta.To.UTC().Format(timeFormat),
+		"points":     len(g.coords),
+	}
+	if g.meta.TripID != 0 {
+		props["trip_id"] = g.meta.TripID
+	}
+	propsJSON, err := json.Marshal(props)
+	if err != nil {
+		return err
+	}
+	fmt.Fprint(g.bw, ",\n"+`{"type":"Feature","geometry":{"type":"LineString","coordinates":[`)
+	for i, c := range g.coords {
+		if i > 0 {
+			fmt.Fprint(g.bw, ",")
+		}
+		fmt.Fprintf(g.bw, "[%.7f,%.7f]", c[0], c[1])
+	}
+	fmt.Fprintf(g.bw, "]},\"properties\":%s}\n]}\n", propsJSON)
+	return g.bw.Flush()
+}
@@ -0,0 +1,54 @@
+package export
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/librescoot/uplink-server/internal/store"
+)
+
+// gpxWriter writes GPX 1.1 with one track segment. Speed uses the Garmin
+// TrackPointExtension (m/s), which QGIS and OsmAnd read; the vehicle state
+// goes in each point's <type>.
+type gpxWriter struct {
+	w  io.Writer
+	bw *bufio.Writer
+}
+
+func (g *gpxWriter) Begin(meta Meta) error {
+	g.bw = bufio.NewWriter(g.w)
+	fmt.Fprint(g.bw, xml.Header)
+	fmt.Fprint(g.bw, `<gpx version="1.1" creator="uplink-server"`+
+		` xmlns="http://www.topografix.com/GPX/1/1"`+
+		` xmlns:gpxtpx="http://www.garmin.com/xmlschemas/TrackPointExtension/v2">`+"\n")
+	fmt.Fprintf(g.bw, "  <metadata><name>%s</name><time>%s</time></metadata>\n",
+		escapeXML(meta.title()), meta.From.UTC().Format(timeFormat))
+	fmt.Fprintf(g.bw, "  <trk><name>%s</name><trkseg>\n", escapeXML(meta.title()))
+	return nil
+}
+
+func (g *gpxWriter) Point(p store.TrackPoint) error {
+	fmt.Fprintf(g.bw, `    <trkpt lat="%.7f" lon="%.7f"><time>%s</time>`, p.Lat, p.Lng, p.Timestamp.UTC().Format(timeFormat))
+	if p.State != "" {
+		fmt.Fprintf(g.bw, "<type>%s</type>", escapeXML(p.State))
+	}
+	if p.Speed != nil {
+		fmt.Fprintf(g.bw, "<extensions><gpxtpx:TrackPointExtension><gpxtpx:speed>%.2f</gpxtpx:speed></gpxtpx:TrackPointExtension></extensions>", kmhToMS(*p.Speed))
+	}
+	_, err := fmt.Fprint(g.bw, "</trkpt>\n")
+	return err
+}
+
+func (g *gpxWriter) End() error {
+	fmt.Fprint(g.bw, "  </trkseg></trk>\n</gpx>\n")
+	return g.bw.Flush()
+}
+
+func escapeXML(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
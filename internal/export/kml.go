@@ -0,0 +1,54 @@
+package export
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+
+	"github.com/librescoot/uplink-server/internal/store"
+)
+
+// kmlWriter writes a KML document with a folder of timestamped point
+// placemarks (speed and state as ExtendedData), so Google Earth's time slider
+// can replay the ride, followed by a LineString placemark of the whole track.
+type kmlWriter struct {
+	w      io.Writer
+	bw     *bufio.Writer
+	coords [][2]float64
+}
+
+func (k *kmlWriter) Begin(meta Meta) error {
+	k.bw = bufio.NewWriter(k.w)
+	fmt.Fprint(k.bw, xml.Header)
+	fmt.Fprint(k.bw, `<kml xmlns="http://www.opengis.net/kml/2.2">`+"\n")
+	fmt.Fprintf(k.bw, "<Document><name>%s</name>\n", escapeXML(meta.title()))
+	_, err := fmt.Fprint(k.bw, "<Folder><name>Points</name>\n")
+	return err
+}
+
+func (k *kmlWriter) Point(p store.TrackPoint) error {
+	k.coords = append(k.coords, [2]float64{p.Lng, p.Lat})
+	fmt.Fprintf(k.bw, "<Placemark><TimeStamp><when>%s</when></TimeStamp><ExtendedData>",
+		p.Timestamp.UTC().Format(timeFormat))
+	if p.Speed != nil {
+		fmt.Fprintf(k.bw, `<Data name="speed"><value>%.1f</value></Data>`, *p.Speed)
+	}
+	if p.State != "" {
+		fmt.Fprintf(k.bw, `<Data name="state"><value>%s</value></Data>`, escapeXML(p.State))
+	}
+	_, err := fmt.Fprintf(k.bw, "</ExtendedData><Point><coordinates>%.7f,%.7f</coordinates></Point></Placemark>\n", p.Lng, p.Lat)
+	return err
+}
+
+func (k *kmlWriter) End() error {
+	fmt.Fprint(k.bw, "</Folder>\n<Placemark><name>Track</name><LineString><tessellate>1</tessellate><coordinates>")
+	for i, c := range k.coords {
+		if i > 0 {
+			fmt.Fprint(k.bw, " ")
+		}
+		fmt.Fprintf(k.bw, "%.7f,%.7f", c[0], c[1])
+	}
+	fmt.Fprint(k.bw, "</coordinates></LineString></Placemark>\n</Document>\n</kml>\n")
+	return k.bw.Flush()
+}
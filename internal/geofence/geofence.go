@@ -0,0 +1,282 @@
+// Package geofence evaluates scooter positions against stored geofences and
+// reports enter/exit transitions as synthetic events.
+package geofence
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/librescoot/uplink-server/internal/store"
+	"github.com/librescoot/uplink-server/internal/trips"
+)
+
+// Event names emitted on transitions.
+const (
+	EventEnter = "geofence_enter"
+	EventExit  = "geofence_exit"
+)
+
+// EventFunc records a synthetic scooter event.
+type EventFunc func(scooterID, event string, data map[string]any, ts time.Time)
+
+// GroupResolver returns the groups a scooter belongs to.
+type GroupResolver func(scooterID string) []string
+
+// Validate checks that f describes a usable fence.
+func Validate(f *store.Geofence) error {
+	if f.Name == "" {
+		return errors.New("name is required")
+	}
+	switch f.Shape {
+	case store.ShapePolygon:
+		if len(f.Points) < 3 {
+			return errors.New("polygon needs at least 3 points")
+		}
+		for _, p := range f.Points {
+			if err := validPoint(p); err != nil {
+				return err
+			}
+		}
+	case store.ShapeCircle:
+		if f.Center == nil {
+			return errors.New("circle needs a center")
+		}
+		if err := validPoint(*f.Center); err != nil {
+			return err
+		}
+		if f.RadiusM <= 0 {
+			return errors.New("circle needs a positive radius_m")
+		}
+	default:
+		return fmt.Errorf("shape must be %q or %q", store.ShapePolygon, store.ShapeCircle)
+	}
+	return nil
+}
+
+func validPoint(p [2]float64) error {
+	if p[0] < -90 || p[0] > 90 || p[1] < -180 || p[1] > 180 {
+		return fmt.Errorf("point [%g, %g] is not a valid [lat, lng]", p[0], p[1])
+	}
+	return nil
+}
+
+// Contains reports whether (lat, lng) lies inside f. Polygons are treated as
+// planar in lat/lng, which is accurate enough at depot and city scale.
+func Contains(f *store.Geofence, lat, lng float64) bool {
+	switch f.Shape {
+	case store.ShapeCircle:
+		return f.Center != nil && trips.Haversine(f.Center[0], f.Center[1], lat, lng) <= f.RadiusM
+	case store.ShapePolygon:
+		// Ray casting along the latitude axis.
+		inside := false
+		pts := f.Points
+		for i, j := 0, len(pts)-1; i < len(pts); j, i = i, i+1 {
+			yi, xi := pts[i][0], pts[i][1]
+			yj, xj := pts[j][0], pts[j][1]
+			if (yi > lat) != (yj > lat) && lng < (xj-xi)*(lat-yi)/(yj-yi)+xi {
+				inside = !inside
+			}
+		}
+		return inside
+	}
+	return false
+}
+
+// Monitor tracks which fences each scooter is inside. Fences are cached in
+// memory; call Reload after changing them in the store.
+type Monitor struct {
+	db   *store.Store
+	emit EventFunc
+
+	mu     sync.Mutex
+	fences []store.Geofence
+	inside map[string]map[int64]bool // scooter -> fence -> inside
+	groups GroupResolver
+}
+
+// NewMonitor loads fences and the last recorded scooter states from db.
+// Transitions are reported through emit.
+func NewMonitor(db *store.Store, emit EventFunc) (*Monitor, error) {
+	m := &Monitor{db: db, emit: emit, inside: make(map[string]map[int64]bool)}
+	states, err := db.GeofenceStates()
+	if err != nil {
+		return nil, err
+	}
+	for _, st := range states {
+		m.scooterState(st.ScooterID)[st.GeofenceID] = st.Inside
+	}
+	if err := m.Reload(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// SetGroupResolver sets how fences targeting groups find their scooters.
+// Without one, group targets match no scooter.
+func (m *Monitor) SetGroupResolver(fn GroupResolver) {
+	m.mu.Lock()
+	m.groups = fn
+	m.mu.Unlock()
+}
+
+// Reload refreshes the cached fences from the store.
+func (m *Monitor) Reload() error {
+	fences, err := m.db.ListGeofences()
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.fences = fences
+	for _, st := range m.inside {
+		for id := range st {
+			if !hasFence(fences, id) {
+				delete(st, id)
+			}
+		}
+	}
+	m.mu.Unlock()
+	return nil
+}
+
+// Inside returns the scooters last seen inside the fence.
+func (m *Monitor) Inside(fenceID int64) []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []string
+	for scooterID, st := range m.inside {
+		if st[fenceID] {
+			out = append(out, scooterID)
+		}
+	}
+	return out
+}
+
+// transition is a change in whether a scooter is inside a fence. Baseline
+// transitions record the first observation and are not reported.
+type transition struct {
+	fenceID  int64
+	name     string
+	inside   bool
+	baseline bool
+}
+
+// Check evaluates a scooter's merged state at ts against its fences and
+// emits an event for every fence it entered or left. The first position seen
+// for a fence only establishes whether the scooter is inside. States without
+// a GPS position are ignored.
+func (m *Monitor) Check(scooterID string, state map[string]any, ts time.Time) {
+	lat, lng, ok := Position(state)
+	if !ok {
+		return
+	}
+
+	var changed []transition
+	m.mu.Lock()
+	var groups []string
+	if m.groups != nil {
+		groups = m.groups(scooterID)
+	}
+	st := m.scooterState(scooterID)
+	for i := range m.fences {
+		f := &m.fences[i]
+		if !applies(f, scooterID, groups) {
+			continue
+		}
+		in := Contains(f, lat, lng)
+		was, known := st[f.ID]
+		if known && was == in {
+			continue
+		}
+		st[f.ID] = in
+		changed = append(changed, transition{fenceID: f.ID, name: f.Name, inside: in, baseline: !known})
+	}
+	m.mu.Unlock()
+
+	for _, t := range changed {
+		if err := m.db.SetGeofenceState(t.fenceID, scooterID, t.inside, ts); err != nil {
+			log.Printf("[Geofence] Failed to persist state for %s: %v", scooterID, err)
+		}
+		if t.baseline || m.emit == nil {
+			continue
+		}
+		event := EventExit
+		if t.inside {
+			event = EventEnter
+		}
+		log.Printf("[Geofence] %s: %s '%s'", scooterID, event, t.name)
+		m.emit(scooterID, event, map[string]any{
+			"geofence_id":   t.fenceID,
+			"geofence_name": t.name,
+			"lat":           lat,
+			"lng":           lng,
+		}, ts)
+	}
+}
+
+func (m *Monitor) scooterState(scooterID string) map[int64]bool {
+	st, ok := m.inside[scooterID]
+	if !ok {
+		st = make(map[int64]bool)
+		m.inside[scooterID] = st
+	}
+	return st
+}
+
+// applies reports whether f targets the scooter.
+func applies(f *store.Geofence, scooterID string, groups []string) bool {
+	if len(f.Scooters) == 0 && len(f.Groups) == 0 {
+		return true
+	}
+	for _, id := range f.Scooters {
+		if id == scooterID {
+			return true
+		}
+	}
+	for _, g := range f.Groups {
+		for _, sg := range groups {
+			if g == sg {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func hasFence(fences []store.Geofence, id int64) bool {
+	for _, f := range fences {
+		if f.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Position extracts gps.latitude/longitude from a scooter state. Scooters
+// report 0/0 before their first fix, which is treated as no position.
+func Position(state map[string]any) (lat, lng float64, ok bool) {
+	gps, _ := state["gps"].(map[string]any)
+	if gps == nil {
+		return 0, 0, false
+	}
+	lat, okLat := number(gps["latitude"])
+	lng, okLng := number(gps["longitude"])
+	if !okLat || !okLng || (lat == 0 && lng == 0) {
+		return 0, 0, false
+	}
+	return lat, lng, true
+}
+
+func number(v any) (float64, bool) {
+	switch x := v.(type) {
+	case float64:
+		return x, true
+	case string:
+		f, err := strconv.ParseFloat(x, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
@@ -0,0 +1,169 @@
+package geofence
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/librescoot/uplink-server/internal/store"
+)
+
+// depot is a square of roughly 1.1 km around (52.5, 13.4).
+var depot = store.Geofence{
+	Name:   "depot",
+	Shape:  store.ShapePolygon,
+	Points: [][2]float64{{52.495, 13.395}, {52.495, 13.405}, {52.505, 13.405}, {52.505, 13.395}},
+}
+
+func gps(lat, lng string) map[string]any {
+	return map[string]any{"gps": map[string]any{"latitude": lat, "longitude": lng}}
+}
+
+func TestContains(t *testing.T) {
+	if !Contains(&depot, 52.5, 13.4) {
+		t.Error("center of polygon should be inside")
+	}
+	if Contains(&depot, 52.51, 13.4) {
+		t.Error("point north of polygon should be outside")
+	}
+
+	circle := store.Geofence{Shape: store.ShapeCircle, Center: &[2]float64{52.5, 13.4}, RadiusM: 200}
+	if !Contains(&circle, 52.501, 13.4) { // ~111 m north
+		t.Error("point 111 m from center should be inside 200 m circle")
+	}
+	if Contains(&circle, 52.503, 13.4) { // ~333 m north
+		t.Error("point 333 m from center should be outside 200 m circle")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	bad := []store.Geofence{
+		{Shape: store.ShapeCircle, Center: &[2]float64{0, 0}, RadiusM: 10},
+		{Name: "x", Shape: "square"},
+		{Name: "x", Shape: store.ShapePolygon, Points: [][2]float64{{0, 0}, {1, 1}}},
+		{Name: "x", Shape: store.ShapePolygon, Points: [][2]float64{{0, 0}, {1, 1}, {95, 1}}},
+		{Name: "x", Shape: store.ShapeCircle, Center: &[2]float64{0, 0}},
+		{Name: "x", Shape: store.ShapeCircle, RadiusM: 5},
+	}
+	for i, f := range bad {
+		if err := Validate(&f); err == nil {
+			t.Errorf("case %d: expected error", i)
+		}
+	}
+	if err := Validate(&depot); err != nil {
+		t.Errorf("depot: %v", err)
+	}
+}
+
+type recorded struct {
+	scooterID, event string
+	data             map[string]any
+}
+
+func newMonitor(t *testing.T) (*store.Store, *Monitor, *[]recorded) {
+	t.Helper()
+	db, err := store.Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	var events []recorded
+	m, err := NewMonitor(db, func(scooterID, event string, data map[string]any, ts time.Time) {
+		events = append(events, recorded{scooterID, event, data})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, m, &events
+}
+
+func TestMonitorTransitions(t *testing.T) {
+	db, m, events := newMonitor(t)
+	f := depot
+	if err := db.CreateGeofence(&f); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	m.Check("VIN1", gps("52.5", "13.4"), now)                         // baseline: inside
+	m.Check("VIN1", map[string]any{"vehicle": map[string]any{}}, now) // no position
+	m.Check("VIN1", gps("0", "0"), now)                               // no fix
+	m.Check("VIN1", gps("52.501", "13.4"), now)                       // still inside
+	m.Check("VIN1", gps("52.52", "13.4"), now.Add(time.Minute))       // exit
+	m.Check("VIN1", gps("52.5", "13.4"), now.Add(2*time.Minute))      // enter
+	if len(*events) != 2 {
+		t.Fatalf("got %d events, want 2: %+v", len(*events), *events)
+	}
+	if e := (*events)[0]; e.event != EventExit || e.scooterID != "VIN1" || e.data["geofence_id"] != f.ID {
+		t.Errorf("first event = %+v", e)
+	}
+	if (*events)[1].event != EventEnter {
+		t.Errorf("second event = %+v", (*events)[1])
+	}
+	if got := m.Inside(f.ID); len(got) != 1 || got[0] != "VIN1" {
+		t.Errorf("Inside = %v", got)
+	}
+
+	// State survives a restart: leaving after reopening is still an exit.
+	m2, err := NewMonitor(db, func(scooterID, event string, data map[string]any, ts time.Time) {
+		*events = append(*events, recorded{scooterID, event, data})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m2.Check("VIN1", gps("52.52", "13.4"), now.Add(3*time.Minute))
+	if len(*events) != 3 || (*events)[2].event != EventExit {
+		t.Errorf("after restart: %+v", *events)
+	}
+}
+
+func TestMonitorTargets(t *testing.T) {
+	db, m, events := newMonitor(t)
+	byScooter := depot
+	byScooter.Scooters = []string{"VIN1"}
+	byGroup := depot
+	byGroup.Groups = []string{"berlin"}
+	for _, f := range []*store.Geofence{&byScooter, &byGroup} {
+		if err := db.CreateGeofence(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	for _, id := range []string{"VIN1", "VIN2"} {
+		m.Check(id, gps("52.5", "13.4"), now)
+		m.Check(id, gps("52.52", "13.4"), now)
+	}
+	if len(*events) != 1 || (*events)[0].scooterID != "VIN1" {
+		t.Fatalf("without groups: %+v", *events)
+	}
+
+	m.SetGroupResolver(func(scooterID string) []string {
+		if scooterID == "VIN2" {
+			return []string{"berlin"}
+		}
+		return nil
+	})
+	m.Check("VIN2", gps("52.5", "13.4"), now)  // baseline for the group fence
+	m.Check("VIN2", gps("52.52", "13.4"), now) // exit
+	if len(*events) != 2 || (*events)[1].scooterID != "VIN2" || (*events)[1].data["geofence_id"] != byGroup.ID {
+		t.Errorf("with groups: %+v", *events)
+	}
+
+	// Deleting a fence forgets its state.
+	if ok, err := db.DeleteGeofence(byGroup.ID); !ok || err != nil {
+		t.Fatalf("delete: %v %v", ok, err)
+	}
+	if err := m.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if got := m.Inside(byGroup.ID); len(got) != 0 {
+		t.Errorf("Inside after delete = %v", got)
+	}
+}
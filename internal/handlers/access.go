@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/librescoot/uplink-server/internal/access"
+)
+
+// credentialRecheckInterval is how often the web UI WebSocket and the SSE
+// stream check that their credential still grants the access they started
+// with.
+const credentialRecheckInterval = 15 * time.Second
+
+// routeRule is the permission a request needs: a minimum role and, for
+// fleet-wide resources, access to every scooter.
+type routeRule struct {
+	method string // empty matches any method
+	path   string // "*" matches one segment; a trailing "/" matches the rest
+	role   string
+	fleet  bool
+}
+
+// routeRules are checked in order; the first match applies. Reads not listed
+// need a viewer (scooter data is then limited to the principal's scope);
+// writes not listed need a fleet-wide admin.
+var routeRules = []routeRule{
+	// Scooter operations, limited to the principal's scope.
+	{http.MethodPost, "/api/commands", access.RoleOperator, false},
+	{http.MethodPost, "/api/scooters/*/config", access.RoleAdmin, false},
+	{http.MethodDelete, "/api/scooters/*/events", access.RoleOperator, false},
+	{http.MethodDelete, "/api/scooters/*/events/", access.RoleOperator, false},
+	{http.MethodPost, "/api/alerts/*/ack", access.RoleOperator, false},
+
+	// Login sessions; handlers limit users to their own.
+	{"", "/api/sessions", access.RoleViewer, false},
+	{"", "/api/sessions/", access.RoleViewer, false},
+
+	// Fleet-wide resources.
+	{"", "/api/webhooks", access.RoleAdmin, true},
+	{"", "/api/users", access.RoleAdmin, true},
+	{"", "/api/users/", access.RoleAdmin, true},
+	{"", "/api/auth/failures", access.RoleAdmin, true},
+	{"", "/api/auth/lockouts", access.RoleAdmin, true},
+	{"", "/api/audit", access.RoleAdmin, true},
+	{http.MethodGet, "/api/retention", access.RoleViewer, true},
+	{http.MethodGet, "/api/alerts/rules", access.RoleViewer, true},
+	{http.MethodGet, "/api/geofences", access.RoleViewer, true},
+	{http.MethodGet, "/api/geofences/", access.RoleViewer, true},
+	{http.MethodGet, "/api/batches", access.RoleViewer, true},
+	{http.MethodGet, "/api/batches/", access.RoleViewer, true},
+	{http.MethodPost, "/api/batches", access.RoleOperator, true},
+	{"", "/api/schedules", access.RoleOperator, true},
+	{"", "/api/schedules/", access.RoleOperator, true},
+}
+
+// scopedPaths are prefixes followed by a scooter ID, which must be within
+// the principal's scope.
+var scopedPaths = []string{"/api/scooters/", "/api/registry/"}
+
+func matchRoute(pattern, path string) bool {
+	prefix := strings.HasSuffix(pattern, "/")
+	want := strings.Split(strings.Trim(pattern, "/"), "/")
+	got := strings.Split(strings.Trim(path, "/"), "/")
+	if len(got) < len(want) || (!prefix && len(got) != len(want)) {
+		return false
+	}
+	for i, seg := range want {
+		if seg != "*" && seg != got[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// authorize checks a request against the route rules and the scope of the
+// scooter it addresses. It returns the reason for a refusal, or "".
+func authorize(p *access.Principal, r *http.Request) string {
+	// GET rules also cover HEAD.
+	method := r.Method
+	if method == http.MethodHead {
+		method = http.MethodGet
+	}
+	rule := routeRule{role: access.RoleAdmin, fleet: true}
+	if method == http.MethodGet {
+		rule = routeRule{role: access.RoleViewer}
+	}
+	for _, rr := range routeRules {
+		if (rr.method == "" || rr.method == method) && matchRoute(rr.path, r.URL.Path) {
+			rule = rr
+			break
+		}
+	}
+	if !p.Allows(rule.role) {
+		return fmt.Sprintf("Requires the %s role", rule.role)
+	}
+	if rule.fleet && !p.Fleet() {
+		return "Requires access to the whole fleet"
+	}
+	for _, prefix := range scopedPaths {
+		rest, ok := strings.CutPrefix(r.URL.Path, prefix)
+		if id, _, _ := strings.Cut(rest, "/"); ok && id != "" && !p.CanAccess(id) {
+			return "Scooter is outside your scope"
+		}
+	}
+	return ""
+}
+
+type principalKey struct{}
+
+// principal returns the principal of an authenticated request.
+func (h *APIHandler) principal(r *http.Request) *access.Principal {
+	if p, ok := r.Context().Value(principalKey{}).(*access.Principal); ok {
+		return p
+	}
+	return &access.Principal{}
+}
+
+func withPrincipal(r *http.Request, p *access.Principal) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), principalKey{}, p))
+}
+
+// credentialValid reports whether a stream's credential still authenticates
+// as p with the same role and scope: its session was not revoked and has not
+// expired, and its user was neither removed nor changed.
+func credentialValid(policy *access.Policy, credential string, p *access.Principal) bool {
+	current, ok := policy.Recheck(credential)
+	return ok && current.Equal(p)
+}
+
+// checkCommand reports why the request's principal may not send command, or
+// "". Commands missing from the catalog are left to its validation.
+func (h *APIHandler) checkCommand(r *http.Request, command string) string {
+	cmd, ok := h.wsHandler.Catalog().Lookup(command)
+	if ok && !h.principal(r).Allows(cmd.Role) {
+		return fmt.Sprintf("%s requires the %s role", command, cmd.Role)
+	}
+	return ""
+}
+
+// HandleMe handles GET /api/me: the caller's name, role and scope.
+func (h *APIHandler) HandleMe(w http.ResponseWriter, r *http.Request) {
+	h.cors(h.authenticate(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			h.writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		h.writeJSON(w, http.StatusOK, h.principal(r))
+	}))(w, r)
+}
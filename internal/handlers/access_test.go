@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/librescoot/uplink-server/internal/access"
+	"github.com/librescoot/uplink-server/internal/models"
+)
+
+func TestMatchRoute(t *testing.T) {
+	tests := []struct {
+		pattern, path string
+		want          bool
+	}{
+		{"/api/commands", "/api/commands", true},
+		{"/api/commands", "/api/commands/", true},
+		{"/api/commands", "/api/commands/x", false},
+		{"/api/users/", "/api/users/alice", true},
+		{"/api/users/", "/api/users/alice/sessions", true},
+		{"/api/users/", "/api/users", true},
+		{"/api/scooters/*/config", "/api/scooters/S1/config", true},
+		{"/api/scooters/*/config", "/api/scooters/S1/config/x", false},
+		{"/api/scooters/*/config", "/api/scooters/S1/state", false},
+		{"/api/scooters/*/events/", "/api/scooters/S1/events/42", true},
+		{"/api/alerts/*/ack", "/api/alerts/7/ack", true},
+		{"/api/alerts/*/ack", "/api/alerts/ack", false},
+	}
+	for _, tt := range tests {
+		if got := matchRoute(tt.pattern, tt.path); got != tt.want {
+			t.Errorf("matchRoute(%q, %q) = %t, want %t", tt.pattern, tt.path, got, tt.want)
+		}
+	}
+}
+
+// TestAuthorizeRoles checks every route rule, and the defaults for routes
+// without one, against each role with and without fleet-wide scope.
+func TestAuthorizeRoles(t *testing.T) {
+	tests := []struct {
+		method, path string
+		role         string // minimum role
+		fleet        bool   // requires access to every scooter
+	}{
+		// Scooter operations.
+		{http.MethodPost, "/api/commands", access.RoleOperator, false},
+		{http.MethodPost, "/api/scooters/S1/config", access.RoleAdmin, false},
+		{http.MethodDelete, "/api/scooters/S1/events", access.RoleOperator, false},
+		{http.MethodDelete, "/api/scooters/S1/events/42", access.RoleOperator, false},
+		{http.MethodPost, "/api/alerts/7/ack", access.RoleOperator, false},
+
+		// Login sessions.
+		{http.MethodGet, "/api/sessions", access.RoleViewer, false},
+		{http.MethodDelete, "/api/sessions", access.RoleViewer, false},
+		{http.MethodDelete, "/api/sessions/abc", access.RoleViewer, false},
+
+		// Fleet-wide resources.
+		{http.MethodGet, "/api/webhooks", access.RoleAdmin, true},
+		{http.MethodPost, "/api/webhooks", access.RoleAdmin, true},
+		{http.MethodGet, "/api/users", access.RoleAdmin, true},
+		{http.MethodPatch, "/api/users/alice", access.RoleAdmin, true},
+		{http.MethodGet, "/api/auth/failures", access.RoleAdmin, true},
+		{http.MethodDelete, "/api/auth/lockouts", access.RoleAdmin, true},
+		{http.MethodGet, "/api/audit", access.RoleAdmin, true},
+		{http.MethodGet, "/api/retention", access.RoleViewer, true},
+		{http.MethodGet, "/api/alerts/rules", access.RoleViewer, true},
+		{http.MethodGet, "/api/geofences", access.RoleViewer, true},
+		{http.MethodGet, "/api/geofences/depot", access.RoleViewer, true},
+		{http.MethodGet, "/api/batches", access.RoleViewer, true},
+		{http.MethodGet, "/api/batches/b1", access.RoleViewer, true},
+		{http.MethodPost, "/api/batches", access.RoleOperator, true},
+		{http.MethodGet, "/api/schedules", access.RoleOperator, true},
+		{http.MethodDelete, "/api/schedules/s1", access.RoleOperator, true},
+
+		// HEAD is checked like GET.
+		{http.MethodHead, "/api/geofences", access.RoleViewer, true},
+		{http.MethodHead, "/api/scooters/S1/state", access.RoleViewer, false},
+
+		// Unlisted reads need a viewer, unlisted writes a fleet-wide admin.
+		{http.MethodGet, "/api/scooters", access.RoleViewer, false},
+		{http.MethodGet, "/api/scooters/S1/history", access.RoleViewer, false},
+		{http.MethodPost, "/api/geofences", access.RoleAdmin, true},
+		{http.MethodDelete, "/api/batches/b1", access.RoleAdmin, true},
+		{http.MethodPut, "/api/scooters/S1/groups", access.RoleAdmin, true},
+		{http.MethodPost, "/api/unknown", access.RoleAdmin, true},
+	}
+
+	var principals []*access.Principal
+	for _, role := range []string{access.RoleViewer, access.RoleOperator, access.RoleAdmin} {
+		principals = append(principals,
+			&access.Principal{Name: role, Role: role},
+			&access.Principal{Name: role + "-scoped", Role: role, Scooters: []string{"S1"}},
+		)
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.path, nil)
+		for _, p := range principals {
+			want := p.Allows(tt.role) && (!tt.fleet || p.Fleet())
+			if got := authorize(p, r) == ""; got != want {
+				t.Errorf("%s %s as %s: allowed = %t, want %t", tt.method, tt.path, p.Name, got, want)
+			}
+		}
+	}
+}
+
+func TestAuthorizeScope(t *testing.T) {
+	policy, err := access.New(models.AuthConfig{}, nil, func(id string) []string {
+		if id == "S2" {
+			return []string{"depot-a"}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	single, _ := policy.Grant("single", models.Grant{Role: access.RoleAdmin, Scooters: []string{"S1"}})
+	depot, _ := policy.Grant("depot", models.Grant{Role: access.RoleAdmin, Groups: []string{"depot-a"}})
+
+	tests := []struct {
+		p            *access.Principal
+		method, path string
+		want         bool
+	}{
+		{single, http.MethodGet, "/api/scooters", true},
+		{single, http.MethodGet, "/api/scooters/S1", true},
+		{single, http.MethodGet, "/api/scooters/S1/state", true},
+		{single, http.MethodGet, "/api/scooters/S2/state", false},
+		{single, http.MethodPost, "/api/scooters/S2/config", false},
+		{single, http.MethodGet, "/api/registry/S1", true},
+		{single, http.MethodGet, "/api/registry/S2", false},
+		{depot, http.MethodGet, "/api/scooters/S2/history", true},
+		{depot, http.MethodDelete, "/api/scooters/S2/events", true},
+		{depot, http.MethodGet, "/api/scooters/S1/history", false},
+		{depot, http.MethodGet, "/api/registry/S1/token", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.path, nil)
+		if got := authorize(tt.p, r); (got == "") != tt.want {
+			t.Errorf("%s %s as %s: refusal %q, want allowed = %t", tt.method, tt.path, tt.p.Name, got, tt.want)
+		}
+	}
+}
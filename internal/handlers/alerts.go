@@ -0,0 +1,222 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/librescoot/uplink-server/internal/alerts"
+	"github.com/librescoot/uplink-server/internal/store"
+)
+
+// SetAlerts enables the alert endpoints.
+func (h *APIHandler) SetAlerts(e *alerts.Engine) {
+	h.alerts = e
+}
+
+// HandleAlerts handles GET /api/alerts. Accepts optional
+// ?status=firing|resolved, ?scooter and ?limit (default 100).
+func (h *APIHandler) HandleAlerts(w http.ResponseWriter, r *http.Request) {
+	h.cors(h.authenticate(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			h.writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		if h.alerts == nil {
+			h.writeError(w, http.StatusServiceUnavailable, "Alerting is not enabled")
+			return
+		}
+
+		status := r.URL.Query().Get("status")
+		if status != "" && status != store.AlertFiring && status != store.AlertResolved {
+			h.writeError(w, http.StatusBadRequest, "status must be firing or resolved")
+			return
+		}
+		limit := 100
+		if v := r.URL.Query().Get("limit"); v != "" {
+			if n, err := strconv.Atoi(v); err == nil && n > 0 {
+				limit = n
+			}
+		}
+		p := h.principal(r)
+		scooter := r.URL.Query().Get("scooter")
+		if scooter != "" && !p.CanAccess(scooter) {
+			h.writeError(w, http.StatusForbidden, "Scooter is outside your scope")
+			return
+		}
+		list, err := h.db.ListAlerts(status, scooter, limit)
+		if err != nil {
+			h.writeError(w, http.StatusInternalServerError, "Failed to query alerts")
+			return
+		}
+		if !p.Fleet() {
+			list = slices.DeleteFunc(list, func(a store.Alert) bool { return !p.CanAccess(a.ScooterID) })
+		}
+		if list == nil {
+			list = []store.Alert{}
+		}
+		h.writeJSON(w, http.StatusOK, map[string]any{
+			"alerts": list,
+			"total":  len(list),
+		})
+	}))(w, r)
+}
+
+// HandleAlertDetail handles /api/alerts/*:
+//
+//	GET    /api/alerts/{id}
+//	POST   /api/alerts/{id}/ack
+//	GET    /api/alerts/rules
+//	POST   /api/alerts/rules
+//	DELETE /api/alerts/rules/{name}
+func (h *APIHandler) HandleAlertDetail(w http.ResponseWriter, r *http.Request) {
+	h.cors(h.authenticate(func(w http.ResponseWriter, r *http.Request) {
+		if h.alerts == nil {
+			h.writeError(w, http.StatusServiceUnavailable, "Alerting is not enabled")
+			return
+		}
+		param := extractPathParam(r.URL.Path, "/api/alerts/")
+
+		switch {
+		case param == "rules":
+			switch r.Method {
+			case http.MethodGet:
+				rules := h.alerts.Rules()
+				h.writeJSON(w, http.StatusOK, map[string]any{
+					"rules": rules,
+					"total": len(rules),
+				})
+			case http.MethodPost:
+				h.handleSaveAlertRule(w, r)
+			default:
+				h.writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			}
+
+		case strings.HasPrefix(param, "rules/"):
+			if r.Method != http.MethodDelete {
+				h.writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+				return
+			}
+			name := strings.TrimPrefix(param, "rules/")
+			ok, err := h.alerts.DeleteRule(name)
+			if errors.Is(err, alerts.ErrConfigRule) {
+				h.writeError(w, http.StatusConflict, err.Error())
+				return
+			}
+			if err != nil {
+				h.writeError(w, http.StatusInternalServerError, "Failed to delete rule")
+				return
+			}
+			if !ok {
+				h.writeError(w, http.StatusNotFound, "Rule not found")
+				return
+			}
+			h.writeJSON(w, http.StatusOK, map[string]any{
+				"name":    name,
+				"message": "Rule removed",
+			})
+
+		case strings.HasSuffix(param, "/ack"):
+			if r.Method != http.MethodPost {
+				h.writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+				return
+			}
+			id, err := strconv.ParseInt(strings.TrimSuffix(param, "/ack"), 10, 64)
+			if err != nil {
+				h.writeError(w, http.StatusBadRequest, "Alert ID required")
+				return
+			}
+			if !h.alertInScope(w, r, id) {
+				return
+			}
+			alert, ok, err := h.db.AckAlert(id, h.requestUser(r), time.Now())
+			if err != nil {
+				h.writeError(w, http.StatusInternalServerError, "Failed to acknowledge alert")
+				return
+			}
+			if !ok {
+				h.writeError(w, http.StatusNotFound, "Alert not found")
+				return
+			}
+			h.writeJSON(w, http.StatusOK, alert)
+
+		default:
+			if r.Method != http.MethodGet {
+				h.writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+				return
+			}
+			id, err := strconv.ParseInt(param, 10, 64)
+			if err != nil {
+				h.writeError(w, http.StatusBadRequest, "Alert ID required")
+				return
+			}
+			alert, ok, err := h.db.GetAlert(id)
+			if err != nil {
+				h.writeError(w, http.StatusInternalServerError, "Failed to query alert")
+				return
+			}
+			if !ok {
+				h.writeError(w, http.StatusNotFound, "Alert not found")
+				return
+			}
+			if !h.principal(r).CanAccess(alert.ScooterID) {
+				h.writeError(w, http.StatusForbidden, "Scooter is outside your scope")
+				return
+			}
+			h.writeJSON(w, http.StatusOK, alert)
+		}
+	}))(w, r)
+}
+
+// alertInScope checks that the alert's scooter is within the principal's
+// scope, writing an error response if it is not.
+func (h *APIHandler) alertInScope(w http.ResponseWriter, r *http.Request, id int64) bool {
+	p := h.principal(r)
+	if p.Fleet() {
+		return true
+	}
+	alert, ok, err := h.db.GetAlert(id)
+	if err != nil {
+		h.writeError(w, http.StatusInternalServerError, "Failed to query alert")
+		return false
+	}
+	if ok && !p.CanAccess(alert.ScooterID) {
+		h.writeError(w, http.StatusForbidden, "Scooter is outside your scope")
+		return false
+	}
+	return true
+}
+
+// handleSaveAlertRule creates or replaces an API-defined alert rule.
+func (h *APIHandler) handleSaveAlertRule(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, "Failed to read request body")
+		return
+	}
+	var rule alerts.Rule
+	if err := json.Unmarshal(body, &rule); err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid JSON format")
+		return
+	}
+	if err := rule.Compile(); err != nil {
+		h.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	err = h.alerts.SaveRule(rule)
+	if errors.Is(err, alerts.ErrConfigRule) {
+		h.writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		h.writeError(w, http.StatusInternalServerError, "Failed to save rule")
+		return
+	}
+	rule.Source = alerts.SourceAPI
+	h.writeJSON(w, http.StatusCreated, rule)
+}
@@ -1,19 +1,27 @@
 package handlers
 
 import (
-	"crypto/subtle"
 	"encoding/json"
 	"io"
 	"log"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/librescoot/uplink-server/internal/access"
+	"github.com/librescoot/uplink-server/internal/alerts"
+	"github.com/librescoot/uplink-server/internal/auth"
+	"github.com/librescoot/uplink-server/internal/batch"
+	"github.com/librescoot/uplink-server/internal/geofence"
+	"github.com/librescoot/uplink-server/internal/lockout"
 	"github.com/librescoot/uplink-server/internal/registry"
+	"github.com/librescoot/uplink-server/internal/scheduler"
 	"github.com/librescoot/uplink-server/internal/session"
 	"github.com/librescoot/uplink-server/internal/storage"
 	"github.com/librescoot/uplink-server/internal/store"
+	"github.com/librescoot/uplink-server/internal/webhooks"
 )
 
 // defaultQueueTTL is how long an offline-queued command remains deliverable
@@ -27,16 +35,24 @@ type APIHandler struct {
 	responseStore *storage.ResponseStore
 	stateStore    *storage.StateStore
 	eventStore    *storage.EventStore
-	db            *store.Store       // durable persistence; may be nil
-	registry      *registry.Registry // scooter registration; may be nil
-	sessions      *session.Store     // login sessions; may be nil
-	users         map[string]string  // username -> password
-	apiKey        string
+	db            *store.Store         // durable persistence; may be nil
+	registry      *registry.Registry   // scooter registration; may be nil
+	sessions      *session.Store       // login sessions; may be nil
+	pruner        *store.Pruner        // retention pruning; may be nil
+	geofences     *geofence.Monitor    // geofence evaluation; may be nil
+	alerts        *alerts.Engine       // alert rules; may be nil
+	webhooks      *webhooks.Dispatcher // outbound webhooks; may be nil
+	scheduler     *scheduler.Scheduler // scheduled commands; may be nil
+	batches       *batch.Manager       // bulk commands; may be nil
+	targets       *scheduler.Resolver  // group/tag targeting for config pushes; may be nil
+	lockout       *lockout.Guard       // failed login throttling; may be nil
+	policy        *access.Policy
 }
 
 // NewAPIHandler creates a new API handler. db and reg may be nil to disable
 // durable history endpoints and runtime scooter registration respectively.
-func NewAPIHandler(ws *WebSocketHandler, mgr *storage.ConnectionManager, respStore *storage.ResponseStore, stateStore *storage.StateStore, eventStore *storage.EventStore, db *store.Store, reg *registry.Registry, sessions *session.Store, users map[string]string, apiKey string) *APIHandler {
+// policy decides who may call which endpoint.
+func NewAPIHandler(ws *WebSocketHandler, mgr *storage.ConnectionManager, respStore *storage.ResponseStore, stateStore *storage.StateStore, eventStore *storage.EventStore, db *store.Store, reg *registry.Registry, sessions *session.Store, policy *access.Policy) *APIHandler {
 	return &APIHandler{
 		wsHandler:     ws,
 		connMgr:       mgr,
@@ -46,8 +62,7 @@ func NewAPIHandler(ws *WebSocketHandler, mgr *storage.ConnectionManager, respSto
 		db:            db,
 		registry:      reg,
 		sessions:      sessions,
-		users:         users,
-		apiKey:        apiKey,
+		policy:        policy,
 	}
 }
 
@@ -82,8 +97,8 @@ func (h *APIHandler) HandleCommandResponse(w http.ResponseWriter, r *http.Reques
 	}))(w, r)
 }
 
-// HandleScooters handles GET /api/scooters (list connected) and
-// POST /api/scooters (register a new scooter).
+// HandleScooters handles GET /api/scooters (list connected, filtered by
+// ?group and ?tag) and POST /api/scooters (register a new scooter).
 func (h *APIHandler) HandleScooters(w http.ResponseWriter, r *http.Request) {
 	h.cors(h.authenticate(func(w http.ResponseWriter, r *http.Request) {
 		if r.URL.Path != "/api/scooters" {
@@ -102,7 +117,8 @@ func (h *APIHandler) HandleScooters(w http.ResponseWriter, r *http.Request) {
 }
 
 // HandleRegistry handles GET /api/registry: all registered scooters with a
-// connected flag (including those currently offline).
+// connected flag (including those currently offline), optionally filtered by
+// ?group and ?tag.
 func (h *APIHandler) HandleRegistry(w http.ResponseWriter, r *http.Request) {
 	h.cors(h.authenticate(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
@@ -113,15 +129,16 @@ func (h *APIHandler) HandleRegistry(w http.ResponseWriter, r *http.Request) {
 			h.writeError(w, http.StatusServiceUnavailable, "Registry is not enabled")
 			return
 		}
+		filter := parseLabelFilter(r)
+		p := h.principal(r)
 		list := h.registry.List()
+		sort.Slice(list, func(i, j int) bool { return list[i].Identifier < list[j].Identifier })
 		scooters := make([]map[string]any, 0, len(list))
 		for _, s := range list {
-			_, connected := h.connMgr.GetConnection(s.Identifier)
-			scooters = append(scooters, map[string]any{
-				"identifier": s.Identifier,
-				"name":       s.Name,
-				"connected":  connected,
-			})
+			if !filter.match(s) || !p.CanAccess(s.Identifier) {
+				continue
+			}
+			scooters = append(scooters, h.registryEntry(s))
 		}
 		h.writeJSON(w, http.StatusOK, map[string]any{
 			"scooters": scooters,
@@ -149,6 +166,7 @@ func (h *APIHandler) handleCreateScooter(w http.ResponseWriter, r *http.Request)
 		h.writeError(w, http.StatusBadRequest, "Invalid JSON format")
 		return
 	}
+	h.audit(r, "scooter.create", req.Identifier, map[string]any{"name": req.Name})
 	if req.Identifier == "" {
 		h.writeError(w, http.StatusBadRequest, "identifier is required")
 		return
@@ -172,6 +190,7 @@ func (h *APIHandler) handleCreateScooter(w http.ResponseWriter, r *http.Request)
 
 // handleDeleteScooter removes a registered scooter.
 func (h *APIHandler) handleDeleteScooter(w http.ResponseWriter, r *http.Request, scooterID string) {
+	h.audit(r, "scooter.delete", scooterID, nil)
 	if h.registry == nil {
 		h.writeError(w, http.StatusServiceUnavailable, "Registry is not enabled")
 		return
@@ -212,6 +231,28 @@ func (h *APIHandler) HandleScooterDetail(w http.ResponseWriter, r *http.Request)
 				return
 			}
 			h.handleGetScooterHistory(w, r, scooterID)
+		} else if isTripsRequest(r.URL.Path) {
+			if r.Method != http.MethodGet {
+				h.writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+				return
+			}
+			scooterID := extractScooterIDForSuffix(r.URL.Path, "/trips")
+			if scooterID == "" {
+				h.writeError(w, http.StatusBadRequest, "Scooter ID required")
+				return
+			}
+			h.handleGetScooterTrips(w, r, scooterID)
+		} else if isTrackRequest(r.URL.Path) {
+			if r.Method != http.MethodGet {
+				h.writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+				return
+			}
+			scooterID := extractScooterIDForSuffix(r.URL.Path, "/track")
+			if scooterID == "" {
+				h.writeError(w, http.StatusBadRequest, "Scooter ID required")
+				return
+			}
+			h.handleGetScooterTrack(w, r, scooterID)
 		} else if isCommandHistoryRequest(r.URL.Path) {
 			if r.Method != http.MethodGet {
 				h.writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
@@ -292,11 +333,24 @@ func (h *APIHandler) handleSendCommand(w http.ResponseWriter, r *http.Request) {
 		h.writeError(w, http.StatusBadRequest, "Invalid JSON format")
 		return
 	}
+	h.audit(r, "command.send", req.ScooterID, map[string]any{
+		"command": req.Command,
+		"params":  req.Params,
+		"queue":   req.Queue,
+	})
 
 	if req.ScooterID == "" || req.Command == "" {
 		h.writeError(w, http.StatusBadRequest, "scooter_id and command are required")
 		return
 	}
+	if !h.principal(r).CanAccess(req.ScooterID) {
+		h.writeError(w, http.StatusForbidden, "Scooter is outside your scope")
+		return
+	}
+	if reason := h.checkCommand(r, req.Command); reason != "" {
+		h.writeError(w, http.StatusForbidden, reason)
+		return
+	}
 
 	if req.Params == nil {
 		req.Params = make(map[string]any)
@@ -304,6 +358,7 @@ func (h *APIHandler) handleSendCommand(w http.ResponseWriter, r *http.Request) {
 
 	requestID, err := h.wsHandler.SendCommand(req.ScooterID, req.Command, req.Params)
 	if err == nil {
+		h.auditParam(r, "request_id", requestID)
 		h.writeJSON(w, http.StatusCreated, map[string]any{
 			"request_id": requestID,
 			"status":     "sent",
@@ -312,6 +367,10 @@ func (h *APIHandler) handleSendCommand(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if status, msg, ok := commandRejection(err); ok {
+		h.writeError(w, status, msg)
+		return
+	}
 	if err == ErrSendChannelFull {
 		h.writeError(w, http.StatusServiceUnavailable, "Send channel full, try again later")
 		return
@@ -327,11 +386,6 @@ func (h *APIHandler) handleSendCommand(w http.ResponseWriter, r *http.Request) {
 		h.writeError(w, http.StatusNotFound, "Scooter not connected")
 		return
 	}
-	if !IsQueueable(req.Command) {
-		h.writeError(w, http.StatusConflict, "Command may not be queued while offline")
-		return
-	}
-
 	ttl := defaultQueueTTL
 	if req.TTL != "" {
 		if d, perr := time.ParseDuration(req.TTL); perr == nil {
@@ -339,10 +393,15 @@ func (h *APIHandler) handleSendCommand(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 	queuedID, qerr := h.wsHandler.EnqueueCommand(req.ScooterID, req.Command, req.Params, ttl)
+	if status, msg, ok := commandRejection(qerr); ok {
+		h.writeError(w, status, msg)
+		return
+	}
 	if qerr != nil {
 		h.writeError(w, http.StatusInternalServerError, "Failed to queue command")
 		return
 	}
+	h.auditParam(r, "request_id", queuedID)
 	h.writeJSON(w, http.StatusAccepted, map[string]any{
 		"request_id": queuedID,
 		"status":     "queued",
@@ -354,6 +413,22 @@ func (h *APIHandler) handleSendCommand(w http.ResponseWriter, r *http.Request) {
 func (h *APIHandler) handleGetCommandResponse(w http.ResponseWriter, r *http.Request, requestID string) {
 	record, exists := h.responseStore.Get(requestID)
 	if !exists {
+		// Outcomes decided by the server survive restarts only in the
+		// command history.
+		if h.db != nil {
+			if rec, ok, err := h.db.GetCommand(requestID); err == nil && ok && h.principal(r).CanAccess(rec.ScooterID) &&
+				(rec.Status == store.StatusTimedOut || rec.Status == store.StatusExpired) {
+				h.writeJSON(w, http.StatusOK, map[string]any{
+					"request_id": rec.RequestID,
+					"scooter_id": rec.ScooterID,
+					"command":    rec.Command,
+					"status":     rec.Status,
+					"error":      rec.Error,
+					"attempts":   rec.Attempts,
+				})
+				return
+			}
+		}
 		h.writeJSON(w, http.StatusOK, map[string]any{
 			"request_id": requestID,
 			"status":     "pending",
@@ -362,6 +437,11 @@ func (h *APIHandler) handleGetCommandResponse(w http.ResponseWriter, r *http.Req
 		return
 	}
 
+	if !h.principal(r).CanAccess(record.ScooterID) {
+		h.writeError(w, http.StatusForbidden, "Scooter is outside your scope")
+		return
+	}
+
 	response := map[string]any{
 		"request_id":  record.RequestID,
 		"scooter_id":  record.ScooterID,
@@ -387,9 +467,21 @@ func (h *APIHandler) handleGetCommandResponse(w http.ResponseWriter, r *http.Req
 // handleListScooters lists all connected scooters
 func (h *APIHandler) handleListScooters(w http.ResponseWriter, r *http.Request) {
 	connections := h.connMgr.GetAllConnections()
+	filter := parseLabelFilter(r)
+	p := h.principal(r)
 
 	scooters := make([]map[string]any, 0, len(connections))
 	for _, conn := range connections {
+		if !p.CanAccess(conn.Identifier) {
+			continue
+		}
+		var info auth.ScooterInfo
+		if h.registry != nil {
+			info, _ = h.registry.Get(conn.Identifier)
+		}
+		if !filter.match(info) {
+			continue
+		}
 		stats := conn.GetStats()
 		scooters = append(scooters, map[string]any{
 			"identifier":     stats["identifier"],
@@ -398,6 +490,8 @@ func (h *APIHandler) handleListScooters(w http.ResponseWriter, r *http.Request)
 			"last_seen":      stats["last_seen"],
 			"uptime_seconds": stats["uptime_seconds"],
 			"authenticated":  stats["authenticated"],
+			"groups":         info.Groups,
+			"tags":           info.Tags,
 		})
 	}
 
@@ -452,7 +546,9 @@ func (h *APIHandler) handleGetScooterCommands(w http.ResponseWriter, r *http.Req
 }
 
 // authenticate middleware accepts either the configured API key or a valid
-// login session token, both presented via X-API-Key.
+// login session token, both presented via X-API-Key. Writes by an
+// authenticated principal, including refused ones, are recorded in the audit
+// log.
 func (h *APIHandler) authenticate(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == http.MethodOptions {
@@ -460,30 +556,36 @@ func (h *APIHandler) authenticate(next http.HandlerFunc) http.HandlerFunc {
 			return
 		}
 
-		if !h.validCredential(r.Header.Get("X-API-Key")) {
+		p, ok := h.policy.Authenticate(r.Header.Get("X-API-Key"))
+		if !ok {
 			h.writeError(w, http.StatusUnauthorized, "Invalid or missing credentials")
 			return
 		}
+		if !isWrite(r) {
+			if reason := authorize(p, r); reason != "" {
+				h.writeError(w, http.StatusForbidden, reason)
+				return
+			}
+			next(w, withPrincipal(r, p))
+			return
+		}
 
-		next(w, r)
+		rec := newAuditRecord(r)
+		if reason := authorize(p, r); reason != "" {
+			h.writeError(w, http.StatusForbidden, reason)
+			h.recordAudit(r, p.Name, rec, http.StatusForbidden, reason)
+			return
+		}
+		aw := &auditWriter{ResponseWriter: w}
+		next(aw, withAudit(withPrincipal(r, p), rec))
+		h.recordAudit(r, p.Name, rec, aw.status, aw.errorMessage())
 	}
 }
 
-// validCredential reports whether key is the configured API key or a valid
-// session token.
-func (h *APIHandler) validCredential(key string) bool {
-	if key == "" {
-		return false
-	}
-	if subtle.ConstantTimeCompare([]byte(key), []byte(h.apiKey)) == 1 {
-		return true
-	}
-	if h.sessions != nil {
-		if _, ok := h.sessions.Validate(key); ok {
-			return true
-		}
-	}
-	return false
+// requestUser names who made an authenticated request: the session's
+// username, the API key's name, or "api-key" for the shared key.
+func (h *APIHandler) requestUser(r *http.Request) string {
+	return h.principal(r).Name
 }
 
 // HandleLogin handles POST /api/login with a username/password body and returns
@@ -494,7 +596,7 @@ func (h *APIHandler) HandleLogin(w http.ResponseWriter, r *http.Request) {
 			h.writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
 			return
 		}
-		if h.sessions == nil || len(h.users) == 0 {
+		if !h.policy.PasswordLogin() {
 			h.writeError(w, http.StatusServiceUnavailable, "Password login is not enabled")
 			return
 		}
@@ -513,21 +615,45 @@ func (h *APIHandler) HandleLogin(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		if !h.checkPassword(req.Username, req.Password) {
+		rec := &auditRecord{action: "login"}
+		var ip string
+		if h.lockout != nil {
+			ip = h.lockout.ClientIP(r)
+			if wait := h.lockout.Check(lockout.KindLogin, req.Username, ip, time.Now()); wait > 0 {
+				h.writeLockedOut(w, wait)
+				h.recordAudit(r, req.Username, rec, http.StatusTooManyRequests, "locked out")
+				return
+			}
+		}
+		if !h.policy.CheckPassword(req.Username, req.Password) {
+			log.Printf("[API] Failed login for %q from %s", req.Username, r.RemoteAddr)
+			if h.lockout != nil {
+				h.lockout.Fail(lockout.KindLogin, req.Username, ip, "invalid username or password", time.Now())
+			}
 			h.writeError(w, http.StatusUnauthorized, "Invalid username or password")
+			h.recordAudit(r, req.Username, rec, http.StatusUnauthorized, "invalid username or password")
 			return
 		}
+		if h.lockout != nil {
+			h.lockout.Succeed(lockout.KindLogin, req.Username, ip)
+		}
 
-		token, ttl, err := h.sessions.Create(req.Username)
+		token, ttl, err := h.sessions.Create(req.Username, r.UserAgent(), h.clientIP(r))
 		if err != nil {
 			h.writeError(w, http.StatusInternalServerError, "Failed to create session")
+			h.recordAudit(r, req.Username, rec, http.StatusInternalServerError, "failed to create session")
 			return
 		}
-		h.writeJSON(w, http.StatusOK, map[string]any{
+		h.recordAudit(r, req.Username, rec, http.StatusOK, "")
+		resp := map[string]any{
 			"token":      token,
 			"expires_in": int(ttl.Seconds()),
 			"username":   req.Username,
-		})
+		}
+		if p, ok := h.policy.User(req.Username); ok {
+			resp["role"] = p.Role
+		}
+		h.writeJSON(w, http.StatusOK, resp)
 	})(w, r)
 }
 
@@ -540,30 +666,21 @@ func (h *APIHandler) HandleLogout(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		if h.sessions != nil {
-			h.sessions.Delete(r.Header.Get("X-API-Key"))
+			key := r.Header.Get("X-API-Key")
+			if name, ok := h.sessions.Validate(key); ok {
+				h.recordAudit(r, name, &auditRecord{action: "logout"}, http.StatusOK, "")
+			}
+			h.sessions.Delete(key)
 		}
 		h.writeJSON(w, http.StatusOK, map[string]any{"message": "Logged out"})
 	})(w, r)
 }
 
-// checkPassword validates credentials in constant time against the configured
-// users. It always performs a comparison to avoid leaking which usernames
-// exist via timing.
-func (h *APIHandler) checkPassword(username, password string) bool {
-	stored, ok := h.users[username]
-	if !ok {
-		// Compare against a dummy value so timing is similar for unknown users.
-		subtle.ConstantTimeCompare([]byte(password), []byte("dummy-placeholder"))
-		return false
-	}
-	return subtle.ConstantTimeCompare([]byte(password), []byte(stored)) == 1
-}
-
 // cors middleware adds CORS headers
 func (h *APIHandler) cors(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
 		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, X-API-Key")
 
 		if r.Method == http.MethodOptions {
@@ -655,6 +772,7 @@ func (h *APIHandler) handlePushConfig(w http.ResponseWriter, r *http.Request, sc
 		h.writeError(w, http.StatusBadRequest, "Invalid JSON format")
 		return
 	}
+	h.audit(r, "config.push", scooterID, map[string]any{"deltas": req.Deltas, "restart": req.Restart})
 	if len(req.Deltas) == 0 {
 		h.writeError(w, http.StatusBadRequest, "deltas are required")
 		return
@@ -684,7 +802,9 @@ func extractScooterIDForSuffix(path, suffix string) string {
 }
 
 // handleGetScooterHistory returns persisted telemetry history for a scooter.
-// Accepts optional ?from and ?to (RFC3339) and ?limit query parameters.
+// Accepts optional ?from and ?to (RFC3339), ?limit and
+// ?resolution=auto|raw|1m|15m|1h (default raw; auto reads raw snapshots if
+// they fit in the limit and otherwise the finest tier that does).
 func (h *APIHandler) handleGetScooterHistory(w http.ResponseWriter, r *http.Request, scooterID string) {
 	if h.db == nil {
 		h.writeError(w, http.StatusServiceUnavailable, "History persistence is not enabled")
@@ -709,19 +829,49 @@ func (h *APIHandler) handleGetScooterHistory(w http.ResponseWriter, r *http.Requ
 			limit = n
 		}
 	}
-
-	rows, err := h.db.QueryTelemetry(scooterID, from, to, limit)
-	if err != nil {
-		h.writeError(w, http.StatusInternalServerError, "Failed to query history")
+	resolution := r.URL.Query().Get("resolution")
+	if resolution == "" {
+		resolution = store.ResolutionRaw
+	}
+	if !store.ValidResolution(resolution) {
+		h.writeError(w, http.StatusBadRequest, "resolution must be one of auto, raw, 1m, 15m, 1h")
 		return
 	}
+	if resolution == store.ResolutionAuto {
+		var err error
+		if resolution, err = h.db.ResolveAuto(scooterID, from, to, limit); err != nil {
+			h.writeError(w, http.StatusInternalServerError, "Failed to query history")
+			return
+		}
+	}
+
+	var (
+		history any
+		count   int
+	)
+	if resolution == store.ResolutionRaw {
+		rows, err := h.db.QueryTelemetry(scooterID, from, to, limit)
+		if err != nil {
+			h.writeError(w, http.StatusInternalServerError, "Failed to query history")
+			return
+		}
+		history, count = rows, len(rows)
+	} else {
+		rows, err := h.db.QueryRollups(scooterID, resolution, from, to, limit)
+		if err != nil {
+			h.writeError(w, http.StatusInternalServerError, "Failed to query history")
+			return
+		}
+		history, count = rows, len(rows)
+	}
 
 	h.writeJSON(w, http.StatusOK, map[string]any{
 		"scooter_id": scooterID,
 		"from":       from.Format(time.RFC3339),
 		"to":         to.Format(time.RFC3339),
-		"count":      len(rows),
-		"history":    rows,
+		"resolution": resolution,
+		"count":      count,
+		"history":    history,
 	})
 }
 
@@ -786,6 +936,7 @@ func (h *APIHandler) handleGetScooterEvents(w http.ResponseWriter, r *http.Reque
 
 // handleDeleteScooterEvent deletes a single event
 func (h *APIHandler) handleDeleteScooterEvent(w http.ResponseWriter, r *http.Request, scooterID, eventID string) {
+	h.audit(r, "event.delete", scooterID, map[string]any{"event_id": eventID})
 	_, exists := h.connMgr.GetConnection(scooterID)
 	if !exists {
 		h.writeError(w, http.StatusNotFound, "Scooter not connected")
@@ -805,6 +956,7 @@ func (h *APIHandler) handleDeleteScooterEvent(w http.ResponseWriter, r *http.Req
 
 // handleClearScooterEvents clears all events for a scooter
 func (h *APIHandler) handleClearScooterEvents(w http.ResponseWriter, r *http.Request, scooterID string) {
+	h.audit(r, "events.clear", scooterID, nil)
 	_, exists := h.connMgr.GetConnection(scooterID)
 	if !exists {
 		h.writeError(w, http.StatusNotFound, "Scooter not connected")
@@ -0,0 +1,275 @@
+package handlers
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/librescoot/uplink-server/internal/store"
+)
+
+// maxAuditBody bounds how much of an error response is kept to extract its
+// message.
+const maxAuditBody = 1024
+
+// auditRecord is how a write request appears in the audit log. authenticate
+// fills in defaults; handlers name the action with audit.
+type auditRecord struct {
+	action    string
+	scooterID string
+	params    map[string]any
+}
+
+type auditKey struct{}
+
+// newAuditRecord returns the default record of a request: its method and
+// path, and the scooter a scoped path addresses.
+func newAuditRecord(r *http.Request) *auditRecord {
+	rec := &auditRecord{action: r.Method + " " + r.URL.Path}
+	for _, prefix := range scopedPaths {
+		if rest, ok := strings.CutPrefix(r.URL.Path, prefix); ok {
+			rec.scooterID, _, _ = strings.Cut(rest, "/")
+			break
+		}
+	}
+	return rec
+}
+
+func withAudit(r *http.Request, rec *auditRecord) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), auditKey{}, rec))
+}
+
+// audit names the action a request performs, the scooter it targets (empty
+// keeps the one taken from the path) and its parameters for the audit log.
+// Secrets must not be passed in params.
+func (h *APIHandler) audit(r *http.Request, action, scooterID string, params map[string]any) {
+	rec, ok := r.Context().Value(auditKey{}).(*auditRecord)
+	if !ok {
+		return
+	}
+	rec.action = action
+	if scooterID != "" {
+		rec.scooterID = scooterID
+	}
+	rec.params = params
+}
+
+// auditParam adds a parameter to the request's audit record, e.g. a result
+// only known once the action succeeded.
+func (h *APIHandler) auditParam(r *http.Request, key string, value any) {
+	rec, ok := r.Context().Value(auditKey{}).(*auditRecord)
+	if !ok {
+		return
+	}
+	if rec.params == nil {
+		rec.params = map[string]any{}
+	}
+	rec.params[key] = value
+}
+
+// auditWriter captures the status of a response and the start of its body
+// if it is an error.
+type auditWriter struct {
+	http.ResponseWriter
+	status int
+	body   []byte
+}
+
+func (w *auditWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *auditWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.status >= 400 && len(w.body) < maxAuditBody {
+		w.body = append(w.body, b[:min(len(b), maxAuditBody-len(w.body))]...)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *auditWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// errorMessage returns the message of a captured error response.
+func (w *auditWriter) errorMessage() string {
+	var resp struct {
+		Error string `json:"error"`
+	}
+	json.Unmarshal(w.body, &resp)
+	return resp.Error
+}
+
+// auditOutcome classifies a response status.
+func auditOutcome(status int) string {
+	switch {
+	case status == http.StatusUnauthorized, status == http.StatusForbidden, status == http.StatusTooManyRequests:
+		return store.AuditDenied
+	case status >= 400:
+		return store.AuditFailure
+	default:
+		return store.AuditSuccess
+	}
+}
+
+// clientIP returns the IP a request came from, honouring X-Forwarded-For
+// when the lockout configuration trusts proxies.
+func (h *APIHandler) clientIP(r *http.Request) string {
+	if h.lockout != nil {
+		return h.lockout.ClientIP(r)
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// recordAudit appends an action by actor to the audit log. Without
+// persistence nothing is recorded.
+func (h *APIHandler) recordAudit(r *http.Request, actor string, rec *auditRecord, status int, errMsg string) {
+	if h.db == nil {
+		return
+	}
+	if status == 0 {
+		status = http.StatusOK
+	}
+	e := &store.AuditEntry{
+		Time:      time.Now(),
+		Actor:     actor,
+		Action:    rec.action,
+		ScooterID: rec.scooterID,
+		Params:    rec.params,
+		SourceIP:  h.clientIP(r),
+		Outcome:   auditOutcome(status),
+		Status:    status,
+		Error:     errMsg,
+	}
+	if err := h.db.InsertAudit(e); err != nil {
+		log.Printf("[API] Failed to record audit entry %s by %s: %v", e.Action, actor, err)
+	}
+}
+
+// isWrite reports whether a request changes anything and so is audited.
+func isWrite(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+	return true
+}
+
+// HandleAudit handles GET /api/audit: recorded operator actions, newest
+// first. Accepts ?actor, ?action, ?scooter, ?outcome (success, failure or
+// denied), ?since and ?until (RFC3339), ?limit (default 100) and ?format=csv.
+func (h *APIHandler) HandleAudit(w http.ResponseWriter, r *http.Request) {
+	h.cors(h.authenticate(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			h.writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		if h.db == nil {
+			h.writeError(w, http.StatusServiceUnavailable, "History persistence is not enabled")
+			return
+		}
+
+		q := r.URL.Query()
+		filter := store.AuditFilter{
+			Actor:     q.Get("actor"),
+			Action:    q.Get("action"),
+			ScooterID: q.Get("scooter"),
+			Outcome:   q.Get("outcome"),
+		}
+		switch filter.Outcome {
+		case "", store.AuditSuccess, store.AuditFailure, store.AuditDenied:
+		default:
+			h.writeError(w, http.StatusBadRequest, "outcome must be success, failure or denied")
+			return
+		}
+		for name, t := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+			if v := q.Get(name); v != "" {
+				parsed, err := time.Parse(time.RFC3339, v)
+				if err != nil {
+					h.writeError(w, http.StatusBadRequest, name+" must be an RFC3339 time")
+					return
+				}
+				*t = parsed
+			}
+		}
+		if v := q.Get("limit"); v != "" {
+			if n, err := strconv.Atoi(v); err == nil && n > 0 {
+				filter.Limit = n
+			}
+		}
+
+		list, err := h.db.QueryAudit(filter)
+		if err != nil {
+			h.writeError(w, http.StatusInternalServerError, "Failed to query audit log")
+			return
+		}
+		if list == nil {
+			list = []store.AuditEntry{}
+		}
+
+		switch q.Get("format") {
+		case "", "json":
+			h.writeJSON(w, http.StatusOK, map[string]any{
+				"entries": list,
+				"total":   len(list),
+			})
+		case "csv":
+			writeAuditCSV(w, list)
+		default:
+			h.writeError(w, http.StatusBadRequest, "format must be json or csv")
+		}
+	}))(w, r)
+}
+
+// writeAuditCSV writes audit entries as a CSV download; params are a JSON
+// object in the last column.
+func writeAuditCSV(w http.ResponseWriter, list []store.AuditEntry) {
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="audit.csv"`)
+	w.WriteHeader(http.StatusOK)
+
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"id", "time", "actor", "action", "scooter_id", "source_ip", "outcome", "status", "error", "params"})
+	for _, e := range list {
+		var params string
+		if len(e.Params) > 0 {
+			b, _ := json.Marshal(e.Params)
+			params = string(b)
+		}
+		var status string
+		if e.Status != 0 {
+			status = strconv.Itoa(e.Status)
+		}
+		cw.Write([]string{
+			strconv.FormatInt(e.ID, 10),
+			e.Time.Format(time.RFC3339Nano),
+			e.Actor,
+			e.Action,
+			e.ScooterID,
+			e.SourceIP,
+			e.Outcome,
+			status,
+			e.Error,
+			params,
+		})
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		log.Printf("[API] Failed to write audit CSV: %v", err)
+	}
+}
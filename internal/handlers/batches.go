@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/librescoot/uplink-server/internal/batch"
+	"github.com/librescoot/uplink-server/internal/store"
+)
+
+// SetBatches enables the bulk command endpoints.
+func (h *APIHandler) SetBatches(m *batch.Manager) {
+	h.batches = m
+}
+
+// HandleBatches handles GET /api/batches (recent batches, ?limit, default 50)
+// and POST /api/batches (send a command to many scooters).
+func (h *APIHandler) HandleBatches(w http.ResponseWriter, r *http.Request) {
+	h.cors(h.authenticate(func(w http.ResponseWriter, r *http.Request) {
+		if h.batches == nil {
+			h.writeError(w, http.StatusServiceUnavailable, "Bulk commands are not enabled")
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+			list, err := h.db.ListBatches(limit)
+			if err != nil {
+				h.writeError(w, http.StatusInternalServerError, "Failed to list batches")
+				return
+			}
+			if list == nil {
+				list = []store.Batch{}
+			}
+			h.writeJSON(w, http.StatusOK, map[string]any{
+				"batches": list,
+				"total":   len(list),
+			})
+		case http.MethodPost:
+			h.handleCreateBatch(w, r)
+		default:
+			h.writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		}
+	}))(w, r)
+}
+
+func (h *APIHandler) handleCreateBatch(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, "Failed to read request body")
+		return
+	}
+	var req batch.Request
+	if err := json.Unmarshal(body, &req); err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid JSON format")
+		return
+	}
+	h.audit(r, "batch.create", "", map[string]any{
+		"command":   req.Command,
+		"params":    req.Params,
+		"scooters":  req.Scooters,
+		"groups":    req.Groups,
+		"tags":      req.Tags,
+		"all":       req.All,
+		"condition": req.Condition,
+		"queue":     req.Queue,
+	})
+	if req.Command == "" {
+		h.writeError(w, http.StatusBadRequest, "command is required")
+		return
+	}
+	if req.Params == nil {
+		req.Params = make(map[string]any)
+	}
+	if reason := h.checkCommand(r, req.Command); reason != "" {
+		h.writeError(w, http.StatusForbidden, reason)
+		return
+	}
+
+	targets, err := h.batches.Check(&req)
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	b, err := h.batches.Create(&req, targets, h.requestUser(r))
+	if err != nil {
+		h.writeError(w, http.StatusInternalServerError, "Failed to create batch")
+		return
+	}
+	h.auditParam(r, "batch_id", b.ID)
+	h.writeJSON(w, http.StatusAccepted, b)
+}
+
+// HandleBatch handles GET /api/batches/{id}: the batch's progress and the
+// status of each scooter's command.
+func (h *APIHandler) HandleBatch(w http.ResponseWriter, r *http.Request) {
+	h.cors(h.authenticate(func(w http.ResponseWriter, r *http.Request) {
+		if h.batches == nil {
+			h.writeError(w, http.StatusServiceUnavailable, "Bulk commands are not enabled")
+			return
+		}
+		if r.Method != http.MethodGet {
+			h.writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		id, err := strconv.ParseInt(extractPathParam(r.URL.Path, "/api/batches/"), 10, 64)
+		if err != nil {
+			h.writeError(w, http.StatusBadRequest, "Batch ID required")
+			return
+		}
+		b, ok, err := h.db.GetBatch(id)
+		if err != nil {
+			h.writeError(w, http.StatusInternalServerError, "Failed to query batch")
+			return
+		}
+		if !ok {
+			h.writeError(w, http.StatusNotFound, "Batch not found")
+			return
+		}
+		items, err := h.db.BatchItems(id)
+		if err != nil {
+			h.writeError(w, http.StatusInternalServerError, "Failed to query batch items")
+			return
+		}
+		if items == nil {
+			items = []store.BatchItem{}
+		}
+		h.writeJSON(w, http.StatusOK, map[string]any{
+			"batch": b,
+			"items": items,
+		})
+	}))(w, r)
+}
@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+
+	"github.com/librescoot/uplink-server/internal/commands"
+)
+
+// SetCatalog replaces the command catalog used to validate commands. Must be
+// called before the server starts accepting connections.
+func (h *WebSocketHandler) SetCatalog(c *commands.Catalog) {
+	h.catalog = c
+}
+
+// Catalog returns the command catalog.
+func (h *WebSocketHandler) Catalog() *commands.Catalog {
+	return h.catalog
+}
+
+// HandleCommandCatalog handles GET /api/commands/catalog: every command the
+// server accepts that the caller's role may send, with its parameter schema
+// and UI hints.
+func (h *APIHandler) HandleCommandCatalog(w http.ResponseWriter, r *http.Request) {
+	h.cors(h.authenticate(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			h.writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		p := h.principal(r)
+		list := slices.DeleteFunc(h.wsHandler.Catalog().Commands(), func(c commands.Command) bool {
+			return !p.Allows(c.Role)
+		})
+		h.writeJSON(w, http.StatusOK, map[string]any{
+			"commands": list,
+			"total":    len(list),
+		})
+	}))(w, r)
+}
+
+// commandRejection maps a catalog or queueing error to an HTTP status and
+// message; ok is false for other errors.
+func commandRejection(err error) (status int, msg string, ok bool) {
+	var (
+		paramErr   *commands.ParamError
+		versionErr *commands.VersionError
+	)
+	switch {
+	case errors.Is(err, commands.ErrUnknownCommand), errors.As(err, &paramErr):
+		return http.StatusBadRequest, err.Error(), true
+	case errors.As(err, &versionErr):
+		return http.StatusConflict, err.Error(), true
+	case errors.Is(err, ErrNotQueueable):
+		return http.StatusConflict, "Command may not be queued while offline", true
+	}
+	return 0, "", false
+}
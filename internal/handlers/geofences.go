@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/librescoot/uplink-server/internal/geofence"
+	"github.com/librescoot/uplink-server/internal/store"
+)
+
+// SetGeofences enables the geofence endpoints.
+func (h *APIHandler) SetGeofences(m *geofence.Monitor) {
+	h.geofences = m
+}
+
+// HandleGeofences handles GET /api/geofences (list) and POST /api/geofences
+// (create).
+func (h *APIHandler) HandleGeofences(w http.ResponseWriter, r *http.Request) {
+	h.cors(h.authenticate(func(w http.ResponseWriter, r *http.Request) {
+		if h.geofences == nil {
+			h.writeError(w, http.StatusServiceUnavailable, "Geofencing is not enabled")
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			fences, err := h.db.ListGeofences()
+			if err != nil {
+				h.writeError(w, http.StatusInternalServerError, "Failed to list geofences")
+				return
+			}
+			if fences == nil {
+				fences = []store.Geofence{}
+			}
+			h.writeJSON(w, http.StatusOK, map[string]any{
+				"geofences": fences,
+				"total":     len(fences),
+			})
+		case http.MethodPost:
+			f, ok := h.readGeofence(w, r)
+			if !ok {
+				return
+			}
+			if err := h.db.CreateGeofence(f); err != nil {
+				h.writeError(w, http.StatusInternalServerError, "Failed to create geofence")
+				return
+			}
+			h.reloadGeofences()
+			h.writeJSON(w, http.StatusCreated, f)
+		default:
+			h.writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		}
+	}))(w, r)
+}
+
+// HandleGeofence handles GET/PUT/DELETE /api/geofences/{id}. GET includes the
+// scooters currently inside the fence.
+func (h *APIHandler) HandleGeofence(w http.ResponseWriter, r *http.Request) {
+	h.cors(h.authenticate(func(w http.ResponseWriter, r *http.Request) {
+		if h.geofences == nil {
+			h.writeError(w, http.StatusServiceUnavailable, "Geofencing is not enabled")
+			return
+		}
+		id, err := strconv.ParseInt(extractPathParam(r.URL.Path, "/api/geofences/"), 10, 64)
+		if err != nil {
+			h.writeError(w, http.StatusBadRequest, "Geofence ID required")
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			f, ok, err := h.db.GetGeofence(id)
+			if err != nil {
+				h.writeError(w, http.StatusInternalServerError, "Failed to query geofence")
+				return
+			}
+			if !ok {
+				h.writeError(w, http.StatusNotFound, "Geofence not found")
+				return
+			}
+			inside := h.geofences.Inside(id)
+			if inside == nil {
+				inside = []string{}
+			}
+			h.writeJSON(w, http.StatusOK, map[string]any{
+				"geofence": f,
+				"inside":   inside,
+			})
+		case http.MethodPut:
+			f, ok := h.readGeofence(w, r)
+			if !ok {
+				return
+			}
+			f.ID = id
+			found, err := h.db.UpdateGeofence(f)
+			if err != nil {
+				h.writeError(w, http.StatusInternalServerError, "Failed to update geofence")
+				return
+			}
+			if !found {
+				h.writeError(w, http.StatusNotFound, "Geofence not found")
+				return
+			}
+			h.reloadGeofences()
+			if updated, ok, err := h.db.GetGeofence(id); err == nil && ok {
+				f = updated
+			}
+			h.writeJSON(w, http.StatusOK, f)
+		case http.MethodDelete:
+			found, err := h.db.DeleteGeofence(id)
+			if err != nil {
+				h.writeError(w, http.StatusInternalServerError, "Failed to delete geofence")
+				return
+			}
+			if !found {
+				h.writeError(w, http.StatusNotFound, "Geofence not found")
+				return
+			}
+			h.reloadGeofences()
+			h.writeJSON(w, http.StatusOK, map[string]any{
+				"id":      id,
+				"message": "Geofence removed",
+			})
+		default:
+			h.writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		}
+	}))(w, r)
+}
+
+// readGeofence decodes and validates a geofence from the request body,
+// writing an error response on failure.
+func (h *APIHandler) readGeofence(w http.ResponseWriter, r *http.Request) (*store.Geofence, bool) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, "Failed to read request body")
+		return nil, false
+	}
+	var f store.Geofence
+	if err := json.Unmarshal(body, &f); err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid JSON format")
+		return nil, false
+	}
+	if err := geofence.Validate(&f); err != nil {
+		h.writeError(w, http.StatusBadRequest, err.Error())
+		return nil, false
+	}
+	return &f, true
+}
+
+func (h *APIHandler) reloadGeofences() {
+	if err := h.geofences.Reload(); err != nil {
+		log.Printf("[API] Failed to reload geofences: %v", err)
+	}
+}
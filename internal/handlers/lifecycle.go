@@ -0,0 +1,234 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/librescoot/uplink-server/internal/models"
+	"github.com/librescoot/uplink-server/internal/protocol"
+	"github.com/librescoot/uplink-server/internal/store"
+)
+
+// commandSweepInterval is how often overdue commands are retried or timed out.
+const commandSweepInterval = 5 * time.Second
+
+// CommandPolicy controls how long the server waits for a scooter to answer a
+// command and when it sends the command again.
+type CommandPolicy struct {
+	AckTimeout     time.Duration   // per attempt; 0 disables timeouts and retries
+	Retries        int             // extra attempts for idempotent commands
+	Idempotent     map[string]bool // commands safe to resend while the scooter is connected
+	DeliveryWindow time.Duration   // how long after sending an unanswered command is still resent on reconnect; 0 = no limit
+	RunTimeout     time.Duration   // how long a running command may go without progress; 0 = no limit
+}
+
+// defaultIdempotent lists commands that set an absolute state, so running
+// them twice has the same effect as running them once.
+var defaultIdempotent = []string{
+	"get_state", "ping", "lock", "lock_hibernate", "handlebar_lock",
+	"blinker_left", "blinker_right", "blinker_both", "blinker_off",
+	"dashboard_on", "dashboard_off",
+	"alarm_arm", "alarm_disarm", "alarm_stop", "alarm_enable", "alarm_disable",
+}
+
+// DefaultCommandPolicy returns the policy used unless SetCommandPolicy is
+// called: a 30s ack timeout, no retries, a one hour delivery window and a
+// ten minute run timeout.
+func DefaultCommandPolicy() CommandPolicy {
+	return CommandPolicy{
+		AckTimeout:     30 * time.Second,
+		Idempotent:     IdempotentSet(nil),
+		DeliveryWindow: time.Hour,
+		RunTimeout:     10 * time.Minute,
+	}
+}
+
+// IdempotentSet builds CommandPolicy.Idempotent from a list of commands; an
+// empty list selects the built-in defaults.
+func IdempotentSet(commands []string) map[string]bool {
+	if len(commands) == 0 {
+		commands = defaultIdempotent
+	}
+	set := make(map[string]bool, len(commands))
+	for _, c := range commands {
+		set[c] = true
+	}
+	return set
+}
+
+// SetCommandPolicy replaces the command ack/retry policy. Must be called
+// before the server starts accepting connections.
+func (h *WebSocketHandler) SetCommandPolicy(p CommandPolicy) {
+	h.policy = p
+}
+
+// StartCommandSweeper periodically retries or times out commands whose
+// scooter has not answered or finished in time. It does nothing without
+// persistence or with both timeouts disabled.
+func (h *WebSocketHandler) StartCommandSweeper() {
+	if h.db == nil || (h.policy.AckTimeout <= 0 && h.policy.RunTimeout <= 0) {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(commandSweepInterval)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			h.sweepCommands(now)
+		}
+	}()
+}
+
+// sweepCommands handles commands whose deadline has passed. Running commands
+// time out. For sent ones, while the scooter is connected, idempotent commands
+// with retries left are resent and everything else times out; commands for
+// offline scooters wait for the reconnect unless their delivery window has
+// closed.
+func (h *WebSocketHandler) sweepCommands(now time.Time) {
+	overdue, err := h.db.OverdueCommands(now)
+	if err != nil {
+		log.Printf("[WS] Failed to load overdue commands: %v", err)
+		return
+	}
+	for _, pc := range overdue {
+		if pc.Status == store.StatusRunning {
+			h.timeOutCommand(pc, "no progress or result from scooter")
+			continue
+		}
+		if !pc.Expires.IsZero() && !now.Before(pc.Expires) {
+			h.timeOutCommand(pc, "no response before the delivery window closed")
+			continue
+		}
+		conn, online := h.connMgr.GetConnection(pc.ScooterID)
+		if !online {
+			continue
+		}
+		if h.policy.Idempotent[pc.Command] && pc.Attempts <= h.policy.Retries {
+			h.resendCommand(conn, pc)
+			continue
+		}
+		h.timeOutCommand(pc, "no response from scooter")
+	}
+}
+
+// resendUnacked resends commands a previous connection of this scooter left
+// unanswered, since they may never have arrived. Commands that are unsafe to
+// deliver late (not queueable in the catalog) time out instead.
+func (h *WebSocketHandler) resendUnacked(conn *models.Connection) {
+	unacked, err := h.db.UnackedCommands(conn.Identifier, conn.ConnectedAt)
+	if err != nil {
+		log.Printf("[WS] Failed to load unacknowledged commands for %s: %v", conn.Identifier, err)
+		return
+	}
+	now := time.Now()
+	for _, pc := range unacked {
+		switch {
+		case !pc.Expires.IsZero() && !now.Before(pc.Expires):
+			h.timeOutCommand(pc, "no response before the delivery window closed")
+		case !h.catalog.Queueable(pc.Command):
+			h.timeOutCommand(pc, "connection lost before the scooter answered")
+		default:
+			h.resendCommand(conn, pc)
+		}
+	}
+}
+
+// resendCommand sends a pending command again under its original request ID,
+// so a scooter that did receive the first copy can recognise the duplicate.
+func (h *WebSocketHandler) resendCommand(conn *models.Connection, pc store.PendingCommand) {
+	data, err := json.Marshal(protocol.CommandMessage{
+		Type:      protocol.MsgTypeCommand,
+		RequestID: pc.RequestID,
+		Command:   pc.Command,
+		Params:    pc.Params,
+		Timestamp: protocol.Timestamp(),
+	})
+	if err != nil {
+		return
+	}
+	select {
+	case conn.SendChannel() <- data:
+	default:
+		log.Printf("[WS] Send channel full resending to %s", conn.Identifier)
+		return
+	}
+	conn.IncrementCommandsSent()
+	if err := h.db.MarkResent(pc.RequestID, h.ackDeadline(time.Now())); err != nil {
+		log.Printf("[WS] Failed to record command resend: %v", err)
+	}
+	log.Printf("[WS] Resent command to %s: %s (request_id=%s, attempt %d)", conn.Identifier, pc.Command, pc.RequestID, pc.Attempts+1)
+}
+
+// timeOutCommand gives up on a command and reports the outcome like a
+// scooter response, so pollers and command result hooks see it.
+func (h *WebSocketHandler) timeOutCommand(pc store.PendingCommand, reason string) {
+	ok, err := h.db.MarkTimedOut(pc.RequestID, reason)
+	if err != nil {
+		log.Printf("[WS] Failed to time out command %s: %v", pc.RequestID, err)
+		return
+	}
+	if !ok {
+		return // answered in the meantime
+	}
+	resp := &protocol.CommandResponse{
+		Type:      protocol.MsgTypeCommandResponse,
+		RequestID: pc.RequestID,
+		Status:    store.StatusTimedOut,
+		Error:     reason,
+		Timestamp: protocol.Timestamp(),
+	}
+	h.responseStore.Store(pc.RequestID, pc.ScooterID, pc.Command, resp)
+	for _, hook := range h.commandHooks {
+		hook(pc.ScooterID, resp)
+	}
+	log.Printf("[WS] Command timed out for %s: %s (request_id=%s, %d attempts): %s", pc.ScooterID, pc.Command, pc.RequestID, pc.Attempts, reason)
+}
+
+// settleCommand records a scooter's final response in the command history. It
+// returns false when the command was no longer awaiting one, typically a late
+// response to a command that already timed out, whose outcome has been
+// reported.
+func (h *WebSocketHandler) settleCommand(resp *protocol.CommandResponse) bool {
+	if h.db == nil {
+		return true
+	}
+	status := store.StatusSuccess
+	if resp.Status != "success" {
+		status = store.StatusFailed
+	}
+	ok, err := h.db.UpdateResult(resp.RequestID, status, resp.Result, resp.Error)
+	if err != nil {
+		log.Printf("[WS] Failed to persist command result: %v", err)
+		return true
+	}
+	if !ok {
+		log.Printf("[WS] Ignoring late command response: request_id=%s status=%s", resp.RequestID, resp.Status)
+	}
+	return ok
+}
+
+// ackDeadline is when an attempt sent at now times out; zero when timeouts
+// are disabled.
+func (h *WebSocketHandler) ackDeadline(now time.Time) time.Time {
+	if h.policy.AckTimeout <= 0 {
+		return time.Time{}
+	}
+	return now.Add(h.policy.AckTimeout)
+}
+
+// runDeadline is when a running command that last reported progress at now
+// times out; zero when run timeouts are disabled.
+func (h *WebSocketHandler) runDeadline(now time.Time) time.Time {
+	if h.policy.RunTimeout <= 0 {
+		return time.Time{}
+	}
+	return now.Add(h.policy.RunTimeout)
+}
+
+// deliveryExpiry is when a command first sent at now stops being resent.
+func (h *WebSocketHandler) deliveryExpiry(now time.Time) time.Time {
+	if h.policy.DeliveryWindow <= 0 {
+		return time.Time{}
+	}
+	return now.Add(h.policy.DeliveryWindow)
+}
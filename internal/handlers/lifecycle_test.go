@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/librescoot/uplink-server/internal/models"
+	"github.com/librescoot/uplink-server/internal/protocol"
+	"github.com/librescoot/uplink-server/internal/storage"
+	"github.com/librescoot/uplink-server/internal/store"
+)
+
+// TestLateResponseAfterTimeout checks that a response arriving after the
+// server gave up on a command neither rewrites its outcome nor reports it a
+// second time.
+func TestLateResponseAfterTimeout(t *testing.T) {
+	db, err := store.Open(filepath.Join(t.TempDir(), "uplink.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	h := NewWebSocketHandler(nil, storage.NewConnectionManager(0), storage.NewResponseStore(time.Minute), nil, nil, db.Storage(), 0, 0, 0)
+	var reported []string
+	h.OnCommandResult(func(scooterID string, resp *protocol.CommandResponse) {
+		reported = append(reported, resp.Status)
+	})
+
+	if err := db.RecordSent("req-1", "VIN1", "locate", nil, time.Now().Add(-time.Second), time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	h.timeOutCommand(store.PendingCommand{RequestID: "req-1", ScooterID: "VIN1", Command: "locate"}, "no response")
+
+	late, _ := json.Marshal(protocol.CommandResponse{
+		Type:      protocol.MsgTypeCommandResponse,
+		RequestID: "req-1",
+		Status:    "success",
+		Timestamp: protocol.Timestamp(),
+	})
+	h.handleMessage(models.NewConnection("VIN1", nil), late)
+
+	if len(reported) != 1 || reported[0] != store.StatusTimedOut {
+		t.Errorf("reported outcomes = %v, want only %s", reported, store.StatusTimedOut)
+	}
+	rec, _, err := db.GetCommand("req-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Status != store.StatusTimedOut {
+		t.Errorf("status = %s after late response, want %s", rec.Status, store.StatusTimedOut)
+	}
+}
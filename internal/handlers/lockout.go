@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/librescoot/uplink-server/internal/lockout"
+	"github.com/librescoot/uplink-server/internal/store"
+)
+
+// SetLockout enables throttling of failed logins.
+func (h *APIHandler) SetLockout(g *lockout.Guard) {
+	h.lockout = g
+}
+
+// SetLockout enables throttling of failed scooter authentications.
+func (h *WebSocketHandler) SetLockout(g *lockout.Guard) {
+	h.lockout = g
+}
+
+// lockedOutMessage describes a lockout to the client.
+func lockedOutMessage(wait time.Duration) string {
+	return fmt.Sprintf("Too many failed attempts; try again in %s", wait.Round(time.Second))
+}
+
+// setRetryAfter tells the client when a lockout ends.
+func setRetryAfter(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+}
+
+// writeLockedOut refuses a locked-out attempt with 429 and Retry-After.
+func (h *APIHandler) writeLockedOut(w http.ResponseWriter, wait time.Duration) {
+	setRetryAfter(w, wait)
+	h.writeError(w, http.StatusTooManyRequests, lockedOutMessage(wait))
+}
+
+// HandleAuthFailures handles GET /api/auth/failures: recorded failed logins
+// and scooter authentications, newest first. Accepts ?kind (login or
+// scooter), ?identifier, ?ip, ?since (RFC3339) and ?limit (default 100).
+func (h *APIHandler) HandleAuthFailures(w http.ResponseWriter, r *http.Request) {
+	h.cors(h.authenticate(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			h.writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		if h.db == nil {
+			h.writeError(w, http.StatusServiceUnavailable, "History persistence is not enabled")
+			return
+		}
+
+		q := r.URL.Query()
+		filter := store.AuthFailureFilter{
+			Kind:       q.Get("kind"),
+			Identifier: q.Get("identifier"),
+			SourceIP:   q.Get("ip"),
+		}
+		if filter.Kind != "" && filter.Kind != lockout.KindLogin && filter.Kind != lockout.KindScooter {
+			h.writeError(w, http.StatusBadRequest, "kind must be login or scooter")
+			return
+		}
+		if v := q.Get("since"); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				h.writeError(w, http.StatusBadRequest, "since must be an RFC3339 time")
+				return
+			}
+			filter.Since = t
+		}
+		if v := q.Get("limit"); v != "" {
+			if n, err := strconv.Atoi(v); err == nil && n > 0 {
+				filter.Limit = n
+			}
+		}
+
+		list, err := h.db.ListAuthFailures(filter)
+		if err != nil {
+			h.writeError(w, http.StatusInternalServerError, "Failed to query auth failures")
+			return
+		}
+		if list == nil {
+			list = []store.AuthFailure{}
+		}
+		h.writeJSON(w, http.StatusOK, map[string]any{
+			"failures": list,
+			"total":    len(list),
+		})
+	}))(w, r)
+}
+
+// HandleLockouts handles GET /api/auth/lockouts (current lockouts) and
+// DELETE /api/auth/lockouts?kind=…&identifier=…&ip=… (lift those matching
+// the identifier, the IP or both).
+func (h *APIHandler) HandleLockouts(w http.ResponseWriter, r *http.Request) {
+	h.cors(h.authenticate(func(w http.ResponseWriter, r *http.Request) {
+		if h.lockout == nil {
+			h.writeError(w, http.StatusServiceUnavailable, "Lockout is not enabled")
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			list := h.lockout.Lockouts(time.Now())
+			h.writeJSON(w, http.StatusOK, map[string]any{
+				"lockouts": list,
+				"total":    len(list),
+			})
+		case http.MethodDelete:
+			q := r.URL.Query()
+			kind, identifier, ip := q.Get("kind"), q.Get("identifier"), q.Get("ip")
+			h.audit(r, "lockout.lift", "", map[string]any{"kind": kind, "identifier": identifier, "ip": ip})
+			if kind != lockout.KindLogin && kind != lockout.KindScooter {
+				h.writeError(w, http.StatusBadRequest, "kind must be login or scooter")
+				return
+			}
+			if identifier == "" && ip == "" {
+				h.writeError(w, http.StatusBadRequest, "Specify identifier, ip or both")
+				return
+			}
+			if !h.lockout.Unlock(kind, identifier, ip) {
+				h.writeError(w, http.StatusNotFound, "No failures recorded for it")
+				return
+			}
+			h.writeJSON(w, http.StatusOK, map[string]any{"message": "Lockout lifted"})
+		default:
+			h.writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		}
+	}))(w, r)
+}
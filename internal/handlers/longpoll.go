@@ -0,0 +1,493 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/librescoot/uplink-server/internal/lockout"
+	"github.com/librescoot/uplink-server/internal/models"
+	"github.com/librescoot/uplink-server/internal/protocol"
+)
+
+// defaultPollWait is how long a poll is held when the client does not ask for
+// a specific timeout.
+const defaultPollWait = 30 * time.Second
+
+// lpSessionGrace is how long a long-poll session survives without an
+// outstanding poll or any other request before the scooter is considered
+// offline.
+const lpSessionGrace = 2 * time.Minute
+
+// maxPollMessages caps how many queued messages one poll response carries.
+const maxPollMessages = 64
+
+// Request body limits for /lp/auth and /lp/messages.
+const (
+	maxAuthBody    = 64 << 10
+	maxMessageBody = 4 << 20
+)
+
+// LongPollHandler is an HTTP long-poll transport for scooters whose networks
+// kill idle WebSockets (e.g. carrier NATs). It speaks the same protocol
+// messages as /ws:
+//
+//	POST /lp/auth      auth message → auth_response with a session token
+//	POST /lp/messages  one message or a JSON array (state, change, event, …)
+//	GET  /lp/poll      held until commands/config updates are pending
+//	POST /lp/close     end the session
+//
+// Requests after auth carry the session token in X-Session-Token.
+type LongPollHandler struct {
+	ws      *WebSocketHandler // shared message processing and command dispatch
+	maxWait time.Duration
+
+	mu       sync.Mutex
+	sessions map[string]*lpSession // session token -> session
+}
+
+// lpSession is one authenticated long-poll scooter. Its models.Connection is
+// registered with the ConnectionManager, so SendCommand and friends reach it
+// through the connection's send channel exactly as for a WebSocket.
+type lpSession struct {
+	token       string
+	conn        *models.Connection
+	polling     int // outstanding polls
+	lastContact time.Time
+	// undelivered holds messages taken for a poll whose response could not
+	// be written; the next poll sends them first.
+	undelivered []json.RawMessage
+
+	// msgMu serialises message processing, as the WebSocket read loop does,
+	// and guards nextMessage, when the rate limit lets the next one through.
+	msgMu       sync.Mutex
+	nextMessage time.Time
+}
+
+// throttle waits until the session may process another message under a limit
+// of rate messages per second (none if rate <= 0), as messageReceiver does
+// for WebSockets. It returns false if the request went away meanwhile.
+// Callers hold msgMu.
+func (s *lpSession) throttle(ctx context.Context, rate int) bool {
+	if rate <= 0 {
+		return true
+	}
+	now := time.Now()
+	if s.nextMessage.Before(now) {
+		s.nextMessage = now
+	}
+	wait := s.nextMessage.Sub(now)
+	s.nextMessage = s.nextMessage.Add(time.Second / time.Duration(rate))
+	if wait <= 0 {
+		return true
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// NewLongPollHandler creates a long-poll transport backed by ws. maxWait caps
+// how long a single poll is held (server.lp_timeout).
+func NewLongPollHandler(ws *WebSocketHandler, maxWait time.Duration) *LongPollHandler {
+	h := &LongPollHandler{
+		ws:       ws,
+		maxWait:  maxWait,
+		sessions: make(map[string]*lpSession),
+	}
+	go h.reapSessions()
+	return h
+}
+
+// HandleAuth handles POST /lp/auth.
+func (h *LongPollHandler) HandleAuth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxAuthBody))
+	if err != nil {
+		http.Error(w, "Failed to read request body", readErrorStatus(err))
+		return
+	}
+
+	var authMsg protocol.AuthMessage
+	if err := json.Unmarshal(body, &authMsg); err != nil || authMsg.Type != protocol.MsgTypeAuth {
+		log.Printf("[LP] Expected auth message from %s", r.RemoteAddr)
+		h.writeAuthResponse(w, http.StatusBadRequest, "error", "Expected authentication message", "")
+		return
+	}
+
+	// Valid credentials get through a lockout of the identifier; only a
+	// lockout of the client IP refuses them.
+	guard := h.ws.lockout
+	var ip string
+	if guard != nil {
+		ip = guard.ClientIP(r)
+		if wait := guard.Check(lockout.KindScooter, "", ip, time.Now()); wait > 0 {
+			log.Printf("[LP] Refused authentication from locked-out %s", r.RemoteAddr)
+			h.writeLockedOut(w, wait)
+			return
+		}
+	}
+	requested := authMsg.Identifier
+	authMsg.Identifier, err = h.ws.auth.AuthenticateScooter(authMsg.Identifier, authMsg.Token, clientCert(r))
+	if err != nil {
+		log.Printf("[LP] Authentication failed from %s: %v", r.RemoteAddr, err)
+		if guard != nil {
+			if wait := guard.Check(lockout.KindScooter, requested, ip, time.Now()); wait > 0 {
+				h.writeLockedOut(w, wait)
+				return
+			}
+			guard.Fail(lockout.KindScooter, requested, ip, err.Error(), time.Now())
+		}
+		h.writeAuthResponse(w, http.StatusUnauthorized, "error", "Authentication failed", "")
+		return
+	}
+	if guard != nil {
+		guard.Succeed(lockout.KindScooter, authMsg.Identifier, ip)
+	}
+
+	token, err := generateSessionToken()
+	if err != nil {
+		h.writeAuthResponse(w, http.StatusInternalServerError, "error", "Failed to create session", "")
+		return
+	}
+
+	connection := models.NewConnection(authMsg.Identifier, nil)
+	connection.Version = authMsg.Version
+	connection.Authenticated = true
+	connection.Name = h.ws.auth.GetName(authMsg.Identifier)
+	connection.Transport = "longpoll"
+
+	// A re-auth replaces any previous session for the same scooter.
+	h.mu.Lock()
+	for t, sess := range h.sessions {
+		if sess.conn.Identifier == authMsg.Identifier {
+			delete(h.sessions, t)
+		}
+	}
+	h.mu.Unlock()
+
+	if err := h.ws.connMgr.AddConnection(connection); err != nil {
+		log.Printf("[LP] Failed to add connection for %s: %v", authMsg.Identifier, err)
+		h.writeAuthResponse(w, http.StatusServiceUnavailable, "error", "Connection already exists", "")
+		return
+	}
+	h.ws.connMgr.MarkAuthenticated(authMsg.Identifier)
+	h.ws.stateStore.SetVersion(authMsg.Identifier, authMsg.Version)
+
+	h.mu.Lock()
+	h.sessions[token] = &lpSession{token: token, conn: connection, lastContact: time.Now()}
+	h.mu.Unlock()
+
+	h.writeAuthResponse(w, http.StatusOK, "success", "", token)
+
+	log.Printf("[LP] Client authenticated: %s (version: %s, protocol: %d)", authMsg.Identifier, authMsg.Version, authMsg.ProtocolVersion)
+
+	// Queued commands land in the send channel and go out with the first poll.
+	h.ws.replayQueuedCommands(connection)
+}
+
+// HandleMessages handles POST /lp/messages. The body is a single protocol
+// message or a JSON array of them, processed in order.
+func (h *LongPollHandler) HandleMessages(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	sess := h.touch(r)
+	if sess == nil {
+		h.writeJSON(w, http.StatusUnauthorized, map[string]any{"error": "Invalid or expired session"})
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxMessageBody))
+	if err != nil {
+		h.writeJSON(w, readErrorStatus(err), map[string]any{"error": "Failed to read request body"})
+		return
+	}
+
+	var messages []json.RawMessage
+	if trimmed := strings.TrimSpace(string(body)); strings.HasPrefix(trimmed, "[") {
+		if err := json.Unmarshal(body, &messages); err != nil {
+			h.writeJSON(w, http.StatusBadRequest, map[string]any{"error": "Invalid JSON format"})
+			return
+		}
+	} else {
+		messages = []json.RawMessage{body}
+	}
+
+	// Concurrent requests of one session are processed one at a time and in
+	// arrival order, and share the scooter's message rate limit.
+	sess.msgMu.Lock()
+	defer sess.msgMu.Unlock()
+	accepted := 0
+	for _, msg := range messages {
+		var base protocol.BaseMessage
+		if err := json.Unmarshal(msg, &base); err != nil || base.Type == protocol.MsgTypeAuth {
+			continue
+		}
+		if !sess.throttle(r.Context(), h.ws.messageRateLimit) {
+			return
+		}
+		h.ws.handleMessage(sess.conn, msg)
+		accepted++
+	}
+
+	h.writeJSON(w, http.StatusOK, map[string]any{"accepted": accepted})
+}
+
+// HandlePoll handles GET /lp/poll. It returns as soon as at least one message
+// is pending for the scooter, or with an empty list after ?timeout= (default
+// 30s, capped at server.lp_timeout).
+func (h *LongPollHandler) HandlePoll(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	sess := h.beginPoll(r)
+	if sess == nil {
+		h.writeJSON(w, http.StatusUnauthorized, map[string]any{"error": "Invalid or expired session"})
+		return
+	}
+	defer h.endPoll(sess)
+
+	wait := defaultPollWait
+	if v := r.URL.Query().Get("timeout"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+			wait = d
+		}
+	}
+	if h.maxWait > 0 && wait > h.maxWait {
+		wait = h.maxWait
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	messages := h.takeUndelivered(sess)
+	if len(messages) == 0 {
+		select {
+		case msg := <-sess.conn.ReceiveChannel():
+			messages = append(messages, msg)
+		case <-timer.C:
+		case <-r.Context().Done():
+			return
+		}
+	}
+	if len(messages) > 0 {
+	drain:
+		for len(messages) < maxPollMessages {
+			select {
+			case msg := <-sess.conn.ReceiveChannel():
+				messages = append(messages, msg)
+			default:
+				break drain
+			}
+		}
+	}
+
+	// The messages have left the send channel, so a response that does not
+	// reach the scooter must not lose them.
+	if err := h.writePoll(w, messages); err != nil {
+		log.Printf("[LP] Failed to deliver %d messages to %s, keeping them for the next poll: %v", len(messages), sess.conn.Identifier, err)
+		h.keepUndelivered(sess, messages)
+		return
+	}
+	for _, msg := range messages {
+		sess.conn.AddBytesSent(int64(len(msg)))
+		sess.conn.IncrementMessagesSent()
+	}
+	sess.conn.UpdateLastSeen()
+}
+
+// writePoll writes and flushes a poll response, reporting whether it reached
+// the client connection.
+func (h *LongPollHandler) writePoll(w http.ResponseWriter, messages []json.RawMessage) error {
+	if messages == nil {
+		messages = []json.RawMessage{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]any{
+		"messages":    messages,
+		"server_time": protocol.Timestamp(),
+	}); err != nil {
+		return err
+	}
+	if err := http.NewResponseController(w).Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return err
+	}
+	return nil
+}
+
+// takeUndelivered removes and returns up to maxPollMessages of the messages
+// failed polls left behind.
+func (h *LongPollHandler) takeUndelivered(sess *lpSession) []json.RawMessage {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	n := min(len(sess.undelivered), maxPollMessages)
+	messages := sess.undelivered[:n:n]
+	sess.undelivered = sess.undelivered[n:]
+	if len(sess.undelivered) == 0 {
+		sess.undelivered = nil
+	}
+	return messages
+}
+
+// keepUndelivered puts messages back for the next poll, ahead of any that
+// another failed poll left meanwhile.
+func (h *LongPollHandler) keepUndelivered(sess *lpSession, messages []json.RawMessage) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	sess.undelivered = append(messages, sess.undelivered...)
+}
+
+// HandleClose handles POST /lp/close, ending the session immediately.
+func (h *LongPollHandler) HandleClose(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	token := sessionToken(r)
+	h.mu.Lock()
+	sess, ok := h.sessions[token]
+	if ok {
+		delete(h.sessions, token)
+	}
+	h.mu.Unlock()
+	if ok {
+		h.detach(sess)
+	}
+	h.writeJSON(w, http.StatusOK, map[string]any{"message": "Session closed"})
+}
+
+// touch looks up the request's session and records contact.
+func (h *LongPollHandler) touch(r *http.Request) *lpSession {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	sess, ok := h.sessions[sessionToken(r)]
+	if !ok {
+		return nil
+	}
+	sess.lastContact = time.Now()
+	return sess
+}
+
+func (h *LongPollHandler) beginPoll(r *http.Request) *lpSession {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	sess, ok := h.sessions[sessionToken(r)]
+	if !ok {
+		return nil
+	}
+	sess.polling++
+	sess.lastContact = time.Now()
+	return sess
+}
+
+func (h *LongPollHandler) endPoll(sess *lpSession) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	sess.polling--
+	sess.lastContact = time.Now()
+}
+
+// reapSessions drops sessions that have neither an outstanding poll nor any
+// contact within lpSessionGrace, taking the scooter offline.
+func (h *LongPollHandler) reapSessions() {
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+	for range ticker.C {
+		var expired []*lpSession
+		h.mu.Lock()
+		for token, sess := range h.sessions {
+			if sess.polling == 0 && time.Since(sess.lastContact) > lpSessionGrace {
+				delete(h.sessions, token)
+				expired = append(expired, sess)
+			}
+		}
+		h.mu.Unlock()
+
+		for _, sess := range expired {
+			log.Printf("[LP] Session expired for %s", sess.conn.Identifier)
+			h.detach(sess)
+		}
+	}
+}
+
+// detach removes the session's connection from the ConnectionManager unless a
+// newer connection for the same scooter has already replaced it.
+func (h *LongPollHandler) detach(sess *lpSession) {
+	if current, ok := h.ws.connMgr.GetConnection(sess.conn.Identifier); ok && current == sess.conn {
+		h.ws.connMgr.RemoveConnection(sess.conn.Identifier)
+	}
+}
+
+func (h *LongPollHandler) writeAuthResponse(w http.ResponseWriter, status int, result, errMsg, token string) {
+	resp := protocol.AuthResponse{
+		Type:       protocol.MsgTypeAuthResponse,
+		Status:     result,
+		Error:      errMsg,
+		ServerTime: protocol.Timestamp(),
+		Session:    token,
+	}
+	if token != "" && h.maxWait > 0 {
+		resp.PollTimeout = h.maxWait.String()
+	}
+	h.writeJSON(w, status, resp)
+}
+
+func (h *LongPollHandler) writeJSON(w http.ResponseWriter, status int, data any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		log.Printf("[LP] Failed to encode JSON response: %v", err)
+	}
+}
+
+// sessionToken returns the long-poll session token from X-Session-Token or an
+// Authorization: Bearer header.
+func sessionToken(r *http.Request) string {
+	if t := r.Header.Get("X-Session-Token"); t != "" {
+		return t
+	}
+	return strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+}
+
+// readErrorStatus maps a request body read error to a response status.
+func readErrorStatus(err error) int {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
+// writeLockedOut refuses a locked-out authentication with 429 and
+// Retry-After.
+func (h *LongPollHandler) writeLockedOut(w http.ResponseWriter, wait time.Duration) {
+	setRetryAfter(w, wait)
+	h.writeAuthResponse(w, http.StatusTooManyRequests, "error", lockedOutMessage(wait), "")
+}
+
+func generateSessionToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
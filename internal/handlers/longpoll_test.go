@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/librescoot/uplink-server/internal/models"
+)
+
+// failingWriter is a ResponseWriter whose client has gone away.
+type failingWriter struct{ header http.Header }
+
+func (w *failingWriter) Header() http.Header       { return w.header }
+func (w *failingWriter) WriteHeader(int)           {}
+func (w *failingWriter) Write([]byte) (int, error) { return 0, errors.New("connection reset by peer") }
+
+// TestPollKeepsMessagesOnWriteFailure checks that messages taken off the send
+// channel for a poll whose response cannot be written go out with the next
+// poll, in order.
+func TestPollKeepsMessagesOnWriteFailure(t *testing.T) {
+	conn := models.NewConnection("VIN1", nil)
+	h := &LongPollHandler{sessions: map[string]*lpSession{
+		"tok": {token: "tok", conn: conn},
+	}}
+	poll := func(w http.ResponseWriter) {
+		r := httptest.NewRequest(http.MethodGet, "/lp/poll?timeout=1s", nil)
+		r.Header.Set("X-Session-Token", "tok")
+		h.HandlePoll(w, r)
+	}
+
+	conn.SendChannel() <- []byte(`{"type":"command","request_id":"req-1"}`)
+	conn.SendChannel() <- []byte(`{"type":"command","request_id":"req-2"}`)
+	poll(&failingWriter{header: make(http.Header)})
+
+	conn.SendChannel() <- []byte(`{"type":"command","request_id":"req-3"}`)
+	rec := httptest.NewRecorder()
+	poll(rec)
+
+	var resp struct {
+		Messages []struct {
+			RequestID string `json:"request_id"`
+		} `json:"messages"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode %q: %v", rec.Body.String(), err)
+	}
+	var got []string
+	for _, m := range resp.Messages {
+		got = append(got, m.RequestID)
+	}
+	if len(got) != 3 || got[0] != "req-1" || got[1] != "req-2" || got[2] != "req-3" {
+		t.Errorf("second poll delivered %v, want [req-1 req-2 req-3]", got)
+	}
+	if n := conn.MessagesSent; n != 3 {
+		t.Errorf("messages sent = %d, want 3", n)
+	}
+}
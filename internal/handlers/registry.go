@@ -0,0 +1,270 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/librescoot/uplink-server/internal/auth"
+	"github.com/librescoot/uplink-server/internal/models"
+	"github.com/librescoot/uplink-server/internal/registry"
+	"github.com/librescoot/uplink-server/internal/scheduler"
+)
+
+// SetTargets enables pushing config to groups and tags of scooters.
+func (h *APIHandler) SetTargets(r *scheduler.Resolver) {
+	h.targets = r
+}
+
+// HandleRegistryScooter handles GET /api/registry/{id}, PATCH
+// /api/registry/{id}, which changes a scooter's name, groups and tags (fields
+// left out of the body are kept), and POST /api/registry/{id}/rotate.
+func (h *APIHandler) HandleRegistryScooter(w http.ResponseWriter, r *http.Request) {
+	h.cors(h.authenticate(func(w http.ResponseWriter, r *http.Request) {
+		if h.registry == nil {
+			h.writeError(w, http.StatusServiceUnavailable, "Registry is not enabled")
+			return
+		}
+		id, action, _ := strings.Cut(extractPathParam(r.URL.Path, "/api/registry/"), "/")
+		if id == "" {
+			h.writeError(w, http.StatusBadRequest, "Scooter ID required")
+			return
+		}
+		switch action {
+		case "":
+		case "rotate":
+			h.handleRotateToken(w, r, id)
+			return
+		default:
+			h.writeError(w, http.StatusNotFound, "Not found")
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			info, ok := h.registry.Get(id)
+			if !ok {
+				h.writeError(w, http.StatusNotFound, "Scooter not registered")
+				return
+			}
+			h.writeJSON(w, http.StatusOK, h.registryEntry(info))
+		case http.MethodPatch:
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				h.writeError(w, http.StatusBadRequest, "Failed to read request body")
+				return
+			}
+			var u registry.Update
+			if err := json.Unmarshal(body, &u); err != nil {
+				h.writeError(w, http.StatusBadRequest, "Invalid JSON format")
+				return
+			}
+			h.audit(r, "scooter.update", id, map[string]any{"update": u})
+			info, err := h.registry.Update(id, u)
+			if err != nil {
+				switch {
+				case strings.Contains(err.Error(), "not found"):
+					h.writeError(w, http.StatusNotFound, err.Error())
+				case strings.Contains(err.Error(), "invalid label"):
+					h.writeError(w, http.StatusBadRequest, err.Error())
+				default:
+					h.writeError(w, http.StatusInternalServerError, err.Error())
+				}
+				return
+			}
+			h.writeJSON(w, http.StatusOK, h.registryEntry(info))
+		default:
+			h.writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		}
+	}))(w, r)
+}
+
+// handleRotateToken issues a new token for a scooter. The old token keeps
+// working for the grace period (?grace= or "grace" in the body, default
+// auth.token_grace_period), and unless "push" is false the new token is sent
+// to the scooter, if connected, as a scooter.token config update.
+func (h *APIHandler) handleRotateToken(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != http.MethodPost {
+		h.writeError(w, http.StatusMethodNotAllowed, "Use POST to rotate the token")
+		return
+	}
+	var req struct {
+		Grace string `json:"grace"`
+		Push  *bool  `json:"push"`
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, "Failed to read request body")
+		return
+	}
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &req); err != nil {
+			h.writeError(w, http.StatusBadRequest, "Invalid JSON format")
+			return
+		}
+	}
+	if g := r.URL.Query().Get("grace"); g != "" {
+		req.Grace = g
+	}
+	h.audit(r, "token.rotate", id, map[string]any{"grace": req.Grace, "push": req.Push == nil || *req.Push})
+	grace := h.registry.TokenGracePeriod()
+	if req.Grace != "" {
+		if grace, err = models.ParseRetention(req.Grace); err != nil {
+			h.writeError(w, http.StatusBadRequest, "Invalid grace period")
+			return
+		}
+	}
+
+	token, until, err := h.registry.Rotate(id, grace)
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			h.writeError(w, http.StatusNotFound, err.Error())
+		} else {
+			h.writeError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	resp := map[string]any{
+		"identifier": id,
+		"token":      token,
+		"pushed":     false,
+	}
+	if !until.IsZero() {
+		resp["previous_valid_until"] = until.Format(time.RFC3339)
+	}
+	// The config update is only queued on the connection; the scooter does
+	// not acknowledge it, so "pushed" does not mean the token was applied.
+	if req.Push == nil || *req.Push {
+		switch err := h.wsHandler.SendConfigUpdate(id, map[string]string{"scooter.token": token}, false); err {
+		case nil:
+			resp["pushed"] = true
+		case ErrConnectionNotFound:
+			resp["push_error"] = "Scooter not connected"
+		default:
+			resp["push_error"] = err.Error()
+		}
+	}
+	h.writeJSON(w, http.StatusOK, resp)
+}
+
+// registryEntry is the API view of a registered scooter.
+func (h *APIHandler) registryEntry(s auth.ScooterInfo) map[string]any {
+	_, connected := h.connMgr.GetConnection(s.Identifier)
+	groups, tags := s.Groups, s.Tags
+	if groups == nil {
+		groups = []string{}
+	}
+	if tags == nil {
+		tags = []string{}
+	}
+	return map[string]any{
+		"identifier": s.Identifier,
+		"name":       s.Name,
+		"groups":     groups,
+		"tags":       tags,
+		"connected":  connected,
+	}
+}
+
+// labelFilter selects scooters in any of its groups and carrying any of its
+// tags; an empty side matches everything.
+type labelFilter struct {
+	groups, tags scooterFilter
+}
+
+// parseLabelFilter reads ?group and ?tag, each repeatable or comma-separated.
+func parseLabelFilter(r *http.Request) labelFilter {
+	q := r.URL.Query()
+	return labelFilter{groups: parseScooterFilter(q["group"]), tags: parseScooterFilter(q["tag"])}
+}
+
+func (f labelFilter) match(s auth.ScooterInfo) bool {
+	return matchesAny(f.groups, s.Groups) && matchesAny(f.tags, s.Tags)
+}
+
+func matchesAny(f scooterFilter, labels []string) bool {
+	if len(f) == 0 {
+		return true
+	}
+	for _, l := range labels {
+		if f[l] {
+			return true
+		}
+	}
+	return false
+}
+
+// HandleConfigPush handles POST /api/config: push config deltas to every
+// connected scooter among the targets, which are resolved like a bulk
+// command's (listed scooters, groups and tags, narrowed by condition).
+func (h *APIHandler) HandleConfigPush(w http.ResponseWriter, r *http.Request) {
+	h.cors(h.authenticate(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			h.writeError(w, http.StatusMethodNotAllowed, "Use POST to push config")
+			return
+		}
+		if h.targets == nil {
+			h.writeError(w, http.StatusServiceUnavailable, "Targeted config pushes are not enabled")
+			return
+		}
+		var req struct {
+			Deltas    map[string]string `json:"deltas"`
+			Restart   bool              `json:"restart"`
+			Scooters  []string          `json:"scooters"`
+			Groups    []string          `json:"groups"`
+			Tags      []string          `json:"tags"`
+			Condition string            `json:"condition"`
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			h.writeError(w, http.StatusBadRequest, "Failed to read request body")
+			return
+		}
+		if err := json.Unmarshal(body, &req); err != nil {
+			h.writeError(w, http.StatusBadRequest, "Invalid JSON format")
+			return
+		}
+		h.audit(r, "config.push", "", map[string]any{
+			"deltas":    req.Deltas,
+			"restart":   req.Restart,
+			"scooters":  req.Scooters,
+			"groups":    req.Groups,
+			"tags":      req.Tags,
+			"condition": req.Condition,
+		})
+		if len(req.Deltas) == 0 {
+			h.writeError(w, http.StatusBadRequest, "deltas are required")
+			return
+		}
+		if len(req.Scooters) == 0 && len(req.Groups) == 0 && len(req.Tags) == 0 {
+			h.writeError(w, http.StatusBadRequest, "scooters, groups or tags are required")
+			return
+		}
+		targets, err := h.targets.Resolve(false, req.Scooters, req.Groups, req.Tags, req.Condition)
+		if err != nil {
+			h.writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		sent := []string{}
+		failed := map[string]string{}
+		for _, id := range targets {
+			switch err := h.wsHandler.SendConfigUpdate(id, req.Deltas, req.Restart); err {
+			case nil:
+				sent = append(sent, id)
+			case ErrConnectionNotFound:
+				failed[id] = "Scooter not connected"
+			default:
+				failed[id] = err.Error()
+			}
+		}
+		h.auditParam(r, "sent", sent)
+		h.writeJSON(w, http.StatusOK, map[string]any{
+			"sent":   sent,
+			"failed": failed,
+			"total":  len(targets),
+			"deltas": len(req.Deltas),
+		})
+	}))(w, r)
+}
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/librescoot/uplink-server/internal/store"
+)
+
+// SetPruner enables the retention endpoints.
+func (h *APIHandler) SetPruner(p *store.Pruner) {
+	h.pruner = p
+}
+
+// HandleRetention handles GET /api/retention: the effective retention policy,
+// per-scooter overrides and the outcome of the last pruning pass.
+func (h *APIHandler) HandleRetention(w http.ResponseWriter, r *http.Request) {
+	h.cors(h.authenticate(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			h.writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		if h.pruner == nil {
+			h.writeError(w, http.StatusServiceUnavailable, "Retention is not enabled")
+			return
+		}
+
+		ret := h.pruner.Retention()
+		scooters := make(map[string]any, len(ret.Scooters))
+		for id, p := range ret.Scooters {
+			scooters[id] = retentionPolicyJSON(p)
+		}
+		resp := map[string]any{
+			"default":    retentionPolicyJSON(ret.Default),
+			"scooters":   scooters,
+			"last_prune": nil,
+		}
+		if last, err := h.pruner.Last(); last != nil {
+			lp := pruneResultJSON(*last)
+			if err != nil {
+				lp["error"] = err.Error()
+			}
+			resp["last_prune"] = lp
+		}
+		h.writeJSON(w, http.StatusOK, resp)
+	}))(w, r)
+}
+
+// HandleRetentionPrune handles POST /api/retention/prune, running a pruning
+// pass immediately.
+func (h *APIHandler) HandleRetentionPrune(w http.ResponseWriter, r *http.Request) {
+	h.cors(h.authenticate(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			h.writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		if h.pruner == nil {
+			h.writeError(w, http.StatusServiceUnavailable, "Retention is not enabled")
+			return
+		}
+		res, err := h.pruner.Run()
+		if err != nil {
+			h.writeError(w, http.StatusInternalServerError, "Prune failed: "+err.Error())
+			return
+		}
+		h.writeJSON(w, http.StatusOK, pruneResultJSON(res))
+	}))(w, r)
+}
+
+func retentionPolicyJSON(p store.RetentionPolicy) map[string]string {
+	return map[string]string{
+		"telemetry": formatRetention(p.Telemetry),
+		"rollups":   formatRetention(p.Rollups),
+		"events":    formatRetention(p.Events),
+		"commands":  formatRetention(p.Commands),
+	}
+}
+
+func pruneResultJSON(r store.PruneResult) map[string]any {
+	return map[string]any{
+		"started_at":  r.StartedAt.UTC().Format(time.RFC3339),
+		"duration_ms": r.Duration.Milliseconds(),
+		"deleted": map[string]int64{
+			"telemetry": r.Telemetry,
+			"rollups":   r.Rollups,
+			"events":    r.Events,
+			"commands":  r.Commands,
+		},
+	}
+}
+
+// formatRetention renders a retention the way it is configured: whole days as
+// "30d", zero as "forever".
+func formatRetention(d time.Duration) string {
+	const day = 24 * time.Hour
+	switch {
+	case d == 0:
+		return "forever"
+	case d%day == 0:
+		return strconv.Itoa(int(d/day)) + "d"
+	default:
+		return d.String()
+	}
+}
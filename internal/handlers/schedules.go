@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/librescoot/uplink-server/internal/scheduler"
+	"github.com/librescoot/uplink-server/internal/store"
+)
+
+// SetScheduler enables the schedule endpoints.
+func (h *APIHandler) SetScheduler(s *scheduler.Scheduler) {
+	h.scheduler = s
+}
+
+// HandleSchedules handles GET /api/schedules (list) and POST /api/schedules
+// (create).
+func (h *APIHandler) HandleSchedules(w http.ResponseWriter, r *http.Request) {
+	h.cors(h.authenticate(func(w http.ResponseWriter, r *http.Request) {
+		if h.scheduler == nil {
+			h.writeError(w, http.StatusServiceUnavailable, "Scheduling is not enabled")
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			list, err := h.db.ListSchedules()
+			if err != nil {
+				h.writeError(w, http.StatusInternalServerError, "Failed to list schedules")
+				return
+			}
+			if list == nil {
+				list = []store.Schedule{}
+			}
+			h.writeJSON(w, http.StatusOK, map[string]any{
+				"schedules": list,
+				"total":     len(list),
+			})
+		case http.MethodPost:
+			sc, ok := h.readSchedule(w, r)
+			if !ok {
+				return
+			}
+			h.audit(r, "schedule.create", "", scheduleAuditParams(sc))
+			sc.CreatedBy = h.requestUser(r)
+			if err := h.db.CreateSchedule(sc); err != nil {
+				h.writeError(w, http.StatusInternalServerError, "Failed to create schedule")
+				return
+			}
+			h.auditParam(r, "id", sc.ID)
+			h.writeJSON(w, http.StatusCreated, sc)
+		default:
+			h.writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		}
+	}))(w, r)
+}
+
+// HandleSchedule handles /api/schedules/*:
+//
+//	GET    /api/schedules/{id}
+//	PUT    /api/schedules/{id}
+//	DELETE /api/schedules/{id}
+//	GET    /api/schedules/{id}/runs   (?limit, default 100)
+func (h *APIHandler) HandleSchedule(w http.ResponseWriter, r *http.Request) {
+	h.cors(h.authenticate(func(w http.ResponseWriter, r *http.Request) {
+		if h.scheduler == nil {
+			h.writeError(w, http.StatusServiceUnavailable, "Scheduling is not enabled")
+			return
+		}
+		param := extractPathParam(r.URL.Path, "/api/schedules/")
+		param, runs := strings.CutSuffix(param, "/runs")
+		id, err := strconv.ParseInt(param, 10, 64)
+		if err != nil {
+			h.writeError(w, http.StatusBadRequest, "Schedule ID required")
+			return
+		}
+
+		if runs {
+			if r.Method != http.MethodGet {
+				h.writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+				return
+			}
+			limit := 100
+			if v := r.URL.Query().Get("limit"); v != "" {
+				if n, err := strconv.Atoi(v); err == nil && n > 0 {
+					limit = n
+				}
+			}
+			list, err := h.db.ScheduleRuns(id, limit)
+			if err != nil {
+				h.writeError(w, http.StatusInternalServerError, "Failed to query schedule runs")
+				return
+			}
+			if list == nil {
+				list = []store.ScheduleRun{}
+			}
+			h.writeJSON(w, http.StatusOK, map[string]any{
+				"runs":  list,
+				"total": len(list),
+			})
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			sc, ok, err := h.db.GetSchedule(id)
+			if err != nil {
+				h.writeError(w, http.StatusInternalServerError, "Failed to query schedule")
+				return
+			}
+			if !ok {
+				h.writeError(w, http.StatusNotFound, "Schedule not found")
+				return
+			}
+			h.writeJSON(w, http.StatusOK, sc)
+		case http.MethodPut:
+			sc, ok := h.readSchedule(w, r)
+			if !ok {
+				return
+			}
+			sc.ID = id
+			h.audit(r, "schedule.update", "", scheduleAuditParams(sc))
+			found, err := h.db.UpdateSchedule(sc)
+			if err != nil {
+				h.writeError(w, http.StatusInternalServerError, "Failed to update schedule")
+				return
+			}
+			if !found {
+				h.writeError(w, http.StatusNotFound, "Schedule not found")
+				return
+			}
+			if updated, ok, err := h.db.GetSchedule(id); err == nil && ok {
+				sc = updated
+			}
+			h.writeJSON(w, http.StatusOK, sc)
+		case http.MethodDelete:
+			h.audit(r, "schedule.delete", "", map[string]any{"id": id})
+			found, err := h.db.DeleteSchedule(id)
+			if err != nil {
+				h.writeError(w, http.StatusInternalServerError, "Failed to delete schedule")
+				return
+			}
+			if !found {
+				h.writeError(w, http.StatusNotFound, "Schedule not found")
+				return
+			}
+			h.writeJSON(w, http.StatusOK, map[string]any{
+				"id":      id,
+				"message": "Schedule removed",
+			})
+		default:
+			h.writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		}
+	}))(w, r)
+}
+
+// readSchedule decodes and validates a schedule from the request body and
+// computes its next run, writing an error response on failure. Jobs are
+// enabled unless the body says otherwise.
+func (h *APIHandler) readSchedule(w http.ResponseWriter, r *http.Request) (*store.Schedule, bool) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, "Failed to read request body")
+		return nil, false
+	}
+	sc := store.Schedule{Enabled: true}
+	if err := json.Unmarshal(body, &sc); err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid JSON format")
+		return nil, false
+	}
+	// Bookkeeping fields are the server's.
+	sc.ID, sc.LastRun, sc.CreatedBy = 0, nil, ""
+	if reason := h.checkCommand(r, sc.Command); reason != "" {
+		h.writeError(w, http.StatusForbidden, reason)
+		return nil, false
+	}
+	if err := h.scheduler.Prepare(&sc); err != nil {
+		h.writeError(w, http.StatusBadRequest, err.Error())
+		return nil, false
+	}
+	return &sc, true
+}
+
+// scheduleAuditParams describes a created or updated schedule for the audit
+// log: what it sends, to whom and when.
+func scheduleAuditParams(sc *store.Schedule) map[string]any {
+	params := map[string]any{
+		"name":      sc.Name,
+		"command":   sc.Command,
+		"params":    sc.Params,
+		"scooters":  sc.Scooters,
+		"groups":    sc.Groups,
+		"tags":      sc.Tags,
+		"all":       sc.All,
+		"condition": sc.Condition,
+		"cron":      sc.Cron,
+		"enabled":   sc.Enabled,
+	}
+	if sc.ID != 0 {
+		params["id"] = sc.ID
+	}
+	if sc.At != nil {
+		params["at"] = sc.At
+	}
+	return params
+}
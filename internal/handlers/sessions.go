@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/librescoot/uplink-server/internal/access"
+	"github.com/librescoot/uplink-server/internal/session"
+)
+
+// sessionView is the API view of a session, marking the caller's own.
+type sessionView struct {
+	session.Session
+	Current bool `json:"current,omitempty"`
+}
+
+// sessionManager reports whether the caller may see and end every user's
+// sessions.
+func (h *APIHandler) sessionManager(r *http.Request) bool {
+	p := h.principal(r)
+	return p.Allows(access.RoleAdmin) && p.Fleet()
+}
+
+// currentSession returns the session the request was made with, if any.
+func (h *APIHandler) currentSession(r *http.Request) (session.Session, bool) {
+	return h.sessions.Get(r.Header.Get("X-API-Key"))
+}
+
+// HandleSessions handles GET /api/sessions (active login sessions, most
+// recently used first) and DELETE /api/sessions ("log out everywhere": end
+// every session of the caller, including the current one). Users address
+// their own sessions; fleet-wide admins list everyone's and may pass ?user=
+// to list or end one user's.
+func (h *APIHandler) HandleSessions(w http.ResponseWriter, r *http.Request) {
+	h.cors(h.authenticate(func(w http.ResponseWriter, r *http.Request) {
+		if h.sessions == nil {
+			h.writeError(w, http.StatusServiceUnavailable, "Password login is not enabled")
+			return
+		}
+		current, isSession := h.currentSession(r)
+		username := r.URL.Query().Get("user")
+		switch {
+		case username != "" && username != current.Username && !h.sessionManager(r):
+			h.writeError(w, http.StatusForbidden, "Only admins may manage other users' sessions")
+			return
+		case username == "" && isSession && !(r.Method == http.MethodGet && h.sessionManager(r)):
+			username = current.Username
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			var list []session.Session
+			if username != "" || h.sessionManager(r) {
+				list = h.sessions.List(username)
+			}
+			views := make([]sessionView, 0, len(list))
+			for _, s := range list {
+				views = append(views, sessionView{Session: s, Current: isSession && s.ID == current.ID})
+			}
+			h.writeJSON(w, http.StatusOK, map[string]any{
+				"sessions": views,
+				"total":    len(views),
+			})
+		case http.MethodDelete:
+			if username == "" {
+				h.writeError(w, http.StatusBadRequest, "Not a login session; specify ?user=")
+				return
+			}
+			h.audit(r, "sessions.revoke", "", map[string]any{"user": username})
+			n := h.sessions.RevokeUser(username)
+			h.auditParam(r, "revoked", n)
+			h.writeJSON(w, http.StatusOK, map[string]any{
+				"user":    username,
+				"revoked": n,
+				"message": "Logged out everywhere",
+			})
+		default:
+			h.writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		}
+	}))(w, r)
+}
+
+// HandleSession handles GET and DELETE /api/sessions/{id}. Users may only
+// address their own sessions.
+func (h *APIHandler) HandleSession(w http.ResponseWriter, r *http.Request) {
+	h.cors(h.authenticate(func(w http.ResponseWriter, r *http.Request) {
+		if h.sessions == nil {
+			h.writeError(w, http.StatusServiceUnavailable, "Password login is not enabled")
+			return
+		}
+		id := extractPathParam(r.URL.Path, "/api/sessions/")
+		if id == "" {
+			h.writeError(w, http.StatusBadRequest, "Session ID required")
+			return
+		}
+		current, isSession := h.currentSession(r)
+		s, ok := h.sessions.Lookup(id)
+		if !ok || (!h.sessionManager(r) && (!isSession || s.Username != current.Username)) {
+			h.writeError(w, http.StatusNotFound, "Session not found")
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			h.writeJSON(w, http.StatusOK, sessionView{Session: s, Current: isSession && s.ID == current.ID})
+		case http.MethodDelete:
+			h.audit(r, "session.revoke", "", map[string]any{"id": id, "user": s.Username})
+			if _, ok := h.sessions.Revoke(id); !ok {
+				h.writeError(w, http.StatusNotFound, "Session not found")
+				return
+			}
+			h.writeJSON(w, http.StatusOK, map[string]any{
+				"id":      id,
+				"message": "Session revoked",
+			})
+		default:
+			h.writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		}
+	}))(w, r)
+}
@@ -0,0 +1,382 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/librescoot/uplink-server/internal/access"
+	"github.com/librescoot/uplink-server/internal/storage"
+)
+
+// sseBacklogSize is how many recent stream messages are retained so a client
+// reconnecting with Last-Event-ID can resume without gaps.
+const sseBacklogSize = 1000
+
+// sseKeepaliveInterval is how often an idle stream receives a comment line, so
+// proxies do not time the connection out.
+const sseKeepaliveInterval = 15 * time.Second
+
+// SSE event names.
+const (
+	sseEventState      = "state"
+	sseEventEvent      = "event"
+	sseEventConnection = "connection"
+)
+
+// sseMessage is one typed Server-Sent Event. ID is 0 for snapshot messages,
+// which are not part of the resumable sequence.
+type sseMessage struct {
+	ID        uint64
+	Event     string
+	ScooterID string
+	Data      []byte
+}
+
+// SSEHandler streams state, event and connection broadcasts as Server-Sent
+// Events at /api/stream, for dashboards behind proxies that drop WebSocket
+// upgrades. A single set of store subscriptions feeds all clients and a
+// bounded backlog, which backs Last-Event-ID resume.
+type SSEHandler struct {
+	stateStore *storage.StateStore
+	eventStore *storage.EventStore
+	connMgr    *storage.ConnectionManager
+	auth       Authenticator
+	policy     *access.Policy
+
+	mu         sync.RWMutex
+	nextID     uint64
+	backlog    []sseMessage // oldest first, at most sseBacklogSize
+	clients    map[int]chan sseMessage
+	nextClient int
+}
+
+// NewSSEHandler creates an SSE handler and starts forwarding store broadcasts.
+// Each client only receives messages about scooters within its scope.
+func NewSSEHandler(stateStore *storage.StateStore, eventStore *storage.EventStore, connMgr *storage.ConnectionManager, auth Authenticator, policy *access.Policy) *SSEHandler {
+	h := &SSEHandler{
+		stateStore: stateStore,
+		eventStore: eventStore,
+		connMgr:    connMgr,
+		auth:       auth,
+		policy:     policy,
+		clients:    make(map[int]chan sseMessage),
+		// Seed the sequence from the clock so IDs held by clients from a
+		// previous run never look resumable after a restart.
+		nextID: uint64(time.Now().UnixMicro()),
+	}
+	go h.forward()
+	return h
+}
+
+// forward subscribes to the stores for the lifetime of the process and
+// publishes every broadcast to the stream.
+func (h *SSEHandler) forward() {
+	updateChan, _ := h.stateStore.Subscribe()
+	eventChan, _ := h.eventStore.Subscribe()
+	connChan, _ := h.connMgr.Subscribe()
+
+	for {
+		select {
+		case update := <-updateChan:
+			h.publish(sseEventState, update.ScooterID, stateStreamPayload(update.ScooterID, update.Type, update.State, update.Timestamp))
+
+		case event := <-eventChan:
+			h.publish(sseEventEvent, event.ScooterID, eventStreamPayload(event))
+
+		case ce := <-connChan:
+			payload := map[string]any{
+				"scooter_id": ce.Identifier,
+				"status":     ce.Type,
+				"timestamp":  time.Now().UTC().Format(time.RFC3339),
+			}
+			if ce.Connection != nil {
+				payload["name"] = ce.Connection.Name
+				payload["version"] = ce.Connection.Version
+			}
+			h.publish(sseEventConnection, ce.Identifier, payload)
+		}
+	}
+}
+
+func stateStreamPayload(scooterID, updateType string, state map[string]any, ts time.Time) map[string]any {
+	return map[string]any{
+		"scooter_id":  scooterID,
+		"update_type": updateType,
+		"state":       state,
+		"timestamp":   ts.UTC().Format(time.RFC3339),
+	}
+}
+
+func eventStreamPayload(event *storage.Event) map[string]any {
+	return map[string]any{
+		"id":         event.ID,
+		"scooter_id": event.ScooterID,
+		"event":      event.Event,
+		"data":       event.Data,
+		"timestamp":  event.Timestamp.UTC().Format(time.RFC3339),
+	}
+}
+
+// publish assigns the next sequence ID, appends to the backlog and fans out to
+// connected clients. Slow clients miss messages rather than block the stream;
+// they can recover by reconnecting with Last-Event-ID.
+func (h *SSEHandler) publish(event, scooterID string, payload map[string]any) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("[SSE] Failed to marshal %s payload: %v", event, err)
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.nextID++
+	msg := sseMessage{ID: h.nextID, Event: event, ScooterID: scooterID, Data: data}
+	h.backlog = append(h.backlog, msg)
+	if len(h.backlog) > sseBacklogSize {
+		h.backlog = h.backlog[len(h.backlog)-sseBacklogSize:]
+	}
+
+	for _, ch := range h.clients {
+		select {
+		case ch <- msg:
+		default:
+			// Skip slow clients
+		}
+	}
+}
+
+// subscribe registers a client and returns the backlog messages after lastID.
+// resumed is false when lastID is unknown or has already been evicted, in
+// which case the client needs a fresh snapshot instead.
+func (h *SSEHandler) subscribe(lastID uint64) (ch chan sseMessage, id int, missed []sseMessage, resumed bool) {
+	ch = make(chan sseMessage, 256)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	id = h.nextClient
+	h.nextClient++
+	h.clients[id] = ch
+
+	// Backlog IDs are contiguous, so anything older than the first retained
+	// message (or from a previous process) cannot be resumed.
+	oldest := h.nextID - uint64(len(h.backlog)) + 1
+	if lastID == 0 || lastID > h.nextID || lastID+1 < oldest {
+		return ch, id, nil, false
+	}
+	for _, msg := range h.backlog {
+		if msg.ID > lastID {
+			missed = append(missed, msg)
+		}
+	}
+	return ch, id, missed, true
+}
+
+func (h *SSEHandler) unsubscribe(id int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.clients, id)
+}
+
+// HandleStream handles GET /api/stream. Optional ?scooter=ID (repeatable or
+// comma-separated) restricts the stream to those scooters; a Last-Event-ID
+// header (or ?last_event_id=) resumes after a reconnect.
+func (h *SSEHandler) HandleStream(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "X-API-Key, Last-Event-ID")
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// EventSource cannot set headers, so the key may also come as ?api_key=.
+	apiKey := r.Header.Get("X-API-Key")
+	if apiKey == "" {
+		apiKey = r.URL.Query().Get("api_key")
+	}
+	p, ok := h.policy.Authenticate(apiKey)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	filter := parseScooterFilter(r.URL.Query()["scooter"])
+	allowed := func(scooterID string) bool {
+		return filter.match(scooterID) && p.CanAccess(scooterID)
+	}
+
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("last_event_id")
+	}
+	lastID, _ := strconv.ParseUint(lastEventID, 10, 64)
+
+	ch, subID, missed, resumed := h.subscribe(lastID)
+	defer h.unsubscribe(subID)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no") // disable nginx response buffering
+	w.WriteHeader(http.StatusOK)
+
+	log.Printf("[SSE] Client connected from %s (resumed=%t, filter=%d scooters)", r.RemoteAddr, resumed, len(filter))
+
+	fmt.Fprintf(w, "retry: 3000\n\n")
+
+	sent := lastID
+	if resumed {
+		for _, msg := range missed {
+			if !allowed(msg.ScooterID) {
+				continue
+			}
+			if err := writeSSE(w, msg); err != nil {
+				return
+			}
+			sent = msg.ID
+		}
+	} else {
+		if err := h.writeSnapshot(w, allowed); err != nil {
+			return
+		}
+		sent = 0
+	}
+	flusher.Flush()
+
+	keepalive := time.NewTicker(sseKeepaliveInterval)
+	defer keepalive.Stop()
+	recheck := time.NewTicker(credentialRecheckInterval)
+	defer recheck.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			log.Printf("[SSE] Client disconnected from %s", r.RemoteAddr)
+			return
+
+		case msg := <-ch:
+			// Messages already replayed from the backlog may arrive again.
+			if msg.ID <= sent || !allowed(msg.ScooterID) {
+				continue
+			}
+			if err := writeSSE(w, msg); err != nil {
+				return
+			}
+			sent = msg.ID
+			flusher.Flush()
+
+		case <-keepalive.C:
+			if _, err := fmt.Fprintf(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+
+		case <-recheck.C:
+			// A revoked session or changed user ends the stream; the client
+			// reconnects and authenticates afresh.
+			if !credentialValid(h.policy, apiKey, p) {
+				log.Printf("[SSE] Closing stream of %s: credentials revoked or changed", p.Name)
+				return
+			}
+		}
+	}
+}
+
+// writeSnapshot sends the current connection status and full state of every
+// known scooter the client may see, so a fresh client starts from a
+// consistent view.
+func (h *SSEHandler) writeSnapshot(w http.ResponseWriter, allowed func(scooterID string) bool) error {
+	now := time.Now().UTC().Format(time.RFC3339)
+	online := make(map[string]bool)
+	for _, c := range h.connMgr.GetAllConnections() {
+		online[c.Identifier] = true
+		if !allowed(c.Identifier) {
+			continue
+		}
+		data, _ := json.Marshal(map[string]any{
+			"scooter_id": c.Identifier,
+			"status":     "online",
+			"name":       c.Name,
+			"version":    c.Version,
+			"timestamp":  now,
+		})
+		if err := writeSSE(w, sseMessage{Event: sseEventConnection, Data: data}); err != nil {
+			return err
+		}
+	}
+
+	for scooterID := range h.stateStore.GetAllStates() {
+		if !allowed(scooterID) {
+			continue
+		}
+		state, ok := h.stateStore.GetState(scooterID)
+		if !ok {
+			continue
+		}
+		if !online[scooterID] {
+			data, _ := json.Marshal(map[string]any{
+				"scooter_id": scooterID,
+				"status":     "offline",
+				"name":       h.auth.GetName(scooterID),
+				"version":    state.Version,
+				"timestamp":  now,
+			})
+			if err := writeSSE(w, sseMessage{Event: sseEventConnection, Data: data}); err != nil {
+				return err
+			}
+		}
+		data, _ := json.Marshal(stateStreamPayload(scooterID, "full", state.State, state.LastUpdated))
+		if err := writeSSE(w, sseMessage{Event: sseEventState, Data: data}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeSSE writes one message in text/event-stream framing. Data is compact
+// JSON and therefore a single line.
+func writeSSE(w http.ResponseWriter, msg sseMessage) error {
+	var b strings.Builder
+	if msg.ID != 0 {
+		fmt.Fprintf(&b, "id: %d\n", msg.ID)
+	}
+	fmt.Fprintf(&b, "event: %s\ndata: %s\n\n", msg.Event, msg.Data)
+	_, err := w.Write([]byte(b.String()))
+	return err
+}
+
+// scooterFilter is a set of scooter IDs; an empty filter matches everything.
+type scooterFilter map[string]bool
+
+func parseScooterFilter(values []string) scooterFilter {
+	f := make(scooterFilter)
+	for _, v := range values {
+		for _, id := range strings.Split(v, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				f[id] = true
+			}
+		}
+	}
+	return f
+}
+
+func (f scooterFilter) match(scooterID string) bool {
+	return len(f) == 0 || f[scooterID]
+}
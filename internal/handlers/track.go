@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/librescoot/uplink-server/internal/export"
+)
+
+// isTrackRequest checks if path is for a scooter's track export
+func isTrackRequest(path string) bool {
+	return strings.HasSuffix(path, "/track")
+}
+
+// handleGetScooterTrack streams a scooter's GPS track as GPX, GeoJSON or KML.
+// Accepts ?format=gpx|geojson|kml (default gpx) and optional ?from and ?to
+// (RFC3339, default the last 24 hours).
+func (h *APIHandler) handleGetScooterTrack(w http.ResponseWriter, r *http.Request, scooterID string) {
+	if h.db == nil {
+		h.writeError(w, http.StatusServiceUnavailable, "History persistence is not enabled")
+		return
+	}
+
+	to := time.Now()
+	from := to.Add(-24 * time.Hour)
+	if v := r.URL.Query().Get("from"); v != "" {
+		if t, err := time.Parse(time.RFC3339, v); err == nil {
+			from = t
+		}
+	}
+	if v := r.URL.Query().Get("to"); v != "" {
+		if t, err := time.Parse(time.RFC3339, v); err == nil {
+			to = t
+		}
+	}
+
+	h.streamTrack(w, r, export.Meta{
+		Name:      h.scooterName(scooterID),
+		ScooterID: scooterID,
+		From:      from,
+		To:        to,
+	})
+}
+
+// streamTrack writes the track described by meta in the requested ?format.
+// Once streaming has started errors can no longer be reported to the client,
+// so they are logged and the response is cut short.
+func (h *APIHandler) streamTrack(w http.ResponseWriter, r *http.Request, meta export.Meta) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = export.FormatGPX
+	}
+	ew, err := export.NewWriter(format, w)
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, "format must be gpx, geojson or kml")
+		return
+	}
+
+	w.Header().Set("Content-Type", export.ContentType(format))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", export.Filename(meta, format)))
+	w.WriteHeader(http.StatusOK)
+
+	if err := ew.Begin(meta); err != nil {
+		return
+	}
+	if err := h.db.StreamTrack(meta.ScooterID, meta.From, meta.To, ew.Point); err != nil {
+		log.Printf("[API] Track export for %s failed: %v", meta.ScooterID, err)
+		return
+	}
+	ew.End()
+}
+
+// scooterName returns the registered display name of a scooter, if any.
+func (h *APIHandler) scooterName(scooterID string) string {
+	if h.registry == nil {
+		return ""
+	}
+	for _, s := range h.registry.List() {
+		if s.Identifier == scooterID {
+			return s.Name
+		}
+	}
+	return ""
+}
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/librescoot/uplink-server/internal/export"
+)
+
+// isTripsRequest checks if path is for a scooter's trips
+func isTripsRequest(path string) bool {
+	return strings.HasSuffix(path, "/trips")
+}
+
+// handleGetScooterTrips returns detected trips for a scooter. Accepts optional
+// ?from and ?to (RFC3339, default the last 7 days) and ?limit.
+func (h *APIHandler) handleGetScooterTrips(w http.ResponseWriter, r *http.Request, scooterID string) {
+	if h.db == nil {
+		h.writeError(w, http.StatusServiceUnavailable, "History persistence is not enabled")
+		return
+	}
+
+	to := time.Now()
+	from := to.Add(-7 * 24 * time.Hour)
+	if v := r.URL.Query().Get("from"); v != "" {
+		if t, err := time.Parse(time.RFC3339, v); err == nil {
+			from = t
+		}
+	}
+	if v := r.URL.Query().Get("to"); v != "" {
+		if t, err := time.Parse(time.RFC3339, v); err == nil {
+			to = t
+		}
+	}
+	limit := 100
+	if v := r.URL.Query().Get("limit"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			limit = n
+		}
+	}
+
+	trips, err := h.db.QueryTrips(scooterID, from, to, limit)
+	if err != nil {
+		h.writeError(w, http.StatusInternalServerError, "Failed to query trips")
+		return
+	}
+
+	var distance float64
+	for _, t := range trips {
+		distance += t.DistanceM
+	}
+	h.writeJSON(w, http.StatusOK, map[string]any{
+		"scooter_id":       scooterID,
+		"from":             from.Format(time.RFC3339),
+		"to":               to.Format(time.RFC3339),
+		"count":            len(trips),
+		"total_distance_m": distance,
+		"trips":            trips,
+	})
+}
+
+// HandleTrip handles GET /api/trips/{id} and GET /api/trips/{id}/track. With
+// ?track=true the trip response includes its GPS track as JSON; the /track
+// endpoint exports it as GPX, GeoJSON or KML (see streamTrack).
+func (h *APIHandler) HandleTrip(w http.ResponseWriter, r *http.Request) {
+	h.cors(h.authenticate(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			h.writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		if h.db == nil {
+			h.writeError(w, http.StatusServiceUnavailable, "History persistence is not enabled")
+			return
+		}
+		param := extractPathParam(r.URL.Path, "/api/trips/")
+		exportTrack := isTrackRequest(param)
+		id, err := strconv.ParseInt(strings.TrimSuffix(param, "/track"), 10, 64)
+		if err != nil {
+			h.writeError(w, http.StatusBadRequest, "Trip ID required")
+			return
+		}
+
+		trip, ok, err := h.db.GetTrip(id)
+		if err != nil {
+			h.writeError(w, http.StatusInternalServerError, "Failed to query trip")
+			return
+		}
+		if !ok {
+			h.writeError(w, http.StatusNotFound, "Trip not found")
+			return
+		}
+		if !h.principal(r).CanAccess(trip.ScooterID) {
+			h.writeError(w, http.StatusForbidden, "Scooter is outside your scope")
+			return
+		}
+
+		if exportTrack {
+			h.streamTrack(w, r, export.Meta{
+				Name:      h.scooterName(trip.ScooterID),
+				ScooterID: trip.ScooterID,
+				TripID:    trip.ID,
+				From:      trip.Start,
+				To:        trip.End,
+			})
+			return
+		}
+
+		resp := map[string]any{"trip": trip}
+		if r.URL.Query().Get("track") == "true" {
+			track, err := h.db.QueryTrack(trip.ScooterID, trip.Start, trip.End)
+			if err != nil {
+				h.writeError(w, http.StatusInternalServerError, "Failed to query track")
+				return
+			}
+			resp["track"] = track
+		}
+		h.writeJSON(w, http.StatusOK, resp)
+	}))(w, r)
+}
@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/librescoot/uplink-server/internal/models"
+	"github.com/librescoot/uplink-server/internal/registry"
+)
+
+// HandleUsers handles GET /api/users (list) and POST /api/users (create).
+// Password hashes are never returned.
+func (h *APIHandler) HandleUsers(w http.ResponseWriter, r *http.Request) {
+	h.cors(h.authenticate(func(w http.ResponseWriter, r *http.Request) {
+		if h.registry == nil {
+			h.writeError(w, http.StatusServiceUnavailable, "Registry is not enabled")
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			users := h.registry.Users()
+			h.writeJSON(w, http.StatusOK, map[string]any{
+				"users": users,
+				"total": len(users),
+			})
+		case http.MethodPost:
+			var req struct {
+				Name     string   `json:"name"`
+				Password string   `json:"password"`
+				Role     string   `json:"role"`
+				Scooters []string `json:"scooters"`
+				Groups   []string `json:"groups"`
+			}
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				h.writeError(w, http.StatusBadRequest, "Failed to read request body")
+				return
+			}
+			if err := json.Unmarshal(body, &req); err != nil {
+				h.writeError(w, http.StatusBadRequest, "Invalid JSON format")
+				return
+			}
+			h.audit(r, "user.create", "", map[string]any{
+				"name":     req.Name,
+				"role":     req.Role,
+				"scooters": req.Scooters,
+				"groups":   req.Groups,
+			})
+			p, err := h.registry.AddUser(req.Name, req.Password, models.Grant{Role: req.Role, Scooters: req.Scooters, Groups: req.Groups})
+			if err != nil {
+				h.writeUserError(w, err)
+				return
+			}
+			h.writeJSON(w, http.StatusCreated, p)
+		default:
+			h.writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		}
+	}))(w, r)
+}
+
+// HandleUser handles GET, PATCH and DELETE /api/users/{name}. PATCH changes
+// the password, role and scope; fields left out of the body are kept.
+func (h *APIHandler) HandleUser(w http.ResponseWriter, r *http.Request) {
+	h.cors(h.authenticate(func(w http.ResponseWriter, r *http.Request) {
+		if h.registry == nil {
+			h.writeError(w, http.StatusServiceUnavailable, "Registry is not enabled")
+			return
+		}
+		name := extractPathParam(r.URL.Path, "/api/users/")
+		if name == "" {
+			h.writeError(w, http.StatusBadRequest, "User name required")
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			p, ok := h.policy.User(name)
+			if !ok {
+				h.writeError(w, http.StatusNotFound, "User not found")
+				return
+			}
+			h.writeJSON(w, http.StatusOK, p)
+		case http.MethodPatch:
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				h.writeError(w, http.StatusBadRequest, "Failed to read request body")
+				return
+			}
+			var u registry.UserUpdate
+			if err := json.Unmarshal(body, &u); err != nil {
+				h.writeError(w, http.StatusBadRequest, "Invalid JSON format")
+				return
+			}
+			h.audit(r, "user.update", "", userUpdateParams(name, u))
+			p, err := h.registry.UpdateUser(name, u)
+			if err != nil {
+				h.writeUserError(w, err)
+				return
+			}
+			// A new password logs the user out everywhere.
+			if u.Password != nil && h.sessions != nil {
+				h.auditParam(r, "sessions_revoked", h.sessions.RevokeUser(name))
+			}
+			h.writeJSON(w, http.StatusOK, p)
+		case http.MethodDelete:
+			h.audit(r, "user.delete", "", map[string]any{"name": name})
+			if err := h.registry.RemoveUser(name); err != nil {
+				h.writeUserError(w, err)
+				return
+			}
+			if h.sessions != nil {
+				h.sessions.RevokeUser(name)
+			}
+			h.writeJSON(w, http.StatusOK, map[string]any{
+				"name":    name,
+				"message": "User removed",
+			})
+		default:
+			h.writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		}
+	}))(w, r)
+}
+
+// userUpdateParams describes a user update for the audit log without the
+// new password.
+func userUpdateParams(name string, u registry.UserUpdate) map[string]any {
+	params := map[string]any{"name": name, "password_changed": u.Password != nil}
+	if u.Role != nil {
+		params["role"] = *u.Role
+	}
+	if u.Scooters != nil {
+		params["scooters"] = *u.Scooters
+	}
+	if u.Groups != nil {
+		params["groups"] = *u.Groups
+	}
+	return params
+}
+
+// writeUserError maps a registry user error to its HTTP status.
+func (h *APIHandler) writeUserError(w http.ResponseWriter, err error) {
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, "not found"):
+		h.writeError(w, http.StatusNotFound, msg)
+	case strings.Contains(msg, "already exists"):
+		h.writeError(w, http.StatusConflict, msg)
+	case strings.Contains(msg, "not enabled"):
+		h.writeError(w, http.StatusServiceUnavailable, msg)
+	case strings.HasPrefix(msg, "persist config"):
+		h.writeError(w, http.StatusInternalServerError, msg)
+	default:
+		h.writeError(w, http.StatusBadRequest, msg)
+	}
+}
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/librescoot/uplink-server/internal/store"
+	"github.com/librescoot/uplink-server/internal/webhooks"
+)
+
+// SetWebhooks enables the webhook status endpoint.
+func (h *APIHandler) SetWebhooks(d *webhooks.Dispatcher) {
+	h.webhooks = d
+}
+
+// HandleWebhooks handles GET /api/webhooks: the configured subscriptions
+// (without secrets) with their outbox counts and most recent error.
+func (h *APIHandler) HandleWebhooks(w http.ResponseWriter, r *http.Request) {
+	h.cors(h.authenticate(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			h.writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		if h.webhooks == nil {
+			h.writeError(w, http.StatusServiceUnavailable, "Webhooks are not enabled")
+			return
+		}
+
+		counts, lastErrors, err := h.db.WebhookStats()
+		if err != nil {
+			h.writeError(w, http.StatusInternalServerError, "Failed to query webhook outbox")
+			return
+		}
+		subs := h.webhooks.Subscriptions()
+		list := make([]map[string]any, 0, len(subs))
+		for _, s := range subs {
+			c := counts[s.Name]
+			list = append(list, map[string]any{
+				"name":        s.Name,
+				"url":         s.URL,
+				"signed":      s.Secret != "",
+				"types":       s.Types,
+				"scooters":    s.Scooters,
+				"events":      s.Events,
+				"state_paths": s.StatePaths,
+				"pending":     c[store.WebhookPending],
+				"delivered":   c[store.WebhookDelivered],
+				"failed":      c[store.WebhookFailed],
+				"last_error":  lastErrors[s.Name],
+			})
+		}
+		h.writeJSON(w, http.StatusOK, map[string]any{
+			"webhooks": list,
+			"total":    len(list),
+		})
+	}))(w, r)
+}
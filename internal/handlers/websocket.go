@@ -1,7 +1,11 @@
 package handlers
 
 import (
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"time"
@@ -9,6 +13,8 @@ import (
 	"github.com/gorilla/websocket"
 
 	"github.com/librescoot/uplink-server/internal/auth"
+	"github.com/librescoot/uplink-server/internal/commands"
+	"github.com/librescoot/uplink-server/internal/lockout"
 	"github.com/librescoot/uplink-server/internal/models"
 	"github.com/librescoot/uplink-server/internal/protocol"
 	"github.com/librescoot/uplink-server/internal/storage"
@@ -29,15 +35,34 @@ type WebSocketHandler struct {
 	responseStore     *storage.ResponseStore
 	stateStore        *storage.StateStore
 	eventStore        *storage.EventStore
-	db                *store.Store // durable persistence; may be nil
+	db                store.Storage // durable persistence; may be nil
 	keepaliveInterval time.Duration
 	messageRateLimit  int
 	idleTimeout       time.Duration
+	telemetryHooks    []TelemetryHook
+	commandHooks      []CommandResultHook
+	messageHooks      []MessageHook
+	policy            CommandPolicy
+	catalog           *commands.Catalog
+	lockout           *lockout.Guard // failed auth throttling; may be nil
 }
 
+// TelemetryHook is called with a scooter's merged state after each telemetry
+// update, with the time the state applies to.
+type TelemetryHook func(scooterID string, state map[string]any, ts time.Time)
+
+// CommandResultHook is called when a scooter reports a command's final
+// outcome (any response other than "running"), or with status "timed_out"
+// when the server stops waiting for one.
+type CommandResultHook func(scooterID string, resp *protocol.CommandResponse)
+
+// MessageHook is called for each inbound scooter message with its type and
+// size in bytes.
+type MessageHook func(scooterID string, msgType protocol.MessageType, size int)
+
 // NewWebSocketHandler creates a new WebSocket handler. db may be nil to disable
 // durable persistence and command queuing.
-func NewWebSocketHandler(authenticator *auth.Authenticator, connMgr *storage.ConnectionManager, responseStore *storage.ResponseStore, stateStore *storage.StateStore, eventStore *storage.EventStore, db *store.Store, keepaliveInterval time.Duration, messageRateLimit int, idleTimeout time.Duration) *WebSocketHandler {
+func NewWebSocketHandler(authenticator *auth.Authenticator, connMgr *storage.ConnectionManager, responseStore *storage.ResponseStore, stateStore *storage.StateStore, eventStore *storage.EventStore, db store.Storage, keepaliveInterval time.Duration, messageRateLimit int, idleTimeout time.Duration) *WebSocketHandler {
 	return &WebSocketHandler{
 		auth:              authenticator,
 		connMgr:           connMgr,
@@ -48,6 +73,8 @@ func NewWebSocketHandler(authenticator *auth.Authenticator, connMgr *storage.Con
 		keepaliveInterval: keepaliveInterval,
 		messageRateLimit:  messageRateLimit,
 		idleTimeout:       idleTimeout,
+		policy:            DefaultCommandPolicy(),
+		catalog:           commands.Default(),
 	}
 }
 
@@ -92,12 +119,34 @@ func (h *WebSocketHandler) HandleConnection(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	// Authenticate
-	if err := h.auth.Authenticate(authMsg.Identifier, authMsg.Token); err != nil {
-		log.Printf("[WS] Authentication failed for %s: %v", authMsg.Identifier, err)
+	// Authenticate. Valid credentials get through a lockout of the
+	// identifier; only a lockout of the client IP refuses them.
+	var ip string
+	if h.lockout != nil {
+		ip = h.lockout.ClientIP(r)
+		if wait := h.lockout.Check(lockout.KindScooter, "", ip, time.Now()); wait > 0 {
+			log.Printf("[WS] Refused authentication from locked-out %s", clientAddr)
+			h.sendAuthResponse(conn, "error", lockedOutMessage(wait))
+			return
+		}
+	}
+	requested := authMsg.Identifier
+	authMsg.Identifier, err = h.auth.AuthenticateScooter(authMsg.Identifier, authMsg.Token, clientCert(r))
+	if err != nil {
+		log.Printf("[WS] Authentication failed from %s: %v", clientAddr, err)
+		if h.lockout != nil {
+			if wait := h.lockout.Check(lockout.KindScooter, requested, ip, time.Now()); wait > 0 {
+				h.sendAuthResponse(conn, "error", lockedOutMessage(wait))
+				return
+			}
+			h.lockout.Fail(lockout.KindScooter, requested, ip, err.Error(), time.Now())
+		}
 		h.sendAuthResponse(conn, "error", "Authentication failed")
 		return
 	}
+	if h.lockout != nil {
+		h.lockout.Succeed(lockout.KindScooter, authMsg.Identifier, ip)
+	}
 
 	// Create connection object
 	connection := models.NewConnection(authMsg.Identifier, conn)
@@ -105,6 +154,7 @@ func (h *WebSocketHandler) HandleConnection(w http.ResponseWriter, r *http.Reque
 	connection.Authenticated = true
 	connection.Name = h.auth.GetName(authMsg.Identifier)
 	connection.StatsConn = statsWriter.GetStatsConn() // Track wire-level bytes
+	connection.Transport = "websocket"
 
 	// Add to connection manager
 	if err := h.connMgr.AddConnection(connection); err != nil {
@@ -139,6 +189,15 @@ func (h *WebSocketHandler) HandleConnection(w http.ResponseWriter, r *http.Reque
 	h.messageReceiver(connection)
 }
 
+// clientCert returns the client certificate a request's TLS connection
+// presented and verified against the client CAs, or nil.
+func clientCert(r *http.Request) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return r.TLS.VerifiedChains[0][0]
+}
+
 // messageReceiver handles incoming messages
 func (h *WebSocketHandler) messageReceiver(conn *models.Connection) {
 	var rateLimiter <-chan time.Time
@@ -162,129 +221,135 @@ func (h *WebSocketHandler) messageReceiver(conn *models.Connection) {
 			<-rateLimiter
 		}
 
-		conn.AddBytesReceived(int64(len(message)))
-		conn.IncrementMessagesReceived()
-		conn.UpdateLastSeen()
+		h.handleMessage(conn, message)
+	}
+}
 
-		var baseMsg protocol.BaseMessage
-		if err := json.Unmarshal(message, &baseMsg); err != nil {
-			log.Printf("[WS] Failed to parse message from %s: %v", conn.Identifier, err)
-			continue
-		}
+// handleMessage processes one inbound scooter message. It is shared by the
+// WebSocket and long-poll transports.
+func (h *WebSocketHandler) handleMessage(conn *models.Connection, message []byte) {
+	conn.AddBytesReceived(int64(len(message)))
+	conn.IncrementMessagesReceived()
+	conn.UpdateLastSeen()
 
-		switch baseMsg.Type {
-		case protocol.MsgTypeKeepalive:
-			log.Printf("[WS] Received keepalive from %s", conn.Identifier)
+	var baseMsg protocol.BaseMessage
+	if err := json.Unmarshal(message, &baseMsg); err != nil {
+		log.Printf("[WS] Failed to parse message from %s: %v", conn.Identifier, err)
+		return
+	}
+	for _, hook := range h.messageHooks {
+		hook(conn.Identifier, baseMsg.Type, len(message))
+	}
 
-		case protocol.MsgTypeState:
-			var stateMsg protocol.StateMessage
-			if err := json.Unmarshal(message, &stateMsg); err != nil {
-				log.Printf("[WS] Failed to parse state from %s: %v", conn.Identifier, err)
-				continue
-			}
-			conn.IncrementTelemetryReceived()
+	switch baseMsg.Type {
+	case protocol.MsgTypeKeepalive:
+		log.Printf("[WS] Received keepalive from %s", conn.Identifier)
 
-			h.stateStore.UpdateState(conn.Identifier, stateMsg.Data)
-			h.persistTelemetry(conn.Identifier, stateMsg.Data, time.Now())
+	case protocol.MsgTypeState:
+		var stateMsg protocol.StateMessage
+		if err := json.Unmarshal(message, &stateMsg); err != nil {
+			log.Printf("[WS] Failed to parse state from %s: %v", conn.Identifier, err)
+			return
+		}
+		conn.IncrementTelemetryReceived()
 
-			stateJSON, _ := json.MarshalIndent(stateMsg.Data, "", "  ")
-			log.Printf("[WS] Received state snapshot from %s:\n%s", conn.Identifier, string(stateJSON))
+		h.stateStore.UpdateState(conn.Identifier, stateMsg.Data)
+		h.persistTelemetry(conn.Identifier, stateMsg.Data, time.Now())
 
-		case protocol.MsgTypeChange:
-			var changeMsg protocol.ChangeMessage
-			if err := json.Unmarshal(message, &changeMsg); err != nil {
-				log.Printf("[WS] Failed to parse change from %s: %v", conn.Identifier, err)
-				continue
-			}
-			conn.IncrementTelemetryReceived()
+		stateJSON, _ := json.MarshalIndent(stateMsg.Data, "", "  ")
+		log.Printf("[WS] Received state snapshot from %s:\n%s", conn.Identifier, string(stateJSON))
 
-			h.stateStore.UpdateChanges(conn.Identifier, changeMsg.Changes)
-			h.persistMergedTelemetry(conn.Identifier)
+	case protocol.MsgTypeChange:
+		var changeMsg protocol.ChangeMessage
+		if err := json.Unmarshal(message, &changeMsg); err != nil {
+			log.Printf("[WS] Failed to parse change from %s: %v", conn.Identifier, err)
+			return
+		}
+		conn.IncrementTelemetryReceived()
 
-			changeJSON, _ := json.MarshalIndent(changeMsg.Changes, "", "  ")
-			log.Printf("[WS] Received state changes from %s:\n%s", conn.Identifier, string(changeJSON))
+		h.stateStore.UpdateChanges(conn.Identifier, changeMsg.Changes)
+		h.persistMergedTelemetry(conn.Identifier)
 
-		case protocol.MsgTypeTelemetryDelta:
-			var deltaMsg protocol.TelemetryDeltaMessage
-			if err := json.Unmarshal(message, &deltaMsg); err != nil {
-				log.Printf("[WS] Failed to parse telemetry delta from %s: %v", conn.Identifier, err)
-				continue
-			}
-			conn.IncrementTelemetryReceived()
+		changeJSON, _ := json.MarshalIndent(changeMsg.Changes, "", "  ")
+		log.Printf("[WS] Received state changes from %s:\n%s", conn.Identifier, string(changeJSON))
 
-			h.stateStore.UpdateTelemetryDelta(conn.Identifier, deltaMsg.Changes, deltaMsg.Removed)
-			h.persistMergedTelemetry(conn.Identifier)
+	case protocol.MsgTypeTelemetryDelta:
+		var deltaMsg protocol.TelemetryDeltaMessage
+		if err := json.Unmarshal(message, &deltaMsg); err != nil {
+			log.Printf("[WS] Failed to parse telemetry delta from %s: %v", conn.Identifier, err)
+			return
+		}
+		conn.IncrementTelemetryReceived()
 
-			log.Printf("[WS] Received telemetry delta from %s (%d changes, %d removed)",
-				conn.Identifier, len(deltaMsg.Changes), len(deltaMsg.Removed))
+		h.stateStore.UpdateTelemetryDelta(conn.Identifier, deltaMsg.Changes, deltaMsg.Removed)
+		h.persistMergedTelemetry(conn.Identifier)
 
-		case protocol.MsgTypeTelemetryBatch:
-			var batchMsg protocol.TelemetryBatchMessage
-			if err := json.Unmarshal(message, &batchMsg); err != nil {
-				log.Printf("[WS] Failed to parse telemetry batch from %s: %v", conn.Identifier, err)
-				continue
-			}
-			conn.IncrementTelemetryReceived()
+		log.Printf("[WS] Received telemetry delta from %s (%d changes, %d removed)",
+			conn.Identifier, len(deltaMsg.Changes), len(deltaMsg.Removed))
 
-			for _, snap := range batchMsg.Snapshots {
-				h.stateStore.UpdateState(conn.Identifier, snap.Data)
-				ts := parseTimestamp(snap.Timestamp)
-				h.persistTelemetry(conn.Identifier, snap.Data, ts)
-			}
-			log.Printf("[WS] Received telemetry batch from %s (%d snapshots)", conn.Identifier, len(batchMsg.Snapshots))
+	case protocol.MsgTypeTelemetryBatch:
+		var batchMsg protocol.TelemetryBatchMessage
+		if err := json.Unmarshal(message, &batchMsg); err != nil {
+			log.Printf("[WS] Failed to parse telemetry batch from %s: %v", conn.Identifier, err)
+			return
+		}
+		conn.IncrementTelemetryReceived()
 
-		case protocol.MsgTypeEvent:
-			var eventMsg protocol.EventMessage
-			if err := json.Unmarshal(message, &eventMsg); err != nil {
-				log.Printf("[WS] Failed to parse event from %s: %v", conn.Identifier, err)
-				continue
-			}
-			conn.IncrementTelemetryReceived()
+		for _, snap := range batchMsg.Snapshots {
+			h.stateStore.UpdateState(conn.Identifier, snap.Data)
+			ts := parseTimestamp(snap.Timestamp)
+			h.persistTelemetry(conn.Identifier, snap.Data, ts)
+		}
+		log.Printf("[WS] Received telemetry batch from %s (%d snapshots)", conn.Identifier, len(batchMsg.Snapshots))
 
-			// Parse timestamp
-			timestamp, err := time.Parse(time.RFC3339, eventMsg.Timestamp)
-			if err != nil {
-				timestamp = time.Now()
-			}
+	case protocol.MsgTypeEvent:
+		var eventMsg protocol.EventMessage
+		if err := json.Unmarshal(message, &eventMsg); err != nil {
+			log.Printf("[WS] Failed to parse event from %s: %v", conn.Identifier, err)
+			return
+		}
+		conn.IncrementTelemetryReceived()
 
-			// Store event
-			h.eventStore.AddEvent(conn.Identifier, eventMsg.Event, eventMsg.Data, timestamp)
-			if h.db != nil {
-				if err := h.db.InsertEvent(conn.Identifier, timestamp, eventMsg.Event, eventMsg.Data); err != nil {
-					log.Printf("[WS] Failed to persist event: %v", err)
-				}
-			}
+		// Parse timestamp
+		timestamp, err := time.Parse(time.RFC3339, eventMsg.Timestamp)
+		if err != nil {
+			timestamp = time.Now()
+		}
 
-			eventJSON, _ := json.MarshalIndent(eventMsg.Data, "", "  ")
-			log.Printf("[WS] Received EVENT '%s' from %s:\n%s", eventMsg.Event, conn.Identifier, string(eventJSON))
+		// Store event
+		h.RecordEvent(conn.Identifier, eventMsg.Event, eventMsg.Data, timestamp)
 
-		case protocol.MsgTypeCommandResponse:
-			var cmdResp protocol.CommandResponse
-			if err := json.Unmarshal(message, &cmdResp); err != nil {
-				log.Printf("[WS] Failed to parse command response from %s: %v", conn.Identifier, err)
-				continue
-			}
+		eventJSON, _ := json.MarshalIndent(eventMsg.Data, "", "  ")
+		log.Printf("[WS] Received EVENT '%s' from %s:\n%s", eventMsg.Event, conn.Identifier, string(eventJSON))
 
-			h.responseStore.Store(cmdResp.RequestID, conn.Identifier, "", &cmdResp)
+	case protocol.MsgTypeCommandResponse:
+		var cmdResp protocol.CommandResponse
+		if err := json.Unmarshal(message, &cmdResp); err != nil {
+			log.Printf("[WS] Failed to parse command response from %s: %v", conn.Identifier, err)
+			return
+		}
 
-			// Persist terminal outcomes to durable command history. "running"
-			// frames are streaming progress and do not close out the record.
-			if h.db != nil && cmdResp.Status != "running" {
-				status := store.StatusSuccess
-				if cmdResp.Status != "success" {
-					status = store.StatusFailed
-				}
-				if err := h.db.UpdateResult(cmdResp.RequestID, status, cmdResp.Result, cmdResp.Error); err != nil {
-					log.Printf("[WS] Failed to persist command result: %v", err)
-				}
+		h.responseStore.Store(cmdResp.RequestID, conn.Identifier, "", &cmdResp)
+
+		// Persist terminal outcomes to durable command history. "running"
+		// frames are streaming progress and do not close out the record, but
+		// they do acknowledge delivery.
+		if h.db != nil && cmdResp.Status == "running" {
+			if err := h.db.MarkRunning(cmdResp.RequestID, h.runDeadline(time.Now())); err != nil {
+				log.Printf("[WS] Failed to persist command ack: %v", err)
+			}
+		}
+		if cmdResp.Status != "running" && h.settleCommand(&cmdResp) {
+			for _, hook := range h.commandHooks {
+				hook(conn.Identifier, &cmdResp)
 			}
+		}
 
-			log.Printf("[WS] Received command response from %s: request_id=%s status=%s",
-				conn.Identifier, cmdResp.RequestID, cmdResp.Status)
+		log.Printf("[WS] Received command response from %s: request_id=%s status=%s",
+			conn.Identifier, cmdResp.RequestID, cmdResp.Status)
 
-		default:
-			log.Printf("[WS] Unknown message type from %s: %s", conn.Identifier, baseMsg.Type)
-		}
+	default:
+		log.Printf("[WS] Unknown message type from %s: %s", conn.Identifier, baseMsg.Type)
 	}
 }
 
@@ -371,8 +436,13 @@ func (h *WebSocketHandler) sendAuthResponse(conn *websocket.Conn, status, errMsg
 	}
 }
 
-// SendCommand sends a command to a scooter
+// SendCommand sends a command to a scooter. Commands are checked against the
+// catalog first, see commands.Catalog.Validate and CheckVersion.
 func (h *WebSocketHandler) SendCommand(identifier, command string, params map[string]any) (string, error) {
+	if err := h.catalog.Validate(command, params); err != nil {
+		return "", err
+	}
+
 	conn, exists := h.connMgr.GetConnection(identifier)
 	if !exists {
 		return "", ErrConnectionNotFound
@@ -381,6 +451,9 @@ func (h *WebSocketHandler) SendCommand(identifier, command string, params map[st
 	if !conn.Authenticated {
 		return "", ErrNotAuthenticated
 	}
+	if err := h.catalog.CheckVersion(command, conn.Version); err != nil {
+		return "", err
+	}
 
 	cmdMsg := protocol.CommandMessage{
 		Type:      protocol.MsgTypeCommand,
@@ -399,7 +472,8 @@ func (h *WebSocketHandler) SendCommand(identifier, command string, params map[st
 	case conn.SendChannel() <- data:
 		conn.IncrementCommandsSent()
 		if h.db != nil {
-			if err := h.db.RecordSent(cmdMsg.RequestID, identifier, command, params); err != nil {
+			now := time.Now()
+			if err := h.db.RecordSent(cmdMsg.RequestID, identifier, command, params, h.ackDeadline(now), h.deliveryExpiry(now)); err != nil {
 				log.Printf("[WS] Failed to record command: %v", err)
 			}
 		}
@@ -440,11 +514,23 @@ func (h *WebSocketHandler) SendConfigUpdate(identifier string, deltas map[string
 }
 
 // EnqueueCommand persists a command for later delivery to an offline scooter
-// and returns the generated request ID.
+// and returns the generated request ID. Commands the catalog marks as not
+// queueable are refused with ErrNotQueueable.
 func (h *WebSocketHandler) EnqueueCommand(identifier, command string, params map[string]any, ttl time.Duration) (string, error) {
 	if h.db == nil {
 		return "", ErrConnectionNotFound
 	}
+	if err := h.catalog.Validate(command, params); err != nil {
+		return "", err
+	}
+	if !h.catalog.Queueable(command) {
+		return "", ErrNotQueueable
+	}
+	if state, ok := h.stateStore.GetState(identifier); ok {
+		if err := h.catalog.CheckVersion(command, state.Version); err != nil {
+			return "", err
+		}
+	}
 	requestID := generateRequestID()
 	if err := h.db.Enqueue(requestID, identifier, command, params, ttl); err != nil {
 		return "", err
@@ -453,13 +539,27 @@ func (h *WebSocketHandler) EnqueueCommand(identifier, command string, params map
 	return requestID, nil
 }
 
-// replayQueuedCommands delivers any commands queued while the scooter was
+// DispatchCommand sends a command to an online scooter or, when queue is set
+// and the scooter is offline, queues it for ttl. queued reports which of the
+// two happened.
+func (h *WebSocketHandler) DispatchCommand(identifier, command string, params map[string]any, queue bool, ttl time.Duration) (requestID string, queued bool, err error) {
+	requestID, err = h.SendCommand(identifier, command, params)
+	if err != ErrConnectionNotFound || !queue || h.db == nil {
+		return requestID, false, err
+	}
+	requestID, err = h.EnqueueCommand(identifier, command, params, ttl)
+	return requestID, err == nil, err
+}
+
+// replayQueuedCommands resends commands left unanswered by the scooter's
+// previous connection, then delivers any commands queued while it was
 // offline, in enqueue order.
 func (h *WebSocketHandler) replayQueuedCommands(conn *models.Connection) {
 	if h.db == nil {
 		return
 	}
-	queued, err := h.db.DequeueQueued(conn.Identifier)
+	h.resendUnacked(conn)
+	queued, err := h.db.DequeueQueued(conn.Identifier, h.ackDeadline(time.Now()))
 	if err != nil {
 		log.Printf("[WS] Failed to dequeue commands for %s: %v", conn.Identifier, err)
 		return
@@ -486,19 +586,51 @@ func (h *WebSocketHandler) replayQueuedCommands(conn *models.Connection) {
 	}
 }
 
-// persistTelemetry stores a full snapshot to durable history.
+// OnTelemetry registers a hook run after every telemetry update. Hooks must
+// be registered before the server starts accepting connections.
+func (h *WebSocketHandler) OnTelemetry(hook TelemetryHook) {
+	h.telemetryHooks = append(h.telemetryHooks, hook)
+}
+
+// OnCommandResult registers a hook run when a command completes. Hooks must
+// be registered before the server starts accepting connections.
+func (h *WebSocketHandler) OnCommandResult(hook CommandResultHook) {
+	h.commandHooks = append(h.commandHooks, hook)
+}
+
+// OnMessage registers a hook run for every inbound scooter message. Hooks
+// must be registered before the server starts accepting connections.
+func (h *WebSocketHandler) OnMessage(hook MessageHook) {
+	h.messageHooks = append(h.messageHooks, hook)
+}
+
+// RecordEvent stores an event, broadcasts it to subscribers and persists it
+// to durable history. Used for scooter-originated and synthetic events alike.
+func (h *WebSocketHandler) RecordEvent(scooterID, event string, data map[string]any, ts time.Time) {
+	h.eventStore.AddEvent(scooterID, event, data, ts)
+	if h.db != nil {
+		if err := h.db.InsertEvent(scooterID, ts, event, data); err != nil {
+			log.Printf("[WS] Failed to persist event: %v", err)
+		}
+	}
+}
+
+// persistTelemetry stores a full snapshot to durable history and runs the
+// telemetry hooks.
 func (h *WebSocketHandler) persistTelemetry(scooterID string, data map[string]any, ts time.Time) {
-	if h.db == nil {
-		return
+	if h.db != nil {
+		if err := h.db.InsertTelemetry(scooterID, ts, data); err != nil {
+			log.Printf("[WS] Failed to persist telemetry: %v", err)
+		}
 	}
-	if err := h.db.InsertTelemetry(scooterID, ts, data); err != nil {
-		log.Printf("[WS] Failed to persist telemetry: %v", err)
+	for _, hook := range h.telemetryHooks {
+		hook(scooterID, data, ts)
 	}
 }
 
 // persistMergedTelemetry stores the post-merge full state after a delta/change.
 func (h *WebSocketHandler) persistMergedTelemetry(scooterID string) {
-	if h.db == nil {
+	if h.db == nil && len(h.telemetryHooks) == 0 {
 		return
 	}
 	if state, ok := h.stateStore.GetState(scooterID); ok {
@@ -515,28 +647,18 @@ func parseTimestamp(ts string) time.Time {
 	return time.Now()
 }
 
-// neverQueue lists commands that must never be deferred to an offline scooter,
-// because delivering them late could be unsafe or surprising (physical
-// actuation and security-sensitive actions).
-var neverQueue = map[string]bool{
-	"unlock":       true,
-	"open_seatbox": true,
-	"force_lock":   true,
-}
-
-// IsQueueable reports whether a command may be queued for later delivery.
-func IsQueueable(command string) bool {
-	return !neverQueue[command]
-}
-
-// generateRequestID generates a unique request ID
+// generateRequestID generates a unique request ID. The random suffix keeps
+// IDs apart when bulk sends issue several within the same microsecond.
 func generateRequestID() string {
-	return time.Now().Format("20060102-150405.000000")
+	var suffix [4]byte
+	_, _ = rand.Read(suffix[:])
+	return time.Now().Format("20060102-150405.000000") + "-" + hex.EncodeToString(suffix[:])
 }
 
 // Common errors
 var (
-	ErrConnectionNotFound = http.ErrBodyNotAllowed // placeholder
-	ErrNotAuthenticated   = http.ErrNotSupported   // placeholder
-	ErrSendChannelFull    = http.ErrHandlerTimeout // placeholder
+	ErrConnectionNotFound = errors.New("scooter not connected")
+	ErrNotAuthenticated   = errors.New("scooter not authenticated")
+	ErrSendChannelFull    = errors.New("send channel full")
+	ErrNotQueueable       = errors.New("command may not be queued while offline")
 )
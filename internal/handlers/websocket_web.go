@@ -1,15 +1,16 @@
 package handlers
 
 import (
-	"crypto/subtle"
 	"log"
 	"net/http"
 	"time"
 
 	"github.com/gorilla/websocket"
 
-	"github.com/librescoot/uplink-server/internal/session"
+	"github.com/librescoot/uplink-server/internal/access"
+	"github.com/librescoot/uplink-server/internal/batch"
 	"github.com/librescoot/uplink-server/internal/storage"
+	"github.com/librescoot/uplink-server/internal/store"
 )
 
 var webUpgrader = websocket.Upgrader{
@@ -25,8 +26,8 @@ type WebUIHandler struct {
 	eventStore *storage.EventStore
 	connMgr    *storage.ConnectionManager
 	auth       Authenticator
-	sessions   *session.Store
-	apiKey     string
+	policy     *access.Policy
+	batches    *batch.Manager // bulk command progress; may be nil
 }
 
 // Authenticator interface for getting scooter names
@@ -34,32 +35,21 @@ type Authenticator interface {
 	GetName(identifier string) string
 }
 
-// NewWebUIHandler creates a new web UI WebSocket handler
-func NewWebUIHandler(stateStore *storage.StateStore, eventStore *storage.EventStore, connMgr *storage.ConnectionManager, auth Authenticator, sessions *session.Store, apiKey string) *WebUIHandler {
+// NewWebUIHandler creates a new web UI WebSocket handler. Clients only
+// receive data about scooters within their scope under policy.
+func NewWebUIHandler(stateStore *storage.StateStore, eventStore *storage.EventStore, connMgr *storage.ConnectionManager, auth Authenticator, policy *access.Policy) *WebUIHandler {
 	return &WebUIHandler{
 		stateStore: stateStore,
 		eventStore: eventStore,
 		connMgr:    connMgr,
 		auth:       auth,
-		sessions:   sessions,
-		apiKey:     apiKey,
+		policy:     policy,
 	}
 }
 
-// validCredential accepts either the configured API key or a valid session token.
-func (h *WebUIHandler) validCredential(key string) bool {
-	if key == "" {
-		return false
-	}
-	if subtle.ConstantTimeCompare([]byte(key), []byte(h.apiKey)) == 1 {
-		return true
-	}
-	if h.sessions != nil {
-		if _, ok := h.sessions.Validate(key); ok {
-			return true
-		}
-	}
-	return false
+// SetBatches streams bulk command progress to web clients.
+func (h *WebUIHandler) SetBatches(m *batch.Manager) {
+	h.batches = m
 }
 
 // WebMessage represents a message sent to/from web UI clients
@@ -73,6 +63,7 @@ type WebMessage struct {
 	Event      string         `json:"event,omitempty"`
 	EventID    string         `json:"event_id,omitempty"`
 	EventData  map[string]any `json:"event_data,omitempty"`
+	Batch      *store.Batch   `json:"batch,omitempty"`
 	Error      string         `json:"error,omitempty"`
 	Timestamp  string         `json:"timestamp,omitempty"`
 	// Connection stats (included with state updates for connected scooters)
@@ -107,7 +98,8 @@ func (h *WebUIHandler) HandleWebConnection(w http.ResponseWriter, r *http.Reques
 		apiKey = r.URL.Query().Get("api_key")
 	}
 
-	if !h.validCredential(apiKey) {
+	p, ok := h.policy.Authenticate(apiKey)
+	if !ok {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
@@ -120,10 +112,10 @@ func (h *WebUIHandler) HandleWebConnection(w http.ResponseWriter, r *http.Reques
 	}
 	defer conn.Close()
 
-	log.Printf("[WebUI] Client connected from %s", r.RemoteAddr)
+	log.Printf("[WebUI] Client %s connected from %s", p.Name, r.RemoteAddr)
 
 	// Send initial scooter list
-	h.sendScooterList(conn)
+	h.sendScooterList(conn, p)
 
 	// Subscribe to state updates
 	updateChan, stateSubID := h.stateStore.Subscribe()
@@ -138,16 +130,23 @@ func (h *WebUIHandler) HandleWebConnection(w http.ResponseWriter, r *http.Reques
 	defer h.connMgr.Unsubscribe(connSubID)
 
 	// Send initial state for all connected scooters
-	h.sendInitialStates(conn)
+	h.sendInitialStates(conn, p)
 
 	// Send initial events for all connected scooters
-	h.sendInitialEvents(conn)
+	h.sendInitialEvents(conn, p)
 
 	// Start goroutines to listen for updates and broadcast to client
 	done := make(chan struct{})
-	go h.broadcastUpdates(conn, updateChan, done)
-	go h.broadcastEvents(conn, eventChan, done)
-	go h.broadcastConnectionEvents(conn, connChan, done)
+	go h.broadcastUpdates(conn, p, updateChan, done)
+	go h.broadcastEvents(conn, p, eventChan, done)
+	go h.broadcastConnectionEvents(conn, p, connChan, done)
+	go h.watchCredential(conn, apiKey, p, done)
+	// Batches span the fleet.
+	if h.batches != nil && p.Fleet() {
+		batchChan, batchSubID := h.batches.Subscribe()
+		defer h.batches.Unsubscribe(batchSubID)
+		go h.broadcastBatches(conn, batchChan, done)
+	}
 
 	// Keep connection alive and handle disconnection
 	for {
@@ -160,13 +159,39 @@ func (h *WebUIHandler) HandleWebConnection(w http.ResponseWriter, r *http.Reques
 	}
 }
 
+// watchCredential closes the connection once its credential no longer grants
+// p's access, e.g. after a logout, a revoked session or a role change. The
+// client reconnects and authenticates afresh.
+func (h *WebUIHandler) watchCredential(conn *websocket.Conn, credential string, p *access.Principal, done <-chan struct{}) {
+	ticker := time.NewTicker(credentialRecheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if credentialValid(h.policy, credential, p) {
+				continue
+			}
+			log.Printf("[WebUI] Closing connection of %s: credentials revoked or changed", p.Name)
+			msg := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "credentials revoked or changed")
+			conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+			conn.Close()
+			return
+		case <-done:
+			return
+		}
+	}
+}
+
 // sendScooterList sends the list of all scooters (connected and disconnected with state)
-func (h *WebUIHandler) sendScooterList(conn *websocket.Conn) {
+func (h *WebUIHandler) sendScooterList(conn *websocket.Conn, p *access.Principal) {
 	connections := h.connMgr.GetAllConnections()
 	scooterMap := make(map[string]ScooterInfo)
 
 	// Add all currently connected scooters
 	for _, c := range connections {
+		if !p.CanAccess(c.Identifier) {
+			continue
+		}
 		info := ScooterInfo{
 			Identifier:        c.Identifier,
 			Name:              c.Name,
@@ -191,6 +216,9 @@ func (h *WebUIHandler) sendScooterList(conn *websocket.Conn) {
 	// Add scooters with persisted state that aren't currently connected
 	allStates := h.stateStore.GetAllStates()
 	for scooterID, state := range allStates {
+		if !p.CanAccess(scooterID) {
+			continue
+		}
 		if _, exists := scooterMap[scooterID]; !exists {
 			// Scooter has state but is not connected
 			scooterMap[scooterID] = ScooterInfo{
@@ -220,10 +248,13 @@ func (h *WebUIHandler) sendScooterList(conn *websocket.Conn) {
 }
 
 // sendInitialStates sends the current state for all scooters with persisted state
-func (h *WebUIHandler) sendInitialStates(conn *websocket.Conn) {
+func (h *WebUIHandler) sendInitialStates(conn *websocket.Conn, p *access.Principal) {
 	allStates := h.stateStore.GetAllStates()
 
 	for scooterID, state := range allStates {
+		if !p.CanAccess(scooterID) {
+			continue
+		}
 		msg := WebMessage{
 			Type:       "state_update",
 			ScooterID:  scooterID,
@@ -239,10 +270,13 @@ func (h *WebUIHandler) sendInitialStates(conn *websocket.Conn) {
 }
 
 // sendInitialEvents sends stored events for all scooters with events
-func (h *WebUIHandler) sendInitialEvents(conn *websocket.Conn) {
+func (h *WebUIHandler) sendInitialEvents(conn *websocket.Conn, p *access.Principal) {
 	allEvents := h.eventStore.GetAllEvents()
 
 	for scooterID, events := range allEvents {
+		if !p.CanAccess(scooterID) {
+			continue
+		}
 		// Reverse events so oldest is sent first, then prepending in UI reverses back to newest-first
 		for i := len(events) - 1; i >= 0; i-- {
 			event := events[i]
@@ -263,10 +297,13 @@ func (h *WebUIHandler) sendInitialEvents(conn *websocket.Conn) {
 }
 
 // broadcastUpdates listens for state updates and sends them to the web client
-func (h *WebUIHandler) broadcastUpdates(conn *websocket.Conn, updateChan <-chan storage.StateUpdate, done <-chan struct{}) {
+func (h *WebUIHandler) broadcastUpdates(conn *websocket.Conn, p *access.Principal, updateChan <-chan storage.StateUpdate, done <-chan struct{}) {
 	for {
 		select {
 		case update := <-updateChan:
+			if !p.CanAccess(update.ScooterID) {
+				continue
+			}
 			msg := WebMessage{
 				Type:       "state_update",
 				ScooterID:  update.ScooterID,
@@ -303,10 +340,13 @@ func (h *WebUIHandler) broadcastUpdates(conn *websocket.Conn, updateChan <-chan
 }
 
 // broadcastEvents listens for event updates and sends them to the web client
-func (h *WebUIHandler) broadcastEvents(conn *websocket.Conn, eventChan <-chan *storage.Event, done <-chan struct{}) {
+func (h *WebUIHandler) broadcastEvents(conn *websocket.Conn, p *access.Principal, eventChan <-chan *storage.Event, done <-chan struct{}) {
 	for {
 		select {
 		case event := <-eventChan:
+			if !p.CanAccess(event.ScooterID) {
+				continue
+			}
 			msg := WebMessage{
 				Type:      "event",
 				ScooterID: event.ScooterID,
@@ -328,10 +368,13 @@ func (h *WebUIHandler) broadcastEvents(conn *websocket.Conn, eventChan <-chan *s
 }
 
 // broadcastConnectionEvents listens for connection events and sends them to the web client
-func (h *WebUIHandler) broadcastConnectionEvents(conn *websocket.Conn, connChan <-chan storage.ConnectionEvent, done <-chan struct{}) {
+func (h *WebUIHandler) broadcastConnectionEvents(conn *websocket.Conn, p *access.Principal, connChan <-chan storage.ConnectionEvent, done <-chan struct{}) {
 	for {
 		select {
 		case event := <-connChan:
+			if !p.CanAccess(event.Identifier) {
+				continue
+			}
 			if event.Type == "online" && event.Connection != nil {
 				// Scooter came online
 				scooterInfo := ScooterInfo{
@@ -381,3 +424,26 @@ func (h *WebUIHandler) broadcastConnectionEvents(conn *websocket.Conn, connChan
 		}
 	}
 }
+
+// broadcastBatches sends bulk command progress to the web client
+func (h *WebUIHandler) broadcastBatches(conn *websocket.Conn, batchChan <-chan *store.Batch, done <-chan struct{}) {
+	for {
+		select {
+		case b, ok := <-batchChan:
+			if !ok {
+				return
+			}
+			msg := WebMessage{
+				Type:      "batch_progress",
+				Batch:     b,
+				Timestamp: time.Now().UTC().Format(time.RFC3339),
+			}
+			if err := conn.WriteJSON(msg); err != nil {
+				log.Printf("[WebUI] Failed to send batch progress: %v", err)
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
@@ -0,0 +1,311 @@
+// Package lockout throttles repeated authentication failures. Login failures
+// are counted per username and per client IP; scooter failures per identifier
+// and client IP together, so a stranger cannot lock a scooter out, and
+// optionally per client IP. Once a counter reaches its limit, attempts are
+// refused for a lockout that doubles with every further failure. Failures are
+// recorded in the store, and a lockout of a registered scooter raises a
+// security event.
+package lockout
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/librescoot/uplink-server/internal/store"
+)
+
+// Kinds of authentication.
+const (
+	KindLogin   = "login"   // web-UI username/password
+	KindScooter = "scooter" // scooter token or certificate
+)
+
+// EventLockout is the security event recorded for a scooter whose identifier
+// was locked out.
+const EventLockout = "auth_lockout"
+
+const (
+	// keepFailures is how long recorded failures are kept.
+	keepFailures = 90 * 24 * time.Hour
+	// maxIdentifierLen bounds identifiers taken from failed attempts.
+	maxIdentifierLen = 128
+)
+
+// EventFunc records a synthetic scooter event.
+type EventFunc func(scooterID, event string, data map[string]any, ts time.Time)
+
+// Config sets the failure limits and lockout durations.
+type Config struct {
+	MaxFailures          int           // per username, or per scooter identifier and client IP
+	MaxIPFailures        int           // logins per client IP
+	MaxScooterIPFailures int           // scooter authentications per client IP; 0 for no limit
+	Duration             time.Duration // first lockout
+	MaxDuration          time.Duration // longest lockout
+	Window               time.Duration // failures are forgotten after this long without one
+	TrustProxy           bool          // take the client IP from X-Forwarded-For
+}
+
+// Lockout is a username, scooter identifier or client IP that is locked out.
+type Lockout struct {
+	Kind       string    `json:"kind"`
+	Identifier string    `json:"identifier,omitempty"`
+	IP         string    `json:"ip,omitempty"`
+	Failures   int       `json:"failures"`
+	Until      time.Time `json:"until"`
+}
+
+// key names a counter: a username, a scooter identifier with an IP, or an IP.
+type key struct {
+	kind, identifier, ip string
+}
+
+type counter struct {
+	failures int
+	last     time.Time // last failure
+	until    time.Time // end of the current lockout
+}
+
+// stale reports whether the counter can be forgotten: no failure and no
+// lockout for a whole window.
+func (c *counter) stale(now time.Time, window time.Duration) bool {
+	latest := c.last
+	if c.until.After(latest) {
+		latest = c.until
+	}
+	return now.Sub(latest) > window
+}
+
+// Guard counts failures and decides lockouts.
+type Guard struct {
+	cfg    Config
+	db     *store.Store
+	exists func(scooterID string) bool
+	record EventFunc
+
+	mu       sync.Mutex
+	counters map[key]*counter
+}
+
+// New creates a guard. db may be nil to keep no failure history; exists and
+// record may be nil to raise no security events.
+func New(cfg Config, db *store.Store, exists func(scooterID string) bool, record EventFunc) *Guard {
+	return &Guard{
+		cfg:      cfg,
+		db:       db,
+		exists:   exists,
+		record:   record,
+		counters: make(map[key]*counter),
+	}
+}
+
+// Start prunes forgotten counters and old failure history hourly.
+func (g *Guard) Start() {
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			g.sweep(now)
+			if g.db == nil {
+				continue
+			}
+			if n, err := g.db.PruneAuthFailures(now.Add(-keepFailures)); err != nil {
+				log.Printf("[Lockout] Prune error: %v", err)
+			} else if n > 0 {
+				log.Printf("[Lockout] Pruned %d auth failures", n)
+			}
+		}
+	}()
+}
+
+func (g *Guard) sweep(now time.Time) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for k, c := range g.counters {
+		if c.stale(now, g.cfg.Window) {
+			delete(g.counters, k)
+		}
+	}
+}
+
+// ClientIP returns the IP a request came from: the last X-Forwarded-For
+// entry (the one added by the proxy in front of the server) if proxies are
+// trusted, otherwise the peer address.
+func (g *Guard) ClientIP(r *http.Request) string {
+	if g.cfg.TrustProxy {
+		if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+			parts := strings.Split(fwd, ",")
+			if ip := strings.TrimSpace(parts[len(parts)-1]); ip != "" {
+				return ip
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// keys returns the counters an attempt for identifier from ip is counted
+// in. Scooter identifiers are public, so a scooter is only ever locked out
+// for the IP that failed.
+func (g *Guard) keys(kind, identifier, ip string) []key {
+	var keys []key
+	switch {
+	case identifier == "":
+	case kind == KindScooter:
+		keys = append(keys, key{kind: kind, identifier: identifier, ip: ip})
+	default:
+		keys = append(keys, key{kind: kind, identifier: identifier})
+	}
+	if ip != "" && g.ipLimit(kind) > 0 {
+		keys = append(keys, key{kind: kind, ip: ip})
+	}
+	return keys
+}
+
+// ipLimit returns the per-IP failure limit of kind, or zero for none.
+func (g *Guard) ipLimit(kind string) int {
+	if kind == KindScooter {
+		return g.cfg.MaxScooterIPFailures
+	}
+	return g.cfg.MaxIPFailures
+}
+
+// Check returns how long an attempt for identifier from ip is still locked
+// out, or zero if it may proceed. An empty identifier checks only the IP.
+func (g *Guard) Check(kind, identifier, ip string, now time.Time) time.Duration {
+	identifier = truncate(identifier)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	var wait time.Duration
+	for _, k := range g.keys(kind, identifier, ip) {
+		if c := g.counters[k]; c != nil && c.until.After(now) {
+			wait = max(wait, c.until.Sub(now))
+		}
+	}
+	return wait
+}
+
+// Fail records a failed attempt and returns the lockout it started, or zero.
+func (g *Guard) Fail(kind, identifier, ip, reason string, now time.Time) time.Duration {
+	identifier = truncate(identifier)
+	var (
+		locked        time.Duration
+		scooterLocked *Lockout
+	)
+	g.mu.Lock()
+	for _, k := range g.keys(kind, identifier, ip) {
+		limit := g.cfg.MaxFailures
+		if k.identifier == "" {
+			limit = g.ipLimit(kind)
+		}
+		c := g.counters[k]
+		if c == nil || c.stale(now, g.cfg.Window) {
+			c = &counter{}
+			g.counters[k] = c
+		}
+		c.failures++
+		c.last = now
+		if c.failures < limit {
+			continue
+		}
+		d := g.lockoutFor(c.failures - limit)
+		c.until = now.Add(d)
+		locked = max(locked, d)
+		who := k.identifier
+		switch {
+		case k.identifier == "":
+			who = "from " + k.ip
+		case k.ip != "":
+			who += " from " + k.ip
+		}
+		log.Printf("[Lockout] %s %s locked out for %s after %d failures", kind, who, d, c.failures)
+		if kind == KindScooter && k.identifier != "" {
+			scooterLocked = &Lockout{Kind: kind, Identifier: identifier, IP: ip, Failures: c.failures, Until: c.until}
+		}
+	}
+	g.mu.Unlock()
+
+	if g.db != nil {
+		f := &store.AuthFailure{Kind: kind, Identifier: identifier, SourceIP: ip, Reason: reason, Time: now}
+		if err := g.db.InsertAuthFailure(f); err != nil {
+			log.Printf("[Lockout] Failed to record auth failure: %v", err)
+		}
+	}
+	if scooterLocked != nil && g.record != nil && g.exists != nil && g.exists(identifier) {
+		g.record(identifier, EventLockout, map[string]any{
+			"failures":     scooterLocked.Failures,
+			"source_ip":    ip,
+			"reason":       reason,
+			"locked_until": scooterLocked.Until.UTC().Format(time.RFC3339),
+		}, now)
+	}
+	return locked
+}
+
+// lockoutFor returns the lockout after extra failures beyond the limit.
+func (g *Guard) lockoutFor(extra int) time.Duration {
+	d := g.cfg.Duration
+	for i := 0; i < extra && d < g.cfg.MaxDuration; i++ {
+		d *= 2
+	}
+	return min(d, g.cfg.MaxDuration)
+}
+
+// Succeed forgets the failures of identifier (from ip, for a scooter) after
+// a successful attempt. The client IP's own failures are kept, so one valid
+// account doesn't reset them.
+func (g *Guard) Succeed(kind, identifier, ip string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	k := key{kind: kind, identifier: truncate(identifier)}
+	if kind == KindScooter {
+		k.ip = ip
+	}
+	delete(g.counters, k)
+}
+
+// Lockouts returns the current lockouts, longest first.
+func (g *Guard) Lockouts(now time.Time) []Lockout {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	out := []Lockout{}
+	for k, c := range g.counters {
+		if c.until.After(now) {
+			out = append(out, Lockout{Kind: k.kind, Identifier: k.identifier, IP: k.ip, Failures: c.failures, Until: c.until})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Until.After(out[j].Until) })
+	return out
+}
+
+// Unlock lifts the lockouts of an identifier, an IP or both and forgets
+// their failures: every counter of kind that matches the non-empty
+// arguments. It returns false if there was none.
+func (g *Guard) Unlock(kind, identifier, ip string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	found := false
+	for k := range g.counters {
+		if k.kind == kind && (identifier == "" || k.identifier == identifier) && (ip == "" || k.ip == ip) {
+			delete(g.counters, k)
+			found = true
+		}
+	}
+	return found
+}
+
+func truncate(identifier string) string {
+	if len(identifier) > maxIdentifierLen {
+		return identifier[:maxIdentifierLen]
+	}
+	return identifier
+}
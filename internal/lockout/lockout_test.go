@@ -0,0 +1,168 @@
+package lockout
+
+import (
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/librescoot/uplink-server/internal/store"
+)
+
+var testConfig = Config{
+	MaxFailures:   3,
+	MaxIPFailures: 5,
+	Duration:      time.Minute,
+	MaxDuration:   10 * time.Minute,
+	Window:        15 * time.Minute,
+}
+
+func TestLockout(t *testing.T) {
+	g := New(testConfig, nil, nil, nil)
+	now := time.Now()
+
+	for i := 0; i < 2; i++ {
+		if d := g.Fail(KindLogin, "admin", "10.0.0.1", "", now); d != 0 {
+			t.Fatalf("failure %d locked out for %s", i+1, d)
+		}
+	}
+	if d := g.Fail(KindLogin, "admin", "10.0.0.1", "", now); d != time.Minute {
+		t.Fatalf("third failure locked out for %s, want 1m", d)
+	}
+	if d := g.Check(KindLogin, "admin", "10.0.0.2", now.Add(30*time.Second)); d != 30*time.Second {
+		t.Errorf("identifier lockout from another IP = %s", d)
+	}
+	if d := g.Check(KindLogin, "alice", "10.0.0.1", now); d != 0 {
+		t.Errorf("another user from the same IP locked out for %s", d)
+	}
+	if d := g.Check(KindScooter, "admin", "10.0.0.1", now); d != 0 {
+		t.Errorf("kinds share counters: %s", d)
+	}
+
+	// Each failure after the lockout doubles it, up to the maximum.
+	now = now.Add(2 * time.Minute)
+	if d := g.Check(KindLogin, "admin", "10.0.0.1", now); d != 0 {
+		t.Fatalf("still locked out after expiry: %s", d)
+	}
+	for _, want := range []time.Duration{2 * time.Minute, 4 * time.Minute, 8 * time.Minute, 10 * time.Minute} {
+		if d := g.Fail(KindLogin, "admin", "10.0.0.3", "", now); d != want {
+			t.Errorf("lockout = %s, want %s", d, want)
+		}
+		now = now.Add(want)
+	}
+
+	// Failures are forgotten after a quiet window, and on success.
+	now = now.Add(16 * time.Minute)
+	if d := g.Fail(KindLogin, "admin", "10.0.0.4", "", now); d != 0 {
+		t.Errorf("failure after the window locked out for %s", d)
+	}
+	g.Succeed(KindLogin, "admin", "10.0.0.4")
+	g.Fail(KindLogin, "admin", "10.0.0.4", "", now)
+	if d := g.Fail(KindLogin, "admin", "10.0.0.4", "", now); d != 0 {
+		t.Errorf("success did not reset the counter: %s", d)
+	}
+
+	// Per-IP limit across usernames.
+	for i := 0; i < 5; i++ {
+		g.Fail(KindLogin, "user"+string(rune('a'+i)), "10.0.0.9", "", now)
+	}
+	if d := g.Check(KindLogin, "someone", "10.0.0.9", now); d != time.Minute {
+		t.Errorf("IP lockout = %s", d)
+	}
+	locks := g.Lockouts(now)
+	if len(locks) != 1 || locks[0].IP != "10.0.0.9" || locks[0].Failures != 5 {
+		t.Errorf("lockouts = %+v", locks)
+	}
+	if !g.Unlock(KindLogin, "", "10.0.0.9") || g.Check(KindLogin, "someone", "10.0.0.9", now) != 0 {
+		t.Error("unlock failed")
+	}
+	if g.Unlock(KindLogin, "", "10.0.0.9") {
+		t.Error("unlocked twice")
+	}
+
+	g.sweep(now.Add(time.Hour))
+	if len(g.counters) != 0 {
+		t.Errorf("sweep left %d counters", len(g.counters))
+	}
+}
+
+func TestScooterLockout(t *testing.T) {
+	g := New(testConfig, nil, nil, nil)
+	now := time.Now()
+
+	// Scooters are locked out per identifier and IP, with no per-IP limit.
+	for i := 0; i < 3; i++ {
+		g.Fail(KindScooter, "S1", "10.0.0.1", "", now)
+		g.Fail(KindScooter, "S"+string(rune('2'+i)), "10.0.0.1", "", now)
+	}
+	if d := g.Check(KindScooter, "S1", "10.0.0.1", now); d != time.Minute {
+		t.Errorf("scooter lockout = %s", d)
+	}
+	if d := g.Check(KindScooter, "S1", "10.0.0.2", now); d != 0 {
+		t.Errorf("scooter locked out from another IP for %s", d)
+	}
+	if d := g.Check(KindScooter, "", "10.0.0.1", now); d != 0 {
+		t.Errorf("IP locked out without a scooter IP limit: %s", d)
+	}
+	g.Succeed(KindScooter, "S1", "10.0.0.1")
+	if d := g.Check(KindScooter, "S1", "10.0.0.1", now); d != 0 {
+		t.Errorf("success did not lift the lockout: %s", d)
+	}
+
+	cfg := testConfig
+	cfg.MaxScooterIPFailures = 4
+	g = New(cfg, nil, nil, nil)
+	for i := 0; i < 4; i++ {
+		g.Fail(KindScooter, "S"+string(rune('1'+i)), "10.0.0.1", "", now)
+	}
+	if d := g.Check(KindScooter, "", "10.0.0.1", now); d != time.Minute {
+		t.Errorf("scooter IP lockout = %s", d)
+	}
+	g.Fail(KindScooter, "S1", "10.0.0.1", "", now)
+	g.Fail(KindScooter, "S1", "10.0.0.1", "", now)
+	if !g.Unlock(KindScooter, "", "10.0.0.1") || len(g.Lockouts(now)) != 0 {
+		t.Errorf("unlocking the IP left %+v", g.Lockouts(now))
+	}
+}
+
+func TestScooterLockoutEvent(t *testing.T) {
+	db, err := store.Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var events []string
+	exists := func(id string) bool { return id == "S1" }
+	record := func(scooterID, event string, data map[string]any, ts time.Time) {
+		events = append(events, scooterID+" "+event)
+	}
+	g := New(testConfig, db, exists, record)
+
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		g.Fail(KindScooter, "S1", "10.0.0.1", "invalid token for identifier: S1", now)
+		g.Fail(KindScooter, "nobody", "10.0.0.2", "unknown identifier: nobody", now)
+	}
+	if len(events) != 1 || events[0] != "S1 "+EventLockout {
+		t.Errorf("events = %v", events)
+	}
+
+	failures, err := db.ListAuthFailures(store.AuthFailureFilter{Identifier: "S1"})
+	if err != nil || len(failures) != 3 || failures[0].SourceIP != "10.0.0.1" || failures[0].Kind != KindScooter {
+		t.Errorf("recorded failures = %+v, %v", failures, err)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	r := httptest.NewRequest("POST", "/api/login", nil)
+	r.RemoteAddr = "192.0.2.1:4321"
+	r.Header.Set("X-Forwarded-For", "198.51.100.7, 203.0.113.5")
+
+	if ip := New(Config{}, nil, nil, nil).ClientIP(r); ip != "192.0.2.1" {
+		t.Errorf("untrusted proxy: %s", ip)
+	}
+	if ip := New(Config{TrustProxy: true}, nil, nil, nil).ClientIP(r); ip != "203.0.113.5" {
+		t.Errorf("trusted proxy: %s", ip)
+	}
+}
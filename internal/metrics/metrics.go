@@ -0,0 +1,286 @@
+// Package metrics exposes server and per-scooter metrics in the Prometheus
+// exposition format. Connection and queue figures are read from the stores
+// at scrape time; message, command and database write counts are fed in
+// through handler and store hooks.
+package metrics
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/librescoot/uplink-server/internal/protocol"
+	"github.com/librescoot/uplink-server/internal/storage"
+	"github.com/librescoot/uplink-server/internal/store"
+)
+
+const namespace = "uplink"
+
+// DefaultStatePaths are the state leaves exported as gauges when none are
+// configured.
+var DefaultStatePaths = []string{"battery:0.charge", "battery:1.charge", "engine-ecu.odometer"}
+
+// Metrics collects and serves the metrics.
+type Metrics struct {
+	conns      *storage.ConnectionManager
+	db         *store.Store // may be nil
+	statePaths []string
+	registry   *prometheus.Registry
+
+	messages       *prometheus.CounterVec
+	messageBytes   *prometheus.CounterVec
+	commandResults *prometheus.CounterVec
+	writeDuration  *prometheus.HistogramVec
+
+	mu     sync.Mutex
+	values map[string]map[string]float64 // scooter -> state path -> value
+
+	connections     *prometheus.Desc
+	connectionsSeen *prometheus.Desc
+	scooterUp       *prometheus.Desc
+	scooterCounters map[string]*prometheus.Desc // GetStats key -> counter
+	sendQueue       *prometheus.Desc
+	commandQueue    *prometheus.Desc
+	webhookOutbox   *prometheus.Desc
+	stateValue      *prometheus.Desc
+}
+
+// New creates the metrics registry. statePaths lists dotted state paths
+// ("battery:0.charge") exported as per-scooter gauges; empty means
+// DefaultStatePaths.
+func New(conns *storage.ConnectionManager, db *store.Store, statePaths []string) *Metrics {
+	if len(statePaths) == 0 {
+		statePaths = DefaultStatePaths
+	}
+	scooterLabels := []string{"scooter"}
+	m := &Metrics{
+		conns:      conns,
+		db:         db,
+		statePaths: statePaths,
+		registry:   prometheus.NewRegistry(),
+		values:     make(map[string]map[string]float64),
+
+		messages: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Name: "messages_received_total",
+			Help: "Scooter messages received, by message type.",
+		}, []string{"type"}),
+		messageBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Name: "message_bytes_received_total",
+			Help: "Uncompressed bytes of scooter messages received, by message type.",
+		}, []string{"type"}),
+		commandResults: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Name: "command_results_total",
+			Help: "Final command responses from scooters, by status.",
+		}, []string{"status"}),
+		writeDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace, Name: "store_write_duration_seconds",
+			Help:    "Latency of single-statement database writes.",
+			Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+		}, []string{"backend", "statement", "table"}),
+
+		connections: prometheus.NewDesc(namespace+"_connections",
+			"Current scooter connections, by transport.", []string{"transport"}, nil),
+		connectionsSeen: prometheus.NewDesc(namespace+"_connections_total",
+			"Scooter connections accepted since start.", nil, nil),
+		scooterUp: prometheus.NewDesc(namespace+"_scooter_connected",
+			"1 while the scooter is connected.", []string{"scooter", "transport", "version"}, nil),
+		scooterCounters: map[string]*prometheus.Desc{
+			"messages_received": prometheus.NewDesc(namespace+"_scooter_messages_received_total",
+				"Messages received on the scooter's current connection.", scooterLabels, nil),
+			"messages_sent": prometheus.NewDesc(namespace+"_scooter_messages_sent_total",
+				"Messages sent on the scooter's current connection.", scooterLabels, nil),
+			"bytes_received": prometheus.NewDesc(namespace+"_scooter_bytes_received_total",
+				"Uncompressed bytes received on the scooter's current connection.", scooterLabels, nil),
+			"bytes_sent": prometheus.NewDesc(namespace+"_scooter_bytes_sent_total",
+				"Uncompressed bytes sent on the scooter's current connection.", scooterLabels, nil),
+			"wire_bytes_received": prometheus.NewDesc(namespace+"_scooter_wire_bytes_received_total",
+				"Network bytes received on the scooter's current connection.", scooterLabels, nil),
+			"wire_bytes_sent": prometheus.NewDesc(namespace+"_scooter_wire_bytes_sent_total",
+				"Network bytes sent on the scooter's current connection.", scooterLabels, nil),
+			"telemetry_received": prometheus.NewDesc(namespace+"_scooter_telemetry_received_total",
+				"Telemetry messages received on the scooter's current connection.", scooterLabels, nil),
+			"commands_sent": prometheus.NewDesc(namespace+"_scooter_commands_sent_total",
+				"Commands sent on the scooter's current connection.", scooterLabels, nil),
+		},
+		sendQueue: prometheus.NewDesc(namespace+"_scooter_send_queue_length",
+			"Messages waiting in the scooter's send channel.", scooterLabels, nil),
+		commandQueue: prometheus.NewDesc(namespace+"_command_queue_depth",
+			"Commands queued for offline scooters.", nil, nil),
+		webhookOutbox: prometheus.NewDesc(namespace+"_webhook_outbox",
+			"Webhook outbox entries, by subscription and status.", []string{"subscription", "status"}, nil),
+		stateValue: prometheus.NewDesc(namespace+"_scooter_state",
+			"Numeric scooter state leaves, by dotted path.", []string{"scooter", "path"}, nil),
+	}
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.messages, m.messageBytes, m.commandResults, m.writeDuration,
+		m,
+	)
+	return m
+}
+
+// Handler serves the metrics. A non-empty token must be presented as
+// "Authorization: Bearer <token>".
+func (m *Metrics) Handler(token string) http.Handler {
+	h := promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{ErrorLog: log.Default()})
+	if token == "" {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// ObserveMessage counts an inbound scooter message; it is registered as a
+// message hook.
+func (m *Metrics) ObserveMessage(_ string, msgType protocol.MessageType, size int) {
+	m.messages.WithLabelValues(string(msgType)).Inc()
+	m.messageBytes.WithLabelValues(string(msgType)).Add(float64(size))
+}
+
+// ObserveCommandResult counts a command's final outcome; it is registered as
+// a command result hook.
+func (m *Metrics) ObserveCommandResult(_ string, resp *protocol.CommandResponse) {
+	m.commandResults.WithLabelValues(resp.Status).Inc()
+}
+
+// ObserveWrite records a database write's latency; it is registered as the
+// store's write observer.
+func (m *Metrics) ObserveWrite(statement, table string, d time.Duration) {
+	backend := ""
+	if m.db != nil {
+		backend = m.db.Backend()
+	}
+	m.writeDuration.WithLabelValues(backend, statement, table).Observe(d.Seconds())
+}
+
+// ObserveState caches the configured numeric leaves of a scooter's merged
+// state; it is registered as a telemetry hook. Values are read here rather
+// than at scrape time because the state maps are only safe to read on the
+// scooter's connection goroutine.
+func (m *Metrics) ObserveState(scooterID string, state map[string]any, _ time.Time) {
+	values := make(map[string]float64, len(m.statePaths))
+	for _, path := range m.statePaths {
+		if v, ok := number(lookup(state, path)); ok {
+			values[path] = v
+		}
+	}
+	m.mu.Lock()
+	m.values[scooterID] = values
+	m.mu.Unlock()
+}
+
+// Describe implements prometheus.Collector.
+func (m *Metrics) Describe(ch chan<- *prometheus.Desc) {
+	ch <- m.connections
+	ch <- m.connectionsSeen
+	ch <- m.scooterUp
+	for _, d := range m.scooterCounters {
+		ch <- d
+	}
+	ch <- m.sendQueue
+	ch <- m.commandQueue
+	ch <- m.webhookOutbox
+	ch <- m.stateValue
+}
+
+// Collect implements prometheus.Collector.
+func (m *Metrics) Collect(ch chan<- prometheus.Metric) {
+	byTransport := map[string]int{"websocket": 0, "longpoll": 0}
+	for _, conn := range m.conns.GetAllConnections() {
+		stats := conn.GetStats()
+		transport, _ := stats["transport"].(string)
+		version, _ := stats["version"].(string)
+		byTransport[transport]++
+		id := conn.Identifier
+		ch <- prometheus.MustNewConstMetric(m.scooterUp, prometheus.GaugeValue, 1, id, transport, version)
+		for key, desc := range m.scooterCounters {
+			if n, ok := stats[key].(int64); ok {
+				ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, float64(n), id)
+			}
+		}
+		ch <- prometheus.MustNewConstMetric(m.sendQueue, prometheus.GaugeValue, float64(len(conn.SendChannel())), id)
+	}
+	for transport, n := range byTransport {
+		ch <- prometheus.MustNewConstMetric(m.connections, prometheus.GaugeValue, float64(n), transport)
+	}
+	if total, ok := m.conns.GetStats()["total_connections"].(int64); ok {
+		ch <- prometheus.MustNewConstMetric(m.connectionsSeen, prometheus.CounterValue, float64(total))
+	}
+
+	m.mu.Lock()
+	for id, values := range m.values {
+		for path, v := range values {
+			ch <- prometheus.MustNewConstMetric(m.stateValue, prometheus.GaugeValue, v, id, path)
+		}
+	}
+	m.mu.Unlock()
+
+	if m.db == nil {
+		return
+	}
+	if n, err := m.db.QueuedCount(); err == nil {
+		ch <- prometheus.MustNewConstMetric(m.commandQueue, prometheus.GaugeValue, float64(n))
+	} else {
+		ch <- prometheus.NewInvalidMetric(m.commandQueue, err)
+	}
+	if counts, _, err := m.db.WebhookStats(); err == nil {
+		for sub, byStatus := range counts {
+			for status, n := range byStatus {
+				ch <- prometheus.MustNewConstMetric(m.webhookOutbox, prometheus.GaugeValue, float64(n), sub, status)
+			}
+		}
+	} else {
+		ch <- prometheus.NewInvalidMetric(m.webhookOutbox, err)
+	}
+}
+
+// lookup returns the value at a dotted path in m.
+func lookup(m map[string]any, path string) any {
+	var cur any = m
+	for _, key := range strings.Split(path, ".") {
+		mm, ok := cur.(map[string]any)
+		if !ok {
+			return nil
+		}
+		cur = mm[key]
+	}
+	return cur
+}
+
+// number converts a state leaf to a float. Scooters report most values as
+// strings.
+func number(v any) (float64, bool) {
+	switch x := v.(type) {
+	case float64:
+		return x, true
+	case int:
+		return float64(x), true
+	case int64:
+		return float64(x), true
+	case bool:
+		if x {
+			return 1, true
+		}
+		return 0, true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(x), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
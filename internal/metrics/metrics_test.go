@@ -0,0 +1,100 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/librescoot/uplink-server/internal/models"
+	"github.com/librescoot/uplink-server/internal/protocol"
+	"github.com/librescoot/uplink-server/internal/storage"
+	"github.com/librescoot/uplink-server/internal/store"
+)
+
+func scrape(t *testing.T, h http.Handler, token string) (int, string) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	body, _ := io.ReadAll(rec.Body)
+	return rec.Code, string(body)
+}
+
+func TestMetrics(t *testing.T) {
+	db, err := store.Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	conns := storage.NewConnectionManager(0)
+	conn := models.NewConnection("VIN1", nil)
+	conn.Transport = "websocket"
+	conn.Authenticated = true
+	conn.AddBytesReceived(120)
+	conn.IncrementMessagesReceived()
+	if err := conns.AddConnection(conn); err != nil {
+		t.Fatal(err)
+	}
+
+	m := New(conns, db, nil)
+	db.ObserveWrites(m.ObserveWrite)
+	if err := db.Enqueue("req-1", "VIN2", "lock", nil, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	m.ObserveMessage("VIN1", protocol.MsgTypeState, 100)
+	m.ObserveMessage("VIN1", protocol.MsgTypeState, 20)
+	m.ObserveCommandResult("VIN1", &protocol.CommandResponse{Status: "success"})
+	m.ObserveState("VIN1", map[string]any{
+		"battery:0":  map[string]any{"charge": "64"},
+		"engine-ecu": map[string]any{"odometer": "1234567", "speed": "0"},
+		"vehicle":    map[string]any{"state": "parked"},
+	}, time.Now())
+
+	code, body := scrape(t, m.Handler(""), "")
+	if code != http.StatusOK {
+		t.Fatalf("status %d", code)
+	}
+	for _, want := range []string{
+		`uplink_connections{transport="websocket"} 1`,
+		`uplink_connections_total 1`,
+		`uplink_scooter_connected{scooter="VIN1",transport="websocket",version=""} 1`,
+		`uplink_scooter_bytes_received_total{scooter="VIN1"} 120`,
+		`uplink_scooter_messages_received_total{scooter="VIN1"} 1`,
+		`uplink_messages_received_total{type="state"} 2`,
+		`uplink_message_bytes_received_total{type="state"} 120`,
+		`uplink_command_results_total{status="success"} 1`,
+		`uplink_command_queue_depth 1`,
+		`uplink_scooter_state{path="battery:0.charge",scooter="VIN1"} 64`,
+		`uplink_scooter_state{path="engine-ecu.odometer",scooter="VIN1"} 1.234567e+06`,
+		`uplink_store_write_duration_seconds_count{backend="sqlite",statement="insert",table="commands"} 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("missing %s", want)
+		}
+	}
+	if strings.Contains(body, `path="battery:1.charge"`) {
+		t.Error("absent state path exported")
+	}
+}
+
+func TestHandlerToken(t *testing.T) {
+	m := New(storage.NewConnectionManager(0), nil, nil)
+	h := m.Handler("secret")
+	if code, _ := scrape(t, h, ""); code != http.StatusUnauthorized {
+		t.Errorf("no token: status %d, want 401", code)
+	}
+	if code, _ := scrape(t, h, "wrong"); code != http.StatusUnauthorized {
+		t.Errorf("wrong token: status %d, want 401", code)
+	}
+	if code, body := scrape(t, h, "secret"); code != http.StatusOK || !strings.Contains(body, "uplink_connections") {
+		t.Errorf("valid token: status %d", code)
+	}
+}
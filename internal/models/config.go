@@ -1,46 +1,454 @@
 package models
 
-import "time"
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
 
 // Config represents the server configuration
 type Config struct {
-	Server  ServerConfig  `yaml:"server"`
-	Auth    AuthConfig    `yaml:"auth"`
-	Storage StorageConfig `yaml:"storage"`
-	Logging LoggingConfig `yaml:"logging"`
+	Server    ServerConfig    `yaml:"server"`
+	Auth      AuthConfig      `yaml:"auth"`
+	Storage   StorageConfig   `yaml:"storage"`
+	Retention RetentionConfig `yaml:"retention,omitempty"`
+	Alerts    AlertsConfig    `yaml:"alerts,omitempty"`
+	Webhooks  []WebhookConfig `yaml:"webhooks,omitempty"`
+	MQTT      MQTTConfig      `yaml:"mqtt,omitempty"`
+	Metrics   MetricsConfig   `yaml:"metrics,omitempty"`
+	Commands  CommandsConfig  `yaml:"commands,omitempty"`
+	Logging   LoggingConfig   `yaml:"logging"`
 }
 
 // ServerConfig contains server settings
 type ServerConfig struct {
-	WSPort            int    `yaml:"ws_port"`
-	LPPort            int    `yaml:"lp_port"`
-	SSEPort           int    `yaml:"sse_port"`
-	EnableWebUI       bool   `yaml:"enable_web_ui"`
-	KeepaliveInterval string `yaml:"keepalive_interval"`
-	LPTimeout         string `yaml:"lp_timeout"`
-	MaxConnections    int    `yaml:"max_connections"`
-	MessageRateLimit  int    `yaml:"message_rate_limit"`  // max messages per second per connection (0 = unlimited)
-	IdleTimeout       string `yaml:"idle_timeout"`        // disconnect after no messages for this duration (0 = disabled)
-}
-
-// ScooterConfig contains scooter-specific settings
+	WSPort            int       `yaml:"ws_port"`
+	LPPort            int       `yaml:"lp_port"`
+	SSEPort           int       `yaml:"sse_port"`
+	EnableWebUI       bool      `yaml:"enable_web_ui"`
+	KeepaliveInterval string    `yaml:"keepalive_interval"`
+	LPTimeout         string    `yaml:"lp_timeout"`
+	MaxConnections    int       `yaml:"max_connections"`
+	MessageRateLimit  int       `yaml:"message_rate_limit"`  // max messages per second per connection (0 = unlimited)
+	IdleTimeout       string    `yaml:"idle_timeout"`        // disconnect after no messages for this duration (0 = disabled)
+	TLS               TLSConfig `yaml:"tls,omitempty"`
+}
+
+// Scooter authentication modes. With a client CA configured, scooters may
+// present a certificate naming them in its CN or a DNS SAN.
+const (
+	ScooterAuthToken        = "token"          // token required (default)
+	ScooterAuthCert         = "cert"           // client certificate required instead of the token
+	ScooterAuthCertOrToken  = "cert_or_token"  // either one
+	ScooterAuthCertAndToken = "cert_and_token" // both
+)
+
+// TLSConfig enables HTTPS/WSS on every listener. The certificate, key and
+// client CA files are reloaded when they change.
+type TLSConfig struct {
+	CertFile     string `yaml:"cert_file,omitempty"`
+	KeyFile      string `yaml:"key_file,omitempty"`
+	ClientCAFile string `yaml:"client_ca_file,omitempty"` // PEM CAs for scooter client certificates (mTLS)
+	ScooterAuth  string `yaml:"scooter_auth,omitempty"`   // token, cert, cert_or_token or cert_and_token
+}
+
+// Enabled reports whether TLS is configured.
+func (c *TLSConfig) Enabled() bool {
+	return c.CertFile != "" || c.KeyFile != ""
+}
+
+// GetScooterAuth returns the scooter authentication mode, defaulting to token
+func (c *TLSConfig) GetScooterAuth() string {
+	if c.ScooterAuth == "" {
+		return ScooterAuthToken
+	}
+	return c.ScooterAuth
+}
+
+// Validate checks that the files and mode fit together.
+func (c *TLSConfig) Validate() error {
+	switch c.GetScooterAuth() {
+	case ScooterAuthToken, ScooterAuthCert, ScooterAuthCertOrToken, ScooterAuthCertAndToken:
+	default:
+		return fmt.Errorf("invalid scooter_auth %q", c.ScooterAuth)
+	}
+	if c.Enabled() && (c.CertFile == "" || c.KeyFile == "") {
+		return fmt.Errorf("cert_file and key_file are both required")
+	}
+	if c.ClientCAFile != "" && !c.Enabled() {
+		return fmt.Errorf("client_ca_file requires cert_file and key_file")
+	}
+	if c.GetScooterAuth() != ScooterAuthToken && c.ClientCAFile == "" {
+		return fmt.Errorf("scooter_auth %q requires client_ca_file", c.ScooterAuth)
+	}
+	return nil
+}
+
+// ScooterConfig contains scooter-specific settings. Groups are named sets a
+// scooter belongs to (customer, depot, ...); tags are free-form labels
+// (hardware revision, ...). Both can be used to target commands.
+//
+// Token is a hash of the scooter's token ("sha256:..."); plaintext tokens
+// are hashed on startup. After a rotation the previous token's hash is
+// accepted until PreviousTokenExpires.
 type ScooterConfig struct {
-	Token string `yaml:"token"`
-	Name  string `yaml:"name,omitempty"`
+	Token                string    `yaml:"token"`
+	PreviousToken        string    `yaml:"previous_token,omitempty"`
+	PreviousTokenExpires time.Time `yaml:"previous_token_expires,omitempty"`
+	Name                 string    `yaml:"name,omitempty"`
+	Groups               []string  `yaml:"groups,omitempty"`
+	Tags                 []string  `yaml:"tags,omitempty"`
 }
 
 // AuthConfig contains authentication settings
 type AuthConfig struct {
+	// APIKey is the shared key with full (admin) access.
 	APIKey string                   `yaml:"api_key"`
 	Tokens map[string]ScooterConfig `yaml:"tokens"`
-	// Users maps a web-UI username to its password. A successful login issues a
-	// session token that is accepted anywhere the API key is.
-	Users map[string]string `yaml:"users,omitempty"`
+	// Users maps a web-UI username to its password and grant. A successful
+	// login issues a session token that is accepted anywhere the API key is,
+	// with the user's permissions.
+	Users map[string]UserConfig `yaml:"users,omitempty"`
+	// APIKeys are additional named keys, each with its own grant.
+	APIKeys []APIKeyConfig `yaml:"api_keys,omitempty"`
+	// TokenGracePeriod is how long a scooter's previous token keeps working
+	// after a rotation, e.g. "24h" or "7d".
+	TokenGracePeriod string `yaml:"token_grace_period,omitempty"`
+	// Lockout throttles repeated failed logins and scooter authentications.
+	Lockout LockoutConfig `yaml:"lockout,omitempty"`
+	// Sessions sets when login sessions expire.
+	Sessions SessionConfig `yaml:"sessions,omitempty"`
+}
+
+// GetTokenGracePeriod parses and returns the token grace period (default 24h)
+func (c *AuthConfig) GetTokenGracePeriod() time.Duration {
+	if c.TokenGracePeriod == "" {
+		return 24 * time.Hour
+	}
+	d, err := ParseRetention(c.TokenGracePeriod)
+	if err != nil {
+		return 24 * time.Hour
+	}
+	return d
+}
+
+// LockoutConfig sets when repeated authentication failures lock out a
+// username, a scooter identifier from one client IP, or a client IP. The
+// first lockout lasts Duration and each further failure doubles it, up to
+// MaxDuration.
+type LockoutConfig struct {
+	MaxFailures          int    `yaml:"max_failures,omitempty"`            // failures per username, or per scooter and client IP, before a lockout (default 5)
+	MaxIPFailures        int    `yaml:"max_ip_failures,omitempty"`         // failed logins per client IP before a lockout (default 20)
+	MaxScooterIPFailures int    `yaml:"max_scooter_ip_failures,omitempty"` // failed scooter authentications per client IP before a lockout (default 0: no limit)
+	Duration             string `yaml:"duration,omitempty"`                // first lockout (default "1m")
+	MaxDuration          string `yaml:"max_duration,omitempty"`            // longest lockout (default "1h")
+	Window               string `yaml:"window,omitempty"`                  // failures are forgotten after this long without one (default "15m")
+	TrustProxy           bool   `yaml:"trust_proxy,omitempty"`             // take the client IP from X-Forwarded-For
+}
+
+// GetMaxFailures returns the per-identifier failure limit (default 5)
+func (c *LockoutConfig) GetMaxFailures() int {
+	if c.MaxFailures <= 0 {
+		return 5
+	}
+	return c.MaxFailures
+}
+
+// GetMaxIPFailures returns the per-IP failed login limit (default 20)
+func (c *LockoutConfig) GetMaxIPFailures() int {
+	if c.MaxIPFailures <= 0 {
+		return 20
+	}
+	return c.MaxIPFailures
+}
+
+// GetDuration parses and returns the first lockout duration (default 1m)
+func (c *LockoutConfig) GetDuration() time.Duration {
+	d, err := time.ParseDuration(c.Duration)
+	if err != nil || d <= 0 {
+		return time.Minute
+	}
+	return d
+}
+
+// GetMaxDuration parses and returns the longest lockout (default 1h)
+func (c *LockoutConfig) GetMaxDuration() time.Duration {
+	d, err := time.ParseDuration(c.MaxDuration)
+	if err != nil || d <= 0 {
+		return time.Hour
+	}
+	return d
+}
+
+// GetWindow parses and returns how long failures are remembered (default 15m)
+func (c *LockoutConfig) GetWindow() time.Duration {
+	d, err := time.ParseDuration(c.Window)
+	if err != nil || d <= 0 {
+		return 15 * time.Minute
+	}
+	return d
+}
+
+// SessionConfig sets when a login session expires: after IdleTimeout without
+// a request, and in any case AbsoluteTimeout after the login. Durations are Go
+// durations or whole days ("7d"); "0" disables a limit.
+type SessionConfig struct {
+	IdleTimeout     string `yaml:"idle_timeout,omitempty"`     // default "24h"
+	AbsoluteTimeout string `yaml:"absolute_timeout,omitempty"` // default "7d"
+}
+
+// GetIdleTimeout parses and returns the idle timeout (default 24h)
+func (c *SessionConfig) GetIdleTimeout() time.Duration {
+	return parseTimeout(c.IdleTimeout, 24*time.Hour)
+}
+
+// GetAbsoluteTimeout parses and returns the absolute timeout (default 7d)
+func (c *SessionConfig) GetAbsoluteTimeout() time.Duration {
+	return parseTimeout(c.AbsoluteTimeout, 7*24*time.Hour)
+}
+
+func parseTimeout(s string, def time.Duration) time.Duration {
+	if s == "" {
+		return def
+	}
+	d, err := ParseRetention(s)
+	if err != nil {
+		return def
+	}
+	return d
+}
+
+// Grant is what a user or API key may do: its role (viewer, operator or
+// admin) and, optionally, the scooters and groups it is limited to. An empty
+// scope means the whole fleet.
+type Grant struct {
+	Role     string   `yaml:"role,omitempty"`
+	Scooters []string `yaml:"scooters,omitempty"`
+	Groups   []string `yaml:"groups,omitempty"`
+}
+
+// UserConfig is a web-UI user. A bare string in the config is read as the
+// password of an admin, the format used before roles existed; otherwise the
+// role defaults to viewer.
+type UserConfig struct {
+	Password string `yaml:"password"`
+	Grant    `yaml:",inline"`
+}
+
+// UnmarshalYAML accepts both "name: password" and the full form.
+func (u *UserConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var password string
+	if err := unmarshal(&password); err == nil {
+		*u = UserConfig{Password: password, Grant: Grant{Role: "admin"}}
+		return nil
+	}
+	type plain UserConfig
+	return unmarshal((*plain)(u))
+}
+
+// APIKeyConfig is a named API key. The role defaults to viewer.
+type APIKeyConfig struct {
+	Name  string `yaml:"name"`
+	Key   string `yaml:"key"`
+	Grant `yaml:",inline"`
 }
 
 // StorageConfig contains storage settings
 type StorageConfig struct {
-	Type string `yaml:"type"`
+	Type string `yaml:"type"`           // "sqlite" (default), "postgres" or "timescaledb"
+	Path string `yaml:"path,omitempty"` // SQLite database file
+	DSN  string `yaml:"dsn,omitempty"`  // PostgreSQL/TimescaleDB connection string
+}
+
+// RetentionPolicy holds retention durations for each kind of persisted data.
+// Values are Go durations ("720h") or whole days ("30d"); "0" keeps data
+// forever and an empty value inherits the default.
+type RetentionPolicy struct {
+	Telemetry string `yaml:"telemetry,omitempty"`
+	Rollups   string `yaml:"rollups,omitempty"`
+	Events    string `yaml:"events,omitempty"`
+	Commands  string `yaml:"commands,omitempty"`
+}
+
+// RetentionConfig contains data retention settings
+type RetentionConfig struct {
+	RetentionPolicy `yaml:",inline"`
+	Interval        string                     `yaml:"interval,omitempty"` // how often pruning runs
+	Scooters        map[string]RetentionPolicy `yaml:"scooters,omitempty"` // per-scooter overrides
+}
+
+// GetInterval parses and returns the pruning interval
+func (c *RetentionConfig) GetInterval() time.Duration {
+	d, err := time.ParseDuration(c.Interval)
+	if err != nil || d <= 0 {
+		return time.Hour
+	}
+	return d
+}
+
+// AlertRuleConfig defines an alert rule in the config file. See the alerts
+// package for the meaning of each field.
+type AlertRuleConfig struct {
+	Name       string   `yaml:"name"`
+	Type       string   `yaml:"type"`                  // "state", "event" or "offline"
+	Condition  string   `yaml:"condition,omitempty"`   // state: e.g. "battery:0.charge < 15"
+	Event      string   `yaml:"event,omitempty"`       // event: event name
+	OfflineFor string   `yaml:"offline_for,omitempty"` // offline: e.g. "2h"
+	For        string   `yaml:"for,omitempty"`         // condition must hold this long to fire
+	ClearFor   string   `yaml:"clear_for,omitempty"`   // and be false this long to resolve
+	Severity   string   `yaml:"severity,omitempty"`    // "info", "warning" (default) or "critical"
+	Scooters   []string `yaml:"scooters,omitempty"`    // empty means all scooters
+}
+
+// AlertsConfig contains alerting settings
+type AlertsConfig struct {
+	Interval string            `yaml:"interval,omitempty"` // how often rules are re-evaluated
+	Rules    []AlertRuleConfig `yaml:"rules,omitempty"`
+}
+
+// GetInterval parses and returns the evaluation interval
+func (c *AlertsConfig) GetInterval() time.Duration {
+	d, err := time.ParseDuration(c.Interval)
+	if err != nil || d <= 0 {
+		return 30 * time.Second
+	}
+	return d
+}
+
+// WebhookConfig defines an outbound webhook subscription. Empty filters match
+// everything.
+type WebhookConfig struct {
+	Name       string   `yaml:"name"`
+	URL        string   `yaml:"url"`
+	Secret     string   `yaml:"secret,omitempty"`      // HMAC-SHA256 signing key
+	Types      []string `yaml:"types,omitempty"`       // "event", "state", "connection", "command"
+	Scooters   []string `yaml:"scooters,omitempty"`    // scooter identifiers
+	Events     []string `yaml:"events,omitempty"`      // event names
+	StatePaths []string `yaml:"state_paths,omitempty"` // dotted paths a state update must touch
+}
+
+// MQTTConfig configures the MQTT bridge. The bridge is disabled when Broker
+// is empty.
+type MQTTConfig struct {
+	Broker      string `yaml:"broker,omitempty"`       // e.g. tcp://localhost:1883
+	ClientID    string `yaml:"client_id,omitempty"`    // default "uplink-server"
+	Username    string `yaml:"username,omitempty"`
+	Password    string `yaml:"password,omitempty"`
+	TopicPrefix string `yaml:"topic_prefix,omitempty"` // default "uplink"
+	QoS         int    `yaml:"qos,omitempty"`
+	ReadOnly    bool   `yaml:"read_only,omitempty"` // publish only; ignore commands
+	QueueTTL    string `yaml:"queue_ttl,omitempty"` // lifetime of commands queued for offline scooters
+	Grant       `yaml:",inline"`                    // role and scope for commands (default: viewer of the whole fleet)
+}
+
+// GetQueueTTL parses and returns the offline command queue TTL
+func (c *MQTTConfig) GetQueueTTL() time.Duration {
+	d, err := time.ParseDuration(c.QueueTTL)
+	if err != nil || d <= 0 {
+		return time.Hour
+	}
+	return d
+}
+
+// CommandsConfig contains command delivery settings
+type CommandsConfig struct {
+	AckTimeout     string   `yaml:"ack_timeout,omitempty"`     // wait for a response per attempt ("0" disables)
+	Retries        int      `yaml:"retries,omitempty"`         // extra attempts for idempotent commands
+	Idempotent     []string `yaml:"idempotent,omitempty"`      // commands safe to retry (empty = built-in list)
+	DeliveryWindow string   `yaml:"delivery_window,omitempty"` // how long unanswered commands are resent on reconnect ("0" = no limit)
+	RunTimeout     string   `yaml:"run_timeout,omitempty"`     // how long a running command may go without progress ("0" disables)
+	Catalog        []CommandSpecConfig `yaml:"catalog,omitempty"` // commands added to or replacing the built-in catalog
+	BatchConcurrency int    `yaml:"batch_concurrency,omitempty"` // bulk command dispatches in flight per batch (default 8)
+}
+
+// CommandSpecConfig describes a catalog command in the config file. See the
+// commands package for the meaning of each field.
+type CommandSpecConfig struct {
+	Name        string                        `yaml:"name"`
+	Label       string                        `yaml:"label,omitempty"`
+	Group       string                        `yaml:"group,omitempty"`
+	Description string                        `yaml:"description,omitempty"`
+	Params      map[string]CommandParamConfig `yaml:"params,omitempty"`
+	Queueable   bool                          `yaml:"queueable,omitempty"`
+	Confirm     string                        `yaml:"confirm,omitempty"`     // confirmation prompt shown in the UI
+	MinVersion  string                        `yaml:"min_version,omitempty"` // oldest client version that supports it
+	Role        string                        `yaml:"role,omitempty"`        // "viewer", "operator" (default) or "admin"
+	Variant     string                        `yaml:"variant,omitempty"`     // UI button style: "primary" or "danger"
+}
+
+// CommandParamConfig describes one parameter of a catalog command
+type CommandParamConfig struct {
+	Type        string   `yaml:"type"` // "string", "integer", "number" or "boolean"
+	Description string   `yaml:"description,omitempty"`
+	Required    bool     `yaml:"required,omitempty"`
+	Enum        []any    `yaml:"enum,omitempty"`
+	Minimum     *float64 `yaml:"minimum,omitempty"`
+	Maximum     *float64 `yaml:"maximum,omitempty"`
+	MaxLength   *int     `yaml:"max_length,omitempty"`
+	Default     any      `yaml:"default,omitempty"`
+}
+
+// GetAckTimeout parses and returns the command ack timeout
+func (c *CommandsConfig) GetAckTimeout() time.Duration {
+	if c.AckTimeout == "" {
+		return 30 * time.Second
+	}
+	d, err := time.ParseDuration(c.AckTimeout)
+	if err != nil || d < 0 {
+		return 30 * time.Second
+	}
+	return d
+}
+
+// GetDeliveryWindow parses and returns the command delivery window
+func (c *CommandsConfig) GetDeliveryWindow() time.Duration {
+	if c.DeliveryWindow == "" {
+		return time.Hour
+	}
+	d, err := time.ParseDuration(c.DeliveryWindow)
+	if err != nil || d < 0 {
+		return time.Hour
+	}
+	return d
+}
+
+// GetRunTimeout parses and returns how long a running command may go without
+// a progress frame or result
+func (c *CommandsConfig) GetRunTimeout() time.Duration {
+	if c.RunTimeout == "" {
+		return 10 * time.Minute
+	}
+	d, err := time.ParseDuration(c.RunTimeout)
+	if err != nil || d < 0 {
+		return 10 * time.Minute
+	}
+	return d
+}
+
+// MetricsConfig configures the Prometheus /metrics endpoint
+type MetricsConfig struct {
+	Enabled    bool     `yaml:"enabled"`
+	Token      string   `yaml:"token,omitempty"`       // bearer token required to scrape (empty = open)
+	StatePaths []string `yaml:"state_paths,omitempty"` // numeric state leaves exported as gauges
+}
+
+// ParseRetention parses a retention duration: a Go duration, a whole number
+// of days ("30d"), or "0" for forever.
+func ParseRetention(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid retention %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid retention %q", s)
+	}
+	return d, nil
 }
 
 // LoggingConfig contains logging settings
@@ -79,6 +487,14 @@ func (c *ServerConfig) GetIdleTimeout() time.Duration {
 	return d
 }
 
+// GetPath returns the SQLite database file, defaulting to data/uplink.db
+func (c *StorageConfig) GetPath() string {
+	if c.Path == "" {
+		return "data/uplink.db"
+	}
+	return c.Path
+}
+
 // GetStatsInterval parses and returns the stats interval
 func (c *LoggingConfig) GetStatsInterval() time.Duration {
 	d, err := time.ParseDuration(c.StatsInterval)
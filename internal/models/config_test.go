@@ -3,6 +3,8 @@ package models
 import (
 	"testing"
 	"time"
+
+	"gopkg.in/yaml.v2"
 )
 
 func TestGetKeepaliveInterval(t *testing.T) {
@@ -83,3 +85,160 @@ func TestGetStatsInterval(t *testing.T) {
 		}
 	}
 }
+
+func TestGetAckTimeout(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected time.Duration
+	}{
+		{"10s", 10 * time.Second},
+		{"0", 0},
+		{"", 30 * time.Second},
+		{"invalid", 30 * time.Second},
+	}
+
+	for _, tt := range tests {
+		c := CommandsConfig{AckTimeout: tt.input}
+		got := c.GetAckTimeout()
+		if got != tt.expected {
+			t.Errorf("GetAckTimeout(%q) = %v, want %v", tt.input, got, tt.expected)
+		}
+	}
+}
+
+func TestGetRunTimeout(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected time.Duration
+	}{
+		{"2m", 2 * time.Minute},
+		{"0", 0},
+		{"", 10 * time.Minute},
+		{"invalid", 10 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		c := CommandsConfig{RunTimeout: tt.input}
+		got := c.GetRunTimeout()
+		if got != tt.expected {
+			t.Errorf("GetRunTimeout(%q) = %v, want %v", tt.input, got, tt.expected)
+		}
+	}
+}
+
+func TestParseRetention(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected time.Duration
+		wantErr  bool
+	}{
+		{"30d", 30 * 24 * time.Hour, false},
+		{"720h", 720 * time.Hour, false},
+		{"0", 0, false},
+		{"0d", 0, false},
+		{"-1d", 0, true},
+		{"forever", 0, true},
+		{"", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseRetention(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseRetention(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			continue
+		}
+		if got != tt.expected {
+			t.Errorf("ParseRetention(%q) = %v, want %v", tt.input, got, tt.expected)
+		}
+	}
+}
+
+func TestUserConfigUnmarshal(t *testing.T) {
+	var auth AuthConfig
+	data := `
+users:
+  admin: secret
+  alice:
+    password: pw
+    role: operator
+    scooters: [VIN1, VIN2]
+  bob:
+    password: pw2
+api_keys:
+  - name: acme
+    key: k1
+    role: viewer
+    groups: [acme]
+`
+	if err := yaml.Unmarshal([]byte(data), &auth); err != nil {
+		t.Fatal(err)
+	}
+	if u := auth.Users["admin"]; u.Password != "secret" || u.Role != "admin" {
+		t.Errorf("legacy user = %+v", u)
+	}
+	if u := auth.Users["alice"]; u.Password != "pw" || u.Role != "operator" || len(u.Scooters) != 2 {
+		t.Errorf("alice = %+v", u)
+	}
+	if u := auth.Users["bob"]; u.Role != "" {
+		t.Errorf("bob = %+v", u)
+	}
+	if len(auth.APIKeys) != 1 || auth.APIKeys[0].Key != "k1" || auth.APIKeys[0].Groups[0] != "acme" {
+		t.Errorf("api keys = %+v", auth.APIKeys)
+	}
+}
+
+func TestGetTokenGracePeriod(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected time.Duration
+	}{
+		{"", 24 * time.Hour},
+		{"1h", time.Hour},
+		{"7d", 7 * 24 * time.Hour},
+		{"0", 0},
+		{"soon", 24 * time.Hour},
+	}
+
+	for _, tt := range tests {
+		c := AuthConfig{TokenGracePeriod: tt.input}
+		if got := c.GetTokenGracePeriod(); got != tt.expected {
+			t.Errorf("GetTokenGracePeriod(%q) = %v, want %v", tt.input, got, tt.expected)
+		}
+	}
+}
+
+func TestTLSConfigValidate(t *testing.T) {
+	tests := []struct {
+		config TLSConfig
+		valid  bool
+	}{
+		{TLSConfig{}, true},
+		{TLSConfig{CertFile: "s.crt", KeyFile: "s.key"}, true},
+		{TLSConfig{CertFile: "s.crt", KeyFile: "s.key", ClientCAFile: "ca.crt", ScooterAuth: "cert"}, true},
+		{TLSConfig{CertFile: "s.crt"}, false},
+		{TLSConfig{ClientCAFile: "ca.crt"}, false},
+		{TLSConfig{CertFile: "s.crt", KeyFile: "s.key", ScooterAuth: "cert_or_token"}, false},
+		{TLSConfig{CertFile: "s.crt", KeyFile: "s.key", ClientCAFile: "ca.crt", ScooterAuth: "password"}, false},
+	}
+
+	for _, tt := range tests {
+		if err := tt.config.Validate(); (err == nil) != tt.valid {
+			t.Errorf("Validate(%+v) = %v, want valid %v", tt.config, err, tt.valid)
+		}
+	}
+}
+
+func TestSessionConfig(t *testing.T) {
+	var c SessionConfig
+	if c.GetIdleTimeout() != 24*time.Hour || c.GetAbsoluteTimeout() != 7*24*time.Hour {
+		t.Errorf("defaults = %s, %s", c.GetIdleTimeout(), c.GetAbsoluteTimeout())
+	}
+	c = SessionConfig{IdleTimeout: "30m", AbsoluteTimeout: "0"}
+	if c.GetIdleTimeout() != 30*time.Minute || c.GetAbsoluteTimeout() != 0 {
+		t.Errorf("got %s, %s", c.GetIdleTimeout(), c.GetAbsoluteTimeout())
+	}
+	c = SessionConfig{IdleTimeout: "soon", AbsoluteTimeout: "30d"}
+	if c.GetIdleTimeout() != 24*time.Hour || c.GetAbsoluteTimeout() != 30*24*time.Hour {
+		t.Errorf("got %s, %s", c.GetIdleTimeout(), c.GetAbsoluteTimeout())
+	}
+}
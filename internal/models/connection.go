@@ -18,6 +18,7 @@ type Connection struct {
 	ConnectedAt   time.Time
 	LastSeen      time.Time
 	Version       string
+	Transport     string // "websocket" or "longpoll"
 
 	// Statistics (application-level, uncompressed)
 	BytesSent         int64
@@ -125,6 +126,7 @@ func (c *Connection) GetStats() map[string]any {
 		"telemetry_received": c.TelemetryReceived,
 		"commands_sent":      c.CommandsSent,
 		"version":            c.Version,
+		"transport":          c.Transport,
 	}
 
 	// Add wire-level stats if available
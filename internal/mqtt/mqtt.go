@@ -0,0 +1,485 @@
+// Package mqtt bridges the fleet to an MQTT broker. It publishes each
+// scooter's merged state (one retained topic per component), state deltas,
+// events and connection transitions, and accepts commands on a per-scooter
+// command topic, publishing their outcome back.
+//
+// Topics, under a configurable prefix (default "uplink"):
+//
+//	{prefix}/{id}/state/{component}  merged component state (retained)
+//	{prefix}/{id}/delta              state changes as received
+//	{prefix}/{id}/events             scooter events
+//	{prefix}/{id}/connection         "online"/"offline" transitions (retained)
+//	{prefix}/{id}/command            commands in (subscribed)
+//	{prefix}/{id}/command/response   command acknowledgements and results
+//	{prefix}/bridge/status           bridge availability (retained, with will)
+//
+// Commands are checked against the bridge's principal like an API caller's:
+// the catalog role of the command and the principal's scope. Without one the
+// bridge is a fleet-wide viewer and only accepts read-only commands.
+package mqtt
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+
+	"github.com/librescoot/uplink-server/internal/access"
+	"github.com/librescoot/uplink-server/internal/commands"
+	"github.com/librescoot/uplink-server/internal/handlers"
+	"github.com/librescoot/uplink-server/internal/protocol"
+	"github.com/librescoot/uplink-server/internal/storage"
+	"github.com/librescoot/uplink-server/internal/store"
+)
+
+// DefaultTopicPrefix is the topic root when none is configured.
+const DefaultTopicPrefix = "uplink"
+
+// Bridge availability payloads on {prefix}/bridge/status.
+const (
+	statusOnline  = "online"
+	statusOffline = "offline"
+)
+
+// Tuning.
+const (
+	defaultQueueTTL = time.Hour
+	pendingTTL      = time.Hour // minimum time a command's correlation id is kept
+	connectTimeout  = 10 * time.Second
+)
+
+// Config configures the bridge.
+type Config struct {
+	Broker      string // e.g. tcp://localhost:1883, ssl://broker:8883, ws://broker/mqtt
+	ClientID    string
+	Username    string
+	Password    string
+	TopicPrefix string
+	QoS         byte
+	ReadOnly    bool              // publish only; ignore the command topic
+	QueueTTL    time.Duration     // lifetime of commands queued for offline scooters
+	Principal   *access.Principal // role and scope for commands; nil is a viewer
+}
+
+// Commander sends commands to scooters; *handlers.WebSocketHandler
+// implements it.
+type Commander interface {
+	Catalog() *commands.Catalog
+	SendCommand(identifier, command string, params map[string]any) (string, error)
+	EnqueueCommand(identifier, command string, params map[string]any, ttl time.Duration) (string, error)
+}
+
+// CommandRequest is the JSON payload accepted on {prefix}/{id}/command.
+type CommandRequest struct {
+	Command       string         `json:"command"`
+	Params        map[string]any `json:"params,omitempty"`
+	Queue         bool           `json:"queue,omitempty"` // queue when the scooter is offline
+	TTL           string         `json:"ttl,omitempty"`
+	CorrelationID string         `json:"correlation_id,omitempty"` // echoed in responses
+}
+
+// CommandResponse is published on {prefix}/{id}/command/response: once when
+// the command is sent, queued or rejected, and again with its final outcome.
+type CommandResponse struct {
+	RequestID     string         `json:"request_id,omitempty"`
+	CorrelationID string         `json:"correlation_id,omitempty"`
+	Command       string         `json:"command,omitempty"`
+	Status        string         `json:"status"` // "sent", "queued", "rejected", "success", "error"
+	Result        map[string]any `json:"result,omitempty"`
+	Error         string         `json:"error,omitempty"`
+	Timestamp     time.Time      `json:"timestamp"`
+}
+
+// pendingCommand remembers a command sent over MQTT until its result arrives.
+type pendingCommand struct {
+	command       string
+	correlationID string
+	sentAt        time.Time
+}
+
+// Bridge connects the in-memory stores and the command path to a broker.
+type Bridge struct {
+	cfg       Config
+	commander Commander
+	client    paho.Client
+	db        *store.Store // audit log; may be nil
+
+	// publish sends one message; replaced in tests.
+	publish func(topic string, retained bool, payload []byte)
+
+	mu        sync.Mutex
+	published map[string]string // retained state topic -> last payload
+	pending   map[string]pendingCommand
+}
+
+// New creates a bridge. It does not connect until Start.
+func New(cfg Config, commander Commander) (*Bridge, error) {
+	u, err := url.Parse(cfg.Broker)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid broker %q", cfg.Broker)
+	}
+	switch u.Scheme {
+	case "tcp", "mqtt", "ssl", "tls", "mqtts", "ws", "wss":
+	default:
+		return nil, fmt.Errorf("unsupported broker scheme %q", u.Scheme)
+	}
+	if cfg.QoS > 2 {
+		return nil, fmt.Errorf("invalid qos %d", cfg.QoS)
+	}
+	cfg.TopicPrefix = strings.Trim(cfg.TopicPrefix, "/")
+	if cfg.TopicPrefix == "" {
+		cfg.TopicPrefix = DefaultTopicPrefix
+	}
+	if strings.ContainsAny(cfg.TopicPrefix, "+#") {
+		return nil, fmt.Errorf("topic prefix %q must not contain wildcards", cfg.TopicPrefix)
+	}
+	if cfg.ClientID == "" {
+		cfg.ClientID = "uplink-server"
+	}
+	if cfg.QueueTTL <= 0 {
+		cfg.QueueTTL = defaultQueueTTL
+	}
+	if cfg.Principal == nil {
+		cfg.Principal = &access.Principal{Name: "mqtt", Role: access.RoleViewer}
+	}
+	b := &Bridge{
+		cfg:       cfg,
+		commander: commander,
+		published: make(map[string]string),
+		pending:   make(map[string]pendingCommand),
+	}
+	b.publish = b.publishMQTT
+	return b, nil
+}
+
+// SetAuditLog records every command received over MQTT in db's audit log,
+// with the bridge's principal as the actor.
+func (b *Bridge) SetAuditLog(db *store.Store) {
+	b.db = db
+}
+
+// Start connects to the broker and forwards updates from the stores in the
+// background. The client reconnects on its own after connection loss; an
+// error is returned only when the first connection attempt fails outright.
+func (b *Bridge) Start(states *storage.StateStore, events *storage.EventStore, conns *storage.ConnectionManager) error {
+	opts := paho.NewClientOptions().
+		AddBroker(b.cfg.Broker).
+		SetClientID(b.cfg.ClientID).
+		SetUsername(b.cfg.Username).
+		SetPassword(b.cfg.Password).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectTimeout(connectTimeout).
+		SetWill(b.topic("bridge", "status"), statusOffline, 1, true).
+		SetOnConnectHandler(b.onConnect).
+		SetConnectionLostHandler(func(_ paho.Client, err error) {
+			log.Printf("[MQTT] Connection lost: %v", err)
+		})
+	b.client = paho.NewClient(opts)
+	// With SetConnectRetry the token only completes once connected; don't
+	// hold up startup waiting for an unreachable broker.
+	tok := b.client.Connect()
+	if tok.WaitTimeout(connectTimeout) && tok.Error() != nil {
+		return tok.Error()
+	}
+
+	stateCh, _ := states.Subscribe()
+	eventCh, _ := events.Subscribe()
+	connCh, _ := conns.Subscribe()
+	go func() {
+		for {
+			select {
+			case u, ok := <-stateCh:
+				if !ok {
+					return
+				}
+				if u.Type == "delta" {
+					b.PublishDelta(u.ScooterID, u.State, u.Timestamp)
+				}
+			case ev, ok := <-eventCh:
+				if !ok {
+					return
+				}
+				b.PublishEvent(ev.ScooterID, ev.Event, ev.Data, ev.Timestamp)
+			case ce, ok := <-connCh:
+				if !ok {
+					return
+				}
+				b.PublishConnection(ce.Identifier, ce.Type, time.Now())
+			}
+		}
+	}()
+	return nil
+}
+
+// Stop announces the bridge offline and disconnects.
+func (b *Bridge) Stop() {
+	if b.client == nil {
+		return
+	}
+	b.client.Publish(b.topic("bridge", "status"), 1, true, statusOffline).WaitTimeout(time.Second)
+	b.client.Disconnect(250)
+}
+
+// onConnect runs after every (re)connect: it announces the bridge, subscribes
+// to the command topic and forgets what was published, so state is
+// republished in full in case the broker lost its retained messages.
+func (b *Bridge) onConnect(c paho.Client) {
+	log.Printf("[MQTT] Connected to %s", b.cfg.Broker)
+	b.mu.Lock()
+	clear(b.published)
+	b.mu.Unlock()
+	c.Publish(b.topic("bridge", "status"), 1, true, statusOnline)
+	if b.cfg.ReadOnly {
+		return
+	}
+	filter := b.topic("+", "command")
+	tok := c.Subscribe(filter, b.cfg.QoS, func(_ paho.Client, m paho.Message) {
+		b.HandleCommand(m.Topic(), m.Payload())
+	})
+	go func() {
+		if tok.Wait(); tok.Error() != nil {
+			log.Printf("[MQTT] Failed to subscribe to %s: %v", filter, tok.Error())
+		}
+	}()
+}
+
+func (b *Bridge) publishMQTT(topic string, retained bool, payload []byte) {
+	if b.client == nil {
+		return
+	}
+	// Publish is asynchronous; while disconnected paho reports an error on
+	// the token, which is not worth waiting for.
+	b.client.Publish(topic, b.cfg.QoS, retained, payload)
+}
+
+// topic joins parts under the configured prefix.
+func (b *Bridge) topic(parts ...string) string {
+	return b.cfg.TopicPrefix + "/" + strings.Join(parts, "/")
+}
+
+// ObserveState publishes each top-level component of a scooter's merged
+// state to its retained topic, skipping components whose payload has not
+// changed since the last publish. It is registered as a telemetry hook, so
+// it runs on the scooter's connection goroutine and may read state safely.
+func (b *Bridge) ObserveState(scooterID string, state map[string]any, _ time.Time) {
+	if !validTopicLevel(scooterID) {
+		return
+	}
+	for component, value := range state {
+		if !validTopicLevel(component) {
+			continue
+		}
+		payload, err := json.Marshal(value)
+		if err != nil {
+			continue
+		}
+		topic := b.topic(scooterID, "state", component)
+		b.mu.Lock()
+		unchanged := b.published[topic] == string(payload)
+		if !unchanged {
+			b.published[topic] = string(payload)
+		}
+		b.mu.Unlock()
+		if !unchanged {
+			b.publish(topic, true, payload)
+		}
+	}
+}
+
+// PublishDelta publishes state changes as received from a scooter.
+func (b *Bridge) PublishDelta(scooterID string, changes map[string]any, ts time.Time) {
+	b.publishJSON(scooterID, false, map[string]any{"changes": changes, "timestamp": ts.UTC()}, "delta")
+}
+
+// PublishEvent publishes a scooter event.
+func (b *Bridge) PublishEvent(scooterID, event string, data map[string]any, ts time.Time) {
+	b.publishJSON(scooterID, false, map[string]any{"event": event, "data": data, "timestamp": ts.UTC()}, "events")
+}
+
+// PublishConnection publishes a connection transition; status is "online"
+// or "offline".
+func (b *Bridge) PublishConnection(scooterID, status string, ts time.Time) {
+	b.publishJSON(scooterID, true, map[string]any{"status": status, "timestamp": ts.UTC()}, "connection")
+}
+
+// CommandResult publishes a command's final outcome. It is registered as a
+// command result hook; results of commands not sent over MQTT are published
+// too, so subscribers see every command a scooter completes.
+func (b *Bridge) CommandResult(scooterID string, resp *protocol.CommandResponse) {
+	b.mu.Lock()
+	p := b.pending[resp.RequestID]
+	delete(b.pending, resp.RequestID)
+	b.mu.Unlock()
+	b.respond(scooterID, CommandResponse{
+		RequestID:     resp.RequestID,
+		CorrelationID: p.correlationID,
+		Command:       p.command,
+		Status:        resp.Status,
+		Result:        resp.Result,
+		Error:         resp.Error,
+	})
+}
+
+// HandleCommand dispatches a message received on {prefix}/{id}/command and
+// publishes the acknowledgement. Offline scooters get the command queued
+// when the request asks for it and the command may be deferred.
+func (b *Bridge) HandleCommand(topic string, payload []byte) {
+	scooterID, ok := b.commandScooter(topic)
+	if !ok {
+		return
+	}
+	var (
+		req    CommandRequest
+		reply  CommandResponse
+		denied bool
+	)
+	defer func() { b.audit(scooterID, &req, &reply, denied) }()
+
+	if err := json.Unmarshal(payload, &req); err != nil {
+		reply = CommandResponse{Status: "rejected", Error: "invalid JSON"}
+		b.respond(scooterID, reply)
+		return
+	}
+	reply = CommandResponse{Command: req.Command, CorrelationID: req.CorrelationID}
+	if req.Command == "" {
+		reply.Status, reply.Error = "rejected", "command is required"
+		b.respond(scooterID, reply)
+		return
+	}
+	if req.Params == nil {
+		req.Params = make(map[string]any)
+	}
+	if reason := b.authorize(scooterID, req.Command); reason != "" {
+		log.Printf("[MQTT] Refused %s for %s: %s", req.Command, scooterID, reason)
+		reply.Status, reply.Error, denied = "rejected", reason, true
+		b.respond(scooterID, reply)
+		return
+	}
+
+	requestID, err := b.commander.SendCommand(scooterID, req.Command, req.Params)
+	switch {
+	case err == nil:
+		reply.Status = "sent"
+	case commands.IsRejection(err):
+		reply.Status, reply.Error = "rejected", err.Error()
+	case errors.Is(err, handlers.ErrConnectionNotFound) && req.Queue:
+		ttl := b.cfg.QueueTTL
+		if d, perr := time.ParseDuration(req.TTL); perr == nil && d > 0 {
+			ttl = d
+		}
+		requestID, err = b.commander.EnqueueCommand(scooterID, req.Command, req.Params, ttl)
+		switch {
+		case err == nil:
+			reply.Status = "queued"
+		case errors.Is(err, handlers.ErrNotQueueable):
+			reply.Status, reply.Error = "rejected", "command may not be queued while offline"
+		case commands.IsRejection(err):
+			reply.Status, reply.Error = "rejected", err.Error()
+		default:
+			reply.Status, reply.Error = "rejected", "failed to queue command"
+		}
+	case errors.Is(err, handlers.ErrConnectionNotFound):
+		reply.Status, reply.Error = "rejected", "scooter not connected"
+	case errors.Is(err, handlers.ErrSendChannelFull):
+		reply.Status, reply.Error = "rejected", "send channel full, try again later"
+	default:
+		reply.Status, reply.Error = "rejected", "failed to send command"
+	}
+	reply.RequestID = requestID
+
+	if reply.Status == "sent" || reply.Status == "queued" {
+		log.Printf("[MQTT] Command %s for %s %s (request_id=%s)", req.Command, scooterID, reply.Status, requestID)
+		// Results of queued commands can arrive as late as the queue TTL.
+		now, keep := time.Now(), max(pendingTTL, b.cfg.QueueTTL)
+		b.mu.Lock()
+		for id, p := range b.pending {
+			if now.Sub(p.sentAt) > keep {
+				delete(b.pending, id)
+			}
+		}
+		b.pending[requestID] = pendingCommand{command: req.Command, correlationID: req.CorrelationID, sentAt: now}
+		b.mu.Unlock()
+	}
+	b.respond(scooterID, reply)
+}
+
+// audit records a command received over MQTT and its acknowledgement.
+func (b *Bridge) audit(scooterID string, req *CommandRequest, reply *CommandResponse, denied bool) {
+	if b.db == nil {
+		return
+	}
+	outcome := store.AuditSuccess
+	switch {
+	case denied:
+		outcome = store.AuditDenied
+	case reply.Status == "rejected":
+		outcome = store.AuditFailure
+	}
+	params := map[string]any{
+		"command":        req.Command,
+		"params":         req.Params,
+		"queue":          req.Queue,
+		"request_id":     reply.RequestID,
+		"correlation_id": req.CorrelationID,
+	}
+	if err := b.db.AuditCommand(b.cfg.Principal.Name, scooterID, outcome, params, reply.Error); err != nil {
+		log.Printf("[MQTT] Failed to audit %s for %s: %v", req.Command, scooterID, err)
+	}
+}
+
+// authorize checks a command against the bridge's principal and returns why
+// it is refused, or "". Unknown commands are left to the catalog to reject.
+func (b *Bridge) authorize(scooterID, command string) string {
+	p := b.cfg.Principal
+	if !p.CanAccess(scooterID) {
+		return "scooter is outside the bridge's scope"
+	}
+	if cmd, ok := b.commander.Catalog().Lookup(command); ok && !p.Allows(cmd.Role) {
+		return fmt.Sprintf("%s requires the %s role", command, cmd.Role)
+	}
+	return ""
+}
+
+// commandScooter extracts the scooter id from a command topic.
+func (b *Bridge) commandScooter(topic string) (string, bool) {
+	rest, ok := strings.CutPrefix(topic, b.cfg.TopicPrefix+"/")
+	if !ok {
+		return "", false
+	}
+	id, suffix, ok := strings.Cut(rest, "/")
+	if !ok || suffix != "command" || id == "" {
+		return "", false
+	}
+	return id, true
+}
+
+func (b *Bridge) respond(scooterID string, resp CommandResponse) {
+	if resp.Timestamp.IsZero() {
+		resp.Timestamp = time.Now().UTC()
+	}
+	b.publishJSON(scooterID, false, resp, "command", "response")
+}
+
+func (b *Bridge) publishJSON(scooterID string, retained bool, v any, parts ...string) {
+	if !validTopicLevel(scooterID) {
+		return
+	}
+	payload, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("[MQTT] Failed to encode %s payload: %v", strings.Join(parts, "/"), err)
+		return
+	}
+	b.publish(b.topic(append([]string{scooterID}, parts...)...), retained, payload)
+}
+
+// validTopicLevel reports whether s can be used as a single topic level.
+func validTopicLevel(s string) bool {
+	return s != "" && !strings.ContainsAny(s, "/+#")
+}
@@ -0,0 +1,246 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/librescoot/uplink-server/internal/access"
+	"github.com/librescoot/uplink-server/internal/commands"
+	"github.com/librescoot/uplink-server/internal/handlers"
+	"github.com/librescoot/uplink-server/internal/protocol"
+	"github.com/librescoot/uplink-server/internal/store"
+)
+
+type message struct {
+	topic    string
+	retained bool
+	payload  string
+}
+
+type fakeCommander struct {
+	catalog *commands.Catalog
+	online  map[string]bool
+	sent    []string
+	queued  []string
+	ttl     time.Duration
+}
+
+func (f *fakeCommander) Catalog() *commands.Catalog {
+	return f.catalog
+}
+
+func (f *fakeCommander) SendCommand(id, command string, params map[string]any) (string, error) {
+	if err := f.catalog.Validate(command, params); err != nil {
+		return "", err
+	}
+	if !f.online[id] {
+		return "", handlers.ErrConnectionNotFound
+	}
+	f.sent = append(f.sent, command)
+	return "req-sent", nil
+}
+
+func (f *fakeCommander) EnqueueCommand(id, command string, params map[string]any, ttl time.Duration) (string, error) {
+	if !f.catalog.Queueable(command) {
+		return "", handlers.ErrNotQueueable
+	}
+	f.queued = append(f.queued, command)
+	f.ttl = ttl
+	return "req-queued", nil
+}
+
+func newTestBridge(t *testing.T, cmd Commander) (*Bridge, *[]message) {
+	t.Helper()
+	operator := &access.Principal{Name: "mqtt", Role: access.RoleOperator}
+	b, err := New(Config{Broker: "tcp://localhost:1883", TopicPrefix: "fleet/", Principal: operator}, cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		mu   sync.Mutex
+		msgs []message
+	)
+	b.publish = func(topic string, retained bool, payload []byte) {
+		mu.Lock()
+		defer mu.Unlock()
+		msgs = append(msgs, message{topic, retained, string(payload)})
+	}
+	return b, &msgs
+}
+
+func TestNewValidates(t *testing.T) {
+	for _, broker := range []string{"", "localhost:1883", "http://broker:1883", "tcp://"} {
+		if _, err := New(Config{Broker: broker}, nil); err == nil {
+			t.Errorf("broker %q: expected error", broker)
+		}
+	}
+	if _, err := New(Config{Broker: "tcp://broker:1883", TopicPrefix: "a/#"}, nil); err == nil {
+		t.Error("wildcard prefix: expected error")
+	}
+	b, err := New(Config{Broker: "ssl://broker:8883"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.cfg.TopicPrefix != DefaultTopicPrefix || b.cfg.QueueTTL != defaultQueueTTL {
+		t.Errorf("defaults not applied: %+v", b.cfg)
+	}
+}
+
+func TestObserveStatePublishesChangedComponents(t *testing.T) {
+	b, msgs := newTestBridge(t, nil)
+	state := map[string]any{
+		"battery:0": map[string]any{"charge": 80},
+		"vehicle":   map[string]any{"state": "parked"},
+	}
+	b.ObserveState("VIN1", state, time.Now())
+	if len(*msgs) != 2 {
+		t.Fatalf("got %d messages, want 2", len(*msgs))
+	}
+	for _, m := range *msgs {
+		if !m.retained {
+			t.Errorf("%s not retained", m.topic)
+		}
+	}
+
+	// Only the changed component is republished.
+	*msgs = nil
+	state["vehicle"] = map[string]any{"state": "ready-to-drive"}
+	b.ObserveState("VIN1", state, time.Now())
+	if len(*msgs) != 1 {
+		t.Fatalf("got %d messages, want 1", len(*msgs))
+	}
+	if m := (*msgs)[0]; m.topic != "fleet/VIN1/state/vehicle" || m.payload != `{"state":"ready-to-drive"}` {
+		t.Errorf("unexpected message %+v", m)
+	}
+
+	// Components that cannot be a topic level are skipped.
+	*msgs = nil
+	b.ObserveState("VIN1", map[string]any{"a/b": 1, "c#": 2}, time.Now())
+	if len(*msgs) != 0 {
+		t.Errorf("published invalid topics: %+v", *msgs)
+	}
+}
+
+func TestHandleCommand(t *testing.T) {
+	cmd := &fakeCommander{catalog: commands.Default(), online: map[string]bool{"VIN1": true}}
+	b, msgs := newTestBridge(t, cmd)
+
+	last := func() (string, CommandResponse) {
+		t.Helper()
+		if len(*msgs) == 0 {
+			t.Fatal("no response published")
+		}
+		m := (*msgs)[len(*msgs)-1]
+		var r CommandResponse
+		if err := json.Unmarshal([]byte(m.payload), &r); err != nil {
+			t.Fatal(err)
+		}
+		return m.topic, r
+	}
+
+	b.HandleCommand("fleet/VIN1/command", []byte(`{"command":"get_state","correlation_id":"c1"}`))
+	topic, r := last()
+	if topic != "fleet/VIN1/command/response" || r.Status != "sent" || r.RequestID != "req-sent" || r.CorrelationID != "c1" {
+		t.Errorf("online: got %s %+v", topic, r)
+	}
+
+	b.HandleCommand("fleet/VIN2/command", []byte(`{"command":"get_state"}`))
+	if _, r = last(); r.Status != "rejected" || r.Error != "scooter not connected" {
+		t.Errorf("offline without queue: got %+v", r)
+	}
+
+	b.HandleCommand("fleet/VIN2/command", []byte(`{"command":"get_state","queue":true,"ttl":"2h"}`))
+	if _, r = last(); r.Status != "queued" || r.RequestID != "req-queued" || cmd.ttl != 2*time.Hour {
+		t.Errorf("offline queued: got %+v ttl=%s", r, cmd.ttl)
+	}
+
+	b.HandleCommand("fleet/VIN2/command", []byte(`{"command":"unlock","queue":true}`))
+	if _, r = last(); r.Status != "rejected" || len(cmd.queued) != 1 {
+		t.Errorf("unqueueable command: got %+v queued=%v", r, cmd.queued)
+	}
+
+	b.HandleCommand("fleet/VIN1/command", []byte(`{"command":"blinker_lefft"}`))
+	if _, r = last(); r.Status != "rejected" || r.Error != `unknown command "blinker_lefft"` {
+		t.Errorf("unknown command: got %+v", r)
+	}
+
+	b.HandleCommand("fleet/VIN1/command", []byte(`{"command":"reboot"}`))
+	if _, r = last(); r.Status != "rejected" || r.Error != "reboot requires the admin role" {
+		t.Errorf("admin command as operator: got %+v", r)
+	}
+
+	b.HandleCommand("fleet/VIN1/command", []byte(`not json`))
+	if _, r = last(); r.Status != "rejected" {
+		t.Errorf("invalid JSON: got %+v", r)
+	}
+
+	n := len(*msgs)
+	b.HandleCommand("fleet/VIN1/command/response", []byte(`{"command":"get_state"}`))
+	b.HandleCommand("other/VIN1/command", []byte(`{"command":"get_state"}`))
+	if len(*msgs) != n || len(cmd.sent) != 1 {
+		t.Errorf("foreign topics were handled: sent=%v", cmd.sent)
+	}
+
+	// The final result carries the correlation id of the original request.
+	b.CommandResult("VIN1", &protocol.CommandResponse{RequestID: "req-sent", Status: "success", Result: map[string]any{"ok": true}})
+	topic, r = last()
+	if topic != "fleet/VIN1/command/response" || r.Status != "success" || r.CorrelationID != "c1" || r.Command != "get_state" {
+		t.Errorf("result: got %s %+v", topic, r)
+	}
+	if len(b.pending) != 1 { // the queued command is still outstanding
+		t.Errorf("pending = %d, want 1", len(b.pending))
+	}
+}
+
+func TestCommandScope(t *testing.T) {
+	cmd := &fakeCommander{catalog: commands.Default(), online: map[string]bool{"VIN1": true, "VIN2": true}}
+	b, err := New(Config{Broker: "tcp://localhost:1883"}, cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.publish = func(string, bool, []byte) {}
+	db, err := store.Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	b.SetAuditLog(db)
+
+	// Without a principal the bridge only sends read-only commands.
+	b.HandleCommand("uplink/VIN1/command", []byte(`{"command":"lock"}`))
+	b.HandleCommand("uplink/VIN1/command", []byte(`{"command":"ping"}`))
+
+	b.cfg.Principal = &access.Principal{Name: "mqtt", Role: access.RoleAdmin, Scooters: []string{"VIN1"}}
+	b.HandleCommand("uplink/VIN2/command", []byte(`{"command":"lock"}`))
+	b.HandleCommand("uplink/VIN1/command", []byte(`{"command":"reboot"}`))
+	if len(cmd.sent) != 2 || cmd.sent[0] != "ping" || cmd.sent[1] != "reboot" {
+		t.Errorf("sent = %v", cmd.sent)
+	}
+
+	entries, _ := db.QueryAudit(store.AuditFilter{Actor: "mqtt"})
+	outcomes := map[string]int{}
+	for _, e := range entries {
+		outcomes[e.Outcome]++
+	}
+	if len(entries) != 4 || outcomes[store.AuditDenied] != 2 || entries[0].Params["request_id"] != "req-sent" {
+		t.Errorf("audit = %+v", entries)
+	}
+}
+
+func TestPublishConnectionRetained(t *testing.T) {
+	b, msgs := newTestBridge(t, nil)
+	b.PublishConnection("VIN1", "offline", time.Now())
+	b.PublishEvent("VIN1", "alarm", map[string]any{"level": 2}, time.Now())
+	if len(*msgs) != 2 {
+		t.Fatalf("got %d messages, want 2", len(*msgs))
+	}
+	if m := (*msgs)[0]; m.topic != "fleet/VIN1/connection" || !m.retained {
+		t.Errorf("connection: %+v", m)
+	}
+	if m := (*msgs)[1]; m.topic != "fleet/VIN1/events" || m.retained {
+		t.Errorf("event: %+v", m)
+	}
+}
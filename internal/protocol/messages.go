@@ -44,6 +44,11 @@ type AuthResponse struct {
 	Status     string      `json:"status"` // "success" or "error"
 	Error      string      `json:"error,omitempty"`
 	ServerTime string      `json:"server_time"`
+	// Session and PollTimeout are set only by the long-poll transport: the
+	// session token authorizes subsequent requests, and PollTimeout is the
+	// longest a poll will be held open.
+	Session     string `json:"session,omitempty"`
+	PollTimeout string `json:"poll_timeout,omitempty"`
 }
 
 // StateMessage - Client sends full state snapshot
@@ -1,5 +1,6 @@
 // Package registry manages scooter registration (token issuance + persistence)
-// so scooters can be added/removed at runtime instead of via the CLI.
+// and web-UI users, so both can be changed at runtime instead of by editing
+// the config file.
 package registry
 
 import (
@@ -7,20 +8,26 @@ import (
 	"encoding/hex"
 	"fmt"
 	"os"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	"gopkg.in/yaml.v2"
 
+	"github.com/librescoot/uplink-server/internal/access"
 	"github.com/librescoot/uplink-server/internal/auth"
 	"github.com/librescoot/uplink-server/internal/models"
 )
 
-// Registry issues scooter credentials and persists them to the config file.
+// Registry issues scooter credentials, manages users, and persists both to
+// the config file.
 type Registry struct {
-	mu   sync.Mutex
-	cfg  *models.Config
-	path string
-	auth *auth.Authenticator
+	mu    sync.Mutex
+	cfg   *models.Config
+	path  string
+	auth  *auth.Authenticator
+	users *access.Policy // live users; may be nil
 }
 
 // New creates a registry bound to the running config, its file path, and the
@@ -29,11 +36,24 @@ func New(cfg *models.Config, path string, a *auth.Authenticator) *Registry {
 	return &Registry{cfg: cfg, path: path, auth: a}
 }
 
+// SetUsers binds the live access policy, whose users are then managed and
+// persisted by the registry.
+func (r *Registry) SetUsers(p *access.Policy) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.users = p
+}
+
 // List returns the registered scooters (without tokens).
 func (r *Registry) List() []auth.ScooterInfo {
 	return r.auth.List()
 }
 
+// Get returns a registered scooter (without its token).
+func (r *Registry) Get(identifier string) (auth.ScooterInfo, bool) {
+	return r.auth.Get(identifier)
+}
+
 // Add registers a new scooter, generating a token, updating the live
 // authenticator, and persisting the config. It returns the generated token.
 func (r *Registry) Add(identifier, name string) (string, error) {
@@ -71,11 +91,124 @@ func (r *Registry) Delete(identifier string) error {
 	return r.saveLocked()
 }
 
-// saveLocked marshals the config (with a fresh token snapshot) to disk, keeping
-// a .backup of the previous file. Caller holds r.mu.
+// Rotate issues a new token for a registered scooter and persists the config.
+// The old token keeps working for grace (not at all if grace is 0). It
+// returns the new token and when the old one stops working.
+func (r *Registry) Rotate(identifier string, grace time.Duration) (string, time.Time, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	token, err := generateToken(32)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	var until time.Time
+	if grace > 0 {
+		until = time.Now().Add(grace).UTC()
+	}
+	old, ok := r.auth.Rotate(identifier, token, until)
+	if !ok {
+		return "", time.Time{}, fmt.Errorf("scooter %q not found", identifier)
+	}
+	if err := r.saveLocked(); err != nil {
+		r.auth.Restore(identifier, old)
+		return "", time.Time{}, fmt.Errorf("persist config: %w", err)
+	}
+	return token, until, nil
+}
+
+// TokenGracePeriod is how long a rotated-out token keeps working by default.
+func (r *Registry) TokenGracePeriod() time.Duration {
+	return r.cfg.Auth.GetTokenGracePeriod()
+}
+
+// MigrateTokens hashes any plaintext scooter tokens and persists the config
+// if there were some. It returns how many tokens were hashed.
+func (r *Registry) MigrateTokens() (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := r.auth.HashTokens()
+	if n == 0 {
+		return 0, nil
+	}
+	if err := r.saveLocked(); err != nil {
+		return n, fmt.Errorf("persist config: %w", err)
+	}
+	return n, nil
+}
+
+// Update is a change to a registered scooter's name, groups and tags. Nil
+// fields are left as they are.
+type Update struct {
+	Name   *string   `json:"name"`
+	Groups *[]string `json:"groups"`
+	Tags   *[]string `json:"tags"`
+}
+
+// Update applies u to a registered scooter and persists the config.
+func (r *Registry) Update(identifier string, u Update) (auth.ScooterInfo, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	old, ok := r.auth.Get(identifier)
+	if !ok {
+		return auth.ScooterInfo{}, fmt.Errorf("scooter %q not found", identifier)
+	}
+	next := old
+	if u.Name != nil {
+		next.Name = strings.TrimSpace(*u.Name)
+	}
+	var err error
+	if u.Groups != nil {
+		if next.Groups, err = NormalizeLabels(*u.Groups); err != nil {
+			return auth.ScooterInfo{}, err
+		}
+	}
+	if u.Tags != nil {
+		if next.Tags, err = NormalizeLabels(*u.Tags); err != nil {
+			return auth.ScooterInfo{}, err
+		}
+	}
+	r.auth.Update(identifier, next.Name, next.Groups, next.Tags)
+	if err := r.saveLocked(); err != nil {
+		r.auth.Update(identifier, old.Name, old.Groups, old.Tags)
+		return auth.ScooterInfo{}, fmt.Errorf("persist config: %w", err)
+	}
+	return next, nil
+}
+
+// maxLabelLen bounds group and tag names.
+const maxLabelLen = 64
+
+// NormalizeLabels trims, de-duplicates and sorts group or tag names, dropping
+// empty ones. Names may not contain commas, which separate them in filters.
+func NormalizeLabels(labels []string) ([]string, error) {
+	seen := make(map[string]bool, len(labels))
+	var out []string
+	for _, l := range labels {
+		l = strings.TrimSpace(l)
+		if l == "" || seen[l] {
+			continue
+		}
+		if strings.Contains(l, ",") || len(l) > maxLabelLen {
+			return nil, fmt.Errorf("invalid label %q: at most %d characters, no commas", l, maxLabelLen)
+		}
+		seen[l] = true
+		out = append(out, l)
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// saveLocked marshals the config (with fresh token and user snapshots) to
+// disk, keeping a .backup of the previous file. Caller holds r.mu.
 func (r *Registry) saveLocked() error {
 	out := *r.cfg
 	out.Auth.Tokens = r.auth.Snapshot()
+	if r.users != nil {
+		out.Auth.Users = r.users.Snapshot()
+	}
 
 	data, err := yaml.Marshal(&out)
 	if err != nil {
@@ -0,0 +1,187 @@
+package registry
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/librescoot/uplink-server/internal/access"
+	"github.com/librescoot/uplink-server/internal/auth"
+	"github.com/librescoot/uplink-server/internal/models"
+)
+
+func newTestRegistry(t *testing.T) (*Registry, string) {
+	t.Helper()
+	cfg := &models.Config{Auth: models.AuthConfig{Tokens: map[string]models.ScooterConfig{
+		"VIN1": {Token: "secret", Name: "One"},
+	}}}
+	path := filepath.Join(t.TempDir(), "config.yml")
+	return New(cfg, path, auth.NewAuthenticator(cfg)), path
+}
+
+func TestUpdate(t *testing.T) {
+	r, path := newTestRegistry(t)
+
+	groups := []string{" depot-b", "depot-a", "depot-b", ""}
+	info, err := r.Update("VIN1", Update{Groups: &groups})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Name != "One" || !reflect.DeepEqual(info.Groups, []string{"depot-a", "depot-b"}) || info.Tags != nil {
+		t.Fatalf("updated = %+v", info)
+	}
+
+	name, tags := "Renamed", []string{"rev3"}
+	if _, err := r.Update("VIN1", Update{Name: &name, Tags: &tags}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved models.Config
+	if err := yaml.Unmarshal(data, &saved); err != nil {
+		t.Fatal(err)
+	}
+	got := saved.Auth.Tokens["VIN1"]
+	want := models.ScooterConfig{Token: "secret", Name: "Renamed", Groups: []string{"depot-a", "depot-b"}, Tags: []string{"rev3"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("persisted %+v, want %+v", got, want)
+	}
+
+	bad := []string{"a,b"}
+	if _, err := r.Update("VIN1", Update{Tags: &bad}); err == nil {
+		t.Error("label with comma accepted")
+	}
+	if _, err := r.Update("VIN2", Update{Name: &name}); err == nil {
+		t.Error("unknown scooter updated")
+	}
+}
+
+func TestUpdateRollback(t *testing.T) {
+	r, _ := newTestRegistry(t)
+	r.path = filepath.Join(t.TempDir(), "missing", "config.yml")
+
+	groups := []string{"depot-a"}
+	if _, err := r.Update("VIN1", Update{Groups: &groups}); err == nil {
+		t.Fatal("expected persist error")
+	}
+	if g := r.auth.Groups("VIN1"); g != nil {
+		t.Errorf("groups after failed save = %v", g)
+	}
+}
+
+func TestRotate(t *testing.T) {
+	r, path := newTestRegistry(t)
+
+	if n, err := r.MigrateTokens(); err != nil || n != 1 {
+		t.Fatalf("migrate = %d, %v", n, err)
+	}
+	token, until, err := r.Rotate("VIN1", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(token) != 64 || time.Until(until) < 59*time.Minute {
+		t.Fatalf("rotate = %q, %v", token, until)
+	}
+	for _, tok := range []string{token, "secret"} {
+		if err := r.auth.Authenticate("VIN1", tok); err != nil {
+			t.Errorf("%s: %v", tok, err)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved models.Config
+	if err := yaml.Unmarshal(data, &saved); err != nil {
+		t.Fatal(err)
+	}
+	got := saved.Auth.Tokens["VIN1"]
+	if got.Token != auth.HashToken(token) || got.PreviousToken != auth.HashToken("secret") || !got.PreviousTokenExpires.Equal(until) {
+		t.Errorf("persisted %+v", got)
+	}
+
+	if _, _, err := r.Rotate("VIN2", 0); err == nil {
+		t.Error("rotated unknown scooter")
+	}
+}
+
+func TestUsers(t *testing.T) {
+	cfg := &models.Config{Auth: models.AuthConfig{Users: map[string]models.UserConfig{
+		"admin": {Password: "plaintext", Grant: models.Grant{Role: access.RoleAdmin}},
+	}}}
+	path := filepath.Join(t.TempDir(), "config.yml")
+	r := New(cfg, path, auth.NewAuthenticator(cfg))
+	policy, err := access.New(cfg.Auth, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.SetUsers(policy)
+
+	if n, err := r.MigratePasswords(); err != nil || n != 1 {
+		t.Fatalf("migrate = %d, %v", n, err)
+	}
+	if n, _ := r.MigratePasswords(); n != 0 {
+		t.Errorf("migrated %d passwords twice", n)
+	}
+	if !policy.CheckPassword("admin", "plaintext") {
+		t.Error("migrated password rejected")
+	}
+
+	if _, err := r.AddUser("alice", "short", models.Grant{}); err == nil {
+		t.Error("short password accepted")
+	}
+	if _, err := r.AddUser("alice", "long enough", models.Grant{Role: "owner"}); err == nil {
+		t.Error("invalid role accepted")
+	}
+	p, err := r.AddUser("alice", "long enough", models.Grant{Scooters: []string{"VIN1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Role != access.RoleViewer || p.CanAccess("VIN2") {
+		t.Errorf("added = %+v", p)
+	}
+	if _, err := r.AddUser("alice", "long enough", models.Grant{}); err == nil {
+		t.Error("duplicate user accepted")
+	}
+
+	role, password := access.RoleOperator, "another one"
+	if _, err := r.UpdateUser("alice", UserUpdate{Role: &role, Password: &password}); err != nil {
+		t.Fatal(err)
+	}
+	if !policy.CheckPassword("alice", password) || policy.CheckPassword("alice", "long enough") {
+		t.Error("password not changed")
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved models.Config
+	if err := yaml.Unmarshal(data, &saved); err != nil {
+		t.Fatal(err)
+	}
+	alice := saved.Auth.Users["alice"]
+	if !access.IsHash(alice.Password) || !access.IsHash(saved.Auth.Users["admin"].Password) {
+		t.Errorf("plaintext password persisted: %+v", saved.Auth.Users)
+	}
+	if alice.Role != access.RoleOperator || !reflect.DeepEqual(alice.Scooters, []string{"VIN1"}) {
+		t.Errorf("persisted alice = %+v", alice)
+	}
+
+	if err := r.RemoveUser("alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.RemoveUser("alice"); err == nil {
+		t.Error("removed unknown user")
+	}
+	if users := r.Users(); len(users) != 1 || users[0].Name != "admin" {
+		t.Errorf("users = %+v", users)
+	}
+}
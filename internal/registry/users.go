@@ -0,0 +1,171 @@
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/librescoot/uplink-server/internal/access"
+	"github.com/librescoot/uplink-server/internal/models"
+)
+
+var errNoUsers = errors.New("user management is not enabled")
+
+// UserUpdate is a change to a user's password, role and scope. Nil fields are
+// left as they are.
+type UserUpdate struct {
+	Password *string   `json:"password"`
+	Role     *string   `json:"role"`
+	Scooters *[]string `json:"scooters"`
+	Groups   *[]string `json:"groups"`
+}
+
+// Users returns the configured users (without password hashes).
+func (r *Registry) Users() []*access.Principal {
+	if r.users == nil {
+		return nil
+	}
+	return r.users.Users()
+}
+
+// AddUser creates a user with a hashed password and persists the config.
+func (r *Registry) AddUser(name, password string, g models.Grant) (*access.Principal, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.users == nil {
+		return nil, errNoUsers
+	}
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > maxLabelLen || strings.ContainsAny(name, " \t/") {
+		return nil, fmt.Errorf("invalid user name %q: 1-%d characters, no spaces or slashes", name, maxLabelLen)
+	}
+	if _, ok := r.users.User(name); ok {
+		return nil, fmt.Errorf("user %q already exists", name)
+	}
+	cfg, err := userConfig(models.UserConfig{}, UserUpdate{Password: &password, Role: &g.Role, Scooters: &g.Scooters, Groups: &g.Groups})
+	if err != nil {
+		return nil, err
+	}
+	if err := r.users.SetUser(name, cfg); err != nil {
+		return nil, err
+	}
+	if err := r.saveLocked(); err != nil {
+		r.users.RemoveUser(name)
+		return nil, fmt.Errorf("persist config: %w", err)
+	}
+	p, _ := r.users.User(name)
+	return p, nil
+}
+
+// UpdateUser applies u to a user and persists the config. A new password is
+// hashed.
+func (r *Registry) UpdateUser(name string, u UserUpdate) (*access.Principal, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.users == nil {
+		return nil, errNoUsers
+	}
+	old, ok := r.users.UserConfig(name)
+	if !ok {
+		return nil, fmt.Errorf("user %q not found", name)
+	}
+	cfg, err := userConfig(old, u)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.users.SetUser(name, cfg); err != nil {
+		return nil, err
+	}
+	if err := r.saveLocked(); err != nil {
+		r.users.SetUser(name, old)
+		return nil, fmt.Errorf("persist config: %w", err)
+	}
+	p, _ := r.users.User(name)
+	return p, nil
+}
+
+// RemoveUser deletes a user and persists the config.
+func (r *Registry) RemoveUser(name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.users == nil {
+		return errNoUsers
+	}
+	old, ok := r.users.UserConfig(name)
+	if !ok {
+		return fmt.Errorf("user %q not found", name)
+	}
+	r.users.RemoveUser(name)
+	if err := r.saveLocked(); err != nil {
+		r.users.SetUser(name, old)
+		return fmt.Errorf("persist config: %w", err)
+	}
+	return nil
+}
+
+// MigratePasswords hashes any plaintext passwords and persists the config if
+// there were some. It returns how many passwords were hashed.
+func (r *Registry) MigratePasswords() (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.users == nil {
+		return 0, nil
+	}
+	n := 0
+	for name, cfg := range r.users.Snapshot() {
+		if access.IsHash(cfg.Password) {
+			continue
+		}
+		hash, err := access.HashPassword(cfg.Password)
+		if err != nil {
+			return n, err
+		}
+		cfg.Password = hash
+		if err := r.users.SetUser(name, cfg); err != nil {
+			return n, err
+		}
+		n++
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	if err := r.saveLocked(); err != nil {
+		return n, fmt.Errorf("persist config: %w", err)
+	}
+	return n, nil
+}
+
+// userConfig applies u to cfg, validating the role and scope and hashing a
+// new password.
+func userConfig(cfg models.UserConfig, u UserUpdate) (models.UserConfig, error) {
+	if u.Role != nil {
+		if *u.Role != "" && !access.ValidRole(*u.Role) {
+			return cfg, fmt.Errorf("invalid role %q: must be %s, %s or %s", *u.Role, access.RoleViewer, access.RoleOperator, access.RoleAdmin)
+		}
+		cfg.Role = *u.Role
+	}
+	var err error
+	if u.Scooters != nil {
+		if cfg.Scooters, err = NormalizeLabels(*u.Scooters); err != nil {
+			return cfg, err
+		}
+	}
+	if u.Groups != nil {
+		if cfg.Groups, err = NormalizeLabels(*u.Groups); err != nil {
+			return cfg, err
+		}
+	}
+	if u.Password != nil {
+		if len(*u.Password) < access.MinPasswordLen {
+			return cfg, fmt.Errorf("password must be at least %d characters", access.MinPasswordLen)
+		}
+		if cfg.Password, err = access.HashPassword(*u.Password); err != nil {
+			return cfg, err
+		}
+	}
+	return cfg, nil
+}
@@ -0,0 +1,359 @@
+// Package scheduler runs one-shot and recurring command jobs stored in the
+// database. When a job fires, its targets are resolved to scooters, the
+// command is sent to each online scooter (or queued for offline ones when the
+// job allows it), and every outcome is recorded as a run linked to the
+// command's request ID.
+package scheduler
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/librescoot/uplink-server/internal/alerts"
+	"github.com/librescoot/uplink-server/internal/commands"
+	"github.com/librescoot/uplink-server/internal/storage"
+	"github.com/librescoot/uplink-server/internal/store"
+)
+
+// pollInterval is how often due jobs are looked up.
+const pollInterval = 5 * time.Second
+
+// defaultQueueTTL applies to queued commands of jobs without a ttl.
+const defaultQueueTTL = time.Hour
+
+// Sender delivers commands; it is implemented by handlers.WebSocketHandler.
+type Sender interface {
+	DispatchCommand(identifier, command string, params map[string]any, queue bool, ttl time.Duration) (requestID string, queued bool, err error)
+}
+
+// GroupResolver returns the groups (or tags) a scooter carries.
+type GroupResolver func(scooterID string) []string
+
+// Resolver turns a job's targets into scooter identifiers.
+type Resolver struct {
+	Fleet  func() []string     // every registered scooter
+	States *storage.StateStore // for conditions; may be nil
+	Groups GroupResolver       // may be nil, in which case groups match nothing
+	Tags   GroupResolver       // likewise for tags
+}
+
+// CheckTargets rejects a target that selects nothing: the whole fleet must
+// be asked for explicitly with all, never implied by empty lists.
+func CheckTargets(all bool, scooters, groups, tags []string) error {
+	listed := len(scooters) > 0 || len(groups) > 0 || len(tags) > 0
+	switch {
+	case all && listed:
+		return errors.New("set either all or scooters, groups and tags, not both")
+	case !all && !listed:
+		return errors.New("scooters, groups, tags or all is required")
+	}
+	return nil
+}
+
+// Resolve returns the scooters a target selects, sorted: the whole fleet if
+// all is set, otherwise the listed scooters plus members of the listed groups
+// and those carrying any listed tag, narrowed to those whose current state
+// matches condition (if any). See CheckTargets.
+func (r *Resolver) Resolve(all bool, scooters, groups, tags []string, condition string) ([]string, error) {
+	if err := CheckTargets(all, scooters, groups, tags); err != nil {
+		return nil, err
+	}
+	var cond *alerts.Condition
+	if condition != "" {
+		c, err := alerts.ParseCondition(condition)
+		if err != nil {
+			return nil, err
+		}
+		cond = &c
+	}
+
+	selected := make(map[string]bool)
+	for _, id := range scooters {
+		selected[id] = true
+	}
+	if all || len(groups) > 0 || len(tags) > 0 {
+		wantGroups, wantTags := stringSet(groups), stringSet(tags)
+		for _, id := range r.Fleet() {
+			if all || inAny(r.Groups, id, wantGroups) || inAny(r.Tags, id, wantTags) {
+				selected[id] = true
+			}
+		}
+	}
+
+	out := make([]string, 0, len(selected))
+	for id := range selected {
+		if cond != nil && !r.matches(id, *cond) {
+			continue
+		}
+		out = append(out, id)
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+func stringSet(list []string) map[string]bool {
+	out := make(map[string]bool, len(list))
+	for _, s := range list {
+		out[s] = true
+	}
+	return out
+}
+
+func inAny(labels GroupResolver, scooterID string, want map[string]bool) bool {
+	if labels == nil || len(want) == 0 {
+		return false
+	}
+	for _, l := range labels(scooterID) {
+		if want[l] {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Resolver) matches(scooterID string, cond alerts.Condition) bool {
+	if r.States == nil {
+		return false
+	}
+	v, ok := r.States.Value(scooterID, cond.Path())
+	if !ok {
+		return false
+	}
+	match, _ := cond.Match(v)
+	return match
+}
+
+// Scheduler fires due jobs.
+type Scheduler struct {
+	db       *store.Store
+	sender   Sender
+	catalog  *commands.Catalog
+	resolver *Resolver
+	now      func() time.Time
+
+	mu      sync.Mutex // serialises firing so a slow run is not started twice
+	stopCh  chan struct{}
+	stopped sync.Once
+}
+
+// New creates a scheduler. Call Start to begin firing jobs.
+func New(db *store.Store, sender Sender, catalog *commands.Catalog, resolver *Resolver) *Scheduler {
+	return &Scheduler{
+		db:       db,
+		sender:   sender,
+		catalog:  catalog,
+		resolver: resolver,
+		now:      time.Now,
+		stopCh:   make(chan struct{}),
+	}
+}
+
+// Start fires due jobs in the background until Stop is called.
+func (s *Scheduler) Start() {
+	go func() {
+		ticker := time.NewTicker(pollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.RunDue()
+			case <-s.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the background loop.
+func (s *Scheduler) Stop() {
+	s.stopped.Do(func() { close(s.stopCh) })
+}
+
+// Prepare validates a job and sets its next run. Enabled one-shot jobs in the
+// past are rejected; a disabled job has no next run.
+func (s *Scheduler) Prepare(sc *store.Schedule) error {
+	if sc.Name == "" {
+		return errors.New("name is required")
+	}
+	if err := s.catalog.Validate(sc.Command, sc.Params); err != nil {
+		return err
+	}
+	if err := CheckTargets(sc.All, sc.Scooters, sc.Groups, sc.Tags); err != nil {
+		return err
+	}
+	if sc.Queue && !s.catalog.Queueable(sc.Command) {
+		return fmt.Errorf("%s may not be queued for offline scooters", sc.Command)
+	}
+	if sc.TTL != "" {
+		if d, err := time.ParseDuration(sc.TTL); err != nil || d <= 0 {
+			return fmt.Errorf("invalid ttl %q", sc.TTL)
+		}
+	}
+	if sc.Condition != "" {
+		if _, err := alerts.ParseCondition(sc.Condition); err != nil {
+			return err
+		}
+	}
+	if _, err := loadLocation(sc.Timezone); err != nil {
+		return err
+	}
+
+	sc.NextRun = nil
+	switch {
+	case sc.At != nil && sc.Cron != "":
+		return errors.New("set either at or cron, not both")
+	case sc.At != nil:
+		if sc.Enabled {
+			if !sc.At.After(s.now()) {
+				return errors.New("at is in the past")
+			}
+			at := sc.At.UTC().Truncate(time.Millisecond)
+			sc.NextRun = &at
+		}
+	case sc.Cron != "":
+		next, err := s.nextRun(sc, s.now())
+		if err != nil {
+			return err
+		}
+		if next == nil {
+			return errors.New("cron expression never matches")
+		}
+		if sc.Enabled {
+			sc.NextRun = next
+		}
+	default:
+		return errors.New("at or cron is required")
+	}
+	return nil
+}
+
+// nextRun returns the first cron match after t, or nil for one-shot jobs.
+func (s *Scheduler) nextRun(sc *store.Schedule, t time.Time) (*time.Time, error) {
+	if sc.Cron == "" {
+		return nil, nil
+	}
+	c, err := ParseCron(sc.Cron)
+	if err != nil {
+		return nil, err
+	}
+	loc, err := loadLocation(sc.Timezone)
+	if err != nil {
+		return nil, err
+	}
+	next := c.Next(t.In(loc))
+	if next.IsZero() {
+		return nil, nil
+	}
+	next = next.UTC().Truncate(time.Millisecond)
+	return &next, nil
+}
+
+// RunDue fires every job that is due now. Runs missed while the server was
+// down collapse into a single run.
+func (s *Scheduler) RunDue() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	due, err := s.db.DueSchedules(now)
+	if err != nil {
+		log.Printf("[Scheduler] Failed to load due schedules: %v", err)
+		return
+	}
+	for i := range due {
+		s.fire(&due[i], now)
+	}
+}
+
+// fire claims one due job, moves it to its next run and runs it.
+func (s *Scheduler) fire(sc *store.Schedule, now time.Time) {
+	next, nextErr := s.nextRun(sc, now)
+	ok, err := s.db.ClaimScheduleRun(sc.ID, *sc.NextRun, next, now)
+	if err != nil {
+		log.Printf("[Scheduler] Failed to claim schedule %d: %v", sc.ID, err)
+		return
+	}
+	if !ok {
+		return // run elsewhere, or changed since it was loaded
+	}
+	switch {
+	case nextErr != nil:
+		log.Printf("[Scheduler] Schedule %d (%s) is invalid, disabled: %v", sc.ID, sc.Name, nextErr)
+	case next == nil && sc.Cron != "":
+		log.Printf("[Scheduler] Schedule %d (%s) has no further runs, disabled", sc.ID, sc.Name)
+	}
+	s.run(sc, now)
+}
+
+// run sends the job's command to each target and records the outcomes.
+func (s *Scheduler) run(sc *store.Schedule, now time.Time) {
+	targets, err := s.resolver.Resolve(sc.All, sc.Scooters, sc.Groups, sc.Tags, sc.Condition)
+	if err != nil {
+		log.Printf("[Scheduler] Schedule %d (%s): %v", sc.ID, sc.Name, err)
+		return
+	}
+	ttl := defaultQueueTTL
+	if d, err := time.ParseDuration(sc.TTL); err == nil && d > 0 {
+		ttl = d
+	}
+
+	counts := make(map[string]int)
+	for _, id := range targets {
+		run := store.ScheduleRun{ScheduleID: sc.ID, ScooterID: id, RanAt: now}
+		run.RequestID, run.Status, run.Error = s.deliver(id, sc, ttl)
+		counts[run.Status]++
+		if err := s.db.InsertScheduleRun(&run); err != nil {
+			log.Printf("[Scheduler] Failed to record run of schedule %d for %s: %v", sc.ID, id, err)
+		}
+		s.audit(sc, &run)
+	}
+	log.Printf("[Scheduler] Ran schedule %d (%s): %s to %d scooters (%d sent, %d queued, %d failed)",
+		sc.ID, sc.Name, sc.Command, len(targets), counts[store.RunSent], counts[store.RunQueued], counts[store.RunFailed])
+}
+
+// audit records a run's command in the audit log as sent by the schedule on
+// behalf of its creator.
+func (s *Scheduler) audit(sc *store.Schedule, run *store.ScheduleRun) {
+	outcome := store.AuditSuccess
+	if run.Status == store.RunFailed {
+		outcome = store.AuditFailure
+	}
+	params := map[string]any{
+		"command":    sc.Command,
+		"params":     sc.Params,
+		"queue":      sc.Queue,
+		"request_id": run.RequestID,
+		"created_by": sc.CreatedBy,
+	}
+	if err := s.db.AuditCommand(fmt.Sprintf("schedule:%d", sc.ID), run.ScooterID, outcome, params, run.Error); err != nil {
+		log.Printf("[Scheduler] Failed to audit run of schedule %d for %s: %v", sc.ID, run.ScooterID, err)
+	}
+}
+
+// deliver sends the command to one scooter, queueing it when the scooter is
+// offline and the job allows it.
+func (s *Scheduler) deliver(scooterID string, sc *store.Schedule, ttl time.Duration) (requestID, status, errMsg string) {
+	requestID, queued, err := s.sender.DispatchCommand(scooterID, sc.Command, sc.Params, sc.Queue, ttl)
+	switch {
+	case err != nil:
+		return "", store.RunFailed, err.Error()
+	case queued:
+		return requestID, store.RunQueued, ""
+	}
+	return requestID, store.RunSent, ""
+}
+
+func loadLocation(name string) (*time.Location, error) {
+	if name == "" {
+		return time.Local, nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown timezone %q", name)
+	}
+	return loc, nil
+}
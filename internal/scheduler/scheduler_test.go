@@ -0,0 +1,240 @@
+package scheduler
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/librescoot/uplink-server/internal/commands"
+	"github.com/librescoot/uplink-server/internal/storage"
+	"github.com/librescoot/uplink-server/internal/store"
+)
+
+func TestCronNext(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("no tzdata")
+	}
+	from := time.Date(2025, 3, 28, 10, 17, 30, 0, time.UTC) // a Friday
+	cases := []struct {
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{"*/15 * * * *", from, time.Date(2025, 3, 28, 10, 30, 0, 0, time.UTC)},
+		{"0 2 * * *", from, time.Date(2025, 3, 29, 2, 0, 0, 0, time.UTC)},
+		{"0 9 * * 1-5", from, time.Date(2025, 3, 31, 9, 0, 0, 0, time.UTC)},
+		{"0 0 1 * *", from, time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC)},
+		{"30 6 15 * 7", from, time.Date(2025, 3, 30, 6, 30, 0, 0, time.UTC)}, // day of month or Sunday
+		{"@yearly", from, time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"@every 90m", from, time.Date(2025, 3, 28, 11, 47, 30, 0, time.UTC)},
+		// 02:30 does not exist on the night DST starts in Berlin.
+		{"30 2 * * *", time.Date(2025, 3, 29, 12, 0, 0, 0, berlin), time.Date(2025, 3, 31, 2, 30, 0, 0, berlin)},
+	}
+	for _, tc := range cases {
+		c, err := ParseCron(tc.expr)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.expr, err)
+		}
+		if got := c.Next(tc.from); !got.Equal(tc.want) {
+			t.Errorf("%s: next = %v, want %v", tc.expr, got, tc.want)
+		}
+	}
+
+	c, _ := ParseCron("0 0 30 2 *")
+	if got := c.Next(from); !got.IsZero() {
+		t.Errorf("Feb 30 matched %v", got)
+	}
+
+	for _, bad := range []string{"", "* * * *", "60 * * * *", "* * * 13 *", "5-1 * * * *", "*/0 * * * *", "@every 10s", "@often"} {
+		if _, err := ParseCron(bad); err == nil {
+			t.Errorf("%q: expected error", bad)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	states := storage.NewStateStore("")
+	states.UpdateState("A", map[string]any{"vehicle": map[string]any{"state": "parked"}})
+	states.UpdateState("B", map[string]any{"vehicle": map[string]any{"state": "ready-to-drive"}})
+	states.UpdateState("C", map[string]any{"vehicle": map[string]any{"state": "parked"}})
+	r := &Resolver{
+		Fleet:  func() []string { return []string{"C", "B", "A"} },
+		States: states,
+		Groups: func(id string) []string {
+			if id == "B" || id == "C" {
+				return []string{"depot"}
+			}
+			return nil
+		},
+		Tags: func(id string) []string {
+			if id == "A" {
+				return []string{"rev3"}
+			}
+			return nil
+		},
+	}
+
+	cases := []struct {
+		all                    bool
+		scooters, groups, tags []string
+		condition              string
+		want                   []string
+	}{
+		{true, nil, nil, nil, "", []string{"A", "B", "C"}},
+		{false, []string{"A"}, nil, nil, "", []string{"A"}},
+		{false, []string{"A"}, []string{"depot"}, nil, "", []string{"A", "B", "C"}},
+		{false, nil, []string{"depot"}, nil, "vehicle.state == parked", []string{"C"}},
+		{false, nil, nil, []string{"rev3"}, "", []string{"A"}},
+		{false, []string{"B"}, nil, []string{"rev3", "rev4"}, "", []string{"A", "B"}},
+		{false, nil, []string{"nowhere"}, nil, "", []string{}},
+		{true, nil, nil, nil, "vehicle.state != parked", []string{"B"}},
+		{false, []string{"X"}, nil, nil, "vehicle.state == parked", []string{}},
+	}
+	for i, tc := range cases {
+		got, err := r.Resolve(tc.all, tc.scooters, tc.groups, tc.tags, tc.condition)
+		if err != nil {
+			t.Fatalf("case %d: %v", i, err)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("case %d: got %v, want %v", i, got, tc.want)
+		}
+	}
+	if _, err := r.Resolve(false, nil, nil, nil, "vehicle.state == parked"); err == nil {
+		t.Error("empty target resolved")
+	}
+	if _, err := r.Resolve(true, []string{"A"}, nil, nil, ""); err == nil {
+		t.Error("all combined with scooters accepted")
+	}
+	if _, err := r.Resolve(true, nil, nil, nil, "vehicle.state ~ parked"); err == nil {
+		t.Error("bad condition accepted")
+	}
+}
+
+// fakeSender delivers to online scooters and queues for the rest.
+type fakeSender struct {
+	online map[string]bool
+	sent   []string
+}
+
+func (f *fakeSender) DispatchCommand(id, command string, params map[string]any, queue bool, ttl time.Duration) (string, bool, error) {
+	f.sent = append(f.sent, id+":"+command)
+	switch {
+	case f.online[id]:
+		return "req-" + id, false, nil
+	case queue:
+		return "queued-" + id, true, nil
+	}
+	return "", false, errors.New("scooter not connected")
+}
+
+func newTestScheduler(t *testing.T, now time.Time) (*Scheduler, *store.Store, *fakeSender) {
+	t.Helper()
+	db, err := store.Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	sender := &fakeSender{online: map[string]bool{"A": true}}
+	s := New(db, sender, commands.Default(), &Resolver{Fleet: func() []string { return []string{"A", "B"} }})
+	s.now = func() time.Time { return now }
+	return s, db, sender
+}
+
+func TestPrepare(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	s, _, _ := newTestScheduler(t, now)
+	past, future := now.Add(-time.Hour), now.Add(time.Hour)
+
+	bad := []store.Schedule{
+		{Command: "lock", All: true, Cron: "@daily", Enabled: true},
+		{Name: "x", Command: "lokc", All: true, Cron: "@daily", Enabled: true},
+		{Name: "x", Command: "honk", All: true, Params: map[string]any{"duration": "long"}, Cron: "@daily", Enabled: true},
+		{Name: "x", Command: "unlock", All: true, Queue: true, Cron: "@daily", Enabled: true},
+		{Name: "x", Command: "lock", All: true, TTL: "soon", Cron: "@daily", Enabled: true},
+		{Name: "x", Command: "lock", All: true, Condition: "speed fast", Cron: "@daily", Enabled: true},
+		{Name: "x", Command: "lock", All: true, Timezone: "Mars/Olympus", Cron: "@daily", Enabled: true},
+		{Name: "x", Command: "lock", All: true, Enabled: true},
+		{Name: "x", Command: "lock", All: true, At: &future, Cron: "@daily", Enabled: true},
+		{Name: "x", Command: "lock", All: true, At: &past, Enabled: true},
+		{Name: "x", Command: "lock", All: true, Cron: "0 0 30 2 *", Enabled: true},
+		{Name: "x", Command: "lock", Cron: "@daily", Enabled: true},
+		{Name: "x", Command: "lock", All: true, Scooters: []string{"A"}, Cron: "@daily", Enabled: true},
+	}
+	for i, sc := range bad {
+		if err := s.Prepare(&sc); err == nil {
+			t.Errorf("case %d: expected error", i)
+		}
+	}
+
+	sc := store.Schedule{Name: "x", Command: "lock", All: true, Cron: "0 22 * * *", Timezone: "UTC", Enabled: true}
+	if err := s.Prepare(&sc); err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2025, 6, 1, 22, 0, 0, 0, time.UTC); sc.NextRun == nil || !sc.NextRun.Equal(want) {
+		t.Errorf("next run = %v, want %v", sc.NextRun, want)
+	}
+	sc.Enabled = false
+	if err := s.Prepare(&sc); err != nil || sc.NextRun != nil {
+		t.Errorf("disabled job: next run %v, %v", sc.NextRun, err)
+	}
+}
+
+func TestRunDue(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	s, db, sender := newTestScheduler(t, now)
+
+	at := now.Add(time.Minute)
+	once := store.Schedule{Name: "once", Command: "lock", All: true, At: &at, Queue: true, Enabled: true}
+	daily := store.Schedule{Name: "daily", Command: "ping", Scooters: []string{"A", "B"}, Cron: "0 12 * * *", Timezone: "UTC", Enabled: true}
+	for _, sc := range []*store.Schedule{&once, &daily} {
+		if err := s.Prepare(sc); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.CreateSchedule(sc); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s.RunDue()
+	if len(sender.sent) != 0 {
+		t.Fatalf("nothing is due yet, sent %v", sender.sent)
+	}
+
+	// Both jobs fire; a day's worth of missed daily runs collapse into one.
+	s.now = func() time.Time { return now.Add(36 * time.Hour) }
+	s.RunDue()
+	s.RunDue()
+	if len(sender.sent) != 4 {
+		t.Fatalf("sent = %v", sender.sent)
+	}
+
+	runs, _ := db.ScheduleRuns(once.ID, 10)
+	status := map[string]string{}
+	for _, r := range runs {
+		status[r.ScooterID] = r.Status + " " + r.RequestID
+	}
+	if status["A"] != "sent req-A" || status["B"] != "queued queued-B" {
+		t.Errorf("one-shot runs = %v", status)
+	}
+	entries, _ := db.QueryAudit(store.AuditFilter{Actor: fmt.Sprintf("schedule:%d", once.ID), ScooterID: "B"})
+	if len(entries) != 1 || entries[0].Action != "command.send" || entries[0].Params["request_id"] != "queued-B" {
+		t.Errorf("audit = %+v", entries)
+	}
+	got, _, _ := db.GetSchedule(once.ID)
+	if got.Enabled || got.NextRun != nil || got.LastRun == nil {
+		t.Errorf("one-shot after run = %+v", got)
+	}
+
+	runs, _ = db.ScheduleRuns(daily.ID, 10)
+	if len(runs) != 2 || runs[0].Status == runs[1].Status {
+		t.Errorf("daily runs = %+v", runs)
+	}
+	got, _, _ = db.GetSchedule(daily.ID)
+	if want := time.Date(2025, 6, 3, 12, 0, 0, 0, time.UTC); !got.Enabled || got.NextRun == nil || !got.NextRun.Equal(want) {
+		t.Errorf("daily next run = %v, want %v", got.NextRun, want)
+	}
+}
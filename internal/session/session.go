@@ -1,84 +1,332 @@
-// Package session provides short-lived bearer tokens issued after a successful
+// Package session provides bearer tokens issued after a successful
 // username/password login. Tokens are accepted anywhere the API key is.
+// A session expires after an idle timeout, renewed by every use, and an
+// absolute timeout after the login. With a store, sessions are persisted
+// (only a hash of the token) and survive restarts.
 package session
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
+	"log"
+	"sort"
 	"sync"
 	"time"
+
+	"github.com/librescoot/uplink-server/internal/store"
 )
 
-// Store holds active sessions in memory with a fixed TTL.
-type Store struct {
-	mu       sync.RWMutex
-	sessions map[string]entry
-	ttl      time.Duration
+// touchInterval is how often a session's last use is written to the store.
+const touchInterval = time.Minute
+
+// Config sets when sessions expire; a zero timeout is no limit.
+type Config struct {
+	IdleTimeout     time.Duration // since the last request
+	AbsoluteTimeout time.Duration // since the login
+}
+
+// Session describes a login session. The token itself is never kept.
+type Session struct {
+	ID        string     `json:"id"`
+	Username  string     `json:"username"`
+	UserAgent string     `json:"user_agent,omitempty"`
+	SourceIP  string     `json:"source_ip,omitempty"`
+	Created   time.Time  `json:"created"`
+	LastSeen  time.Time  `json:"last_seen"`
+	Expires   *time.Time `json:"expires,omitempty"` // nil if the session never expires
 }
 
 type entry struct {
-	username string
-	expires  time.Time
+	Session
+	persisted time.Time // last use as written to the store
 }
 
-// New creates a session store with the given TTL and starts a background
-// cleanup goroutine.
-func New(ttl time.Duration) *Store {
-	if ttl <= 0 {
-		ttl = 24 * time.Hour
+// Store holds active sessions by token hash, backed by the database if one
+// is given.
+type Store struct {
+	cfg Config
+	db  *store.Store // may be nil
+
+	mu       sync.Mutex
+	sessions map[string]*entry
+}
+
+// New creates a session store, loads the unexpired persisted sessions and
+// starts a background cleanup goroutine. db may be nil to keep sessions in
+// memory only.
+func New(cfg Config, db *store.Store) (*Store, error) {
+	s := &Store{cfg: cfg, db: db, sessions: make(map[string]*entry)}
+	if db != nil {
+		list, err := db.ListSessions()
+		if err != nil {
+			return nil, err
+		}
+		now := time.Now()
+		var stale []string
+		for _, p := range list {
+			e := &entry{
+				Session: Session{
+					ID:        p.ID,
+					Username:  p.Username,
+					UserAgent: p.UserAgent,
+					SourceIP:  p.SourceIP,
+					Created:   p.Created,
+					LastSeen:  p.LastSeen,
+				},
+				persisted: p.LastSeen,
+			}
+			if s.expired(e, now) {
+				stale = append(stale, p.ID)
+				continue
+			}
+			s.sessions[p.TokenHash] = e
+		}
+		if err := db.DeleteSessions(stale...); err != nil {
+			return nil, err
+		}
 	}
-	s := &Store{sessions: make(map[string]entry), ttl: ttl}
 	go s.cleanup()
-	return s
+	return s, nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
 }
 
-// Create issues a new token for username and returns it along with its TTL.
-func (s *Store) Create(username string) (string, time.Duration, error) {
-	b := make([]byte, 32)
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
 	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// expiry returns when a session expires, or the zero time if never.
+func (s *Store) expiry(e *entry) time.Time {
+	var t time.Time
+	if s.cfg.IdleTimeout > 0 {
+		t = e.LastSeen.Add(s.cfg.IdleTimeout)
+	}
+	if s.cfg.AbsoluteTimeout > 0 {
+		if abs := e.Created.Add(s.cfg.AbsoluteTimeout); t.IsZero() || abs.Before(t) {
+			t = abs
+		}
+	}
+	return t
+}
+
+func (s *Store) expired(e *entry, now time.Time) bool {
+	t := s.expiry(e)
+	return !t.IsZero() && !now.Before(t)
+}
+
+// info returns the API view of a session.
+func (s *Store) info(e *entry) Session {
+	out := e.Session
+	if t := s.expiry(e); !t.IsZero() {
+		out.Expires = &t
+	}
+	return out
+}
+
+// Create starts a session for username and returns its token and how long
+// it lasts without use (zero if it never expires).
+func (s *Store) Create(username, userAgent, sourceIP string) (string, time.Duration, error) {
+	token, err := randomHex(32)
+	if err != nil {
+		return "", 0, err
+	}
+	id, err := randomHex(8)
+	if err != nil {
 		return "", 0, err
 	}
-	token := hex.EncodeToString(b)
+	now := time.Now()
+	e := &entry{
+		Session: Session{
+			ID:        id,
+			Username:  username,
+			UserAgent: userAgent,
+			SourceIP:  sourceIP,
+			Created:   now,
+			LastSeen:  now,
+		},
+		persisted: now,
+	}
+	hash := hashToken(token)
+	if s.db != nil {
+		err := s.db.InsertSession(&store.Session{
+			ID:        id,
+			TokenHash: hash,
+			Username:  username,
+			UserAgent: userAgent,
+			SourceIP:  sourceIP,
+			Created:   now,
+			LastSeen:  now,
+		})
+		if err != nil {
+			return "", 0, err
+		}
+	}
 
 	s.mu.Lock()
-	s.sessions[token] = entry{username: username, expires: time.Now().Add(s.ttl)}
+	s.sessions[hash] = e
 	s.mu.Unlock()
 
-	return token, s.ttl, nil
+	var ttl time.Duration
+	if t := s.expiry(e); !t.IsZero() {
+		ttl = t.Sub(now)
+	}
+	return token, ttl, nil
 }
 
-// Validate returns the username and true if the token is valid and unexpired.
+// Validate returns the username and true if the token is valid and
+// unexpired, and renews the session's idle timeout.
 func (s *Store) Validate(token string) (string, bool) {
 	if token == "" {
 		return "", false
 	}
-	s.mu.RLock()
-	e, ok := s.sessions[token]
-	s.mu.RUnlock()
-	if !ok || time.Now().After(e.expires) {
+	hash := hashToken(token)
+	now := time.Now()
+
+	s.mu.Lock()
+	e, ok := s.sessions[hash]
+	if !ok {
+		s.mu.Unlock()
+		return "", false
+	}
+	if s.expired(e, now) {
+		delete(s.sessions, hash)
+		s.mu.Unlock()
+		s.forget(e.ID)
 		return "", false
 	}
-	return e.username, true
+	e.LastSeen = now
+	touch := s.db != nil && now.Sub(e.persisted) >= touchInterval
+	if touch {
+		e.persisted = now
+	}
+	id, username := e.ID, e.Username
+	s.mu.Unlock()
+
+	if touch {
+		if err := s.db.TouchSession(id, now); err != nil {
+			log.Printf("[Session] Failed to persist last use of session %s: %v", id, err)
+		}
+	}
+	return username, true
+}
+
+// Get returns the session of a token without renewing it.
+func (s *Store) Get(token string) (Session, bool) {
+	if token == "" {
+		return Session{}, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.sessions[hashToken(token)]
+	if !ok || s.expired(e, time.Now()) {
+		return Session{}, false
+	}
+	return s.info(e), true
+}
+
+// Lookup returns the active session with the given ID.
+func (s *Store) Lookup(id string) (Session, bool) {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, e := range s.sessions {
+		if e.ID == id && !s.expired(e, now) {
+			return s.info(e), true
+		}
+	}
+	return Session{}, false
 }
 
 // Delete invalidates a token (logout).
 func (s *Store) Delete(token string) {
+	hash := hashToken(token)
 	s.mu.Lock()
-	delete(s.sessions, token)
+	e, ok := s.sessions[hash]
+	delete(s.sessions, hash)
 	s.mu.Unlock()
+	if ok {
+		s.forget(e.ID)
+	}
+}
+
+// List returns the active sessions of username, or of every user if
+// username is empty, most recently used first.
+func (s *Store) List(username string) []Session {
+	now := time.Now()
+	s.mu.Lock()
+	out := []Session{}
+	for _, e := range s.sessions {
+		if (username == "" || e.Username == username) && !s.expired(e, now) {
+			out = append(out, s.info(e))
+		}
+	}
+	s.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].LastSeen.After(out[j].LastSeen) })
+	return out
+}
+
+// Revoke ends the session with the given ID and returns it.
+func (s *Store) Revoke(id string) (Session, bool) {
+	s.mu.Lock()
+	for hash, e := range s.sessions {
+		if e.ID == id {
+			delete(s.sessions, hash)
+			s.mu.Unlock()
+			s.forget(id)
+			return e.Session, true
+		}
+	}
+	s.mu.Unlock()
+	return Session{}, false
+}
+
+// RevokeUser ends every session of username ("log out everywhere") and
+// returns how many there were.
+func (s *Store) RevokeUser(username string) int {
+	var ids []string
+	s.mu.Lock()
+	for hash, e := range s.sessions {
+		if e.Username == username {
+			delete(s.sessions, hash)
+			ids = append(ids, e.ID)
+		}
+	}
+	s.mu.Unlock()
+	s.forget(ids...)
+	return len(ids)
+}
+
+// forget deletes ended sessions from the store.
+func (s *Store) forget(ids ...string) {
+	if s.db == nil || len(ids) == 0 {
+		return
+	}
+	if err := s.db.DeleteSessions(ids...); err != nil {
+		log.Printf("[Session] Failed to delete sessions: %v", err)
+	}
 }
 
 func (s *Store) cleanup() {
 	ticker := time.NewTicker(10 * time.Minute)
 	defer ticker.Stop()
-	for range ticker.C {
-		now := time.Now()
+	for now := range ticker.C {
+		var ids []string
 		s.mu.Lock()
-		for token, e := range s.sessions {
-			if now.After(e.expires) {
-				delete(s.sessions, token)
+		for hash, e := range s.sessions {
+			if s.expired(e, now) {
+				delete(s.sessions, hash)
+				ids = append(ids, e.ID)
 			}
 		}
 		s.mu.Unlock()
+		s.forget(ids...)
 	}
 }
@@ -0,0 +1,105 @@
+package session
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/librescoot/uplink-server/internal/store"
+)
+
+func TestExpiry(t *testing.T) {
+	s, err := New(Config{IdleTimeout: 300 * time.Millisecond, AbsoluteTimeout: 400 * time.Millisecond}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, ttl, err := s.Create("alice", "", "")
+	if err != nil || ttl != 300*time.Millisecond {
+		t.Fatalf("create: ttl %s, %v", ttl, err)
+	}
+	idle, _, _ := s.Create("bob", "", "")
+
+	time.Sleep(200 * time.Millisecond)
+	if name, ok := s.Validate(token); !ok || name != "alice" {
+		t.Fatalf("validate = %q, %t", name, ok)
+	}
+
+	// alice's use renewed her session; bob's idled out.
+	time.Sleep(150 * time.Millisecond)
+	if _, ok := s.Get(token); !ok {
+		t.Error("renewed session expired")
+	}
+	if _, ok := s.Validate(idle); ok {
+		t.Error("idle session still valid")
+	}
+
+	// Renewal never extends past the absolute timeout.
+	time.Sleep(100 * time.Millisecond)
+	if _, ok := s.Validate(token); ok {
+		t.Error("session valid past its absolute timeout")
+	}
+}
+
+func TestPersistence(t *testing.T) {
+	db, err := store.Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	cfg := Config{IdleTimeout: time.Hour}
+	s, err := New(cfg, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, _, err := s.Create("alice", "Firefox", "203.0.113.7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _, _ := s.Create("alice", "curl", "198.51.100.1")
+	bob, _, _ := s.Create("bob", "", "")
+
+	rows, _ := db.ListSessions()
+	for _, row := range rows {
+		if row.TokenHash == token || row.TokenHash == other || row.TokenHash == bob {
+			t.Fatal("token stored in plaintext")
+		}
+	}
+
+	// A restart keeps the sessions.
+	s, err = New(cfg, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name, ok := s.Validate(token); !ok || name != "alice" {
+		t.Fatalf("after restart: %q, %t", name, ok)
+	}
+	list := s.List("alice")
+	if len(list) != 2 || list[0].UserAgent != "Firefox" || list[0].SourceIP != "203.0.113.7" || list[0].Expires == nil {
+		t.Fatalf("list = %+v", list)
+	}
+	if got := s.List(""); len(got) != 3 {
+		t.Errorf("all sessions = %d", len(got))
+	}
+
+	// Revocations are persisted too.
+	current, _ := s.Get(token)
+	if _, ok := s.Revoke(current.ID); !ok {
+		t.Fatal("revoke failed")
+	}
+	if n := s.RevokeUser("alice"); n != 1 {
+		t.Errorf("revoked %d", n)
+	}
+	s, err = New(cfg, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tok := range []string{token, other} {
+		if _, ok := s.Validate(tok); ok {
+			t.Error("revoked session survived a restart")
+		}
+	}
+	if _, ok := s.Validate(bob); !ok {
+		t.Error("bob's session lost")
+	}
+}
@@ -243,6 +243,35 @@ func (ss *StateStore) GetState(scooterID string) (*ScooterState, bool) {
 	return stateCopy, true
 }
 
+// Value returns the leaf at a dotted path ("vehicle.state") of a scooter's
+// state. Unlike reading a GetState copy, it is safe against concurrent
+// merges into nested components.
+func (ss *StateStore) Value(scooterID, path string) (any, bool) {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+
+	state, exists := ss.states[scooterID]
+	if !exists {
+		return nil, false
+	}
+	return Lookup(state.State, path)
+}
+
+// Lookup returns the leaf at a dotted path in a nested state map.
+func Lookup(state map[string]any, path string) (any, bool) {
+	var cur any = state
+	for _, key := range strings.Split(path, ".") {
+		m, ok := cur.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if cur, ok = m[key]; !ok {
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
 // GetAllStates retrieves all scooter states
 func (ss *StateStore) GetAllStates() map[string]*ScooterState {
 	ss.mu.RLock()
@@ -0,0 +1,177 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Alert statuses.
+const (
+	AlertFiring   = "firing"
+	AlertResolved = "resolved"
+)
+
+// Alert is one firing (or since resolved) occurrence of an alert rule for a
+// scooter.
+type Alert struct {
+	ID         int64      `json:"id"`
+	Rule       string     `json:"rule"`
+	ScooterID  string     `json:"scooter_id"`
+	Severity   string     `json:"severity"`
+	Status     string     `json:"status"`
+	Message    string     `json:"message,omitempty"`
+	Value      string     `json:"value,omitempty"`
+	FiredAt    time.Time  `json:"fired_at"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+	AckedAt    *time.Time `json:"acked_at,omitempty"`
+	AckedBy    string     `json:"acked_by,omitempty"`
+}
+
+// AlertRuleRecord is an API-defined alert rule as stored: its name and JSON
+// definition.
+type AlertRuleRecord struct {
+	Name       string
+	Definition string
+}
+
+const alertColumns = `id, rule, scooter_id, severity, status, message, value, fired_at, resolved_at, acked_at, acked_by`
+
+// InsertAlert records a newly firing alert and sets its ID.
+func (s *Store) InsertAlert(a *Alert) error {
+	return s.queryRow(
+		`INSERT INTO alerts(rule, scooter_id, severity, status, message, value, fired_at)
+		 VALUES(?,?,?,?,?,?,?) RETURNING id`,
+		a.Rule, a.ScooterID, a.Severity, a.Status, a.Message, a.Value, a.FiredAt.UnixMilli(),
+	).Scan(&a.ID)
+}
+
+// ResolveAlert marks a firing alert resolved at ts.
+func (s *Store) ResolveAlert(id int64, ts time.Time) error {
+	_, err := s.exec(
+		`UPDATE alerts SET status=?, resolved_at=? WHERE id=? AND status=?`,
+		AlertResolved, ts.UnixMilli(), id, AlertFiring,
+	)
+	return err
+}
+
+// AckAlert acknowledges an alert on behalf of by. It returns false if no such
+// alert exists; acknowledging twice keeps the first acknowledgement.
+func (s *Store) AckAlert(id int64, by string, ts time.Time) (*Alert, bool, error) {
+	if _, err := s.exec(
+		`UPDATE alerts SET acked_at=?, acked_by=? WHERE id=? AND acked_at IS NULL`,
+		ts.UnixMilli(), by, id,
+	); err != nil {
+		return nil, false, err
+	}
+	return s.GetAlert(id)
+}
+
+// GetAlert returns a single alert.
+func (s *Store) GetAlert(id int64) (*Alert, bool, error) {
+	alerts, err := s.queryAlerts(`SELECT `+alertColumns+` FROM alerts WHERE id=?`, id)
+	if err != nil || len(alerts) == 0 {
+		return nil, false, err
+	}
+	return &alerts[0], true, nil
+}
+
+// FiringAlerts returns every alert currently firing.
+func (s *Store) FiringAlerts() ([]Alert, error) {
+	return s.queryAlerts(`SELECT `+alertColumns+` FROM alerts WHERE status=? ORDER BY id`, AlertFiring)
+}
+
+// ListAlerts returns alerts newest first, optionally filtered by status and
+// scooter ("" matches any), up to limit rows.
+func (s *Store) ListAlerts(status, scooterID string, limit int) ([]Alert, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+	query := `SELECT ` + alertColumns + ` FROM alerts WHERE 1=1`
+	var args []any
+	if status != "" {
+		query += ` AND status=?`
+		args = append(args, status)
+	}
+	if scooterID != "" {
+		query += ` AND scooter_id=?`
+		args = append(args, scooterID)
+	}
+	query += ` ORDER BY fired_at DESC, id DESC LIMIT ?`
+	return s.queryAlerts(query, append(args, limit)...)
+}
+
+// AlertRules returns the API-defined alert rules.
+func (s *Store) AlertRules() ([]AlertRuleRecord, error) {
+	rows, err := s.query(`SELECT name, definition FROM alert_rules ORDER BY created_at, name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []AlertRuleRecord
+	for rows.Next() {
+		var r AlertRuleRecord
+		if err := rows.Scan(&r.Name, &r.Definition); err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+// SaveAlertRule creates or replaces an API-defined alert rule.
+func (s *Store) SaveAlertRule(name, definition string) error {
+	_, err := s.exec(
+		`INSERT INTO alert_rules(name, definition, created_at) VALUES(?,?,?)
+		 ON CONFLICT(name) DO UPDATE SET definition=excluded.definition`,
+		name, definition, time.Now().UnixMilli(),
+	)
+	return err
+}
+
+// DeleteAlertRule removes an API-defined alert rule. It returns false if no
+// such rule exists.
+func (s *Store) DeleteAlertRule(name string) (bool, error) {
+	res, err := s.exec(`DELETE FROM alert_rules WHERE name=?`, name)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (s *Store) queryAlerts(query string, args ...any) ([]Alert, error) {
+	rows, err := s.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []Alert
+	for rows.Next() {
+		var (
+			a                   Alert
+			firedMs             int64
+			resolvedMs, ackedMs sql.NullInt64
+			message, value, by  sql.NullString
+		)
+		if err := rows.Scan(&a.ID, &a.Rule, &a.ScooterID, &a.Severity, &a.Status, &message, &value,
+			&firedMs, &resolvedMs, &ackedMs, &by); err != nil {
+			return nil, err
+		}
+		a.Message, a.Value, a.AckedBy = message.String, value.String, by.String
+		a.FiredAt = time.UnixMilli(firedMs).UTC()
+		a.ResolvedAt = timePtr(resolvedMs)
+		a.AckedAt = timePtr(ackedMs)
+		out = append(out, a)
+	}
+	return out, rows.Err()
+}
+
+func timePtr(v sql.NullInt64) *time.Time {
+	if !v.Valid {
+		return nil
+	}
+	t := time.UnixMilli(v.Int64).UTC()
+	return &t
+}
@@ -0,0 +1,132 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// Audit outcomes.
+const (
+	AuditSuccess = "success"
+	AuditFailure = "failure"
+	AuditDenied  = "denied"
+)
+
+// AuditEntry records who did what. The audit_log table is append-only: the
+// database refuses updates and deletes.
+type AuditEntry struct {
+	ID        int64          `json:"id"`
+	Time      time.Time      `json:"time"`
+	Actor     string         `json:"actor"` // username, API key name, or the server component that acted
+	Action    string         `json:"action"`
+	ScooterID string         `json:"scooter_id,omitempty"`
+	Params    map[string]any `json:"params,omitempty"`
+	SourceIP  string         `json:"source_ip,omitempty"`
+	Outcome   string         `json:"outcome"`
+	Status    int            `json:"status,omitempty"` // HTTP status of the response
+	Error     string         `json:"error,omitempty"`
+}
+
+// AuditFilter selects audit entries; empty fields match everything.
+type AuditFilter struct {
+	Actor     string
+	Action    string
+	ScooterID string
+	Outcome   string
+	Since     time.Time
+	Until     time.Time
+	Limit     int
+}
+
+// InsertAudit appends an entry to the audit log and sets its ID.
+func (s *Store) InsertAudit(e *AuditEntry) error {
+	var status any
+	if e.Status != 0 {
+		status = e.Status
+	}
+	return s.queryRow(
+		`INSERT INTO audit_log(ts, actor, action, scooter_id, params, source_ip, outcome, status, error)
+		 VALUES(?,?,?,?,?,?,?,?,?) RETURNING id`,
+		e.Time.UnixMilli(), e.Actor, e.Action, nullString(e.ScooterID), marshalMap(e.Params),
+		nullString(e.SourceIP), e.Outcome, status, nullString(e.Error),
+	).Scan(&e.ID)
+}
+
+// AuditCommand records a command sent to a scooter on behalf of actor
+// without an API request: over MQTT, or by a schedule or batch. params are
+// as for command.send; outcome is AuditSuccess, AuditFailure or AuditDenied.
+func (s *Store) AuditCommand(actor, scooterID, outcome string, params map[string]any, errMsg string) error {
+	return s.InsertAudit(&AuditEntry{
+		Time:      time.Now(),
+		Actor:     actor,
+		Action:    "command.send",
+		ScooterID: scooterID,
+		Params:    params,
+		Outcome:   outcome,
+		Error:     errMsg,
+	})
+}
+
+// QueryAudit returns audit entries newest first, up to f.Limit rows
+// (default 100).
+func (s *Store) QueryAudit(f AuditFilter) ([]AuditEntry, error) {
+	if f.Limit <= 0 {
+		f.Limit = 100
+	}
+	query := `SELECT id, ts, actor, action, scooter_id, params, source_ip, outcome, status, error FROM audit_log WHERE 1=1`
+	var args []any
+	if f.Actor != "" {
+		query += ` AND actor=?`
+		args = append(args, f.Actor)
+	}
+	if f.Action != "" {
+		query += ` AND action=?`
+		args = append(args, f.Action)
+	}
+	if f.ScooterID != "" {
+		query += ` AND scooter_id=?`
+		args = append(args, f.ScooterID)
+	}
+	if f.Outcome != "" {
+		query += ` AND outcome=?`
+		args = append(args, f.Outcome)
+	}
+	if !f.Since.IsZero() {
+		query += ` AND ts >= ?`
+		args = append(args, f.Since.UnixMilli())
+	}
+	if !f.Until.IsZero() {
+		query += ` AND ts < ?`
+		args = append(args, f.Until.UnixMilli())
+	}
+	query += ` ORDER BY ts DESC, id DESC LIMIT ?`
+
+	rows, err := s.query(query, append(args, f.Limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []AuditEntry
+	for rows.Next() {
+		var (
+			e                                   AuditEntry
+			ts                                  int64
+			scooterID, params, sourceIP, errMsg sql.NullString
+			status                              sql.NullInt64
+		)
+		if err := rows.Scan(&e.ID, &ts, &e.Actor, &e.Action, &scooterID, &params, &sourceIP,
+			&e.Outcome, &status, &errMsg); err != nil {
+			return nil, err
+		}
+		e.Time = time.UnixMilli(ts).UTC()
+		e.ScooterID, e.SourceIP, e.Error = scooterID.String, sourceIP.String, errMsg.String
+		e.Status = int(status.Int64)
+		if params.Valid {
+			json.Unmarshal([]byte(params.String), &e.Params)
+		}
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
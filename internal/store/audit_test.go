@@ -0,0 +1,62 @@
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAudit(t *testing.T) {
+	s := openTemp(t)
+
+	now := time.Now()
+	for i, e := range []AuditEntry{
+		{Actor: "admin", Action: "login", SourceIP: "203.0.113.7", Outcome: AuditSuccess},
+		{Actor: "admin", Action: "command.send", ScooterID: "S1", Params: map[string]any{"command": "lock"}, Outcome: AuditSuccess, Status: 200},
+		{Actor: "ops-key", Action: "scooter.delete", ScooterID: "S2", Outcome: AuditDenied, Status: 403, Error: "Forbidden"},
+		{Actor: "admin", Action: "command.send", ScooterID: "S2", Outcome: AuditFailure, Status: 404, Error: "Scooter not connected"},
+	} {
+		e.Time = now.Add(time.Duration(i-10) * time.Minute)
+		if err := s.InsertAudit(&e); err != nil || e.ID == 0 {
+			t.Fatalf("insert: %v (id %d)", err, e.ID)
+		}
+	}
+
+	all, err := s.QueryAudit(AuditFilter{})
+	if err != nil || len(all) != 4 {
+		t.Fatalf("all = %d, %v", len(all), err)
+	}
+	if all[0].Error != "Scooter not connected" || all[0].Status != 404 || all[3].Action != "login" || all[3].Status != 0 {
+		t.Errorf("order or fields wrong: %+v", all)
+	}
+	if all[2].Params["command"] != "lock" {
+		t.Errorf("params = %v", all[2].Params)
+	}
+
+	for _, tc := range []struct {
+		filter AuditFilter
+		want   int
+	}{
+		{AuditFilter{Actor: "admin"}, 3},
+		{AuditFilter{Action: "command.send"}, 2},
+		{AuditFilter{ScooterID: "S2", Outcome: AuditDenied}, 1},
+		{AuditFilter{Since: now.Add(-9*time.Minute - time.Second)}, 3},
+		{AuditFilter{Until: now.Add(-9 * time.Minute)}, 1},
+		{AuditFilter{Limit: 1}, 1},
+	} {
+		got, err := s.QueryAudit(tc.filter)
+		if err != nil || len(got) != tc.want {
+			t.Errorf("%+v: %d rows, %v; want %d", tc.filter, len(got), err, tc.want)
+		}
+	}
+
+	// The log is append-only.
+	if _, err := s.exec(`DELETE FROM audit_log`); err == nil {
+		t.Error("delete succeeded")
+	}
+	if _, err := s.exec(`UPDATE audit_log SET actor='someone'`); err == nil {
+		t.Error("update succeeded")
+	}
+	if got, _ := s.QueryAudit(AuditFilter{Actor: "admin"}); len(got) != 3 {
+		t.Errorf("audit log changed: %d admin rows", len(got))
+	}
+}
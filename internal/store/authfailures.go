@@ -0,0 +1,92 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+)
+
+// AuthFailure is one failed web-UI login or scooter authentication.
+type AuthFailure struct {
+	ID         int64     `json:"id"`
+	Kind       string    `json:"kind"` // "login" or "scooter"
+	Identifier string    `json:"identifier,omitempty"`
+	SourceIP   string    `json:"source_ip"`
+	Reason     string    `json:"reason,omitempty"`
+	Time       time.Time `json:"time"`
+}
+
+// AuthFailureFilter selects auth failures; empty fields match everything.
+type AuthFailureFilter struct {
+	Kind       string
+	Identifier string
+	SourceIP   string
+	Since      time.Time
+	Limit      int
+}
+
+// InsertAuthFailure records a failed authentication and sets its ID.
+func (s *Store) InsertAuthFailure(f *AuthFailure) error {
+	return s.queryRow(
+		`INSERT INTO auth_failures(kind, identifier, source_ip, reason, ts) VALUES(?,?,?,?,?) RETURNING id`,
+		f.Kind, nullString(f.Identifier), f.SourceIP, nullString(f.Reason), f.Time.UnixMilli(),
+	).Scan(&f.ID)
+}
+
+// ListAuthFailures returns failures newest first, up to f.Limit rows
+// (default 100).
+func (s *Store) ListAuthFailures(f AuthFailureFilter) ([]AuthFailure, error) {
+	if f.Limit <= 0 {
+		f.Limit = 100
+	}
+	query := `SELECT id, kind, identifier, source_ip, reason, ts FROM auth_failures WHERE 1=1`
+	var args []any
+	if f.Kind != "" {
+		query += ` AND kind=?`
+		args = append(args, f.Kind)
+	}
+	if f.Identifier != "" {
+		query += ` AND identifier=?`
+		args = append(args, f.Identifier)
+	}
+	if f.SourceIP != "" {
+		query += ` AND source_ip=?`
+		args = append(args, f.SourceIP)
+	}
+	if !f.Since.IsZero() {
+		query += ` AND ts >= ?`
+		args = append(args, f.Since.UnixMilli())
+	}
+	query += ` ORDER BY ts DESC, id DESC LIMIT ?`
+
+	rows, err := s.query(query, append(args, f.Limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []AuthFailure
+	for rows.Next() {
+		var (
+			a                  AuthFailure
+			identifier, reason sql.NullString
+			ts                 int64
+		)
+		if err := rows.Scan(&a.ID, &a.Kind, &identifier, &a.SourceIP, &reason, &ts); err != nil {
+			return nil, err
+		}
+		a.Identifier, a.Reason = identifier.String, reason.String
+		a.Time = time.UnixMilli(ts).UTC()
+		out = append(out, a)
+	}
+	return out, rows.Err()
+}
+
+// PruneAuthFailures deletes failures recorded before cutoff and returns how
+// many were removed.
+func (s *Store) PruneAuthFailures(cutoff time.Time) (int64, error) {
+	res, err := s.exec(`DELETE FROM auth_failures WHERE ts < ?`, cutoff.UnixMilli())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,52 @@
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAuthFailures(t *testing.T) {
+	s := openTemp(t)
+
+	now := time.Now()
+	for i, f := range []AuthFailure{
+		{Kind: "login", Identifier: "admin", SourceIP: "203.0.113.7", Reason: "invalid password"},
+		{Kind: "login", Identifier: "admin", SourceIP: "203.0.113.8", Reason: "invalid password"},
+		{Kind: "scooter", Identifier: "S1", SourceIP: "203.0.113.7", Reason: "invalid token"},
+		{Kind: "scooter", SourceIP: "198.51.100.1"},
+	} {
+		f.Time = now.Add(time.Duration(i-10) * time.Minute)
+		if err := s.InsertAuthFailure(&f); err != nil || f.ID == 0 {
+			t.Fatalf("insert: %v (id %d)", err, f.ID)
+		}
+	}
+
+	all, err := s.ListAuthFailures(AuthFailureFilter{})
+	if err != nil || len(all) != 4 {
+		t.Fatalf("all = %d, %v", len(all), err)
+	}
+	if all[0].Kind != "scooter" || all[0].Identifier != "" || all[3].Reason != "invalid password" {
+		t.Errorf("order or fields wrong: %+v", all)
+	}
+
+	for _, tc := range []struct {
+		filter AuthFailureFilter
+		want   int
+	}{
+		{AuthFailureFilter{Kind: "login"}, 2},
+		{AuthFailureFilter{Identifier: "admin", SourceIP: "203.0.113.8"}, 1},
+		{AuthFailureFilter{SourceIP: "203.0.113.7"}, 2},
+		{AuthFailureFilter{Since: now.Add(-8*time.Minute - time.Second)}, 2},
+		{AuthFailureFilter{Limit: 3}, 3},
+	} {
+		got, err := s.ListAuthFailures(tc.filter)
+		if err != nil || len(got) != tc.want {
+			t.Errorf("%+v: %d rows, %v; want %d", tc.filter, len(got), err, tc.want)
+		}
+	}
+
+	n, err := s.PruneAuthFailures(now.Add(-9 * time.Minute))
+	if err != nil || n != 1 {
+		t.Fatalf("pruned %d, %v", n, err)
+	}
+}
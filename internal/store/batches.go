@@ -0,0 +1,265 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// BatchPending is the status of a batch item not dispatched yet. Dispatched
+// items take the status of their command (StatusSent, StatusQueued, ...);
+// items that could not be dispatched are StatusFailed with an error.
+const BatchPending = "pending"
+
+// activeStatuses are the item states in which a batch is still in progress.
+var activeStatuses = []any{BatchPending, StatusQueued, StatusSent, StatusRunning}
+
+// Batch is one command sent to many scooters. Counts holds the number of
+// items per status, following each item's command to its outcome.
+type Batch struct {
+	ID        int64          `json:"id"`
+	Command   string         `json:"command"`
+	Params    map[string]any `json:"params,omitempty"`
+	Scooters  []string       `json:"scooters,omitempty"`
+	Groups    []string       `json:"groups,omitempty"`
+	Tags      []string       `json:"tags,omitempty"`
+	All       bool           `json:"all,omitempty"`
+	Condition string         `json:"condition,omitempty"`
+	Queue     bool           `json:"queue"`
+	TTL       string         `json:"ttl,omitempty"`
+	Total     int            `json:"total"`
+	Counts    map[string]int `json:"counts"`
+	Done      bool           `json:"done"`
+	CreatedBy string         `json:"created_by,omitempty"`
+	CreatedAt time.Time      `json:"created_at"`
+}
+
+// BatchItem is one scooter of a batch.
+type BatchItem struct {
+	ScooterID string `json:"scooter_id"`
+	RequestID string `json:"request_id,omitempty"`
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+}
+
+// CreateBatch inserts b with a pending item per target scooter and sets its
+// ID, total, counts and creation time.
+func (s *Store) CreateBatch(b *Batch, targets []string) error {
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow(s.dialect.rebind(
+		`INSERT INTO command_batches(command, params, target_scooters, target_groups, target_tags, target_all, condition,
+		 queue, ttl, total, created_by, created_at)
+		 VALUES(?,?,?,?,?,?,?,?,?,?,?,?) RETURNING id`),
+		b.Command, marshalMap(b.Params), marshalStrings(b.Scooters), marshalStrings(b.Groups), marshalStrings(b.Tags),
+		boolInt(b.All), nullString(b.Condition),
+		boolInt(b.Queue), nullString(b.TTL), len(targets), nullString(b.CreatedBy), now.UnixMilli(),
+	).Scan(&b.ID)
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare(s.dialect.rebind(`INSERT INTO batch_items(batch_id, scooter_id, status) VALUES(?,?,?)`))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, id := range targets {
+		if _, err := stmt.Exec(b.ID, id, BatchPending); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	b.Total, b.CreatedAt = len(targets), now
+	b.Counts = map[string]int{}
+	if len(targets) > 0 {
+		b.Counts[BatchPending] = len(targets)
+	}
+	b.Done = len(targets) == 0
+	return nil
+}
+
+// SetBatchItem records how a batch item was dispatched.
+func (s *Store) SetBatchItem(batchID int64, scooterID, requestID, status, errMsg string) error {
+	_, err := s.exec(
+		`UPDATE batch_items SET request_id=?, status=?, error=? WHERE batch_id=? AND scooter_id=?`,
+		nullString(requestID), status, nullString(errMsg), batchID, scooterID,
+	)
+	return err
+}
+
+// FailPendingBatchItems marks items left undispatched (by a restart) failed.
+func (s *Store) FailPendingBatchItems(errMsg string) (int64, error) {
+	res, err := s.exec(`UPDATE batch_items SET status=?, error=? WHERE status=?`, StatusFailed, errMsg, BatchPending)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// GetBatch returns a batch with its current counts.
+func (s *Store) GetBatch(id int64) (*Batch, bool, error) {
+	list, err := s.queryBatches(`SELECT `+batchColumns+` FROM command_batches WHERE id=?`, id)
+	if err != nil || len(list) == 0 {
+		return nil, false, err
+	}
+	return &list[0], true, nil
+}
+
+// ListBatches returns the most recent batches, newest first.
+func (s *Store) ListBatches(limit int) ([]Batch, error) {
+	if limit <= 0 {
+		limit = 50
+	}
+	return s.queryBatches(`SELECT `+batchColumns+` FROM command_batches ORDER BY id DESC LIMIT ?`, limit)
+}
+
+// UnfinishedBatches returns the IDs of batches with items still in progress.
+func (s *Store) UnfinishedBatches() ([]int64, error) {
+	rows, err := s.query(
+		`SELECT DISTINCT i.batch_id FROM batch_items i LEFT JOIN commands c ON c.request_id = i.request_id
+		 WHERE COALESCE(c.status, i.status) IN (`+placeholders(len(activeStatuses))+`) ORDER BY i.batch_id`,
+		activeStatuses...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		out = append(out, id)
+	}
+	return out, rows.Err()
+}
+
+// BatchItems returns a batch's items ordered by scooter, each with its
+// command's current status.
+func (s *Store) BatchItems(batchID int64) ([]BatchItem, error) {
+	rows, err := s.query(
+		`SELECT i.scooter_id, i.request_id, COALESCE(c.status, i.status), COALESCE(c.error, i.error)
+		 FROM batch_items i LEFT JOIN commands c ON c.request_id = i.request_id
+		 WHERE i.batch_id=? ORDER BY i.scooter_id`,
+		batchID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []BatchItem
+	for rows.Next() {
+		var (
+			it                 BatchItem
+			requestID, errText sql.NullString
+		)
+		if err := rows.Scan(&it.ScooterID, &requestID, &it.Status, &errText); err != nil {
+			return nil, err
+		}
+		it.RequestID, it.Error = requestID.String, errText.String
+		out = append(out, it)
+	}
+	return out, rows.Err()
+}
+
+// BatchCounts returns the number of a batch's items per status and whether
+// none is still in progress.
+func (s *Store) BatchCounts(batchID int64) (map[string]int, bool, error) {
+	rows, err := s.query(
+		`SELECT COALESCE(c.status, i.status), COUNT(*)
+		 FROM batch_items i LEFT JOIN commands c ON c.request_id = i.request_id
+		 WHERE i.batch_id=? GROUP BY COALESCE(c.status, i.status)`,
+		batchID,
+	)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var (
+			status string
+			n      int
+		)
+		if err := rows.Scan(&status, &n); err != nil {
+			return nil, false, err
+		}
+		counts[status] = n
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, err
+	}
+	done := true
+	for _, st := range activeStatuses {
+		if counts[st.(string)] > 0 {
+			done = false
+		}
+	}
+	return counts, done, nil
+}
+
+const batchColumns = `id, command, params, target_scooters, target_groups, target_tags, target_all, condition, queue, ttl,
+	total, created_by, created_at`
+
+func (s *Store) queryBatches(query string, args ...any) ([]Batch, error) {
+	rows, err := s.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	var out []Batch
+	for rows.Next() {
+		var (
+			b                              Batch
+			params, scooters, groups, tags sql.NullString
+			condition, ttl, createdBy      sql.NullString
+			all, queue                     int
+			createdMs                      int64
+		)
+		if err := rows.Scan(&b.ID, &b.Command, &params, &scooters, &groups, &tags, &all, &condition, &queue, &ttl,
+			&b.Total, &createdBy, &createdMs); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if params.Valid {
+			_ = json.Unmarshal([]byte(params.String), &b.Params)
+		}
+		if scooters.Valid {
+			_ = json.Unmarshal([]byte(scooters.String), &b.Scooters)
+		}
+		if groups.Valid {
+			_ = json.Unmarshal([]byte(groups.String), &b.Groups)
+		}
+		if tags.Valid {
+			_ = json.Unmarshal([]byte(tags.String), &b.Tags)
+		}
+		b.Condition, b.TTL, b.CreatedBy = condition.String, ttl.String, createdBy.String
+		b.All, b.Queue = all != 0, queue != 0
+		b.CreatedAt = time.UnixMilli(createdMs).UTC()
+		out = append(out, b)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	// Counts are queried once the batch rows are closed: SQLite runs on a
+	// single connection.
+	for i := range out {
+		if out[i].Counts, out[i].Done, err = s.BatchCounts(out[i].ID); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
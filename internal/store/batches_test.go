@@ -0,0 +1,89 @@
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBatchProgress(t *testing.T) {
+	s := openTemp(t)
+
+	b := &Batch{Command: "lock", Groups: []string{"depot-a"}, Tags: []string{"rev3"}, Queue: true}
+	if err := s.CreateBatch(b, []string{"A", "B", "C", "D"}); err != nil {
+		t.Fatal(err)
+	}
+	if b.ID == 0 || b.Total != 4 || b.Counts[BatchPending] != 4 || b.Done {
+		t.Fatalf("created batch = %+v", b)
+	}
+
+	now := time.Now()
+	if err := s.RecordSent("req-a", "A", "lock", nil, now.Add(time.Minute), time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Enqueue("req-b", "B", "lock", nil, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	for _, it := range []BatchItem{
+		{ScooterID: "A", RequestID: "req-a", Status: StatusSent},
+		{ScooterID: "B", RequestID: "req-b", Status: StatusQueued},
+		{ScooterID: "C", Status: StatusFailed, Error: "scooter not connected"},
+	} {
+		if err := s.SetBatchItem(b.ID, it.ScooterID, it.RequestID, it.Status, it.Error); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Items follow their commands.
+	if _, err := s.UpdateResult("req-a", StatusSuccess, nil, ""); err != nil {
+		t.Fatal(err)
+	}
+	counts, done, err := s.BatchCounts(b.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int{StatusSuccess: 1, StatusQueued: 1, StatusFailed: 1, BatchPending: 1}
+	if len(counts) != len(want) || done {
+		t.Fatalf("counts = %v, done %t", counts, done)
+	}
+	for st, n := range want {
+		if counts[st] != n {
+			t.Errorf("counts[%s] = %d, want %d", st, counts[st], n)
+		}
+	}
+
+	items, err := s.BatchItems(b.ID)
+	if err != nil || len(items) != 4 {
+		t.Fatalf("items = %+v, %v", items, err)
+	}
+	if items[0].Status != StatusSuccess || items[2].Error != "scooter not connected" || items[3].Status != BatchPending {
+		t.Errorf("items = %+v", items)
+	}
+
+	if ids, err := s.UnfinishedBatches(); err != nil || len(ids) != 1 || ids[0] != b.ID {
+		t.Fatalf("unfinished = %v, %v", ids, err)
+	}
+	if n, err := s.FailPendingBatchItems("interrupted"); err != nil || n != 1 {
+		t.Fatalf("fail pending = %d, %v", n, err)
+	}
+	if _, err := s.Storage().DequeueQueued("B", time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.UpdateResult("req-b", StatusFailed, nil, "no"); err != nil {
+		t.Fatal(err)
+	}
+	got, ok, err := s.GetBatch(b.ID)
+	if err != nil || !ok {
+		t.Fatalf("get: %v %v", ok, err)
+	}
+	if !got.Done || got.Counts[StatusFailed] != 3 || got.Groups[0] != "depot-a" || len(got.Tags) != 1 || !got.Queue {
+		t.Errorf("finished batch = %+v", got)
+	}
+	if ids, _ := s.UnfinishedBatches(); len(ids) != 0 {
+		t.Errorf("still unfinished: %v", ids)
+	}
+
+	list, err := s.ListBatches(10)
+	if err != nil || len(list) != 1 || list[0].Counts[StatusSuccess] != 1 {
+		t.Errorf("list = %+v, %v", list, err)
+	}
+}
@@ -6,13 +6,18 @@ import (
 	"time"
 )
 
-// Command statuses.
+// Command statuses. A command moves queued -> sent -> running (the scooter
+// acknowledged it and is working) -> success/failed. A sent command that gets
+// no response before its last attempt's deadline becomes timed_out; a queued
+// one that outlives its TTL becomes expired.
 const (
-	StatusQueued  = "queued"
-	StatusSent    = "sent"
-	StatusSuccess = "success"
-	StatusFailed  = "failed"
-	StatusExpired = "expired"
+	StatusQueued   = "queued"
+	StatusSent     = "sent"
+	StatusRunning  = "running"
+	StatusSuccess  = "success"
+	StatusFailed   = "failed"
+	StatusExpired  = "expired"
+	StatusTimedOut = "timed_out"
 )
 
 // CommandRecord is the persisted view of a command.
@@ -22,6 +27,7 @@ type CommandRecord struct {
 	Command    string         `json:"command"`
 	Params     map[string]any `json:"params,omitempty"`
 	Status     string         `json:"status"`
+	Attempts   int            `json:"attempts"`
 	Result     map[string]any `json:"result,omitempty"`
 	Error      string         `json:"error,omitempty"`
 	EnqueuedAt time.Time      `json:"enqueued_at"`
@@ -36,14 +42,27 @@ type QueuedCommand struct {
 	Params    map[string]any
 }
 
+// PendingCommand is a sent command still waiting for its first response.
+type PendingCommand struct {
+	RequestID string
+	ScooterID string
+	Command   string
+	Params    map[string]any
+	Status    string // StatusSent or StatusRunning
+	Attempts  int
+	Expires   time.Time // zero when delivery never expires
+}
+
 // RecordSent inserts a command that was delivered immediately to an online
 // scooter, so its metadata (name, params) is known before any ack arrives.
-func (s *Store) RecordSent(requestID, scooterID, command string, params map[string]any) error {
+// deadline is when the first attempt times out and expires when delivery is
+// abandoned altogether; either may be zero for never.
+func (s *Store) RecordSent(requestID, scooterID, command string, params map[string]any, deadline, expires time.Time) error {
 	now := time.Now().UnixMilli()
-	_, err := s.db.Exec(
-		`INSERT INTO commands(request_id, scooter_id, command, params, status, enqueued_at, sent_at, expires_at)
-		 VALUES(?,?,?,?,?,?,?,0)`,
-		requestID, scooterID, command, marshalMap(params), StatusSent, now, now,
+	_, err := s.exec(
+		`INSERT INTO commands(request_id, scooter_id, command, params, status, enqueued_at, sent_at, expires_at, attempts, deadline)
+		 VALUES(?,?,?,?,?,?,?,?,1,?)`,
+		requestID, scooterID, command, marshalMap(params), StatusSent, now, now, unixMilliOrZero(expires), unixMilliOrZero(deadline),
 	)
 	return err
 }
@@ -56,7 +75,7 @@ func (s *Store) Enqueue(requestID, scooterID, command string, params map[string]
 	if ttl > 0 {
 		expires = now.Add(ttl).UnixMilli()
 	}
-	_, err := s.db.Exec(
+	_, err := s.exec(
 		`INSERT INTO commands(request_id, scooter_id, command, params, status, enqueued_at, expires_at)
 		 VALUES(?,?,?,?,?,?,?)`,
 		requestID, scooterID, command, marshalMap(params), StatusQueued, now.UnixMilli(), expires,
@@ -64,30 +83,35 @@ func (s *Store) Enqueue(requestID, scooterID, command string, params map[string]
 	return err
 }
 
-// UpdateResult records the outcome of a command from its ack.
-func (s *Store) UpdateResult(requestID, status string, result map[string]any, errMsg string) error {
-	_, err := s.db.Exec(
-		`UPDATE commands SET status=?, result=?, error=?, acked_at=? WHERE request_id=?`,
-		status, marshalMap(result), nullString(errMsg), time.Now().UnixMilli(), requestID,
+// UpdateResult records the outcome of a command from its ack. It returns false
+// if the command is not awaiting one, e.g. a late response to a command that
+// already timed out, so the outcome is reported only once.
+func (s *Store) UpdateResult(requestID, status string, result map[string]any, errMsg string) (bool, error) {
+	res, err := s.exec(
+		`UPDATE commands SET status=?, result=?, error=?, acked_at=? WHERE request_id=? AND status IN (?,?)`,
+		status, marshalMap(result), nullString(errMsg), time.Now().UnixMilli(), requestID, StatusSent, StatusRunning,
 	)
-	return err
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
 }
 
-// DequeueQueued returns all non-expired queued commands for a scooter and marks
-// them sent. Intended to be called on reconnect.
-func (s *Store) DequeueQueued(scooterID string) ([]QueuedCommand, error) {
-	now := time.Now().UnixMilli()
-	rows, err := s.db.Query(
-		`SELECT request_id, command, params FROM commands
-		 WHERE scooter_id=? AND status=? AND (expires_at=0 OR expires_at>?)
-		 ORDER BY enqueued_at ASC`,
-		scooterID, StatusQueued, now,
+// MarkRunning records that the scooter acknowledged a sent command or
+// reported progress on a running one, replacing its ack timeout with a run
+// timeout at deadline (zero for never).
+func (s *Store) MarkRunning(requestID string, deadline time.Time) error {
+	_, err := s.exec(
+		`UPDATE commands SET status=?, deadline=? WHERE request_id=? AND status IN (?,?)`,
+		StatusRunning, unixMilliOrZero(deadline), requestID, StatusSent, StatusRunning,
 	)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
+	return err
+}
 
+// scanQueued reads request_id, command and params rows as queued commands.
+func scanQueued(rows *sql.Rows) ([]QueuedCommand, error) {
+	defer rows.Close()
 	var out []QueuedCommand
 	for rows.Next() {
 		var (
@@ -103,26 +127,14 @@ func (s *Store) DequeueQueued(scooterID string) ([]QueuedCommand, error) {
 		}
 		out = append(out, qc)
 	}
-	if err := rows.Err(); err != nil {
-		return nil, err
-	}
-
-	if len(out) > 0 {
-		if _, err := s.db.Exec(
-			`UPDATE commands SET status=?, sent_at=? WHERE scooter_id=? AND status=? AND (expires_at=0 OR expires_at>?)`,
-			StatusSent, now, scooterID, StatusQueued, now,
-		); err != nil {
-			return out, err
-		}
-	}
-	return out, nil
+	return out, rows.Err()
 }
 
 // ExpireStale marks queued commands past their expiry as expired, returning the
 // count.
 func (s *Store) ExpireStale() (int64, error) {
 	now := time.Now().UnixMilli()
-	res, err := s.db.Exec(
+	res, err := s.exec(
 		`UPDATE commands SET status=? WHERE status=? AND expires_at!=0 AND expires_at<=?`,
 		StatusExpired, StatusQueued, now,
 	)
@@ -132,10 +144,92 @@ func (s *Store) ExpireStale() (int64, error) {
 	return res.RowsAffected()
 }
 
+// OverdueCommands returns sent commands whose current attempt's deadline, and
+// running commands whose run deadline, has passed at now, oldest first.
+func (s *Store) OverdueCommands(now time.Time) ([]PendingCommand, error) {
+	return s.queryPending(
+		`SELECT request_id, scooter_id, command, params, status, attempts, expires_at FROM commands
+		 WHERE status IN (?,?) AND deadline!=0 AND deadline<=? ORDER BY enqueued_at ASC`,
+		StatusSent, StatusRunning, now.UnixMilli(),
+	)
+}
+
+// UnackedCommands returns a scooter's commands last sent before sentBefore
+// that never got a response, oldest first. Intended to be called on
+// reconnect, with the time the new connection was made.
+func (s *Store) UnackedCommands(scooterID string, sentBefore time.Time) ([]PendingCommand, error) {
+	return s.queryPending(
+		`SELECT request_id, scooter_id, command, params, status, attempts, expires_at FROM commands
+		 WHERE scooter_id=? AND status=? AND sent_at<? ORDER BY enqueued_at ASC`,
+		scooterID, StatusSent, sentBefore.UnixMilli(),
+	)
+}
+
+// MarkResent records another delivery attempt of a sent command, timing out
+// at deadline (zero for never).
+func (s *Store) MarkResent(requestID string, deadline time.Time) error {
+	_, err := s.exec(
+		`UPDATE commands SET attempts=attempts+1, sent_at=?, deadline=? WHERE request_id=? AND status=?`,
+		time.Now().UnixMilli(), unixMilliOrZero(deadline), requestID, StatusSent,
+	)
+	return err
+}
+
+// MarkTimedOut gives up on a sent command that never got a response, or a
+// running one that never finished. It reports false when the command was no
+// longer waiting (a response raced the timeout).
+func (s *Store) MarkTimedOut(requestID, errMsg string) (bool, error) {
+	res, err := s.exec(
+		`UPDATE commands SET status=?, error=?, deadline=0 WHERE request_id=? AND status IN (?,?)`,
+		StatusTimedOut, nullString(errMsg), requestID, StatusSent, StatusRunning,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (s *Store) queryPending(query string, args ...any) ([]PendingCommand, error) {
+	rows, err := s.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []PendingCommand
+	for rows.Next() {
+		var (
+			pc      PendingCommand
+			params  sql.NullString
+			expires int64
+		)
+		if err := rows.Scan(&pc.RequestID, &pc.ScooterID, &pc.Command, &params, &pc.Status, &pc.Attempts, &expires); err != nil {
+			return nil, err
+		}
+		if params.Valid {
+			_ = json.Unmarshal([]byte(params.String), &pc.Params)
+		}
+		if expires != 0 {
+			pc.Expires = time.UnixMilli(expires).UTC()
+		}
+		out = append(out, pc)
+	}
+	return out, rows.Err()
+}
+
+// QueuedCount returns how many commands are waiting for their scooter to
+// reconnect.
+func (s *Store) QueuedCount() (int, error) {
+	var n int
+	err := s.queryRow(`SELECT COUNT(*) FROM commands WHERE status=?`, StatusQueued).Scan(&n)
+	return n, err
+}
+
 // GetCommand returns a single command record.
 func (s *Store) GetCommand(requestID string) (*CommandRecord, bool, error) {
-	row := s.db.QueryRow(
-		`SELECT request_id, scooter_id, command, params, status, result, error, enqueued_at, sent_at, acked_at
+	row := s.queryRow(
+		`SELECT request_id, scooter_id, command, params, status, attempts, result, error, enqueued_at, sent_at, acked_at
 		 FROM commands WHERE request_id=?`, requestID,
 	)
 	rec, ok, err := scanCommand(row)
@@ -147,8 +241,8 @@ func (s *Store) QueryCommands(scooterID string, limit int) ([]CommandRecord, err
 	if limit <= 0 {
 		limit = 100
 	}
-	rows, err := s.db.Query(
-		`SELECT request_id, scooter_id, command, params, status, result, error, enqueued_at, sent_at, acked_at
+	rows, err := s.query(
+		`SELECT request_id, scooter_id, command, params, status, attempts, result, error, enqueued_at, sent_at, acked_at
 		 FROM commands WHERE scooter_id=? ORDER BY enqueued_at DESC LIMIT ?`,
 		scooterID, limit,
 	)
@@ -180,7 +274,7 @@ func scanCommand(sc rowScanner) (*CommandRecord, bool, error) {
 		enqueued                  int64
 		sentAt, ackedAt           sql.NullInt64
 	)
-	err := sc.Scan(&rec.RequestID, &rec.ScooterID, &rec.Command, &params, &rec.Status,
+	err := sc.Scan(&rec.RequestID, &rec.ScooterID, &rec.Command, &params, &rec.Status, &rec.Attempts,
 		&result, &errMsg, &enqueued, &sentAt, &ackedAt)
 	if err == sql.ErrNoRows {
 		return nil, false, nil
@@ -220,6 +314,13 @@ func marshalMap(m map[string]any) any {
 	return string(b)
 }
 
+func unixMilliOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixMilli()
+}
+
 func nullString(s string) any {
 	if s == "" {
 		return nil
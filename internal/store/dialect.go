@@ -0,0 +1,679 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+	_ "modernc.org/sqlite"
+)
+
+// dialect captures what differs between the SQL backends a Store can run on.
+// Queries throughout the package are written once with "?" placeholders and
+// portable SQL; a dialect rebinds them and owns the DDL and connection setup.
+type dialect interface {
+	// name is the backend name used in logs and errors.
+	name() string
+	// rebind rewrites "?" placeholders into the backend's native form.
+	rebind(query string) string
+	// init runs per-database setup (pragmas, extensions) before migrations.
+	init(db *sql.DB) error
+	// migrations returns the backend's schema history, in version order.
+	migrations() []Migration
+	// lockMigrations serialises concurrent migrators within tx.
+	lockMigrations(tx *sql.Tx) error
+	// tableExistsSQL counts tables named by its single argument.
+	tableExistsSQL() string
+	// afterMigrate runs idempotent backend-specific steps once the schema is
+	// current.
+	afterMigrate(db *sql.DB) error
+	// storage wraps s in the backend's Storage implementation.
+	storage(s *Store) Storage
+}
+
+// --- SQLite ---
+
+type sqliteDialect struct{}
+
+func (sqliteDialect) name() string { return "sqlite" }
+
+func (sqliteDialect) rebind(query string) string { return query }
+
+func (sqliteDialect) init(db *sql.DB) error {
+	// modernc's driver is safe for concurrent use but a single writer avoids
+	// "database is locked" churn under bursty telemetry.
+	db.SetMaxOpenConns(1)
+
+	pragmas := []string{
+		"PRAGMA journal_mode=WAL;",
+		"PRAGMA synchronous=NORMAL;",
+		"PRAGMA busy_timeout=5000;",
+	}
+	for _, p := range pragmas {
+		if _, err := db.Exec(p); err != nil {
+			return fmt.Errorf("pragma %q: %w", p, err)
+		}
+	}
+	return nil
+}
+
+func (sqliteDialect) migrations() []Migration { return sqliteMigrations }
+
+// lockMigrations is a no-op: the single connection already serialises writers.
+func (sqliteDialect) lockMigrations(*sql.Tx) error { return nil }
+
+func (sqliteDialect) tableExistsSQL() string {
+	return `SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name=?`
+}
+
+func (sqliteDialect) afterMigrate(*sql.DB) error { return nil }
+
+func (sqliteDialect) storage(s *Store) Storage { return sqliteStorage{s} }
+
+// sqliteMigrations is the SQLite schema history. Append new migrations; never
+// edit one that has shipped.
+var sqliteMigrations = []Migration{
+	{Version: 1, Name: "initial schema", SQL: `
+CREATE TABLE IF NOT EXISTS telemetry_history (
+	id         INTEGER PRIMARY KEY AUTOINCREMENT,
+	scooter_id TEXT    NOT NULL,
+	ts         INTEGER NOT NULL,
+	lat        REAL,
+	lng        REAL,
+	speed      REAL,
+	state      TEXT,
+	data       TEXT    NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_th_scooter_ts ON telemetry_history(scooter_id, ts);
+
+CREATE TABLE IF NOT EXISTS events (
+	id         INTEGER PRIMARY KEY AUTOINCREMENT,
+	scooter_id TEXT    NOT NULL,
+	ts         INTEGER NOT NULL,
+	event      TEXT    NOT NULL,
+	data       TEXT
+);
+CREATE INDEX IF NOT EXISTS idx_ev_scooter_ts ON events(scooter_id, ts);
+
+CREATE TABLE IF NOT EXISTS commands (
+	request_id  TEXT    PRIMARY KEY,
+	scooter_id  TEXT    NOT NULL,
+	command     TEXT    NOT NULL,
+	params      TEXT,
+	status      TEXT    NOT NULL,
+	result      TEXT,
+	error       TEXT,
+	enqueued_at INTEGER NOT NULL,
+	sent_at     INTEGER,
+	acked_at    INTEGER,
+	expires_at  INTEGER NOT NULL DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS idx_cmd_scooter_status ON commands(scooter_id, status);
+`},
+	{Version: 2, Name: "telemetry rollups", SQL: `
+CREATE TABLE IF NOT EXISTS telemetry_rollups (
+	scooter_id TEXT    NOT NULL,
+	resolution TEXT    NOT NULL,
+	bucket     INTEGER NOT NULL,
+	samples    INTEGER NOT NULL,
+	last_ts    INTEGER NOT NULL,
+	lat        REAL,
+	lng        REAL,
+	state      TEXT,
+	data       TEXT    NOT NULL,
+	PRIMARY KEY (scooter_id, resolution, bucket)
+);
+
+CREATE TABLE IF NOT EXISTS rollup_state (
+	name    TEXT    PRIMARY KEY,
+	last_id INTEGER NOT NULL
+);
+`},
+	{Version: 3, Name: "trips", SQL: `
+CREATE TABLE IF NOT EXISTS trips (
+	id           INTEGER PRIMARY KEY AUTOINCREMENT,
+	scooter_id   TEXT    NOT NULL,
+	status       TEXT    NOT NULL,
+	start_ts     INTEGER NOT NULL,
+	end_ts       INTEGER NOT NULL,
+	start_lat    REAL,
+	start_lng    REAL,
+	end_lat      REAL,
+	end_lng      REAL,
+	distance_m   REAL    NOT NULL DEFAULT 0,
+	max_speed    REAL    NOT NULL DEFAULT 0,
+	avg_speed    REAL    NOT NULL DEFAULT 0,
+	samples      INTEGER NOT NULL DEFAULT 0,
+	start_charge TEXT,
+	end_charge   TEXT,
+	energy_used  REAL
+);
+CREATE INDEX IF NOT EXISTS idx_trips_scooter_start ON trips(scooter_id, start_ts);
+CREATE INDEX IF NOT EXISTS idx_trips_status ON trips(status);
+`},
+	{Version: 4, Name: "geofences", SQL: `
+CREATE TABLE IF NOT EXISTS geofences (
+	id              INTEGER PRIMARY KEY AUTOINCREMENT,
+	name            TEXT    NOT NULL,
+	shape           TEXT    NOT NULL,
+	geometry        TEXT    NOT NULL,
+	target_scooters TEXT,
+	target_groups   TEXT,
+	created_at      INTEGER NOT NULL,
+	updated_at      INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS geofence_state (
+	geofence_id INTEGER NOT NULL,
+	scooter_id  TEXT    NOT NULL,
+	inside      INTEGER NOT NULL,
+	updated_at  INTEGER NOT NULL,
+	PRIMARY KEY (geofence_id, scooter_id)
+);
+`},
+	{Version: 5, Name: "alerts", SQL: `
+CREATE TABLE IF NOT EXISTS alert_rules (
+	name       TEXT    PRIMARY KEY,
+	definition TEXT    NOT NULL,
+	created_at INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS alerts (
+	id          INTEGER PRIMARY KEY AUTOINCREMENT,
+	rule        TEXT    NOT NULL,
+	scooter_id  TEXT    NOT NULL,
+	severity    TEXT    NOT NULL,
+	status      TEXT    NOT NULL,
+	message     TEXT,
+	value       TEXT,
+	fired_at    INTEGER NOT NULL,
+	resolved_at INTEGER,
+	acked_at    INTEGER,
+	acked_by    TEXT
+);
+CREATE INDEX IF NOT EXISTS idx_alerts_status ON alerts(status, fired_at);
+CREATE INDEX IF NOT EXISTS idx_alerts_scooter ON alerts(scooter_id, fired_at);
+`},
+	{Version: 6, Name: "webhook outbox", SQL: `
+CREATE TABLE IF NOT EXISTS webhook_outbox (
+	id           INTEGER PRIMARY KEY AUTOINCREMENT,
+	subscription TEXT    NOT NULL,
+	event_type   TEXT    NOT NULL,
+	payload      TEXT    NOT NULL,
+	status       TEXT    NOT NULL,
+	attempts     INTEGER NOT NULL DEFAULT 0,
+	next_attempt INTEGER NOT NULL,
+	last_error   TEXT,
+	created_at   INTEGER NOT NULL,
+	finished_at  INTEGER
+);
+CREATE INDEX IF NOT EXISTS idx_webhook_outbox_due ON webhook_outbox(status, next_attempt);
+`},
+	{Version: 7, Name: "command lifecycle", SQL: `
+ALTER TABLE commands ADD COLUMN attempts INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE commands ADD COLUMN deadline INTEGER NOT NULL DEFAULT 0;
+UPDATE commands SET attempts=1 WHERE sent_at IS NOT NULL;
+UPDATE commands SET status='timed_out' WHERE status='sent';
+CREATE INDEX IF NOT EXISTS idx_cmd_status_deadline ON commands(status, deadline);
+`},
+	{Version: 8, Name: "schedules", SQL: `
+CREATE TABLE IF NOT EXISTS schedules (
+	id              INTEGER PRIMARY KEY AUTOINCREMENT,
+	name            TEXT    NOT NULL,
+	command         TEXT    NOT NULL,
+	params          TEXT,
+	target_scooters TEXT,
+	target_groups   TEXT,
+	condition       TEXT,
+	run_at          INTEGER,
+	cron            TEXT,
+	timezone        TEXT,
+	queue           INTEGER NOT NULL DEFAULT 0,
+	ttl             TEXT,
+	enabled         INTEGER NOT NULL DEFAULT 1,
+	next_run        INTEGER,
+	last_run        INTEGER,
+	created_by      TEXT,
+	created_at      INTEGER NOT NULL,
+	updated_at      INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_schedules_due ON schedules(enabled, next_run);
+CREATE TABLE IF NOT EXISTS schedule_runs (
+	id          INTEGER PRIMARY KEY AUTOINCREMENT,
+	schedule_id INTEGER NOT NULL,
+	scooter_id  TEXT    NOT NULL,
+	request_id  TEXT,
+	status      TEXT    NOT NULL,
+	error       TEXT,
+	ran_at      INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_schedule_runs_schedule ON schedule_runs(schedule_id, ran_at);
+`},
+	{Version: 9, Name: "batches", SQL: `
+CREATE TABLE IF NOT EXISTS command_batches (
+	id              INTEGER PRIMARY KEY AUTOINCREMENT,
+	command         TEXT    NOT NULL,
+	params          TEXT,
+	target_scooters TEXT,
+	target_groups   TEXT,
+	condition       TEXT,
+	queue           INTEGER NOT NULL DEFAULT 0,
+	ttl             TEXT,
+	total           INTEGER NOT NULL,
+	created_by      TEXT,
+	created_at      INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS batch_items (
+	batch_id   INTEGER NOT NULL,
+	scooter_id TEXT    NOT NULL,
+	request_id TEXT,
+	status     TEXT    NOT NULL,
+	error      TEXT,
+	PRIMARY KEY (batch_id, scooter_id)
+);
+`},
+	{Version: 10, Name: "target tags", SQL: `
+ALTER TABLE schedules ADD COLUMN target_tags TEXT;
+ALTER TABLE command_batches ADD COLUMN target_tags TEXT;
+`},
+	{Version: 11, Name: "auth failures", SQL: `
+CREATE TABLE IF NOT EXISTS auth_failures (
+	id         INTEGER PRIMARY KEY AUTOINCREMENT,
+	kind       TEXT    NOT NULL,
+	identifier TEXT,
+	source_ip  TEXT    NOT NULL,
+	reason     TEXT,
+	ts         INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_auth_failures_ts ON auth_failures(ts);
+CREATE INDEX IF NOT EXISTS idx_auth_failures_identifier ON auth_failures(identifier, ts);
+`},
+	{Version: 12, Name: "audit log", SQL: `
+CREATE TABLE IF NOT EXISTS audit_log (
+	id         INTEGER PRIMARY KEY AUTOINCREMENT,
+	ts         INTEGER NOT NULL,
+	actor      TEXT    NOT NULL,
+	action     TEXT    NOT NULL,
+	scooter_id TEXT,
+	params     TEXT,
+	source_ip  TEXT,
+	outcome    TEXT    NOT NULL,
+	status     INTEGER,
+	error      TEXT
+);
+CREATE INDEX IF NOT EXISTS idx_audit_log_ts ON audit_log(ts);
+CREATE INDEX IF NOT EXISTS idx_audit_log_actor ON audit_log(actor, ts);
+CREATE INDEX IF NOT EXISTS idx_audit_log_scooter ON audit_log(scooter_id, ts);
+CREATE TRIGGER IF NOT EXISTS audit_log_no_update BEFORE UPDATE ON audit_log
+BEGIN SELECT RAISE(ABORT, 'audit_log is append-only'); END;
+CREATE TRIGGER IF NOT EXISTS audit_log_no_delete BEFORE DELETE ON audit_log
+BEGIN SELECT RAISE(ABORT, 'audit_log is append-only'); END;
+`},
+	{Version: 13, Name: "sessions", SQL: `
+CREATE TABLE IF NOT EXISTS sessions (
+	id         TEXT    PRIMARY KEY,
+	token_hash TEXT    NOT NULL UNIQUE,
+	username   TEXT    NOT NULL,
+	user_agent TEXT,
+	source_ip  TEXT,
+	created_at INTEGER NOT NULL,
+	last_seen  INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_sessions_username ON sessions(username);
+`},
+	{Version: 14, Name: "target all", SQL: `
+ALTER TABLE schedules ADD COLUMN target_all INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE command_batches ADD COLUMN target_all INTEGER NOT NULL DEFAULT 0;
+UPDATE schedules SET target_all=1
+	WHERE target_scooters IS NULL AND target_groups IS NULL AND target_tags IS NULL;
+UPDATE command_batches SET target_all=1
+	WHERE target_scooters IS NULL AND target_groups IS NULL AND target_tags IS NULL;
+`},
+	{Version: 15, Name: "telemetry received at", SQL: `
+ALTER TABLE telemetry_history ADD COLUMN received_at INTEGER;
+`},
+}
+
+// --- PostgreSQL ---
+
+type postgresDialect struct{}
+
+func (postgresDialect) name() string { return "postgres" }
+
+func (postgresDialect) rebind(query string) string { return rebindDollar(query) }
+
+func (postgresDialect) init(db *sql.DB) error {
+	db.SetMaxOpenConns(10)
+	return nil
+}
+
+func (postgresDialect) migrations() []Migration { return postgresMigrations }
+
+// migrationLockKey is the advisory lock that keeps two servers sharing a
+// database from migrating it at the same time.
+const migrationLockKey = 0x75706c6e6b // "uplnk"
+
+func (postgresDialect) lockMigrations(tx *sql.Tx) error {
+	_, err := tx.Exec(`SELECT pg_advisory_xact_lock($1)`, migrationLockKey)
+	return err
+}
+
+func (postgresDialect) tableExistsSQL() string {
+	return `SELECT COUNT(*) FROM information_schema.tables WHERE table_schema=current_schema() AND table_name=?`
+}
+
+func (postgresDialect) afterMigrate(*sql.DB) error { return nil }
+
+func (postgresDialect) storage(s *Store) Storage { return postgresStorage{s} }
+
+// postgresMigrations mirror the SQLite history (shared with TimescaleDB).
+// Timestamps are Unix milliseconds, so they need BIGINT; telemetry_history's
+// key includes ts so the same table can become a TimescaleDB hypertable.
+var postgresMigrations = []Migration{
+	{Version: 1, Name: "initial schema", SQL: `
+CREATE TABLE IF NOT EXISTS telemetry_history (
+	id         BIGSERIAL,
+	scooter_id TEXT             NOT NULL,
+	ts         BIGINT           NOT NULL,
+	lat        DOUBLE PRECISION,
+	lng        DOUBLE PRECISION,
+	speed      DOUBLE PRECISION,
+	state      TEXT,
+	data       TEXT             NOT NULL,
+	PRIMARY KEY (id, ts)
+);
+CREATE INDEX IF NOT EXISTS idx_th_scooter_ts ON telemetry_history(scooter_id, ts);
+
+CREATE TABLE IF NOT EXISTS events (
+	id         BIGSERIAL PRIMARY KEY,
+	scooter_id TEXT      NOT NULL,
+	ts         BIGINT    NOT NULL,
+	event      TEXT      NOT NULL,
+	data       TEXT
+);
+CREATE INDEX IF NOT EXISTS idx_ev_scooter_ts ON events(scooter_id, ts);
+
+CREATE TABLE IF NOT EXISTS commands (
+	request_id  TEXT   PRIMARY KEY,
+	scooter_id  TEXT   NOT NULL,
+	command     TEXT   NOT NULL,
+	params      TEXT,
+	status      TEXT   NOT NULL,
+	result      TEXT,
+	error       TEXT,
+	enqueued_at BIGINT NOT NULL,
+	sent_at     BIGINT,
+	acked_at    BIGINT,
+	expires_at  BIGINT NOT NULL DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS idx_cmd_scooter_status ON commands(scooter_id, status);
+`},
+	{Version: 2, Name: "telemetry rollups", SQL: `
+CREATE TABLE IF NOT EXISTS telemetry_rollups (
+	scooter_id TEXT             NOT NULL,
+	resolution TEXT             NOT NULL,
+	bucket     BIGINT           NOT NULL,
+	samples    BIGINT           NOT NULL,
+	last_ts    BIGINT           NOT NULL,
+	lat        DOUBLE PRECISION,
+	lng        DOUBLE PRECISION,
+	state      TEXT,
+	data       TEXT             NOT NULL,
+	PRIMARY KEY (scooter_id, resolution, bucket)
+);
+
+CREATE TABLE IF NOT EXISTS rollup_state (
+	name    TEXT   PRIMARY KEY,
+	last_id BIGINT NOT NULL
+);
+`},
+	{Version: 3, Name: "trips", SQL: `
+CREATE TABLE IF NOT EXISTS trips (
+	id           BIGSERIAL        PRIMARY KEY,
+	scooter_id   TEXT             NOT NULL,
+	status       TEXT             NOT NULL,
+	start_ts     BIGINT           NOT NULL,
+	end_ts       BIGINT           NOT NULL,
+	start_lat    DOUBLE PRECISION,
+	start_lng    DOUBLE PRECISION,
+	end_lat      DOUBLE PRECISION,
+	end_lng      DOUBLE PRECISION,
+	distance_m   DOUBLE PRECISION NOT NULL DEFAULT 0,
+	max_speed    DOUBLE PRECISION NOT NULL DEFAULT 0,
+	avg_speed    DOUBLE PRECISION NOT NULL DEFAULT 0,
+	samples      BIGINT           NOT NULL DEFAULT 0,
+	start_charge TEXT,
+	end_charge   TEXT,
+	energy_used  DOUBLE PRECISION
+);
+CREATE INDEX IF NOT EXISTS idx_trips_scooter_start ON trips(scooter_id, start_ts);
+CREATE INDEX IF NOT EXISTS idx_trips_status ON trips(status);
+`},
+	{Version: 4, Name: "geofences", SQL: `
+CREATE TABLE IF NOT EXISTS geofences (
+	id              BIGSERIAL PRIMARY KEY,
+	name            TEXT    NOT NULL,
+	shape           TEXT    NOT NULL,
+	geometry        TEXT    NOT NULL,
+	target_scooters TEXT,
+	target_groups   TEXT,
+	created_at      BIGINT  NOT NULL,
+	updated_at      BIGINT  NOT NULL
+);
+CREATE TABLE IF NOT EXISTS geofence_state (
+	geofence_id BIGINT  NOT NULL,
+	scooter_id  TEXT    NOT NULL,
+	inside      INTEGER NOT NULL,
+	updated_at  BIGINT  NOT NULL,
+	PRIMARY KEY (geofence_id, scooter_id)
+);
+`},
+	{Version: 5, Name: "alerts", SQL: `
+CREATE TABLE IF NOT EXISTS alert_rules (
+	name       TEXT    PRIMARY KEY,
+	definition TEXT    NOT NULL,
+	created_at BIGINT  NOT NULL
+);
+CREATE TABLE IF NOT EXISTS alerts (
+	id          BIGSERIAL PRIMARY KEY,
+	rule        TEXT    NOT NULL,
+	scooter_id  TEXT    NOT NULL,
+	severity    TEXT    NOT NULL,
+	status      TEXT    NOT NULL,
+	message     TEXT,
+	value       TEXT,
+	fired_at    BIGINT  NOT NULL,
+	resolved_at BIGINT,
+	acked_at    BIGINT,
+	acked_by    TEXT
+);
+CREATE INDEX IF NOT EXISTS idx_alerts_status ON alerts(status, fired_at);
+CREATE INDEX IF NOT EXISTS idx_alerts_scooter ON alerts(scooter_id, fired_at);
+`},
+	{Version: 6, Name: "webhook outbox", SQL: `
+CREATE TABLE IF NOT EXISTS webhook_outbox (
+	id           BIGSERIAL PRIMARY KEY,
+	subscription TEXT    NOT NULL,
+	event_type   TEXT    NOT NULL,
+	payload      TEXT    NOT NULL,
+	status       TEXT    NOT NULL,
+	attempts     INTEGER NOT NULL DEFAULT 0,
+	next_attempt BIGINT  NOT NULL,
+	last_error   TEXT,
+	created_at   BIGINT  NOT NULL,
+	finished_at  BIGINT
+);
+CREATE INDEX IF NOT EXISTS idx_webhook_outbox_due ON webhook_outbox(status, next_attempt);
+`},
+	{Version: 7, Name: "command lifecycle", SQL: `
+ALTER TABLE commands ADD COLUMN attempts INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE commands ADD COLUMN deadline BIGINT NOT NULL DEFAULT 0;
+UPDATE commands SET attempts=1 WHERE sent_at IS NOT NULL;
+UPDATE commands SET status='timed_out' WHERE status='sent';
+CREATE INDEX IF NOT EXISTS idx_cmd_status_deadline ON commands(status, deadline);
+`},
+	{Version: 8, Name: "schedules", SQL: `
+CREATE TABLE IF NOT EXISTS schedules (
+	id              BIGSERIAL PRIMARY KEY,
+	name            TEXT    NOT NULL,
+	command         TEXT    NOT NULL,
+	params          TEXT,
+	target_scooters TEXT,
+	target_groups   TEXT,
+	condition       TEXT,
+	run_at          BIGINT,
+	cron            TEXT,
+	timezone        TEXT,
+	queue           INTEGER NOT NULL DEFAULT 0,
+	ttl             TEXT,
+	enabled         INTEGER NOT NULL DEFAULT 1,
+	next_run        BIGINT,
+	last_run        BIGINT,
+	created_by      TEXT,
+	created_at      BIGINT  NOT NULL,
+	updated_at      BIGINT  NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_schedules_due ON schedules(enabled, next_run);
+CREATE TABLE IF NOT EXISTS schedule_runs (
+	id          BIGSERIAL PRIMARY KEY,
+	schedule_id BIGINT  NOT NULL,
+	scooter_id  TEXT    NOT NULL,
+	request_id  TEXT,
+	status      TEXT    NOT NULL,
+	error       TEXT,
+	ran_at      BIGINT  NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_schedule_runs_schedule ON schedule_runs(schedule_id, ran_at);
+`},
+	{Version: 9, Name: "batches", SQL: `
+CREATE TABLE IF NOT EXISTS command_batches (
+	id              BIGSERIAL PRIMARY KEY,
+	command         TEXT    NOT NULL,
+	params          TEXT,
+	target_scooters TEXT,
+	target_groups   TEXT,
+	condition       TEXT,
+	queue           INTEGER NOT NULL DEFAULT 0,
+	ttl             TEXT,
+	total           INTEGER NOT NULL,
+	created_by      TEXT,
+	created_at      BIGINT  NOT NULL
+);
+CREATE TABLE IF NOT EXISTS batch_items (
+	batch_id   BIGINT  NOT NULL,
+	scooter_id TEXT    NOT NULL,
+	request_id TEXT,
+	status     TEXT    NOT NULL,
+	error      TEXT,
+	PRIMARY KEY (batch_id, scooter_id)
+);
+`},
+	{Version: 10, Name: "target tags", SQL: `
+ALTER TABLE schedules ADD COLUMN target_tags TEXT;
+ALTER TABLE command_batches ADD COLUMN target_tags TEXT;
+`},
+	{Version: 11, Name: "auth failures", SQL: `
+CREATE TABLE IF NOT EXISTS auth_failures (
+	id         BIGSERIAL PRIMARY KEY,
+	kind       TEXT    NOT NULL,
+	identifier TEXT,
+	source_ip  TEXT    NOT NULL,
+	reason     TEXT,
+	ts         BIGINT  NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_auth_failures_ts ON auth_failures(ts);
+CREATE INDEX IF NOT EXISTS idx_auth_failures_identifier ON auth_failures(identifier, ts);
+`},
+	{Version: 12, Name: "audit log", SQL: `
+CREATE TABLE IF NOT EXISTS audit_log (
+	id         BIGSERIAL PRIMARY KEY,
+	ts         BIGINT  NOT NULL,
+	actor      TEXT    NOT NULL,
+	action     TEXT    NOT NULL,
+	scooter_id TEXT,
+	params     TEXT,
+	source_ip  TEXT,
+	outcome    TEXT    NOT NULL,
+	status     INTEGER,
+	error      TEXT
+);
+CREATE INDEX IF NOT EXISTS idx_audit_log_ts ON audit_log(ts);
+CREATE INDEX IF NOT EXISTS idx_audit_log_actor ON audit_log(actor, ts);
+CREATE INDEX IF NOT EXISTS idx_audit_log_scooter ON audit_log(scooter_id, ts);
+CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+BEGIN
+	RAISE EXCEPTION 'audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+DROP TRIGGER IF EXISTS audit_log_append_only ON audit_log;
+CREATE TRIGGER audit_log_append_only BEFORE UPDATE OR DELETE ON audit_log
+	FOR EACH ROW EXECUTE FUNCTION audit_log_append_only();
+`},
+	{Version: 13, Name: "sessions", SQL: `
+CREATE TABLE IF NOT EXISTS sessions (
+	id         TEXT    PRIMARY KEY,
+	token_hash TEXT    NOT NULL UNIQUE,
+	username   TEXT    NOT NULL,
+	user_agent TEXT,
+	source_ip  TEXT,
+	created_at BIGINT  NOT NULL,
+	last_seen  BIGINT  NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_sessions_username ON sessions(username);
+`},
+	{Version: 14, Name: "target all", SQL: `
+ALTER TABLE schedules ADD COLUMN target_all INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE command_batches ADD COLUMN target_all INTEGER NOT NULL DEFAULT 0;
+UPDATE schedules SET target_all=1
+	WHERE target_scooters IS NULL AND target_groups IS NULL AND target_tags IS NULL;
+UPDATE command_batches SET target_all=1
+	WHERE target_scooters IS NULL AND target_groups IS NULL AND target_tags IS NULL;
+`},
+	{Version: 15, Name: "telemetry received at", SQL: `
+ALTER TABLE telemetry_history ADD COLUMN received_at BIGINT;
+`},
+}
+
+// --- TimescaleDB ---
+
+// timescaleDialect is PostgreSQL with telemetry_history turned into a
+// hypertable chunked by day.
+type timescaleDialect struct{ postgresDialect }
+
+func (timescaleDialect) name() string { return "timescaledb" }
+
+func (timescaleDialect) init(db *sql.DB) error {
+	db.SetMaxOpenConns(10)
+	if _, err := db.Exec(`CREATE EXTENSION IF NOT EXISTS timescaledb`); err != nil {
+		return fmt.Errorf("enable timescaledb extension: %w", err)
+	}
+	return nil
+}
+
+func (timescaleDialect) afterMigrate(db *sql.DB) error {
+	// ts is integer milliseconds, so the chunk interval is too (one day).
+	_, err := db.Exec(`SELECT create_hypertable('telemetry_history', 'ts',
+		chunk_time_interval => 86400000, if_not_exists => TRUE, migrate_data => TRUE)`)
+	if err != nil {
+		return fmt.Errorf("create hypertable: %w", err)
+	}
+	return nil
+}
+
+// rebindDollar rewrites "?" placeholders as $1, $2, … . Queries in this
+// package never contain a literal question mark.
+func rebindDollar(query string) string {
+	var b strings.Builder
+	b.Grow(len(query) + 8)
+	n := 0
+	for i := 0; i < len(query); i++ {
+		if query[i] == '?' {
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+			continue
+		}
+		b.WriteByte(query[i])
+	}
+	return b.String()
+}
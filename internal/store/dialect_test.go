@@ -0,0 +1,26 @@
+package store
+
+import "testing"
+
+func TestRebindDollar(t *testing.T) {
+	cases := map[string]string{
+		`SELECT 1`:                  `SELECT 1`,
+		`SELECT * FROM t WHERE a=?`: `SELECT * FROM t WHERE a=$1`,
+		`UPDATE t SET a=?, b=? WHERE c=? AND d>?`:   `UPDATE t SET a=$1, b=$2 WHERE c=$3 AND d>$4`,
+		`INSERT INTO t VALUES(?,?,?,?,?,?,?,?,?,?)`: `INSERT INTO t VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9,$10)`,
+	}
+	for in, want := range cases {
+		if got := rebindDollar(in); got != want {
+			t.Errorf("rebindDollar(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestOpenBackendUnknown(t *testing.T) {
+	if _, err := OpenBackend("mysql", "", ""); err == nil {
+		t.Fatal("expected error for unknown backend")
+	}
+	if _, err := OpenBackend(BackendPostgres, "", ""); err == nil {
+		t.Fatal("expected error for postgres without dsn")
+	}
+}
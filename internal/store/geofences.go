@@ -0,0 +1,217 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// Geofence shapes.
+const (
+	ShapePolygon = "polygon"
+	ShapeCircle  = "circle"
+)
+
+// Geofence is a named area. Polygons use Points ([lat, lng] vertices, not
+// closed); circles use Center and RadiusM. A fence applies to the scooters
+// and groups it targets, or to every scooter when it targets neither.
+type Geofence struct {
+	ID        int64        `json:"id"`
+	Name      string       `json:"name"`
+	Shape     string       `json:"shape"`
+	Points    [][2]float64 `json:"points,omitempty"`
+	Center    *[2]float64  `json:"center,omitempty"`
+	RadiusM   float64      `json:"radius_m,omitempty"`
+	Scooters  []string     `json:"scooters,omitempty"`
+	Groups    []string     `json:"groups,omitempty"`
+	CreatedAt time.Time    `json:"created_at"`
+	UpdatedAt time.Time    `json:"updated_at"`
+}
+
+// geofenceGeometry is the stored form of a fence's shape.
+type geofenceGeometry struct {
+	Points  [][2]float64 `json:"points,omitempty"`
+	Center  *[2]float64  `json:"center,omitempty"`
+	RadiusM float64      `json:"radius_m,omitempty"`
+}
+
+// GeofenceState records whether a scooter was last seen inside a fence.
+type GeofenceState struct {
+	GeofenceID int64
+	ScooterID  string
+	Inside     bool
+}
+
+const geofenceColumns = `id, name, shape, geometry, target_scooters, target_groups, created_at, updated_at`
+
+// ListGeofences returns all geofences ordered by id.
+func (s *Store) ListGeofences() ([]Geofence, error) {
+	return s.queryGeofences(`SELECT ` + geofenceColumns + ` FROM geofences ORDER BY id`)
+}
+
+// GetGeofence returns a single geofence.
+func (s *Store) GetGeofence(id int64) (*Geofence, bool, error) {
+	fences, err := s.queryGeofences(`SELECT `+geofenceColumns+` FROM geofences WHERE id=?`, id)
+	if err != nil || len(fences) == 0 {
+		return nil, false, err
+	}
+	return &fences[0], true, nil
+}
+
+// CreateGeofence inserts f and sets its ID and timestamps.
+func (s *Store) CreateGeofence(f *Geofence) error {
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	geometry, scooters, groups, err := marshalGeofence(f)
+	if err != nil {
+		return err
+	}
+	err = s.queryRow(
+		`INSERT INTO geofences(name, shape, geometry, target_scooters, target_groups, created_at, updated_at)
+		 VALUES(?,?,?,?,?,?,?) RETURNING id`,
+		f.Name, f.Shape, geometry, scooters, groups, now.UnixMilli(), now.UnixMilli(),
+	).Scan(&f.ID)
+	if err != nil {
+		return err
+	}
+	f.CreatedAt, f.UpdatedAt = now, now
+	return nil
+}
+
+// UpdateGeofence replaces the stored fence with f's ID. It returns false if
+// no such fence exists. Recorded inside/outside state is kept, so a reshaped
+// fence reports transitions relative to where scooters were.
+func (s *Store) UpdateGeofence(f *Geofence) (bool, error) {
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	geometry, scooters, groups, err := marshalGeofence(f)
+	if err != nil {
+		return false, err
+	}
+	res, err := s.exec(
+		`UPDATE geofences SET name=?, shape=?, geometry=?, target_scooters=?, target_groups=?, updated_at=?
+		 WHERE id=?`,
+		f.Name, f.Shape, geometry, scooters, groups, now.UnixMilli(), f.ID,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 {
+		return false, err
+	}
+	f.UpdatedAt = now
+	return true, nil
+}
+
+// DeleteGeofence removes a fence and its recorded state. It returns false if
+// no such fence exists.
+func (s *Store) DeleteGeofence(id int64) (bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(s.dialect.rebind(`DELETE FROM geofences WHERE id=?`), id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 {
+		return false, err
+	}
+	if _, err := tx.Exec(s.dialect.rebind(`DELETE FROM geofence_state WHERE geofence_id=?`), id); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// GeofenceStates returns the last recorded inside/outside state of every
+// scooter and fence pair.
+func (s *Store) GeofenceStates() ([]GeofenceState, error) {
+	rows, err := s.query(`SELECT geofence_id, scooter_id, inside FROM geofence_state`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []GeofenceState
+	for rows.Next() {
+		var (
+			st     GeofenceState
+			inside int
+		)
+		if err := rows.Scan(&st.GeofenceID, &st.ScooterID, &inside); err != nil {
+			return nil, err
+		}
+		st.Inside = inside != 0
+		out = append(out, st)
+	}
+	return out, rows.Err()
+}
+
+// SetGeofenceState records whether a scooter is inside a fence as of ts.
+func (s *Store) SetGeofenceState(geofenceID int64, scooterID string, inside bool, ts time.Time) error {
+	v := 0
+	if inside {
+		v = 1
+	}
+	_, err := s.exec(
+		`INSERT INTO geofence_state(geofence_id, scooter_id, inside, updated_at) VALUES(?,?,?,?)
+		 ON CONFLICT(geofence_id, scooter_id) DO UPDATE SET inside=excluded.inside, updated_at=excluded.updated_at`,
+		geofenceID, scooterID, v, ts.UnixMilli(),
+	)
+	return err
+}
+
+func (s *Store) queryGeofences(query string, args ...any) ([]Geofence, error) {
+	rows, err := s.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []Geofence
+	for rows.Next() {
+		var (
+			f                    Geofence
+			geometry             string
+			scooters, groups     sql.NullString
+			createdMs, updatedMs int64
+		)
+		if err := rows.Scan(&f.ID, &f.Name, &f.Shape, &geometry, &scooters, &groups, &createdMs, &updatedMs); err != nil {
+			return nil, err
+		}
+		var g geofenceGeometry
+		_ = json.Unmarshal([]byte(geometry), &g)
+		f.Points, f.Center, f.RadiusM = g.Points, g.Center, g.RadiusM
+		if scooters.Valid {
+			_ = json.Unmarshal([]byte(scooters.String), &f.Scooters)
+		}
+		if groups.Valid {
+			_ = json.Unmarshal([]byte(groups.String), &f.Groups)
+		}
+		f.CreatedAt = time.UnixMilli(createdMs).UTC()
+		f.UpdatedAt = time.UnixMilli(updatedMs).UTC()
+		out = append(out, f)
+	}
+	return out, rows.Err()
+}
+
+func marshalGeofence(f *Geofence) (geometry string, scooters, groups any, err error) {
+	b, err := json.Marshal(geofenceGeometry{Points: f.Points, Center: f.Center, RadiusM: f.RadiusM})
+	if err != nil {
+		return "", nil, nil, err
+	}
+	return string(b), marshalStrings(f.Scooters), marshalStrings(f.Groups), nil
+}
+
+func marshalStrings(v []string) any {
+	if len(v) == 0 {
+		return nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	return string(b)
+}
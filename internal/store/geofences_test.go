@@ -0,0 +1,67 @@
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGeofenceCRUD(t *testing.T) {
+	s := openTemp(t)
+
+	f := &Geofence{
+		Name:     "depot",
+		Shape:    ShapeCircle,
+		Center:   &[2]float64{52.5, 13.4},
+		RadiusM:  150,
+		Scooters: []string{"VIN1"},
+	}
+	if err := s.CreateGeofence(f); err != nil {
+		t.Fatal(err)
+	}
+	if f.ID == 0 || f.CreatedAt.IsZero() {
+		t.Fatalf("create did not set id/timestamps: %+v", f)
+	}
+
+	got, ok, err := s.GetGeofence(f.ID)
+	if err != nil || !ok {
+		t.Fatalf("get: %v %v", ok, err)
+	}
+	if got.Center == nil || got.Center[1] != 13.4 || got.RadiusM != 150 || len(got.Scooters) != 1 || got.Groups != nil {
+		t.Errorf("round trip = %+v", got)
+	}
+
+	f.Shape, f.Center, f.RadiusM = ShapePolygon, nil, 0
+	f.Points = [][2]float64{{1, 1}, {1, 2}, {2, 2}}
+	f.Scooters, f.Groups = nil, []string{"depot-a"}
+	if ok, err := s.UpdateGeofence(f); !ok || err != nil {
+		t.Fatalf("update: %v %v", ok, err)
+	}
+	got, _, _ = s.GetGeofence(f.ID)
+	if got.Shape != ShapePolygon || len(got.Points) != 3 || got.Center != nil || got.Scooters != nil || got.Groups[0] != "depot-a" {
+		t.Errorf("after update = %+v", got)
+	}
+	if ok, _ := s.UpdateGeofence(&Geofence{ID: 999, Name: "x", Shape: ShapeCircle}); ok {
+		t.Error("update of missing fence reported success")
+	}
+
+	if err := s.SetGeofenceState(f.ID, "VIN1", true, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetGeofenceState(f.ID, "VIN1", false, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	states, err := s.GeofenceStates()
+	if err != nil || len(states) != 1 || states[0].Inside {
+		t.Fatalf("states = %+v, %v", states, err)
+	}
+
+	if ok, err := s.DeleteGeofence(f.ID); !ok || err != nil {
+		t.Fatalf("delete: %v %v", ok, err)
+	}
+	if n := countRows(t, s, "geofence_state"); n != 0 {
+		t.Errorf("geofence_state rows after delete = %d", n)
+	}
+	if ok, _ := s.DeleteGeofence(f.ID); ok {
+		t.Error("second delete reported success")
+	}
+}
@@ -0,0 +1,174 @@
+package store
+
+import (
+	"fmt"
+	"time"
+)
+
+// Migration is one numbered schema change. Each backend keeps its own ordered
+// list; applied versions are recorded in schema_migrations.
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// MigrationStatus reports whether a known migration has been applied.
+type MigrationStatus struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	AppliedAt *time.Time `json:"applied_at,omitempty"`
+}
+
+const migrationsTable = `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+	version    INTEGER PRIMARY KEY,
+	name       TEXT    NOT NULL,
+	applied_at BIGINT  NOT NULL
+)`
+
+// Migrate applies all pending migrations in order, each in its own
+// transaction, and returns the ones it applied. Databases created before
+// versioning pick up version 1 cleanly because it only creates missing
+// objects.
+func (s *Store) Migrate() ([]Migration, error) {
+	if _, err := s.db.Exec(migrationsTable); err != nil {
+		return nil, fmt.Errorf("create schema_migrations: %w", err)
+	}
+
+	pending, err := s.PendingMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var applied []Migration
+	for _, m := range pending {
+		ok, err := s.applyMigration(m)
+		if err != nil {
+			return applied, fmt.Errorf("%s migration %d (%s): %w", s.dialect.name(), m.Version, m.Name, err)
+		}
+		if ok {
+			applied = append(applied, m)
+		}
+	}
+
+	if err := s.dialect.afterMigrate(s.db); err != nil {
+		return applied, err
+	}
+	return applied, nil
+}
+
+// applyMigration runs m and records it in one transaction. It reports false
+// when another process applied m first.
+func (s *Store) applyMigration(m Migration) (bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if err := s.dialect.lockMigrations(tx); err != nil {
+		return false, fmt.Errorf("lock: %w", err)
+	}
+	var n int
+	if err := tx.QueryRow(s.dialect.rebind(`SELECT COUNT(*) FROM schema_migrations WHERE version=?`), m.Version).Scan(&n); err != nil {
+		return false, err
+	}
+	if n > 0 {
+		return false, nil
+	}
+
+	if _, err := tx.Exec(m.SQL); err != nil {
+		return false, err
+	}
+	if _, err := tx.Exec(
+		s.dialect.rebind(`INSERT INTO schema_migrations(version, name, applied_at) VALUES(?,?,?)`),
+		m.Version, m.Name, time.Now().UnixMilli(),
+	); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// PendingMigrations returns the migrations not yet applied, in order.
+func (s *Store) PendingMigrations() ([]Migration, error) {
+	status, err := s.MigrationStatus()
+	if err != nil {
+		return nil, err
+	}
+	all := s.dialect.migrations()
+	var out []Migration
+	for i, st := range status {
+		if st.AppliedAt == nil {
+			out = append(out, all[i])
+		}
+	}
+	return out, nil
+}
+
+// MigrationStatus lists every migration known to this build for the backend
+// and when it was applied. A database without schema_migrations reports
+// everything as pending.
+func (s *Store) MigrationStatus() ([]MigrationStatus, error) {
+	applied, err := s.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	all := s.dialect.migrations()
+	out := make([]MigrationStatus, len(all))
+	for i, m := range all {
+		out[i] = MigrationStatus{Version: m.Version, Name: m.Name}
+		if at, ok := applied[m.Version]; ok {
+			t := time.UnixMilli(at)
+			out[i].AppliedAt = &t
+		}
+	}
+	return out, nil
+}
+
+// SchemaVersion returns the highest applied migration, 0 for an unversioned
+// database.
+func (s *Store) SchemaVersion() (int, error) {
+	applied, err := s.appliedMigrations()
+	if err != nil {
+		return 0, err
+	}
+	v := 0
+	for version := range applied {
+		if version > v {
+			v = version
+		}
+	}
+	return v, nil
+}
+
+// appliedMigrations maps applied versions to their applied_at (Unix ms).
+func (s *Store) appliedMigrations() (map[int]int64, error) {
+	applied := make(map[int]int64)
+	exists, err := s.tableExists("schema_migrations")
+	if err != nil || !exists {
+		return applied, err
+	}
+	rows, err := s.db.Query(`SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var v int
+		var at int64
+		if err := rows.Scan(&v, &at); err != nil {
+			return nil, err
+		}
+		applied[v] = at
+	}
+	return applied, rows.Err()
+}
+
+func (s *Store) tableExists(name string) (bool, error) {
+	var n int
+	if err := s.queryRow(s.dialect.tableExistsSQL(), name).Scan(&n); err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
@@ -0,0 +1,94 @@
+package store
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMigrationsOrdered(t *testing.T) {
+	for _, d := range []dialect{sqliteDialect{}, postgresDialect{}, timescaleDialect{}} {
+		prev := 0
+		for _, m := range d.migrations() {
+			if m.Version != prev+1 {
+				t.Errorf("%s: migration %q has version %d, want %d", d.name(), m.Name, m.Version, prev+1)
+			}
+			prev = m.Version
+		}
+	}
+}
+
+func TestMigrateFreshAndIdempotent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	s, err := Open(path)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	want := len(sqliteMigrations)
+	if v, err := s.SchemaVersion(); err != nil || v != want {
+		t.Fatalf("schema version = %d, %v; want %d", v, err, want)
+	}
+	s.Close()
+
+	s, err = Connect(BackendSQLite, path, "")
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer s.Close()
+	applied, err := s.Migrate()
+	if err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	if len(applied) != 0 {
+		t.Fatalf("re-migrate applied %d migrations, want 0", len(applied))
+	}
+}
+
+func TestMigrateLegacyDatabase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "legacy.db")
+
+	// A database created before versioning: tables but no schema_migrations.
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("open raw: %v", err)
+	}
+	if _, err := db.Exec(sqliteMigrations[0].SQL); err != nil {
+		t.Fatalf("legacy schema: %v", err)
+	}
+	if _, err := db.Exec(`INSERT INTO events(scooter_id, ts, event) VALUES('VIN1', 1, 'boot')`); err != nil {
+		t.Fatalf("legacy insert: %v", err)
+	}
+	db.Close()
+
+	s, err := Connect(BackendSQLite, path, "")
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer s.Close()
+
+	pending, err := s.PendingMigrations()
+	if err != nil {
+		t.Fatalf("pending: %v", err)
+	}
+	if len(pending) != len(sqliteMigrations) {
+		t.Fatalf("pending = %d, want %d", len(pending), len(sqliteMigrations))
+	}
+	if _, err := s.Migrate(); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	status, err := s.MigrationStatus()
+	if err != nil {
+		t.Fatalf("status: %v", err)
+	}
+	for _, m := range status {
+		if m.AppliedAt == nil {
+			t.Errorf("migration %d still pending", m.Version)
+		}
+	}
+	events, err := s.QueryEvents("VIN1", time.UnixMilli(0), time.Now(), 10)
+	if err != nil || len(events) != 1 {
+		t.Fatalf("legacy rows lost: %d, %v", len(events), err)
+	}
+}
@@ -0,0 +1,30 @@
+package store
+
+import "time"
+
+// postgresStorage is the PostgreSQL and TimescaleDB Storage. Several servers
+// and pooled connections may claim the same scooter's queue, so claiming is a
+// single statement that skips rows another transaction already holds.
+type postgresStorage struct{ *Store }
+
+// DequeueQueued returns all non-expired queued commands for a scooter and marks
+// them sent, with their first attempt timing out at deadline (zero for
+// never). Intended to be called on reconnect.
+func (s postgresStorage) DequeueQueued(scooterID string, deadline time.Time) ([]QueuedCommand, error) {
+	now := time.Now().UnixMilli()
+	rows, err := s.query(
+		`WITH claimed AS (
+			UPDATE commands SET status=?, sent_at=?, attempts=1, deadline=?
+			WHERE request_id IN (
+				SELECT request_id FROM commands
+				WHERE scooter_id=? AND status=? AND (expires_at=0 OR expires_at>?)
+				FOR UPDATE SKIP LOCKED)
+			RETURNING request_id, command, params, enqueued_at)
+		 SELECT request_id, command, params FROM claimed ORDER BY enqueued_at ASC`,
+		StatusSent, now, unixMilliOrZero(deadline), scooterID, StatusQueued, now,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return scanQueued(rows)
+}
@@ -0,0 +1,128 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+)
+
+// openPostgresTest connects backend to the database in the environment
+// variable env, inside a fresh schema that is dropped when the test ends. The
+// test is skipped when env is unset.
+func openPostgresTest(t *testing.T, backend, env string) *Store {
+	t.Helper()
+	dsn := os.Getenv(env)
+	if dsn == "" {
+		t.Skipf("%s not set", env)
+	}
+
+	admin, err := sql.Open("pgx", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { admin.Close() })
+	schema := fmt.Sprintf("uplink_test_%d", time.Now().UnixNano())
+	if _, err := admin.Exec(`CREATE SCHEMA ` + schema); err != nil {
+		t.Fatalf("create schema: %v", err)
+	}
+	t.Cleanup(func() { admin.Exec(`DROP SCHEMA ` + schema + ` CASCADE`) })
+
+	cfg, err := pgx.ParseConfig(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Extensions such as timescaledb live in public, so keep it reachable.
+	cfg.RuntimeParams["search_path"] = schema + ",public"
+	name := stdlib.RegisterConnConfig(cfg)
+	t.Cleanup(func() { stdlib.UnregisterConnConfig(name) })
+
+	s, err := Connect(backend, "", name)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestPostgresRoundTrip(t *testing.T) {
+	tests := []struct{ backend, env string }{
+		{BackendPostgres, "UPLINK_TEST_POSTGRES_DSN"},
+		{BackendTimescaleDB, "UPLINK_TEST_TIMESCALE_DSN"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.backend, func(t *testing.T) {
+			s := openPostgresTest(t, tt.backend, tt.env)
+
+			applied, err := s.Migrate()
+			if err != nil {
+				t.Fatalf("migrate: %v", err)
+			}
+			if len(applied) != len(postgresMigrations) {
+				t.Errorf("applied %d migrations, want %d", len(applied), len(postgresMigrations))
+			}
+			if again, err := s.Migrate(); err != nil || len(again) != 0 {
+				t.Errorf("second migrate applied %d, err %v", len(again), err)
+			}
+
+			st := s.Storage()
+			if st.Backend() != tt.backend {
+				t.Errorf("backend = %q, want %q", st.Backend(), tt.backend)
+			}
+			now := time.Now()
+
+			data := map[string]any{
+				"gps":     map[string]any{"latitude": "52.5", "longitude": "13.4"},
+				"vehicle": map[string]any{"state": "parked"},
+			}
+			if err := st.InsertTelemetry("VIN1", now, data); err != nil {
+				t.Fatalf("insert telemetry: %v", err)
+			}
+			rows, err := st.QueryTelemetry("VIN1", now.Add(-time.Minute), now.Add(time.Minute), 10)
+			if err != nil || len(rows) != 1 {
+				t.Fatalf("query telemetry: %d rows, err %v", len(rows), err)
+			}
+			if rows[0].State != "parked" || rows[0].Lat == nil || *rows[0].Lat != 52.5 {
+				t.Errorf("telemetry row = %+v", rows[0])
+			}
+
+			if err := st.InsertEvent("VIN1", now, "alarm", map[string]any{"level": "high"}); err != nil {
+				t.Fatalf("insert event: %v", err)
+			}
+			events, err := st.QueryEvents("VIN1", now.Add(-time.Minute), now.Add(time.Minute), 10)
+			if err != nil || len(events) != 1 {
+				t.Fatalf("query events: %d rows, err %v", len(events), err)
+			}
+			if events[0].Event != "alarm" || events[0].Data["level"] != "high" {
+				t.Errorf("event row = %+v", events[0])
+			}
+
+			_ = st.Enqueue("req-1", "VIN1", "lock", nil, time.Hour)
+			_ = st.Enqueue("req-2", "VIN1", "honk", map[string]any{"times": float64(2)}, time.Hour)
+			queued, err := st.DequeueQueued("VIN1", time.Time{})
+			if err != nil || len(queued) != 2 {
+				t.Fatalf("dequeue: %d commands, err %v", len(queued), err)
+			}
+			if again, _ := st.DequeueQueued("VIN1", time.Time{}); len(again) != 0 {
+				t.Errorf("second dequeue returned %d commands", len(again))
+			}
+			if _, err := st.UpdateResult("req-2", StatusSuccess, map[string]any{"ok": true}, ""); err != nil {
+				t.Fatalf("update result: %v", err)
+			}
+			rec, ok, err := st.GetCommand("req-2")
+			if err != nil || !ok {
+				t.Fatalf("get command: ok %t, err %v", ok, err)
+			}
+			if rec.Status != StatusSuccess || rec.Params["times"] != float64(2) || rec.Result["ok"] != true {
+				t.Errorf("command record = %+v", rec)
+			}
+			if n, err := st.QueuedCount(); err != nil || n != 0 {
+				t.Errorf("queued count = %d, err %v", n, err)
+			}
+		})
+	}
+}
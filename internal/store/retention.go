@@ -0,0 +1,199 @@
+package store
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RetentionPolicy says how long each kind of persisted data is kept. A zero
+// duration keeps that data forever.
+type RetentionPolicy struct {
+	Telemetry time.Duration // raw telemetry_history snapshots
+	Rollups   time.Duration // telemetry_rollups buckets (all tiers)
+	Events    time.Duration
+	Commands  time.Duration // finished commands and schedule runs; queued/in-flight commands are never pruned
+}
+
+// Retention is the fleet-wide policy plus per-scooter overrides. Overrides are
+// complete policies (already merged with the default).
+type Retention struct {
+	Default  RetentionPolicy
+	Scooters map[string]RetentionPolicy
+}
+
+// PruneResult reports the rows removed by one pruning pass.
+type PruneResult struct {
+	Telemetry int64         `json:"telemetry"`
+	Rollups   int64         `json:"rollups"`
+	Events    int64         `json:"events"`
+	Commands  int64         `json:"commands"`
+	StartedAt time.Time     `json:"started_at"`
+	Duration  time.Duration `json:"-"`
+}
+
+// Total returns the number of rows removed across all tables.
+func (r PruneResult) Total() int64 {
+	return r.Telemetry + r.Rollups + r.Events + r.Commands
+}
+
+// finishedStatuses are the command states eligible for pruning.
+var finishedStatuses = []string{StatusSuccess, StatusFailed, StatusExpired, StatusTimedOut}
+
+// retentionTable describes how to age out one table.
+type retentionTable struct {
+	table  string
+	tsCol  string
+	filter string // extra WHERE clause, may be empty
+	args   []any  // arguments for filter
+	policy func(RetentionPolicy) time.Duration
+}
+
+func retentionTables() []retentionTable {
+	statusArgs := make([]any, len(finishedStatuses))
+	for i, st := range finishedStatuses {
+		statusArgs[i] = st
+	}
+	return []retentionTable{
+		{table: "telemetry_history", tsCol: "ts", policy: func(p RetentionPolicy) time.Duration { return p.Telemetry }},
+		{table: "telemetry_rollups", tsCol: "bucket", policy: func(p RetentionPolicy) time.Duration { return p.Rollups }},
+		{table: "events", tsCol: "ts", policy: func(p RetentionPolicy) time.Duration { return p.Events }},
+		{
+			table:  "commands",
+			tsCol:  "enqueued_at",
+			filter: "status IN (" + placeholders(len(statusArgs)) + ")",
+			args:   statusArgs,
+			policy: func(p RetentionPolicy) time.Duration { return p.Commands },
+		},
+		{table: "schedule_runs", tsCol: "ran_at", policy: func(p RetentionPolicy) time.Duration { return p.Commands }},
+	}
+}
+
+// Prune deletes data older than the retention policy allows, relative to now.
+// Scooters with an override are pruned by their own policy and excluded from
+// the fleet-wide pass.
+func (s *Store) Prune(r Retention, now time.Time) (PruneResult, error) {
+	res := PruneResult{StartedAt: now}
+	start := time.Now()
+
+	overridden := make([]string, 0, len(r.Scooters))
+	for id := range r.Scooters {
+		overridden = append(overridden, id)
+	}
+	sort.Strings(overridden)
+
+	// Schedule runs count as command history.
+	counts := []*int64{&res.Telemetry, &res.Rollups, &res.Events, &res.Commands, &res.Commands}
+	for i, t := range retentionTables() {
+		if d := t.policy(r.Default); d > 0 {
+			n, err := s.pruneTable(t, now.Add(-d), "", overridden)
+			if err != nil {
+				return res, fmt.Errorf("prune %s: %w", t.table, err)
+			}
+			*counts[i] += n
+		}
+		for _, id := range overridden {
+			d := t.policy(r.Scooters[id])
+			if d <= 0 {
+				continue
+			}
+			n, err := s.pruneTable(t, now.Add(-d), id, nil)
+			if err != nil {
+				return res, fmt.Errorf("prune %s for %s: %w", t.table, id, err)
+			}
+			*counts[i] += n
+		}
+	}
+	res.Duration = time.Since(start)
+	return res, nil
+}
+
+// pruneTable deletes rows of t older than cutoff, either for one scooter or for
+// every scooter not in exclude.
+func (s *Store) pruneTable(t retentionTable, cutoff time.Time, scooterID string, exclude []string) (int64, error) {
+	where := []string{t.tsCol + " < ?"}
+	args := []any{cutoff.UnixMilli()}
+	if t.filter != "" {
+		where = append(where, t.filter)
+		args = append(args, t.args...)
+	}
+	if scooterID != "" {
+		where = append(where, "scooter_id = ?")
+		args = append(args, scooterID)
+	} else if len(exclude) > 0 {
+		where = append(where, "scooter_id NOT IN ("+placeholders(len(exclude))+")")
+		for _, id := range exclude {
+			args = append(args, id)
+		}
+	}
+	res, err := s.exec(`DELETE FROM `+t.table+` WHERE `+strings.Join(where, " AND "), args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// placeholders returns n comma-separated "?" placeholders.
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?,", n), ",")
+}
+
+// Pruner applies a Retention on a schedule and on demand.
+type Pruner struct {
+	db        *Store
+	retention Retention
+
+	runMu sync.Mutex // serialises passes
+
+	mu      sync.RWMutex
+	last    *PruneResult
+	lastErr error
+}
+
+// NewPruner creates a pruner for db with the given retention.
+func NewPruner(db *Store, retention Retention) *Pruner {
+	return &Pruner{db: db, retention: retention}
+}
+
+// Retention returns the policy in effect.
+func (p *Pruner) Retention() Retention { return p.retention }
+
+// Start runs a pass every interval in the background.
+func (p *Pruner) Start(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			p.Run()
+		}
+	}()
+}
+
+// Run performs one pruning pass now.
+func (p *Pruner) Run() (PruneResult, error) {
+	p.runMu.Lock()
+	defer p.runMu.Unlock()
+
+	res, err := p.db.Prune(p.retention, time.Now())
+	if err != nil {
+		log.Printf("[Store] Retention prune error: %v", err)
+	} else if res.Total() > 0 {
+		log.Printf("[Store] Pruned %d telemetry, %d rollup, %d event and %d command rows in %s",
+			res.Telemetry, res.Rollups, res.Events, res.Commands, res.Duration.Round(time.Millisecond))
+	}
+
+	p.mu.Lock()
+	p.last, p.lastErr = &res, err
+	p.mu.Unlock()
+	return res, err
+}
+
+// Last returns the most recent pass, or nil if none has run yet.
+func (p *Pruner) Last() (*PruneResult, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.last, p.lastErr
+}
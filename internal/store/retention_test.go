@@ -0,0 +1,70 @@
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+func countRows(t *testing.T, s *Store, table string) int {
+	t.Helper()
+	var n int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM ` + table).Scan(&n); err != nil {
+		t.Fatalf("count %s: %v", table, err)
+	}
+	return n
+}
+
+func TestPruneRetention(t *testing.T) {
+	s := openTemp(t)
+	now := time.Now()
+	old := now.Add(-10 * 24 * time.Hour)
+
+	for _, id := range []string{"VIN1", "VIN2"} {
+		if err := s.InsertTelemetry(id, old, map[string]any{}); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.InsertTelemetry(id, now, map[string]any{}); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.InsertEvent(id, old, "boot", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Old finished and old still-queued commands.
+	if err := s.RecordSent("r1", "VIN1", "ping", nil, time.Time{}, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Enqueue("r2", "VIN1", "ping", nil, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.UpdateResult("r1", StatusSuccess, nil, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.db.Exec(`UPDATE commands SET enqueued_at=?`, old.UnixMilli()); err != nil {
+		t.Fatal(err)
+	}
+
+	ret := Retention{
+		Default: RetentionPolicy{Telemetry: 7 * 24 * time.Hour, Commands: 7 * 24 * time.Hour},
+		// VIN2 keeps telemetry for 30 days and events for 7.
+		Scooters: map[string]RetentionPolicy{
+			"VIN2": {Telemetry: 30 * 24 * time.Hour, Events: 7 * 24 * time.Hour, Commands: 7 * 24 * time.Hour},
+		},
+	}
+	res, err := s.Prune(ret, now)
+	if err != nil {
+		t.Fatalf("prune: %v", err)
+	}
+	if res.Telemetry != 1 || res.Events != 1 || res.Commands != 1 {
+		t.Fatalf("pruned %+v, want 1 telemetry, 1 event, 1 command", res)
+	}
+	if got := countRows(t, s, "telemetry_history"); got != 3 {
+		t.Errorf("telemetry rows = %d, want 3", got)
+	}
+	if got := countRows(t, s, "events"); got != 1 {
+		t.Errorf("event rows = %d, want 1 (VIN1 keeps events forever)", got)
+	}
+	if _, ok, _ := s.GetCommand("r2"); !ok {
+		t.Error("queued command must not be pruned")
+	}
+}
@@ -0,0 +1,461 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Telemetry resolutions accepted by the history endpoint. ResolutionRaw reads
+// telemetry_history; the others read pre-aggregated telemetry_rollups.
+const (
+	ResolutionAuto = "auto"
+	ResolutionRaw  = "raw"
+	Resolution1m   = "1m"
+	Resolution15m  = "15m"
+	Resolution1h   = "1h"
+)
+
+// rollupTiers are the bucket widths maintained by RollupTelemetry.
+var rollupTiers = []struct {
+	name  string
+	width time.Duration
+}{
+	{Resolution1m, time.Minute},
+	{Resolution15m, 15 * time.Minute},
+	{Resolution1h, time.Hour},
+}
+
+// rollupWatermark names the rollup_state row tracking processed raw rows.
+const rollupWatermark = "telemetry"
+
+// watermark returns the last telemetry_history id consumed by the named
+// background processor (rollups, trips), 0 if it has not run yet.
+func (s *Store) watermark(q queryRower, name string) (int64, error) {
+	var lastID int64
+	err := q.QueryRow(s.dialect.rebind(`SELECT last_id FROM rollup_state WHERE name=?`), name).Scan(&lastID)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return lastID, err
+}
+
+// SettleDelay is how long after arrival a telemetry row is assumed to be
+// committed. Row ids are allocated before commit, so on PostgreSQL a
+// concurrent insert can become visible after rows with higher ids; the
+// id-cursor consumers (rollups, trips) only advance over rows older than this
+// and stop at the first newer one.
+const SettleDelay = 30 * time.Second
+
+// unsettled reports whether a row received at receivedAt (Unix ms; NULL for
+// rows stored before the column existed) arrived after settled.
+func unsettled(receivedAt sql.NullInt64, settled time.Time) bool {
+	return receivedAt.Valid && receivedAt.Int64 > settled.UnixMilli()
+}
+
+// queryRower is satisfied by both *sql.DB and *sql.Tx.
+type queryRower interface {
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+func (s *Store) setWatermark(tx *sql.Tx, name string, lastID int64) error {
+	_, err := tx.Exec(s.dialect.rebind(
+		`INSERT INTO rollup_state(name, last_id) VALUES(?,?)
+		 ON CONFLICT(name) DO UPDATE SET last_id=excluded.last_id`), name, lastID)
+	return err
+}
+
+// ValidResolution reports whether r is a known resolution (including auto).
+func ValidResolution(r string) bool {
+	switch r {
+	case ResolutionAuto, ResolutionRaw, Resolution1m, Resolution15m, Resolution1h:
+		return true
+	}
+	return false
+}
+
+// AutoResolution picks the finest rollup tier that covers span in at most
+// limit buckets, or the hourly tier if none does.
+func AutoResolution(span time.Duration, limit int) string {
+	for _, tier := range rollupTiers {
+		if span <= time.Duration(limit)*tier.width {
+			return tier.name
+		}
+	}
+	return Resolution1h
+}
+
+// ResolveAuto picks the resolution for an auto history query: raw if the
+// scooter has at most limit snapshots within [from, to], so the query is not
+// truncated, and otherwise the tier chosen by AutoResolution.
+func (s *Store) ResolveAuto(scooterID string, from, to time.Time, limit int) (string, error) {
+	var n int
+	err := s.queryRow(
+		`SELECT COUNT(*) FROM (SELECT 1 FROM telemetry_history
+		 WHERE scooter_id=? AND ts BETWEEN ? AND ? LIMIT ?) t`,
+		scooterID, from.UnixMilli(), to.UnixMilli(), limit+1,
+	).Scan(&n)
+	if err != nil {
+		return "", err
+	}
+	if n <= limit {
+		return ResolutionRaw, nil
+	}
+	return AutoResolution(to.Sub(from), limit), nil
+}
+
+// leafStats aggregates one numeric leaf within a bucket.
+type leafStats struct {
+	N   int64   `json:"n"`
+	Sum float64 `json:"sum"`
+	Min float64 `json:"min"`
+	Max float64 `json:"max"`
+}
+
+func (a *leafStats) add(b leafStats) {
+	if a.N == 0 {
+		*a = b
+		return
+	}
+	a.N += b.N
+	a.Sum += b.Sum
+	a.Min = min(a.Min, b.Min)
+	a.Max = max(a.Max, b.Max)
+}
+
+// rollupBucket is one (scooter, resolution, bucket) aggregate. Leaves are keyed
+// by dotted path ("battery:0.charge"). lat/lng/state come from the newest
+// sample, so late rows do not overwrite a more recent position.
+type rollupBucket struct {
+	samples int64
+	lastTS  int64
+	lat     sql.NullFloat64
+	lng     sql.NullFloat64
+	state   sql.NullString
+	leaves  map[string]*leafStats
+}
+
+func (b *rollupBucket) addSample(ts int64, lat, lng sql.NullFloat64, state sql.NullString, leaves map[string]float64) {
+	b.samples++
+	if ts >= b.lastTS {
+		b.lastTS = ts
+		if lat.Valid && lng.Valid {
+			b.lat, b.lng = lat, lng
+		}
+		if state.Valid {
+			b.state = state
+		}
+	}
+	for path, v := range leaves {
+		st, ok := b.leaves[path]
+		if !ok {
+			st = &leafStats{}
+			b.leaves[path] = st
+		}
+		st.add(leafStats{N: 1, Sum: v, Min: v, Max: v})
+	}
+}
+
+// merge folds an already-stored aggregate into b.
+func (b *rollupBucket) merge(o *rollupBucket) {
+	b.samples += o.samples
+	if o.lastTS > b.lastTS {
+		b.lastTS = o.lastTS
+		if o.lat.Valid && o.lng.Valid {
+			b.lat, b.lng = o.lat, o.lng
+		}
+		if o.state.Valid {
+			b.state = o.state
+		}
+	} else {
+		if !b.lat.Valid {
+			b.lat, b.lng = o.lat, o.lng
+		}
+		if !b.state.Valid {
+			b.state = o.state
+		}
+	}
+	for path, st := range o.leaves {
+		if cur, ok := b.leaves[path]; ok {
+			cur.add(*st)
+		} else {
+			c := *st
+			b.leaves[path] = &c
+		}
+	}
+}
+
+type rollupKey struct {
+	scooterID  string
+	resolution string
+	bucket     int64
+}
+
+// RollupTelemetry folds up to batch raw telemetry rows not yet rolled up into
+// every rollup tier and returns how many rows it consumed. Progress is
+// tracked by row id rather than timestamp, so snapshots that arrive late (e.g.
+// a replayed offline telemetry_batch) still land in their historical buckets.
+// Rows received after settled, and everything after the first of them, are
+// left for a later run (see SettleDelay).
+func (s *Store) RollupTelemetry(settled time.Time, batch int) (int, error) {
+	if batch <= 0 {
+		batch = 5000
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	lastID, err := s.watermark(tx, rollupWatermark)
+	if err != nil {
+		return 0, err
+	}
+
+	rows, err := tx.Query(s.dialect.rebind(
+		`SELECT id, scooter_id, ts, lat, lng, state, data, received_at FROM telemetry_history
+		 WHERE id > ? ORDER BY id LIMIT ?`), lastID, batch)
+	if err != nil {
+		return 0, err
+	}
+	buckets := make(map[rollupKey]*rollupBucket)
+	n := 0
+	for rows.Next() {
+		var (
+			id, ts    int64
+			scooterID string
+			lat, lng  sql.NullFloat64
+			state     sql.NullString
+			blob      string
+			received  sql.NullInt64
+		)
+		if err := rows.Scan(&id, &scooterID, &ts, &lat, &lng, &state, &blob, &received); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if unsettled(received, settled) {
+			break
+		}
+		n++
+		lastID = max(lastID, id)
+
+		var data map[string]any
+		_ = json.Unmarshal([]byte(blob), &data)
+		leaves := make(map[string]float64)
+		numericLeaves(data, "", leaves)
+
+		for _, tier := range rollupTiers {
+			width := tier.width.Milliseconds()
+			key := rollupKey{scooterID, tier.name, ts - ts%width}
+			b, ok := buckets[key]
+			if !ok {
+				b = &rollupBucket{leaves: make(map[string]*leafStats)}
+				buckets[key] = b
+			}
+			b.addSample(ts, lat, lng, state, leaves)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return 0, err
+	}
+	rows.Close()
+	if n == 0 {
+		return 0, nil
+	}
+
+	for key, b := range buckets {
+		existing, ok, err := s.loadRollup(tx, key)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			b.merge(existing)
+		}
+		if err := s.saveRollup(tx, key, b); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := s.setWatermark(tx, rollupWatermark, lastID); err != nil {
+		return 0, err
+	}
+	return n, tx.Commit()
+}
+
+func (s *Store) loadRollup(tx *sql.Tx, key rollupKey) (*rollupBucket, bool, error) {
+	b := &rollupBucket{}
+	var blob string
+	err := tx.QueryRow(s.dialect.rebind(
+		`SELECT samples, last_ts, lat, lng, state, data FROM telemetry_rollups
+		 WHERE scooter_id=? AND resolution=? AND bucket=?`),
+		key.scooterID, key.resolution, key.bucket,
+	).Scan(&b.samples, &b.lastTS, &b.lat, &b.lng, &b.state, &blob)
+	if err == sql.ErrNoRows {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if err := json.Unmarshal([]byte(blob), &b.leaves); err != nil {
+		return nil, false, fmt.Errorf("decode rollup %v: %w", key, err)
+	}
+	if b.leaves == nil {
+		b.leaves = make(map[string]*leafStats)
+	}
+	return b, true, nil
+}
+
+func (s *Store) saveRollup(tx *sql.Tx, key rollupKey, b *rollupBucket) error {
+	blob, err := json.Marshal(b.leaves)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(s.dialect.rebind(
+		`INSERT INTO telemetry_rollups(scooter_id, resolution, bucket, samples, last_ts, lat, lng, state, data)
+		 VALUES(?,?,?,?,?,?,?,?,?)
+		 ON CONFLICT(scooter_id, resolution, bucket) DO UPDATE SET
+		   samples=excluded.samples, last_ts=excluded.last_ts, lat=excluded.lat,
+		   lng=excluded.lng, state=excluded.state, data=excluded.data`),
+		key.scooterID, key.resolution, key.bucket, b.samples, b.lastTS, b.lat, b.lng, b.state, string(blob),
+	)
+	return err
+}
+
+// RollupRow is one downsampled bucket. Timestamp is the bucket start; Data
+// mirrors the raw snapshot layout with each numeric leaf replaced by its
+// bucket average, and Min/Max carry the extremes in the same layout. Lat, Lng
+// and State are from the newest sample in the bucket.
+type RollupRow struct {
+	Timestamp  time.Time      `json:"timestamp"`
+	Resolution string         `json:"resolution"`
+	Samples    int64          `json:"samples"`
+	Lat        *float64       `json:"lat,omitempty"`
+	Lng        *float64       `json:"lng,omitempty"`
+	Speed      *float64       `json:"speed,omitempty"`
+	State      string         `json:"state,omitempty"`
+	Data       map[string]any `json:"data"`
+	Min        map[string]any `json:"min"`
+	Max        map[string]any `json:"max"`
+}
+
+// QueryRollups returns buckets of the given resolution for a scooter whose
+// start lies within [from, to], newest first, up to limit rows.
+func (s *Store) QueryRollups(scooterID, resolution string, from, to time.Time, limit int) ([]RollupRow, error) {
+	if limit <= 0 {
+		limit = 1000
+	}
+	var width time.Duration
+	for _, tier := range rollupTiers {
+		if tier.name == resolution {
+			width = tier.width
+		}
+	}
+	if width == 0 {
+		return nil, fmt.Errorf("unknown rollup resolution %q", resolution)
+	}
+	// Include the bucket that contains from.
+	fromMs := from.UnixMilli()
+	fromMs -= fromMs % width.Milliseconds()
+
+	rows, err := s.query(
+		`SELECT bucket, samples, lat, lng, state, data FROM telemetry_rollups
+		 WHERE scooter_id=? AND resolution=? AND bucket BETWEEN ? AND ?
+		 ORDER BY bucket DESC LIMIT ?`,
+		scooterID, resolution, fromMs, to.UnixMilli(), limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []RollupRow
+	for rows.Next() {
+		var (
+			bucket, samples int64
+			lat, lng        sql.NullFloat64
+			state           sql.NullString
+			blob            string
+		)
+		if err := rows.Scan(&bucket, &samples, &lat, &lng, &state, &blob); err != nil {
+			return nil, err
+		}
+		row := RollupRow{
+			Timestamp:  time.UnixMilli(bucket).UTC(),
+			Resolution: resolution,
+			Samples:    samples,
+			Data:       make(map[string]any),
+			Min:        make(map[string]any),
+			Max:        make(map[string]any),
+		}
+		if lat.Valid && lng.Valid {
+			la, ln := lat.Float64, lng.Float64
+			row.Lat, row.Lng = &la, &ln
+		}
+		if state.Valid {
+			row.State = state.String
+		}
+		var leaves map[string]leafStats
+		_ = json.Unmarshal([]byte(blob), &leaves)
+		paths := make([]string, 0, len(leaves))
+		for p := range leaves {
+			paths = append(paths, p)
+		}
+		sort.Strings(paths)
+		for _, p := range paths {
+			st := leaves[p]
+			if st.N == 0 {
+				continue
+			}
+			avg := st.Sum / float64(st.N)
+			setPath(row.Data, p, avg)
+			setPath(row.Min, p, st.Min)
+			setPath(row.Max, p, st.Max)
+			if p == "engine-ecu.speed" {
+				row.Speed = &avg
+			}
+		}
+		out = append(out, row)
+	}
+	return out, rows.Err()
+}
+
+// numericLeaves flattens every leaf of data that parses as a number into out,
+// keyed by dotted path. Telemetry leaves are usually numeric strings.
+func numericLeaves(data map[string]any, prefix string, out map[string]float64) {
+	for k, v := range data {
+		path := k
+		if prefix != "" {
+			path = prefix + "." + k
+		}
+		switch x := v.(type) {
+		case map[string]any:
+			numericLeaves(x, path, out)
+		case float64:
+			out[path] = x
+		case string:
+			if f, err := strconv.ParseFloat(strings.TrimSpace(x), 64); err == nil && !math.IsNaN(f) && !math.IsInf(f, 0) {
+				out[path] = f
+			}
+		}
+	}
+}
+
+// setPath stores v in m at a dotted path, creating intermediate maps.
+func setPath(m map[string]any, path string, v any) {
+	parts := strings.Split(path, ".")
+	for _, p := range parts[:len(parts)-1] {
+		next, ok := m[p].(map[string]any)
+		if !ok {
+			next = make(map[string]any)
+			m[p] = next
+		}
+		m = next
+	}
+	m[parts[len(parts)-1]] = v
+}
@@ -0,0 +1,121 @@
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAutoResolution(t *testing.T) {
+	cases := []struct {
+		span time.Duration
+		want string
+	}{
+		{6 * time.Hour, Resolution1m},
+		{24 * time.Hour, Resolution15m},
+		{30 * 24 * time.Hour, Resolution1h},
+		{365 * 24 * time.Hour, Resolution1h},
+	}
+	for _, c := range cases {
+		if got := AutoResolution(c.span, 1000); got != c.want {
+			t.Errorf("AutoResolution(%v) = %s, want %s", c.span, got, c.want)
+		}
+	}
+}
+
+func TestResolveAuto(t *testing.T) {
+	s := openTemp(t)
+	base := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+	for i := range 5 {
+		if err := s.InsertTelemetry("VIN1", base.Add(time.Duration(i)*time.Second), snapshot("10", "80")); err != nil {
+			t.Fatalf("insert: %v", err)
+		}
+	}
+
+	if got, err := s.ResolveAuto("VIN1", base, base.Add(time.Hour), 5); err != nil || got != ResolutionRaw {
+		t.Errorf("5 rows, limit 5 = %s, %v; want raw", got, err)
+	}
+	// More snapshots than the limit would truncate a raw read.
+	if got, err := s.ResolveAuto("VIN1", base, base.Add(time.Hour), 4); err != nil || got != Resolution15m {
+		t.Errorf("5 rows, limit 4 = %s, %v; want 15m", got, err)
+	}
+}
+
+func snapshot(speed, charge string) map[string]any {
+	return map[string]any{
+		"engine-ecu": map[string]any{"speed": speed},
+		"battery:0":  map[string]any{"charge": charge, "state": "active"},
+		"vehicle":    map[string]any{"state": "ready-to-drive"},
+	}
+}
+
+func TestRollupTelemetry(t *testing.T) {
+	s := openTemp(t)
+	base := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	for i, sp := range []string{"10", "20", "30"} {
+		if err := s.InsertTelemetry("VIN1", base.Add(time.Duration(i)*10*time.Second), snapshot(sp, "80")); err != nil {
+			t.Fatalf("insert: %v", err)
+		}
+	}
+	if err := s.InsertTelemetry("VIN1", base.Add(2*time.Minute), snapshot("40", "79")); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+
+	// Rows still within the settle delay are held back.
+	if n, err := s.RollupTelemetry(time.Now().Add(-time.Minute), 0); err != nil || n != 0 {
+		t.Fatalf("unsettled rollup = %d, %v; want 0", n, err)
+	}
+
+	n, err := s.RollupTelemetry(time.Now(), 0)
+	if err != nil || n != 4 {
+		t.Fatalf("rollup = %d, %v; want 4", n, err)
+	}
+
+	rows, err := s.QueryRollups("VIN1", Resolution1m, base, base.Add(time.Hour), 10)
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("got %d 1m buckets, want 2", len(rows))
+	}
+	first := rows[1] // newest first
+	if first.Samples != 3 || first.Speed == nil || *first.Speed != 20 {
+		t.Fatalf("first bucket samples=%d speed=%v, want 3 and 20", first.Samples, first.Speed)
+	}
+	if got := first.Min["engine-ecu"].(map[string]any)["speed"]; got != 10.0 {
+		t.Errorf("min speed = %v, want 10", got)
+	}
+	if got := first.Max["engine-ecu"].(map[string]any)["speed"]; got != 30.0 {
+		t.Errorf("max speed = %v, want 30", got)
+	}
+	if _, ok := first.Data["battery:0"].(map[string]any)["state"]; ok {
+		t.Error("non-numeric leaf should not be aggregated")
+	}
+	if first.State != "ready-to-drive" {
+		t.Errorf("state = %q", first.State)
+	}
+
+	hourly, err := s.QueryRollups("VIN1", Resolution1h, base, base.Add(time.Hour), 10)
+	if err != nil || len(hourly) != 1 || hourly[0].Samples != 4 {
+		t.Fatalf("hourly = %+v, %v; want one bucket of 4", hourly, err)
+	}
+
+	// A late snapshot for an already rolled-up bucket is merged in.
+	if err := s.InsertTelemetry("VIN1", base.Add(5*time.Second), snapshot("60", "80")); err != nil {
+		t.Fatalf("insert late: %v", err)
+	}
+	if n, err := s.RollupTelemetry(time.Now(), 0); err != nil || n != 1 {
+		t.Fatalf("second rollup = %d, %v; want 1", n, err)
+	}
+	rows, _ = s.QueryRollups("VIN1", Resolution1m, base, base.Add(time.Minute-time.Millisecond), 10)
+	if len(rows) != 1 || rows[0].Samples != 4 || *rows[0].Speed != 30 {
+		t.Fatalf("merged bucket = %+v", rows)
+	}
+	if got := rows[0].Max["engine-ecu"].(map[string]any)["speed"]; got != 60.0 {
+		t.Errorf("merged max speed = %v, want 60", got)
+	}
+
+	if n, err := s.RollupTelemetry(time.Now(), 0); err != nil || n != 0 {
+		t.Fatalf("idle rollup = %d, %v; want 0", n, err)
+	}
+}
@@ -0,0 +1,264 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// Schedule run outcomes, one per targeted scooter.
+const (
+	RunSent   = "sent"   // delivered to an online scooter
+	RunQueued = "queued" // scooter offline; queued for its reconnect
+	RunFailed = "failed" // not delivered, see Error
+)
+
+// Schedule is a command job. One-shot jobs set At and are disabled after
+// they run; recurring jobs set Cron, evaluated in Timezone. A job targets
+// the listed scooters, groups and tags, or every scooter when it lists none;
+// Condition further narrows the targets to scooters whose state matches
+// when the job fires.
+type Schedule struct {
+	ID        int64          `json:"id"`
+	Name      string         `json:"name"`
+	Command   string         `json:"command"`
+	Params    map[string]any `json:"params,omitempty"`
+	Scooters  []string       `json:"scooters,omitempty"`
+	Groups    []string       `json:"groups,omitempty"`
+	Tags      []string       `json:"tags,omitempty"`
+	All       bool           `json:"all,omitempty"` // the whole fleet instead of scooters, groups and tags
+	Condition string         `json:"condition,omitempty"`
+	At        *time.Time     `json:"at,omitempty"`
+	Cron      string         `json:"cron,omitempty"`
+	Timezone  string         `json:"timezone,omitempty"`
+	Queue     bool           `json:"queue"`
+	TTL       string         `json:"ttl,omitempty"`
+	Enabled   bool           `json:"enabled"`
+	NextRun   *time.Time     `json:"next_run,omitempty"`
+	LastRun   *time.Time     `json:"last_run,omitempty"`
+	CreatedBy string         `json:"created_by,omitempty"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+}
+
+// ScheduleRun records what one firing of a schedule did for one scooter. The
+// command's outcome is tracked under RequestID.
+type ScheduleRun struct {
+	ID         int64     `json:"id"`
+	ScheduleID int64     `json:"schedule_id"`
+	ScooterID  string    `json:"scooter_id"`
+	RequestID  string    `json:"request_id,omitempty"`
+	Status     string    `json:"status"`
+	Error      string    `json:"error,omitempty"`
+	RanAt      time.Time `json:"ran_at"`
+}
+
+const scheduleColumns = `id, name, command, params, target_scooters, target_groups, target_tags, target_all, condition,
+	run_at, cron, timezone, queue, ttl, enabled, next_run, last_run, created_by, created_at, updated_at`
+
+// ListSchedules returns all schedules ordered by id.
+func (s *Store) ListSchedules() ([]Schedule, error) {
+	return s.querySchedules(`SELECT ` + scheduleColumns + ` FROM schedules ORDER BY id`)
+}
+
+// GetSchedule returns a single schedule.
+func (s *Store) GetSchedule(id int64) (*Schedule, bool, error) {
+	list, err := s.querySchedules(`SELECT `+scheduleColumns+` FROM schedules WHERE id=?`, id)
+	if err != nil || len(list) == 0 {
+		return nil, false, err
+	}
+	return &list[0], true, nil
+}
+
+// DueSchedules returns enabled schedules whose next run is at or before now.
+func (s *Store) DueSchedules(now time.Time) ([]Schedule, error) {
+	return s.querySchedules(
+		`SELECT `+scheduleColumns+` FROM schedules
+		 WHERE enabled=1 AND next_run IS NOT NULL AND next_run<=? ORDER BY next_run, id`,
+		now.UnixMilli(),
+	)
+}
+
+// CreateSchedule inserts sc and sets its ID and timestamps.
+func (s *Store) CreateSchedule(sc *Schedule) error {
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	err := s.queryRow(
+		`INSERT INTO schedules(name, command, params, target_scooters, target_groups, target_tags, target_all, condition,
+		 run_at, cron, timezone, queue, ttl, enabled, next_run, created_by, created_at, updated_at)
+		 VALUES(?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?) RETURNING id`,
+		sc.Name, sc.Command, marshalMap(sc.Params), marshalStrings(sc.Scooters), marshalStrings(sc.Groups),
+		marshalStrings(sc.Tags), boolInt(sc.All), nullString(sc.Condition),
+		unixMilliPtr(sc.At), nullString(sc.Cron), nullString(sc.Timezone), boolInt(sc.Queue), nullString(sc.TTL),
+		boolInt(sc.Enabled), unixMilliPtr(sc.NextRun), nullString(sc.CreatedBy), now.UnixMilli(), now.UnixMilli(),
+	).Scan(&sc.ID)
+	if err != nil {
+		return err
+	}
+	sc.CreatedAt, sc.UpdatedAt = now, now
+	return nil
+}
+
+// UpdateSchedule replaces the definition of the schedule with sc's ID,
+// including its next run. It returns false if no such schedule exists.
+func (s *Store) UpdateSchedule(sc *Schedule) (bool, error) {
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	res, err := s.exec(
+		`UPDATE schedules SET name=?, command=?, params=?, target_scooters=?, target_groups=?, target_tags=?, target_all=?,
+		 condition=?, run_at=?, cron=?, timezone=?, queue=?, ttl=?, enabled=?, next_run=?, updated_at=? WHERE id=?`,
+		sc.Name, sc.Command, marshalMap(sc.Params), marshalStrings(sc.Scooters), marshalStrings(sc.Groups),
+		marshalStrings(sc.Tags), boolInt(sc.All), nullString(sc.Condition),
+		unixMilliPtr(sc.At), nullString(sc.Cron), nullString(sc.Timezone), boolInt(sc.Queue), nullString(sc.TTL),
+		boolInt(sc.Enabled), unixMilliPtr(sc.NextRun), now.UnixMilli(), sc.ID,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 {
+		return false, err
+	}
+	sc.UpdatedAt = now
+	return true, nil
+}
+
+// DeleteSchedule removes a schedule and its run history. It returns false if
+// no such schedule exists.
+func (s *Store) DeleteSchedule(id int64) (bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(s.dialect.rebind(`DELETE FROM schedules WHERE id=?`), id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 {
+		return false, err
+	}
+	if _, err := tx.Exec(s.dialect.rebind(`DELETE FROM schedule_runs WHERE schedule_id=?`), id); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// ClaimScheduleRun moves a due schedule on to its next run (nil disables it)
+// and records ranAt as its last run. It returns false if the schedule's next
+// run is no longer due, i.e. another server sharing the database claimed it
+// or it was edited in the meantime.
+func (s *Store) ClaimScheduleRun(id int64, due time.Time, next *time.Time, ranAt time.Time) (bool, error) {
+	enabled := 1
+	if next == nil {
+		enabled = 0
+	}
+	res, err := s.exec(
+		`UPDATE schedules SET next_run=?, last_run=?, enabled=? WHERE id=? AND enabled=1 AND next_run=?`,
+		unixMilliPtr(next), ranAt.UnixMilli(), enabled, id, due.UnixMilli(),
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// InsertScheduleRun records one scooter's outcome of a schedule firing.
+func (s *Store) InsertScheduleRun(r *ScheduleRun) error {
+	return s.queryRow(
+		`INSERT INTO schedule_runs(schedule_id, scooter_id, request_id, status, error, ran_at)
+		 VALUES(?,?,?,?,?,?) RETURNING id`,
+		r.ScheduleID, r.ScooterID, nullString(r.RequestID), r.Status, nullString(r.Error), r.RanAt.UnixMilli(),
+	).Scan(&r.ID)
+}
+
+// ScheduleRuns returns a schedule's runs newest first, up to limit rows.
+func (s *Store) ScheduleRuns(scheduleID int64, limit int) ([]ScheduleRun, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+	rows, err := s.query(
+		`SELECT id, schedule_id, scooter_id, request_id, status, error, ran_at FROM schedule_runs
+		 WHERE schedule_id=? ORDER BY ran_at DESC, id DESC LIMIT ?`,
+		scheduleID, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []ScheduleRun
+	for rows.Next() {
+		var (
+			r                  ScheduleRun
+			requestID, errText sql.NullString
+			ranMs              int64
+		)
+		if err := rows.Scan(&r.ID, &r.ScheduleID, &r.ScooterID, &requestID, &r.Status, &errText, &ranMs); err != nil {
+			return nil, err
+		}
+		r.RequestID, r.Error = requestID.String, errText.String
+		r.RanAt = time.UnixMilli(ranMs).UTC()
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+func (s *Store) querySchedules(query string, args ...any) ([]Schedule, error) {
+	rows, err := s.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []Schedule
+	for rows.Next() {
+		var (
+			sc                             Schedule
+			params, scooters, groups, tags sql.NullString
+			condition, cron, timezone, ttl sql.NullString
+			createdBy                      sql.NullString
+			runAt, nextRun, lastRun        sql.NullInt64
+			all, queue, enabled            int
+			createdMs, updatedMs           int64
+		)
+		if err := rows.Scan(&sc.ID, &sc.Name, &sc.Command, &params, &scooters, &groups, &tags, &all, &condition, &runAt,
+			&cron, &timezone, &queue, &ttl, &enabled, &nextRun, &lastRun, &createdBy, &createdMs, &updatedMs); err != nil {
+			return nil, err
+		}
+		if params.Valid {
+			_ = json.Unmarshal([]byte(params.String), &sc.Params)
+		}
+		if scooters.Valid {
+			_ = json.Unmarshal([]byte(scooters.String), &sc.Scooters)
+		}
+		if groups.Valid {
+			_ = json.Unmarshal([]byte(groups.String), &sc.Groups)
+		}
+		if tags.Valid {
+			_ = json.Unmarshal([]byte(tags.String), &sc.Tags)
+		}
+		sc.Condition, sc.Cron, sc.Timezone, sc.TTL, sc.CreatedBy = condition.String, cron.String, timezone.String, ttl.String, createdBy.String
+		sc.At, sc.NextRun, sc.LastRun = timePtr(runAt), timePtr(nextRun), timePtr(lastRun)
+		sc.All, sc.Queue, sc.Enabled = all != 0, queue != 0, enabled != 0
+		sc.CreatedAt = time.UnixMilli(createdMs).UTC()
+		sc.UpdatedAt = time.UnixMilli(updatedMs).UTC()
+		out = append(out, sc)
+	}
+	return out, rows.Err()
+}
+
+func unixMilliPtr(t *time.Time) any {
+	if t == nil {
+		return nil
+	}
+	return t.UnixMilli()
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,85 @@
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduleLifecycle(t *testing.T) {
+	s := openTemp(t)
+
+	due := time.Now().Add(-time.Minute).UTC().Truncate(time.Millisecond)
+	sc := &Schedule{
+		Name:     "nightly lock",
+		Command:  "lock",
+		Groups:   []string{"depot-a"},
+		Cron:     "0 2 * * *",
+		Timezone: "Europe/Berlin",
+		Queue:    true,
+		Enabled:  true,
+		NextRun:  &due,
+	}
+	if err := s.CreateSchedule(sc); err != nil {
+		t.Fatal(err)
+	}
+	if sc.ID == 0 || sc.CreatedAt.IsZero() {
+		t.Fatalf("create did not set id/timestamps: %+v", sc)
+	}
+
+	got, ok, err := s.GetSchedule(sc.ID)
+	if err != nil || !ok {
+		t.Fatalf("get: %v %v", ok, err)
+	}
+	if got.Groups[0] != "depot-a" || got.Scooters != nil || got.All || !got.Queue || got.At != nil || !got.NextRun.Equal(due) {
+		t.Errorf("round trip = %+v", got)
+	}
+
+	list, err := s.DueSchedules(time.Now())
+	if err != nil || len(list) != 1 {
+		t.Fatalf("due = %d, %v", len(list), err)
+	}
+
+	next := due.Add(24 * time.Hour)
+	now := time.Now()
+	if ok, err := s.ClaimScheduleRun(sc.ID, due, &next, now); !ok || err != nil {
+		t.Fatalf("claim: %v %v", ok, err)
+	}
+	if ok, _ := s.ClaimScheduleRun(sc.ID, due, &next, now); ok {
+		t.Error("second claim of the same run succeeded")
+	}
+	if list, _ := s.DueSchedules(time.Now()); len(list) != 0 {
+		t.Errorf("still due after claim: %+v", list)
+	}
+
+	for _, r := range []ScheduleRun{
+		{ScheduleID: sc.ID, ScooterID: "VIN1", RequestID: "r1", Status: RunSent, RanAt: now},
+		{ScheduleID: sc.ID, ScooterID: "VIN2", Status: RunFailed, Error: "scooter not connected", RanAt: now},
+	} {
+		if err := s.InsertScheduleRun(&r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	runs, err := s.ScheduleRuns(sc.ID, 10)
+	if err != nil || len(runs) != 2 || runs[0].ScooterID != "VIN2" || runs[1].RequestID != "r1" {
+		t.Fatalf("runs = %+v, %v", runs, err)
+	}
+
+	// The last run of a job disables it.
+	if ok, err := s.ClaimScheduleRun(sc.ID, next, nil, now); !ok || err != nil {
+		t.Fatalf("final claim: %v %v", ok, err)
+	}
+	got, _, _ = s.GetSchedule(sc.ID)
+	if got.Enabled || got.NextRun != nil || got.LastRun == nil {
+		t.Errorf("after final run = %+v", got)
+	}
+
+	if ok, err := s.DeleteSchedule(sc.ID); !ok || err != nil {
+		t.Fatalf("delete: %v %v", ok, err)
+	}
+	if n := countRows(t, s, "schedule_runs"); n != 0 {
+		t.Errorf("schedule_runs rows after delete = %d", n)
+	}
+	if ok, _ := s.UpdateSchedule(sc); ok {
+		t.Error("update of deleted schedule reported success")
+	}
+}
@@ -0,0 +1,70 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Session is a persisted login session. Only a hash of its token is stored.
+type Session struct {
+	ID        string
+	TokenHash string
+	Username  string
+	UserAgent string
+	SourceIP  string
+	Created   time.Time
+	LastSeen  time.Time
+}
+
+// InsertSession persists a new session.
+func (s *Store) InsertSession(sess *Session) error {
+	_, err := s.exec(
+		`INSERT INTO sessions(id, token_hash, username, user_agent, source_ip, created_at, last_seen)
+		 VALUES(?,?,?,?,?,?,?)`,
+		sess.ID, sess.TokenHash, sess.Username, nullString(sess.UserAgent), nullString(sess.SourceIP),
+		sess.Created.UnixMilli(), sess.LastSeen.UnixMilli(),
+	)
+	return err
+}
+
+// ListSessions returns every persisted session, oldest first.
+func (s *Store) ListSessions() ([]Session, error) {
+	rows, err := s.query(`SELECT id, token_hash, username, user_agent, source_ip, created_at, last_seen
+		FROM sessions ORDER BY created_at, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []Session
+	for rows.Next() {
+		var (
+			sess                Session
+			userAgent, sourceIP sql.NullString
+			created, lastSeen   int64
+		)
+		if err := rows.Scan(&sess.ID, &sess.TokenHash, &sess.Username, &userAgent, &sourceIP, &created, &lastSeen); err != nil {
+			return nil, err
+		}
+		sess.UserAgent, sess.SourceIP = userAgent.String, sourceIP.String
+		sess.Created, sess.LastSeen = time.UnixMilli(created).UTC(), time.UnixMilli(lastSeen).UTC()
+		out = append(out, sess)
+	}
+	return out, rows.Err()
+}
+
+// TouchSession records that a session was used at lastSeen.
+func (s *Store) TouchSession(id string, lastSeen time.Time) error {
+	_, err := s.exec(`UPDATE sessions SET last_seen=? WHERE id=?`, lastSeen.UnixMilli(), id)
+	return err
+}
+
+// DeleteSessions deletes sessions by ID.
+func (s *Store) DeleteSessions(ids ...string) error {
+	for _, id := range ids {
+		if _, err := s.exec(`DELETE FROM sessions WHERE id=?`, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSessions(t *testing.T) {
+	s := openTemp(t)
+
+	now := time.Now().Truncate(time.Millisecond)
+	for i, sess := range []Session{
+		{ID: "a", TokenHash: "ha", Username: "alice", UserAgent: "Firefox", SourceIP: "203.0.113.7"},
+		{ID: "b", TokenHash: "hb", Username: "alice"},
+		{ID: "c", TokenHash: "hc", Username: "bob"},
+	} {
+		sess.Created = now.Add(time.Duration(i) * time.Minute)
+		sess.LastSeen = sess.Created
+		if err := s.InsertSession(&sess); err != nil {
+			t.Fatalf("insert %s: %v", sess.ID, err)
+		}
+	}
+	if err := s.InsertSession(&Session{ID: "d", TokenHash: "ha", Username: "eve", Created: now, LastSeen: now}); err == nil {
+		t.Error("duplicate token hash accepted")
+	}
+
+	if err := s.TouchSession("b", now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteSessions("c"); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := s.ListSessions()
+	if err != nil || len(list) != 2 {
+		t.Fatalf("list = %+v, %v", list, err)
+	}
+	if a := list[0]; a.ID != "a" || a.UserAgent != "Firefox" || a.SourceIP != "203.0.113.7" || !a.Created.Equal(now) {
+		t.Errorf("a = %+v", a)
+	}
+	if b := list[1]; !b.LastSeen.Equal(now.Add(time.Hour)) || b.UserAgent != "" {
+		t.Errorf("b = %+v", b)
+	}
+}
@@ -0,0 +1,41 @@
+package store
+
+import "time"
+
+// sqliteStorage is the SQLite Storage. The database has a single connection,
+// so a transaction is enough to claim queued commands atomically.
+type sqliteStorage struct{ *Store }
+
+// DequeueQueued returns all non-expired queued commands for a scooter and marks
+// them sent, with their first attempt timing out at deadline (zero for
+// never). Intended to be called on reconnect.
+func (s sqliteStorage) DequeueQueued(scooterID string, deadline time.Time) ([]QueuedCommand, error) {
+	now := time.Now().UnixMilli()
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(
+		`SELECT request_id, command, params FROM commands
+		 WHERE scooter_id=? AND status=? AND (expires_at=0 OR expires_at>?)
+		 ORDER BY enqueued_at ASC`,
+		scooterID, StatusQueued, now,
+	)
+	if err != nil {
+		return nil, err
+	}
+	out, err := scanQueued(rows)
+	if err != nil || len(out) == 0 {
+		return nil, err
+	}
+	if _, err := tx.Exec(
+		`UPDATE commands SET status=?, sent_at=?, attempts=1, deadline=?
+		 WHERE scooter_id=? AND status=? AND (expires_at=0 OR expires_at>?)`,
+		StatusSent, now, unixMilliOrZero(deadline), scooterID, StatusQueued, now,
+	); err != nil {
+		return nil, err
+	}
+	return out, tx.Commit()
+}
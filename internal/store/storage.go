@@ -0,0 +1,47 @@
+package store
+
+import "time"
+
+// TelemetryStorage persists telemetry snapshots.
+type TelemetryStorage interface {
+	InsertTelemetry(scooterID string, ts time.Time, data map[string]any) error
+	QueryTelemetry(scooterID string, from, to time.Time, limit int) ([]TelemetryRow, error)
+}
+
+// EventStorage persists scooter events.
+type EventStorage interface {
+	InsertEvent(scooterID string, ts time.Time, event string, data map[string]any) error
+	QueryEvents(scooterID string, from, to time.Time, limit int) ([]EventRow, error)
+}
+
+// CommandStorage persists command history and the offline delivery queue.
+type CommandStorage interface {
+	RecordSent(requestID, scooterID, command string, params map[string]any, deadline, expires time.Time) error
+	Enqueue(requestID, scooterID, command string, params map[string]any, ttl time.Duration) error
+	UpdateResult(requestID, status string, result map[string]any, errMsg string) (bool, error)
+	MarkRunning(requestID string, deadline time.Time) error
+	DequeueQueued(scooterID string, deadline time.Time) ([]QueuedCommand, error)
+	ExpireStale() (int64, error)
+	OverdueCommands(now time.Time) ([]PendingCommand, error)
+	UnackedCommands(scooterID string, sentBefore time.Time) ([]PendingCommand, error)
+	MarkResent(requestID string, deadline time.Time) error
+	MarkTimedOut(requestID, errMsg string) (bool, error)
+	QueuedCount() (int, error)
+	GetCommand(requestID string) (*CommandRecord, bool, error)
+	QueryCommands(scooterID string, limit int) ([]CommandRecord, error)
+}
+
+// Storage is what the telemetry, event and command consumers depend on. Each
+// backend provides its own implementation (see sqlite.go and postgres.go);
+// Store.Storage returns the one for the open database.
+type Storage interface {
+	TelemetryStorage
+	EventStorage
+	CommandStorage
+
+	// Backend returns the name of the database backend in use.
+	Backend() string
+}
+
+// Storage returns the backend-specific telemetry, event and command storage.
+func (s *Store) Storage() Storage { return s.storage }
@@ -76,7 +76,7 @@ func TestCommandQueueReplay(t *testing.T) {
 		t.Fatalf("enqueue 2: %v", err)
 	}
 
-	queued, err := s.DequeueQueued("VIN1")
+	queued, err := s.Storage().DequeueQueued("VIN1", time.Time{})
 	if err != nil {
 		t.Fatalf("dequeue: %v", err)
 	}
@@ -91,13 +91,13 @@ func TestCommandQueueReplay(t *testing.T) {
 	}
 
 	// Second dequeue returns nothing (already marked sent).
-	again, _ := s.DequeueQueued("VIN1")
+	again, _ := s.Storage().DequeueQueued("VIN1", time.Time{})
 	if len(again) != 0 {
 		t.Errorf("re-dequeue returned %d, want 0", len(again))
 	}
 
 	// Ack the first command.
-	if err := s.UpdateResult("req-1", StatusSuccess, map[string]any{"ok": true}, ""); err != nil {
+	if _, err := s.UpdateResult("req-1", StatusSuccess, map[string]any{"ok": true}, ""); err != nil {
 		t.Fatalf("update result: %v", err)
 	}
 	rec, ok, err := s.GetCommand("req-1")
@@ -126,8 +126,125 @@ func TestExpireStale(t *testing.T) {
 	if n != 1 {
 		t.Errorf("expired %d, want 1", n)
 	}
-	queued, _ := s.DequeueQueued("VIN1")
+	queued, _ := s.Storage().DequeueQueued("VIN1", time.Time{})
 	if len(queued) != 0 {
 		t.Errorf("expired command still deliverable")
 	}
 }
+
+func TestObserveWrites(t *testing.T) {
+	s := openTemp(t)
+	type write struct{ statement, table string }
+	var writes []write
+	s.ObserveWrites(func(statement, table string, d time.Duration) {
+		writes = append(writes, write{statement, table})
+	})
+
+	if err := s.Enqueue("req-1", "VIN1", "lock", nil, time.Hour); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	if n, err := s.QueuedCount(); err != nil || n != 1 {
+		t.Errorf("queued count = %d, %v; want 1", n, err)
+	}
+	if _, err := s.ExpireStale(); err != nil {
+		t.Fatalf("expire: %v", err)
+	}
+	if _, err := s.PruneTelemetryBefore(time.Now()); err != nil {
+		t.Fatalf("prune: %v", err)
+	}
+
+	want := []write{{"insert", "commands"}, {"update", "commands"}, {"delete", "telemetry_history"}}
+	if len(writes) != len(want) {
+		t.Fatalf("observed %+v, want %+v", writes, want)
+	}
+	for i := range want {
+		if writes[i] != want[i] {
+			t.Errorf("write %d = %+v, want %+v", i, writes[i], want[i])
+		}
+	}
+}
+
+func TestCommandLifecycle(t *testing.T) {
+	s := openTemp(t)
+	now := time.Now()
+
+	// req-1 is overdue, req-2 is not, req-3 gets acknowledged and is still
+	// running within its run timeout, req-4 has run past it.
+	if err := s.RecordSent("req-1", "VIN1", "locate", nil, now.Add(-time.Second), time.Time{}); err != nil {
+		t.Fatalf("record sent: %v", err)
+	}
+	if err := s.RecordSent("req-2", "VIN1", "lock", nil, now.Add(time.Minute), now.Add(time.Hour)); err != nil {
+		t.Fatalf("record sent: %v", err)
+	}
+	if err := s.RecordSent("req-3", "VIN1", "honk", nil, now.Add(-time.Second), time.Time{}); err != nil {
+		t.Fatalf("record sent: %v", err)
+	}
+	if err := s.MarkRunning("req-3", now.Add(time.Minute)); err != nil {
+		t.Fatalf("mark running: %v", err)
+	}
+	if err := s.RecordSent("req-4", "VIN1", "navigate", nil, now.Add(-time.Second), time.Time{}); err != nil {
+		t.Fatalf("record sent: %v", err)
+	}
+	if err := s.MarkRunning("req-4", now.Add(-time.Second)); err != nil {
+		t.Fatalf("mark running: %v", err)
+	}
+
+	overdue, err := s.OverdueCommands(now)
+	if err != nil {
+		t.Fatalf("overdue: %v", err)
+	}
+	byID := make(map[string]PendingCommand)
+	for _, pc := range overdue {
+		byID[pc.RequestID] = pc
+	}
+	if len(overdue) != 2 || byID["req-1"].Attempts != 1 || byID["req-1"].Status != StatusSent || byID["req-4"].Status != StatusRunning {
+		t.Fatalf("overdue = %+v, want req-1 after 1 attempt and running req-4", overdue)
+	}
+	if ok, err := s.MarkTimedOut("req-4", "no progress"); err != nil || !ok {
+		t.Fatalf("time out running: ok=%v err=%v", ok, err)
+	}
+
+	if err := s.MarkResent("req-1", now.Add(time.Minute)); err != nil {
+		t.Fatalf("resend: %v", err)
+	}
+	if overdue, _ = s.OverdueCommands(now); len(overdue) != 0 {
+		t.Errorf("resent command still overdue: %+v", overdue)
+	}
+	rec, _, _ := s.GetCommand("req-1")
+	if rec.Attempts != 2 || rec.Status != StatusSent {
+		t.Errorf("after resend: %+v", rec)
+	}
+
+	unacked, err := s.UnackedCommands("VIN1", time.Now().Add(time.Second))
+	if err != nil {
+		t.Fatalf("unacked: %v", err)
+	}
+	if len(unacked) != 2 || unacked[1].RequestID != "req-2" || unacked[1].Expires.IsZero() {
+		t.Errorf("unacked = %+v, want req-1 and req-2", unacked)
+	}
+	if unacked, _ = s.UnackedCommands("VIN1", now.Add(-time.Minute)); len(unacked) != 0 {
+		t.Errorf("commands sent on the new connection returned: %+v", unacked)
+	}
+
+	if ok, err := s.MarkTimedOut("req-1", "no response"); err != nil || !ok {
+		t.Fatalf("time out: ok=%v err=%v", ok, err)
+	}
+	rec, _, _ = s.GetCommand("req-1")
+	if rec.Status != StatusTimedOut || rec.Error != "no response" {
+		t.Errorf("after timeout: %+v", rec)
+	}
+	// A late response does not reopen it.
+	if ok, err := s.UpdateResult("req-1", StatusSuccess, nil, ""); err != nil || ok {
+		t.Errorf("late result: ok=%v err=%v", ok, err)
+	}
+	if rec, _, _ = s.GetCommand("req-1"); rec.Status != StatusTimedOut {
+		t.Errorf("after late result: %+v", rec)
+	}
+	// A command that already answered cannot time out.
+	if _, err := s.UpdateResult("req-3", StatusSuccess, nil, ""); err != nil {
+		t.Fatalf("update result: %v", err)
+	}
+	if ok, _ := s.MarkTimedOut("req-3", "no response"); ok {
+		t.Error("finished command timed out")
+	}
+}
@@ -0,0 +1,327 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"math"
+	"time"
+)
+
+// Trip statuses.
+const (
+	TripOpen   = "open"   // still being extended by new telemetry
+	TripClosed = "closed" // complete
+)
+
+// tripWatermark names the rollup_state row tracking telemetry consumed by the
+// trip engine.
+const tripWatermark = "trips"
+
+// Trip is one detected ride. Speeds are km/h and EnergyUsed is the drop in
+// battery charge in percentage points, summed over all battery slots.
+type Trip struct {
+	ID          int64              `json:"id"`
+	ScooterID   string             `json:"scooter_id"`
+	Status      string             `json:"status"`
+	Start       time.Time          `json:"start"`
+	End         time.Time          `json:"end"`
+	StartLat    *float64           `json:"start_lat,omitempty"`
+	StartLng    *float64           `json:"start_lng,omitempty"`
+	EndLat      *float64           `json:"end_lat,omitempty"`
+	EndLng      *float64           `json:"end_lng,omitempty"`
+	DistanceM   float64            `json:"distance_m"`
+	MaxSpeed    float64            `json:"max_speed"`
+	AvgSpeed    float64            `json:"avg_speed"`
+	Samples     int64              `json:"samples"`
+	StartCharge map[string]float64 `json:"start_charge,omitempty"`
+	EndCharge   map[string]float64 `json:"end_charge,omitempty"`
+	EnergyUsed  *float64           `json:"energy_used,omitempty"`
+}
+
+// TelemetryPoint is a raw telemetry row as consumed by background processors.
+type TelemetryPoint struct {
+	ID        int64
+	ScooterID string
+	Timestamp time.Time
+	Lat       *float64
+	Lng       *float64
+	Speed     *float64
+	State     string
+	Data      map[string]any
+}
+
+// TrackPoint is one GPS fix of a scooter's track.
+type TrackPoint struct {
+	Timestamp time.Time `json:"timestamp"`
+	Lat       float64   `json:"lat"`
+	Lng       float64   `json:"lng"`
+	Speed     *float64  `json:"speed,omitempty"`
+	State     string    `json:"state,omitempty"`
+}
+
+// TripWatermark returns the last telemetry row id processed by the trip engine.
+func (s *Store) TripWatermark() (int64, error) {
+	return s.watermark(s.db, tripWatermark)
+}
+
+// TelemetrySince returns up to limit telemetry rows with id > afterID, in id
+// (arrival) order, ending before the first row received after settled (see
+// SettleDelay).
+func (s *Store) TelemetrySince(afterID int64, settled time.Time, limit int) ([]TelemetryPoint, error) {
+	rows, err := s.query(
+		`SELECT id, scooter_id, ts, lat, lng, speed, state, data, received_at FROM telemetry_history
+		 WHERE id > ? ORDER BY id LIMIT ?`, afterID, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []TelemetryPoint
+	for rows.Next() {
+		var (
+			p               TelemetryPoint
+			tsMillis        int64
+			lat, lng, speed sql.NullFloat64
+			state           sql.NullString
+			blob            string
+			received        sql.NullInt64
+		)
+		if err := rows.Scan(&p.ID, &p.ScooterID, &tsMillis, &lat, &lng, &speed, &state, &blob, &received); err != nil {
+			return nil, err
+		}
+		if unsettled(received, settled) {
+			break
+		}
+		p.Timestamp = time.UnixMilli(tsMillis).UTC()
+		p.Lat, p.Lng = gpsFix(lat, lng)
+		p.Speed = floatPtr(speed)
+		p.State = state.String
+		_ = json.Unmarshal([]byte(blob), &p.Data)
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+// OpenTrips returns every trip still in progress.
+func (s *Store) OpenTrips() ([]Trip, error) {
+	return s.queryTrips(`SELECT `+tripColumns+` FROM trips WHERE status=? ORDER BY id`, TripOpen)
+}
+
+// QueryTrips returns a scooter's trips that started within [from, to], newest
+// first, up to limit rows.
+func (s *Store) QueryTrips(scooterID string, from, to time.Time, limit int) ([]Trip, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+	return s.queryTrips(
+		`SELECT `+tripColumns+` FROM trips
+		 WHERE scooter_id=? AND start_ts BETWEEN ? AND ? ORDER BY start_ts DESC LIMIT ?`,
+		scooterID, from.UnixMilli(), to.UnixMilli(), limit,
+	)
+}
+
+// GetTrip returns a single trip.
+func (s *Store) GetTrip(id int64) (*Trip, bool, error) {
+	trips, err := s.queryTrips(`SELECT `+tripColumns+` FROM trips WHERE id=?`, id)
+	if err != nil || len(trips) == 0 {
+		return nil, false, err
+	}
+	return &trips[0], true, nil
+}
+
+// CommitTrips persists the trip engine's work in one transaction: trips with
+// ID 0 are inserted (and given an ID), others updated; trips listed in remove
+// are deleted; and the engine's watermark advances to lastID (when > 0).
+func (s *Store) CommitTrips(save []*Trip, remove []int64, lastID int64) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, t := range save {
+		args := []any{
+			t.ScooterID, t.Status, t.Start.UnixMilli(), t.End.UnixMilli(),
+			t.StartLat, t.StartLng, t.EndLat, t.EndLng,
+			t.DistanceM, t.MaxSpeed, t.AvgSpeed, t.Samples,
+			marshalCharges(t.StartCharge), marshalCharges(t.EndCharge), t.EnergyUsed,
+		}
+		if t.ID == 0 {
+			err = tx.QueryRow(s.dialect.rebind(
+				`INSERT INTO trips(scooter_id, status, start_ts, end_ts, start_lat, start_lng, end_lat, end_lng,
+				   distance_m, max_speed, avg_speed, samples, start_charge, end_charge, energy_used)
+				 VALUES(?,?,?,?,?,?,?,?,?,?,?,?,?,?,?) RETURNING id`), args...,
+			).Scan(&t.ID)
+		} else {
+			_, err = tx.Exec(s.dialect.rebind(
+				`UPDATE trips SET scooter_id=?, status=?, start_ts=?, end_ts=?, start_lat=?, start_lng=?,
+				   end_lat=?, end_lng=?, distance_m=?, max_speed=?, avg_speed=?, samples=?,
+				   start_charge=?, end_charge=?, energy_used=?
+				 WHERE id=?`), append(args, t.ID)...,
+			)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	for _, id := range remove {
+		if _, err := tx.Exec(s.dialect.rebind(`DELETE FROM trips WHERE id=?`), id); err != nil {
+			return err
+		}
+	}
+	if lastID > 0 {
+		if err := s.setWatermark(tx, tripWatermark, lastID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// QueryTrack returns a scooter's GPS fixes within [from, to], oldest first.
+func (s *Store) QueryTrack(scooterID string, from, to time.Time) ([]TrackPoint, error) {
+	var out []TrackPoint
+	err := s.StreamTrack(scooterID, from, to, func(p TrackPoint) error {
+		out = append(out, p)
+		return nil
+	})
+	return out, err
+}
+
+// trackPageSize is how many fixes StreamTrack reads per query.
+const trackPageSize = 2000
+
+// StreamTrack calls fn for each of a scooter's GPS fixes within [from, to],
+// oldest first, without loading the track into memory. Rows are read in pages
+// so a slow consumer never holds a database connection (SQLite has only one).
+// An error from fn stops the iteration and is returned.
+func (s *Store) StreamTrack(scooterID string, from, to time.Time, fn func(TrackPoint) error) error {
+	afterTS, afterID := from.UnixMilli()-1, int64(math.MaxInt64)
+	for {
+		page, n, err := s.trackPage(scooterID, &afterTS, &afterID, to.UnixMilli())
+		if err != nil {
+			return err
+		}
+		for _, p := range page {
+			if err := fn(p); err != nil {
+				return err
+			}
+		}
+		if n < trackPageSize {
+			return nil
+		}
+	}
+}
+
+// trackPage reads the next page of rows after (afterTS, afterID) in (ts, id)
+// order and returns their fixes and how many rows it read, advancing afterTS
+// and afterID to the last row.
+func (s *Store) trackPage(scooterID string, afterTS, afterID *int64, toMs int64) ([]TrackPoint, int, error) {
+	rows, err := s.query(
+		`SELECT id, ts, lat, lng, speed, state FROM telemetry_history
+		 WHERE scooter_id=? AND (ts > ? OR (ts = ? AND id > ?)) AND ts <= ?
+		   AND lat IS NOT NULL AND lng IS NOT NULL
+		 ORDER BY ts ASC, id ASC LIMIT ?`,
+		scooterID, *afterTS, *afterTS, *afterID, toMs, trackPageSize,
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var (
+		out []TrackPoint
+		n   int
+	)
+	for rows.Next() {
+		var (
+			p        TrackPoint
+			lat, lng sql.NullFloat64
+			speed    sql.NullFloat64
+			state    sql.NullString
+		)
+		if err := rows.Scan(afterID, afterTS, &lat, &lng, &speed, &state); err != nil {
+			return nil, 0, err
+		}
+		n++
+		latp, lngp := gpsFix(lat, lng)
+		if latp == nil {
+			continue
+		}
+		p.Timestamp = time.UnixMilli(*afterTS).UTC()
+		p.Lat, p.Lng = *latp, *lngp
+		p.Speed = floatPtr(speed)
+		p.State = state.String
+		out = append(out, p)
+	}
+	return out, n, rows.Err()
+}
+
+const tripColumns = `id, scooter_id, status, start_ts, end_ts, start_lat, start_lng, end_lat, end_lng,
+	distance_m, max_speed, avg_speed, samples, start_charge, end_charge, energy_used`
+
+func (s *Store) queryTrips(query string, args ...any) ([]Trip, error) {
+	rows, err := s.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []Trip
+	for rows.Next() {
+		var (
+			t                                  Trip
+			startMs, endMs                     int64
+			startLat, startLng, endLat, endLng sql.NullFloat64
+			startCharge, endCharge             sql.NullString
+			energy                             sql.NullFloat64
+		)
+		if err := rows.Scan(&t.ID, &t.ScooterID, &t.Status, &startMs, &endMs,
+			&startLat, &startLng, &endLat, &endLng,
+			&t.DistanceM, &t.MaxSpeed, &t.AvgSpeed, &t.Samples,
+			&startCharge, &endCharge, &energy); err != nil {
+			return nil, err
+		}
+		t.Start = time.UnixMilli(startMs).UTC()
+		t.End = time.UnixMilli(endMs).UTC()
+		t.StartLat, t.StartLng = floatPtr(startLat), floatPtr(startLng)
+		t.EndLat, t.EndLng = floatPtr(endLat), floatPtr(endLng)
+		t.EnergyUsed = floatPtr(energy)
+		if startCharge.Valid {
+			_ = json.Unmarshal([]byte(startCharge.String), &t.StartCharge)
+		}
+		if endCharge.Valid {
+			_ = json.Unmarshal([]byte(endCharge.String), &t.EndCharge)
+		}
+		out = append(out, t)
+	}
+	return out, rows.Err()
+}
+
+func marshalCharges(m map[string]float64) any {
+	if len(m) == 0 {
+		return nil
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return nil
+	}
+	return string(b)
+}
+
+// gpsFix returns a row's position, or nils if it has none or reports 0/0,
+// which GPS receivers emit before they have a fix.
+func gpsFix(lat, lng sql.NullFloat64) (*float64, *float64) {
+	if !lat.Valid || !lng.Valid || (lat.Float64 == 0 && lng.Float64 == 0) {
+		return nil, nil
+	}
+	return floatPtr(lat), floatPtr(lng)
+}
+
+func floatPtr(v sql.NullFloat64) *float64 {
+	if !v.Valid {
+		return nil
+	}
+	f := v.Float64
+	return &f
+}
@@ -0,0 +1,88 @@
+package store
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func gpsSnapshot(lat, lng float64, speed int, state string) map[string]any {
+	return map[string]any{
+		"gps":        map[string]any{"latitude": strconv.FormatFloat(lat, 'f', 6, 64), "longitude": strconv.FormatFloat(lng, 'f', 6, 64)},
+		"engine-ecu": map[string]any{"speed": strconv.Itoa(speed)},
+		"vehicle":    map[string]any{"state": state},
+	}
+}
+
+func TestStreamTrackPages(t *testing.T) {
+	s := openTemp(t)
+	base := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+	// More than one page, with duplicate timestamps straddling the page
+	// boundary, plus a fix without GPS and a 0/0 one that must be skipped.
+	n := trackPageSize + 10
+	for i := 0; i < n; i++ {
+		ts := base.Add(time.Duration(i) * time.Second)
+		if i == trackPageSize {
+			ts = base.Add(time.Duration(i-1) * time.Second)
+		}
+		lat, lng := 52+float64(i)/1e5, 13.0
+		if i == 3 {
+			lat, lng = 0, 0
+		}
+		if err := s.InsertTelemetry("VIN1", ts, gpsSnapshot(lat, lng, i%40, "ready-to-drive")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.InsertTelemetry("VIN1", base.Add(time.Minute), map[string]any{"vehicle": map[string]any{"state": "parked"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.InsertTelemetry("VIN2", base, gpsSnapshot(1, 1, 0, "parked")); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []TrackPoint
+	err := s.StreamTrack("VIN1", base, base.Add(time.Hour), func(p TrackPoint) error {
+		got = append(got, p)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != n-1 {
+		t.Fatalf("got %d points, want %d", len(got), n-1)
+	}
+	for i := 1; i < len(got); i++ {
+		if got[i].Timestamp.Before(got[i-1].Timestamp) {
+			t.Fatalf("point %d out of order", i)
+		}
+	}
+	if got[0].State != "ready-to-drive" || got[0].Speed == nil || *got[0].Speed != 0 {
+		t.Errorf("first point = %+v", got[0])
+	}
+
+	// The window is inclusive and errors from fn stop the stream.
+	stop := errors.New("stop")
+	calls := 0
+	err = s.StreamTrack("VIN1", base.Add(5*time.Second), base.Add(6*time.Second), func(TrackPoint) error {
+		calls++
+		return stop
+	})
+	if !errors.Is(err, stop) || calls != 1 {
+		t.Errorf("err=%v calls=%d, want stop after 1 call", err, calls)
+	}
+	track, err := s.QueryTrack("VIN1", base.Add(5*time.Second), base.Add(6*time.Second))
+	if err != nil || len(track) != 2 {
+		t.Errorf("QueryTrack = %d points, %v; want 2", len(track), err)
+	}
+
+	// The trip engine's feed drops the 0/0 position too.
+	points, err := s.TelemetrySince(0, time.Now(), 5)
+	if err != nil || len(points) != 5 {
+		t.Fatalf("TelemetrySince = %d points, %v", len(points), err)
+	}
+	if points[3].Lat != nil || points[3].Lng != nil || points[4].Lat == nil {
+		t.Errorf("0/0 fix kept: %v,%v", points[3].Lat, points[3].Lng)
+	}
+}
@@ -0,0 +1,158 @@
+package store
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// Webhook delivery statuses.
+const (
+	WebhookPending   = "pending"
+	WebhookDelivered = "delivered"
+	WebhookFailed    = "failed" // gave up after the maximum number of attempts
+)
+
+// WebhookDelivery is one queued POST of a payload to a subscription.
+type WebhookDelivery struct {
+	ID           int64
+	Subscription string
+	EventType    string
+	Payload      string
+	Attempts     int
+}
+
+// EnqueueWebhook adds a payload to the outbox, due immediately.
+func (s *Store) EnqueueWebhook(subscription, eventType, payload string, now time.Time) error {
+	_, err := s.exec(
+		`INSERT INTO webhook_outbox(subscription, event_type, payload, status, attempts, next_attempt, created_at)
+		 VALUES(?,?,?,?,0,?,?)`,
+		subscription, eventType, payload, WebhookPending, now.UnixMilli(), now.UnixMilli(),
+	)
+	return err
+}
+
+// DueWebhooks returns up to limit pending deliveries to subscription whose
+// next attempt is due at now, oldest first.
+func (s *Store) DueWebhooks(subscription string, now time.Time, limit int) ([]WebhookDelivery, error) {
+	rows, err := s.query(
+		`SELECT id, subscription, event_type, payload, attempts FROM webhook_outbox
+		 WHERE subscription=? AND status=? AND next_attempt <= ? ORDER BY id LIMIT ?`,
+		subscription, WebhookPending, now.UnixMilli(), limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []WebhookDelivery
+	for rows.Next() {
+		var d WebhookDelivery
+		if err := rows.Scan(&d.ID, &d.Subscription, &d.EventType, &d.Payload, &d.Attempts); err != nil {
+			return nil, err
+		}
+		out = append(out, d)
+	}
+	return out, rows.Err()
+}
+
+// WebhookDelivered marks a delivery as done.
+func (s *Store) WebhookDelivered(id int64, attempts int, now time.Time) error {
+	_, err := s.exec(
+		`UPDATE webhook_outbox SET status=?, attempts=?, last_error=NULL, finished_at=? WHERE id=?`,
+		WebhookDelivered, attempts, now.UnixMilli(), id,
+	)
+	return err
+}
+
+// WebhookAttemptFailed records a failed attempt. The delivery is retried at
+// next, or marked failed when next is the zero time.
+func (s *Store) WebhookAttemptFailed(id int64, attempts int, errMsg string, next, now time.Time) error {
+	if next.IsZero() {
+		_, err := s.exec(
+			`UPDATE webhook_outbox SET status=?, attempts=?, last_error=?, finished_at=? WHERE id=?`,
+			WebhookFailed, attempts, errMsg, now.UnixMilli(), id,
+		)
+		return err
+	}
+	_, err := s.exec(
+		`UPDATE webhook_outbox SET attempts=?, last_error=?, next_attempt=? WHERE id=?`,
+		attempts, errMsg, next.UnixMilli(), id,
+	)
+	return err
+}
+
+// AbandonWebhooks marks the pending deliveries of every subscription not in
+// keep as failed with errMsg and returns how many there were.
+func (s *Store) AbandonWebhooks(keep []string, errMsg string, now time.Time) (int64, error) {
+	query := `UPDATE webhook_outbox SET status=?, last_error=?, finished_at=? WHERE status=?`
+	args := []any{WebhookFailed, errMsg, now.UnixMilli(), WebhookPending}
+	if len(keep) > 0 {
+		query += ` AND subscription NOT IN (?` + strings.Repeat(",?", len(keep)-1) + `)`
+		for _, name := range keep {
+			args = append(args, name)
+		}
+	}
+	res, err := s.exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// PruneWebhooks deletes delivered and failed deliveries finished before
+// cutoff and returns how many were removed.
+func (s *Store) PruneWebhooks(cutoff time.Time) (int64, error) {
+	res, err := s.exec(
+		`DELETE FROM webhook_outbox WHERE status IN (?,?) AND finished_at < ?`,
+		WebhookDelivered, WebhookFailed, cutoff.UnixMilli(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// WebhookStats counts outbox rows per subscription and status, and returns
+// the most recent error per subscription.
+func (s *Store) WebhookStats() (map[string]map[string]int, map[string]string, error) {
+	counts := make(map[string]map[string]int)
+	rows, err := s.query(`SELECT subscription, status, COUNT(*) FROM webhook_outbox GROUP BY subscription, status`)
+	if err != nil {
+		return nil, nil, err
+	}
+	for rows.Next() {
+		var (
+			sub, status string
+			n           int
+		)
+		if err := rows.Scan(&sub, &status, &n); err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		if counts[sub] == nil {
+			counts[sub] = make(map[string]int)
+		}
+		counts[sub][status] = n
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	lastErrors := make(map[string]string)
+	for sub := range counts {
+		var msg sql.NullString
+		err := s.queryRow(
+			`SELECT last_error FROM webhook_outbox WHERE subscription=? AND last_error IS NOT NULL
+			 ORDER BY id DESC LIMIT 1`, sub,
+		).Scan(&msg)
+		if err != nil && err != sql.ErrNoRows {
+			return nil, nil, err
+		}
+		if msg.Valid {
+			lastErrors[sub] = msg.String
+		}
+	}
+	return counts, lastErrors, nil
+}
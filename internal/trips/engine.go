@@ -0,0 +1,155 @@
+package trips
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/librescoot/uplink-server/internal/store"
+)
+
+// Engine consumes new telemetry from the store and maintains the trips table.
+// Progress is tracked by telemetry row id and committed together with the
+// trips it produced, so a restart neither skips nor double-counts samples.
+type Engine struct {
+	db     *store.Store
+	cfg    Config
+	settle time.Duration // see store.SettleDelay
+
+	mu sync.Mutex // serialises Run
+}
+
+// NewEngine creates a trip engine over db.
+func NewEngine(db *store.Store, cfg Config) *Engine {
+	return &Engine{db: db, cfg: cfg, settle: store.SettleDelay}
+}
+
+// Start runs the engine every interval in the background.
+func (e *Engine) Start(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := e.RunAll(time.Now()); err != nil {
+				log.Printf("[Trips] Processing error: %v", err)
+			}
+		}
+	}()
+}
+
+// runBatch is how many telemetry rows one Run consumes.
+const runBatch = 5000
+
+// RunAll processes all pending telemetry, then closes trips whose scooter has
+// been silent for longer than the gap timeout as of now.
+func (e *Engine) RunAll(now time.Time) error {
+	for {
+		n, err := e.Run(runBatch)
+		if err != nil {
+			return err
+		}
+		if n < runBatch {
+			break
+		}
+	}
+	return e.CloseStale(now)
+}
+
+// Run processes up to batch pending telemetry rows and returns how many it
+// consumed.
+func (e *Engine) Run(batch int) (int, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	lastID, err := e.db.TripWatermark()
+	if err != nil {
+		return 0, err
+	}
+	points, err := e.db.TelemetrySince(lastID, time.Now().Add(-e.settle), batch)
+	if err != nil || len(points) == 0 {
+		return 0, err
+	}
+
+	open, err := e.openTrips()
+	if err != nil {
+		return 0, err
+	}
+
+	// Group by scooter and replay each scooter's samples in time order; rows
+	// arrive in id order, which differs for replayed offline batches.
+	byScooter := make(map[string][]store.TelemetryPoint)
+	for _, p := range points {
+		byScooter[p.ScooterID] = append(byScooter[p.ScooterID], p)
+		lastID = max(lastID, p.ID)
+	}
+
+	var save []*store.Trip
+	var remove []int64
+	for scooterID, pts := range byScooter {
+		sort.SliceStable(pts, func(i, j int) bool { return pts[i].Timestamp.Before(pts[j].Timestamp) })
+		cur := open[scooterID]
+		for _, p := range pts {
+			var closed *store.Trip
+			cur, closed = e.cfg.Feed(scooterID, cur, SampleFromPoint(p))
+			if closed != nil {
+				save, remove = e.finish(closed, save, remove)
+			}
+		}
+		if cur != nil {
+			save = append(save, cur)
+		}
+	}
+
+	if err := e.db.CommitTrips(save, remove, lastID); err != nil {
+		return 0, err
+	}
+	return len(points), nil
+}
+
+// CloseStale closes open trips with no telemetry for longer than the gap
+// timeout, e.g. because the scooter went offline mid-ride.
+func (e *Engine) CloseStale(now time.Time) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	open, err := e.openTrips()
+	if err != nil {
+		return err
+	}
+	var save []*store.Trip
+	var remove []int64
+	for _, t := range open {
+		if now.Sub(t.End) > e.cfg.GapTimeout {
+			t.Status = store.TripClosed
+			save, remove = e.finish(t, save, remove)
+		}
+	}
+	if len(save) == 0 && len(remove) == 0 {
+		return nil
+	}
+	return e.db.CommitTrips(save, remove, 0)
+}
+
+// finish queues a closed trip for saving, or drops it when insignificant.
+func (e *Engine) finish(t *store.Trip, save []*store.Trip, remove []int64) ([]*store.Trip, []int64) {
+	if e.cfg.Significant(t) {
+		return append(save, t), remove
+	}
+	if t.ID != 0 {
+		remove = append(remove, t.ID)
+	}
+	return save, remove
+}
+
+func (e *Engine) openTrips() (map[string]*store.Trip, error) {
+	trips, err := e.db.OpenTrips()
+	if err != nil {
+		return nil, err
+	}
+	open := make(map[string]*store.Trip, len(trips))
+	for i := range trips {
+		open[trips[i].ScooterID] = &trips[i]
+	}
+	return open, nil
+}
@@ -0,0 +1,221 @@
+// Package trips segments persisted telemetry into trips. A trip starts when
+// a scooter becomes ready-to-drive and ends when it is parked, goes to
+// stand-by (or any other non-driving state), or stops reporting for longer
+// than the gap timeout.
+package trips
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/librescoot/uplink-server/internal/store"
+)
+
+// StateReadyToDrive is the vehicle.state that marks a scooter as driving.
+const StateReadyToDrive = "ready-to-drive"
+
+// Config tunes trip detection.
+type Config struct {
+	// GapTimeout closes a trip when no telemetry arrives for this long.
+	GapTimeout time.Duration
+	// MinDistance and MinSpeed (km/h) decide whether a closed trip counts: it
+	// must have covered MinDistance metres or reached MinSpeed. Trips below
+	// both (e.g. unlocking and locking again) are discarded.
+	MinDistance float64
+	MinSpeed    float64
+	// MaxJumpSpeed (km/h) rejects GPS jumps: a segment implying a faster speed
+	// does not count towards distance.
+	MaxJumpSpeed float64
+}
+
+// DefaultConfig returns the settings used by the server.
+func DefaultConfig() Config {
+	return Config{
+		GapTimeout:   30 * time.Minute,
+		MinDistance:  100,
+		MinSpeed:     5,
+		MaxJumpSpeed: 150,
+	}
+}
+
+// Sample is the subset of a telemetry snapshot trip detection needs.
+type Sample struct {
+	Time    time.Time
+	Lat     *float64
+	Lng     *float64
+	Speed   *float64
+	State   string
+	Charges map[string]float64 // battery slot ("battery:0") -> charge %
+}
+
+// SampleFromPoint extracts a Sample from a stored telemetry row.
+func SampleFromPoint(p store.TelemetryPoint) Sample {
+	return Sample{
+		Time:    p.Timestamp,
+		Lat:     p.Lat,
+		Lng:     p.Lng,
+		Speed:   p.Speed,
+		State:   p.State,
+		Charges: batteryCharges(p.Data),
+	}
+}
+
+// driving reports whether s belongs inside a trip. Without a vehicle state,
+// movement alone counts.
+func (c Config) driving(s Sample) bool {
+	if s.State != "" {
+		return s.State == StateReadyToDrive
+	}
+	return s.Speed != nil && *s.Speed >= c.MinSpeed
+}
+
+// Feed advances a scooter's trip state by one sample, in timestamp order.
+// open is the scooter's trip in progress (nil if none). It returns the trip
+// in progress afterwards and, if one ended, the closed trip.
+func (c Config) Feed(scooterID string, open *store.Trip, s Sample) (next, closed *store.Trip) {
+	if open != nil {
+		if s.Time.Before(open.End) {
+			// Out of order relative to the trip; it cannot be placed reliably.
+			return open, nil
+		}
+		if s.Time.Sub(open.End) > c.GapTimeout {
+			closed = open
+			closed.Status = store.TripClosed
+			open = nil
+		}
+	}
+
+	if open == nil {
+		if c.driving(s) {
+			open = startTrip(scooterID, s)
+		}
+		return open, closed
+	}
+
+	c.extend(open, s)
+	if !c.driving(s) {
+		open.Status = store.TripClosed
+		return nil, open
+	}
+	return open, nil
+}
+
+// Significant reports whether a closed trip is worth keeping.
+func (c Config) Significant(t *store.Trip) bool {
+	return t.DistanceM >= c.MinDistance || t.MaxSpeed >= c.MinSpeed
+}
+
+func startTrip(scooterID string, s Sample) *store.Trip {
+	t := &store.Trip{
+		ScooterID:   scooterID,
+		Status:      store.TripOpen,
+		Start:       s.Time,
+		End:         s.Time,
+		Samples:     1,
+		StartCharge: s.Charges,
+		EndCharge:   s.Charges,
+	}
+	if s.Lat != nil && s.Lng != nil {
+		t.StartLat, t.StartLng = s.Lat, s.Lng
+		t.EndLat, t.EndLng = s.Lat, s.Lng
+	}
+	if s.Speed != nil {
+		t.MaxSpeed = *s.Speed
+	}
+	return t
+}
+
+func (c Config) extend(t *store.Trip, s Sample) {
+	if s.Lat != nil && s.Lng != nil {
+		if t.EndLat != nil && t.EndLng != nil {
+			d := Haversine(*t.EndLat, *t.EndLng, *s.Lat, *s.Lng)
+			dt := s.Time.Sub(t.End).Hours()
+			if dt > 0 && d/1000/dt <= c.MaxJumpSpeed {
+				t.DistanceM += d
+			}
+		}
+		if t.StartLat == nil {
+			t.StartLat, t.StartLng = s.Lat, s.Lng
+		}
+		t.EndLat, t.EndLng = s.Lat, s.Lng
+	}
+	if s.Speed != nil && *s.Speed > t.MaxSpeed {
+		t.MaxSpeed = *s.Speed
+	}
+	if len(s.Charges) > 0 {
+		if len(t.StartCharge) == 0 {
+			t.StartCharge = s.Charges
+		}
+		t.EndCharge = s.Charges
+	}
+	t.End = s.Time
+	t.Samples++
+
+	if hours := t.End.Sub(t.Start).Hours(); hours > 0 {
+		t.AvgSpeed = t.DistanceM / 1000 / hours
+	}
+	t.EnergyUsed = energyUsed(t.StartCharge, t.EndCharge)
+}
+
+// energyUsed sums the charge drop over battery slots present at both ends.
+func energyUsed(start, end map[string]float64) *float64 {
+	var used float64
+	found := false
+	for slot, before := range start {
+		if after, ok := end[slot]; ok {
+			used += before - after
+			found = true
+		}
+	}
+	if !found {
+		return nil
+	}
+	return &used
+}
+
+// batteryCharges reads battery:N.charge from a snapshot.
+func batteryCharges(data map[string]any) map[string]float64 {
+	var out map[string]float64
+	for key, v := range data {
+		if !strings.HasPrefix(key, "battery:") {
+			continue
+		}
+		fields, ok := v.(map[string]any)
+		if !ok {
+			continue
+		}
+		var charge float64
+		switch x := fields["charge"].(type) {
+		case string:
+			f, err := strconv.ParseFloat(x, 64)
+			if err != nil {
+				continue
+			}
+			charge = f
+		case float64:
+			charge = x
+		default:
+			continue
+		}
+		if out == nil {
+			out = make(map[string]float64)
+		}
+		out[key] = charge
+	}
+	return out
+}
+
+// earthRadius is the mean Earth radius in metres.
+const earthRadius = 6371000.0
+
+// Haversine returns the great-circle distance in metres between two points.
+func Haversine(lat1, lng1, lat2, lng2 float64) float64 {
+	rad := math.Pi / 180
+	dLat := (lat2 - lat1) * rad
+	dLng := (lng2 - lng1) * rad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(a))
+}
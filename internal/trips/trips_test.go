@@ -0,0 +1,133 @@
+package trips
+
+import (
+	"math"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/librescoot/uplink-server/internal/store"
+)
+
+func TestHaversine(t *testing.T) {
+	// Berlin Alexanderplatz to Brandenburger Tor, roughly 2.5 km.
+	d := Haversine(52.5219, 13.4132, 52.5163, 13.3777)
+	if math.Abs(d-2480) > 100 {
+		t.Errorf("Haversine = %.0f m, want about 2480", d)
+	}
+	if Haversine(52.5, 13.4, 52.5, 13.4) != 0 {
+		t.Error("distance to self should be 0")
+	}
+}
+
+func snap(state string, lat, lng float64, speed, charge string) map[string]any {
+	return map[string]any{
+		"vehicle":    map[string]any{"state": state},
+		"gps":        map[string]any{"latitude": ftoa(lat), "longitude": ftoa(lng)},
+		"engine-ecu": map[string]any{"speed": speed},
+		"battery:0":  map[string]any{"charge": charge},
+	}
+}
+
+func ftoa(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+func openStore(t *testing.T) *store.Store {
+	t.Helper()
+	s, err := store.Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestEngineDetectsTrip(t *testing.T) {
+	db := openStore(t)
+	base := time.Date(2026, 5, 1, 8, 0, 0, 0, time.UTC)
+	insert := func(offset time.Duration, data map[string]any) {
+		t.Helper()
+		if err := db.InsertTelemetry("VIN1", base.Add(offset), data); err != nil {
+			t.Fatalf("insert: %v", err)
+		}
+	}
+
+	insert(0, snap("parked", 52.5000, 13.4000, "0", "90"))
+	insert(1*time.Minute, snap("ready-to-drive", 52.5000, 13.4000, "0", "90"))
+	insert(2*time.Minute, snap("ready-to-drive", 52.5050, 13.4000, "20", "89"))
+	insert(3*time.Minute, snap("ready-to-drive", 52.5100, 13.4000, "25", "88"))
+
+	e := NewEngine(db, DefaultConfig())
+	e.settle = 0
+	if err := e.RunAll(base.Add(4 * time.Minute)); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	open, err := db.OpenTrips()
+	if err != nil || len(open) != 1 {
+		t.Fatalf("open trips = %d, %v; want 1", len(open), err)
+	}
+
+	// Parking ends the trip.
+	insert(4*time.Minute, snap("parked", 52.5100, 13.4000, "0", "87"))
+	if err := e.RunAll(base.Add(5 * time.Minute)); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	trips, err := db.QueryTrips("VIN1", base, base.Add(time.Hour), 10)
+	if err != nil || len(trips) != 1 {
+		t.Fatalf("trips = %d, %v; want 1", len(trips), err)
+	}
+	trip := trips[0]
+	if trip.Status != store.TripClosed {
+		t.Errorf("status = %s, want closed", trip.Status)
+	}
+	if !trip.Start.Equal(base.Add(time.Minute)) || !trip.End.Equal(base.Add(4*time.Minute)) {
+		t.Errorf("trip spans %v..%v", trip.Start, trip.End)
+	}
+	if math.Abs(trip.DistanceM-1112) > 20 {
+		t.Errorf("distance = %.0f m, want about 1112", trip.DistanceM)
+	}
+	if trip.MaxSpeed != 25 {
+		t.Errorf("max speed = %v, want 25", trip.MaxSpeed)
+	}
+	if trip.EnergyUsed == nil || *trip.EnergyUsed != 3 {
+		t.Errorf("energy used = %v, want 3", trip.EnergyUsed)
+	}
+	if open, _ := db.OpenTrips(); len(open) != 0 {
+		t.Errorf("open trips after parking = %d, want 0", len(open))
+	}
+}
+
+func TestEngineDropsInsignificantAndStale(t *testing.T) {
+	db := openStore(t)
+	base := time.Date(2026, 5, 1, 8, 0, 0, 0, time.UTC)
+	e := NewEngine(db, DefaultConfig())
+	e.settle = 0
+
+	// Unlocked and locked again without moving: discarded.
+	db.InsertTelemetry("VIN1", base, snap("ready-to-drive", 52.5, 13.4, "0", "90"))
+	db.InsertTelemetry("VIN1", base.Add(time.Minute), snap("parked", 52.5, 13.4, "0", "90"))
+
+	// Rides off and goes silent: closed once the gap timeout passes.
+	db.InsertTelemetry("VIN2", base, snap("ready-to-drive", 52.5, 13.4, "0", "90"))
+	db.InsertTelemetry("VIN2", base.Add(time.Minute), snap("ready-to-drive", 52.51, 13.4, "20", "89"))
+
+	if err := e.RunAll(base.Add(10 * time.Minute)); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if trips, _ := db.QueryTrips("VIN1", base, base.Add(time.Hour), 10); len(trips) != 0 {
+		t.Errorf("VIN1 trips = %d, want 0", len(trips))
+	}
+	if open, _ := db.OpenTrips(); len(open) != 1 {
+		t.Fatalf("open trips = %d, want 1", len(open))
+	}
+
+	if err := e.RunAll(base.Add(time.Hour)); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	trips, _ := db.QueryTrips("VIN2", base, base.Add(time.Hour), 10)
+	if len(trips) != 1 || trips[0].Status != store.TripClosed {
+		t.Fatalf("VIN2 trips = %+v, want one closed trip", trips)
+	}
+}
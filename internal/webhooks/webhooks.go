@@ -0,0 +1,390 @@
+// Package webhooks pushes events, state changes, connection transitions and
+// command results to HTTP endpoints. Deliveries go through a persistent
+// outbox so they survive restarts, and failed attempts are retried with
+// exponential backoff.
+package webhooks
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/librescoot/uplink-server/internal/storage"
+	"github.com/librescoot/uplink-server/internal/store"
+)
+
+// Message types.
+const (
+	TypeEvent      = "event"
+	TypeState      = "state"
+	TypeConnection = "connection"
+	TypeCommand    = "command"
+)
+
+// Request headers.
+const (
+	HeaderSignature = "X-Uplink-Signature-256" // "sha256=" + hex HMAC-SHA256 of the body
+	HeaderEvent     = "X-Uplink-Event"
+	HeaderDelivery  = "X-Uplink-Delivery"
+)
+
+// Delivery tuning.
+const (
+	maxAttempts    = 10
+	baseBackoff    = 10 * time.Second
+	maxBackoff     = time.Hour
+	deliveryBatch  = 50
+	keepFinished   = 7 * 24 * time.Hour
+	requestTimeout = 10 * time.Second
+)
+
+// Subscription is one webhook endpoint and the messages it receives. Empty
+// filters match everything.
+type Subscription struct {
+	Name       string   `json:"name"`
+	URL        string   `json:"url"`
+	Secret     string   `json:"-"`
+	Types      []string `json:"types,omitempty"`       // message types
+	Scooters   []string `json:"scooters,omitempty"`    // scooter identifiers
+	Events     []string `json:"events,omitempty"`      // event names, for event messages
+	StatePaths []string `json:"state_paths,omitempty"` // dotted paths a state update must touch
+}
+
+// Validate checks that s is usable.
+func (s *Subscription) Validate() error {
+	if s.Name == "" {
+		return errors.New("name is required")
+	}
+	u, err := url.Parse(s.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid url %q", s.URL)
+	}
+	for _, t := range s.Types {
+		switch t {
+		case TypeEvent, TypeState, TypeConnection, TypeCommand:
+		default:
+			return fmt.Errorf("unknown type %q", t)
+		}
+	}
+	return nil
+}
+
+// Payload is the JSON body POSTed to subscribers.
+type Payload struct {
+	Type      string         `json:"type"`
+	ScooterID string         `json:"scooter_id"`
+	Timestamp time.Time      `json:"timestamp"`
+	Data      map[string]any `json:"data"`
+}
+
+// Dispatcher matches messages against subscriptions, queues them in the
+// outbox and delivers them, with one worker per subscription so a slow or
+// failing endpoint only holds up its own messages.
+type Dispatcher struct {
+	db     *store.Store
+	subs   []Subscription
+	client *http.Client
+	wake   map[string]chan struct{} // per subscription
+}
+
+// NewDispatcher creates a dispatcher for subs.
+func NewDispatcher(db *store.Store, subs []Subscription) (*Dispatcher, error) {
+	wake := make(map[string]chan struct{}, len(subs))
+	for i := range subs {
+		if err := subs[i].Validate(); err != nil {
+			return nil, fmt.Errorf("webhook %q: %w", subs[i].Name, err)
+		}
+		if wake[subs[i].Name] != nil {
+			return nil, fmt.Errorf("duplicate webhook %q", subs[i].Name)
+		}
+		wake[subs[i].Name] = make(chan struct{}, 1)
+	}
+	return &Dispatcher{
+		db:     db,
+		subs:   subs,
+		client: &http.Client{Timeout: requestTimeout},
+		wake:   wake,
+	}, nil
+}
+
+// Subscriptions returns the configured subscriptions.
+func (d *Dispatcher) Subscriptions() []Subscription {
+	return d.subs
+}
+
+// Start subscribes to the in-memory stores and runs the delivery workers in
+// the background. With no subscriptions it does nothing.
+func (d *Dispatcher) Start(states *storage.StateStore, events *storage.EventStore, conns *storage.ConnectionManager) {
+	if len(d.subs) == 0 {
+		return
+	}
+	stateCh, _ := states.Subscribe()
+	eventCh, _ := events.Subscribe()
+	connCh, _ := conns.Subscribe()
+	go func() {
+		for {
+			select {
+			case u, ok := <-stateCh:
+				if !ok {
+					return
+				}
+				d.PublishState(u.ScooterID, u.Type, u.State, u.Timestamp)
+			case ev, ok := <-eventCh:
+				if !ok {
+					return
+				}
+				d.PublishEvent(ev.ScooterID, ev.Event, ev.Data, ev.Timestamp)
+			case ce, ok := <-connCh:
+				if !ok {
+					return
+				}
+				d.PublishConnection(ce.Identifier, ce.Type, time.Now())
+			}
+		}
+	}()
+	d.abandonRemoved()
+	for i := range d.subs {
+		go d.run(&d.subs[i])
+	}
+	go d.prune()
+}
+
+// abandonRemoved fails the pending deliveries of subscriptions removed from
+// the config since they were queued.
+func (d *Dispatcher) abandonRemoved() {
+	names := make([]string, len(d.subs))
+	for i := range d.subs {
+		names[i] = d.subs[i].Name
+	}
+	if n, err := d.db.AbandonWebhooks(names, "subscription no longer configured", time.Now()); err != nil {
+		log.Printf("[Webhooks] Failed to abandon deliveries of removed subscriptions: %v", err)
+	} else if n > 0 {
+		log.Printf("[Webhooks] Abandoned %d deliveries of removed subscriptions", n)
+	}
+}
+
+// run delivers a subscription's due messages whenever woken and at least
+// every second.
+func (d *Dispatcher) run(s *Subscription) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-d.wake[s.Name]:
+		}
+		now := time.Now()
+		for {
+			n, err := d.deliver(s, now)
+			if err != nil {
+				log.Printf("[Webhooks] Delivery error for %s: %v", s.Name, err)
+			}
+			if n < deliveryBatch {
+				break
+			}
+		}
+	}
+}
+
+// prune deletes finished deliveries hourly.
+func (d *Dispatcher) prune() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for now := time.Now(); ; now = <-ticker.C {
+		if n, err := d.db.PruneWebhooks(now.Add(-keepFinished)); err != nil {
+			log.Printf("[Webhooks] Prune error: %v", err)
+		} else if n > 0 {
+			log.Printf("[Webhooks] Pruned %d finished deliveries", n)
+		}
+	}
+}
+
+// PublishEvent queues a scooter event.
+func (d *Dispatcher) PublishEvent(scooterID, event string, data map[string]any, ts time.Time) {
+	d.publish(TypeEvent, scooterID, ts, map[string]any{"event": event, "data": data}, func(s *Subscription) bool {
+		return len(s.Events) == 0 || contains(s.Events, event)
+	})
+}
+
+// PublishState queues a state update; updateType is "full" or "delta".
+func (d *Dispatcher) PublishState(scooterID, updateType string, state map[string]any, ts time.Time) {
+	d.publish(TypeState, scooterID, ts, map[string]any{"update_type": updateType, "state": state}, func(s *Subscription) bool {
+		if len(s.StatePaths) == 0 {
+			return true
+		}
+		for _, p := range s.StatePaths {
+			if hasPath(state, p) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// PublishConnection queues a connection transition; status is "online" or
+// "offline".
+func (d *Dispatcher) PublishConnection(scooterID, status string, ts time.Time) {
+	d.publish(TypeConnection, scooterID, ts, map[string]any{"status": status}, nil)
+}
+
+// PublishCommandResult queues a command completion.
+func (d *Dispatcher) PublishCommandResult(scooterID, requestID, status string, result map[string]any, errMsg string) {
+	data := map[string]any{"request_id": requestID, "status": status}
+	if result != nil {
+		data["result"] = result
+	}
+	if errMsg != "" {
+		data["error"] = errMsg
+	}
+	d.publish(TypeCommand, scooterID, time.Now(), data, nil)
+}
+
+func (d *Dispatcher) publish(msgType, scooterID string, ts time.Time, data map[string]any, match func(*Subscription) bool) {
+	var body []byte
+	for i := range d.subs {
+		s := &d.subs[i]
+		if len(s.Types) > 0 && !contains(s.Types, msgType) {
+			continue
+		}
+		if len(s.Scooters) > 0 && !contains(s.Scooters, scooterID) {
+			continue
+		}
+		if match != nil && !match(s) {
+			continue
+		}
+		if body == nil {
+			var err error
+			body, err = json.Marshal(Payload{Type: msgType, ScooterID: scooterID, Timestamp: ts.UTC(), Data: data})
+			if err != nil {
+				log.Printf("[Webhooks] Failed to encode %s payload: %v", msgType, err)
+				return
+			}
+		}
+		if err := d.db.EnqueueWebhook(s.Name, msgType, string(body), time.Now()); err != nil {
+			log.Printf("[Webhooks] Failed to queue %s for %s: %v", msgType, s.Name, err)
+			continue
+		}
+		select {
+		case d.wake[s.Name] <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Deliver attempts up to one batch of due deliveries per subscription, one
+// subscription after the other, and returns how many were attempted.
+func (d *Dispatcher) Deliver(now time.Time) (int, error) {
+	total := 0
+	for i := range d.subs {
+		n, err := d.deliver(&d.subs[i], now)
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// deliver attempts up to one batch of a subscription's due deliveries and
+// returns how many were attempted.
+func (d *Dispatcher) deliver(s *Subscription, now time.Time) (int, error) {
+	due, err := d.db.DueWebhooks(s.Name, now, deliveryBatch)
+	if err != nil {
+		return 0, err
+	}
+	for _, del := range due {
+		attempts := del.Attempts + 1
+		if sendErr := d.send(s, del); sendErr == nil {
+			err = d.db.WebhookDelivered(del.ID, attempts, time.Now())
+		} else {
+			var next time.Time
+			if attempts < maxAttempts {
+				next = time.Now().Add(Backoff(attempts))
+			} else {
+				log.Printf("[Webhooks] Giving up on delivery %d to %s after %d attempts: %v", del.ID, s.Name, attempts, sendErr)
+			}
+			err = d.db.WebhookAttemptFailed(del.ID, attempts, sendErr.Error(), next, time.Now())
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(due), nil
+}
+
+func (d *Dispatcher) send(s *Subscription, del store.WebhookDelivery) error {
+	req, err := http.NewRequest(http.MethodPost, s.URL, bytes.NewReader([]byte(del.Payload)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "uplink-server")
+	req.Header.Set(HeaderEvent, del.EventType)
+	req.Header.Set(HeaderDelivery, strconv.FormatInt(del.ID, 10))
+	if s.Secret != "" {
+		req.Header.Set(HeaderSignature, Sign(s.Secret, []byte(del.Payload)))
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Sign returns the signature header value for body: "sha256=" followed by
+// the hex HMAC-SHA256 of body keyed with secret.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Backoff returns the wait before the next attempt after attempts failures:
+// 10s doubling per attempt, capped at one hour.
+func Backoff(attempts int) time.Duration {
+	d := baseBackoff
+	for i := 1; i < attempts && d < maxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, maxBackoff)
+}
+
+func contains(list []string, v string) bool {
+	for _, s := range list {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// hasPath reports whether the dotted path exists in m.
+func hasPath(m map[string]any, path string) bool {
+	var cur any = m
+	for _, key := range strings.Split(path, ".") {
+		mm, ok := cur.(map[string]any)
+		if !ok {
+			return false
+		}
+		if cur, ok = mm[key]; !ok {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,218 @@
+package webhooks
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/librescoot/uplink-server/internal/store"
+)
+
+type receiver struct {
+	mu      sync.Mutex
+	status  int
+	bodies  []Payload
+	headers []http.Header
+}
+
+func (rv *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	rv.mu.Lock()
+	defer rv.mu.Unlock()
+	var p Payload
+	json.Unmarshal(body, &p)
+	rv.bodies = append(rv.bodies, p)
+	h := r.Header.Clone()
+	h.Set("X-Test-Valid", "false")
+	if r.Header.Get(HeaderSignature) == Sign("s3cret", body) {
+		h.Set("X-Test-Valid", "true")
+	}
+	rv.headers = append(rv.headers, h)
+	w.WriteHeader(rv.status)
+}
+
+func openDB(t *testing.T) *store.Store {
+	t.Helper()
+	db, err := store.Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestDeliverSigned(t *testing.T) {
+	rv := &receiver{status: http.StatusOK}
+	srv := httptest.NewServer(rv)
+	defer srv.Close()
+
+	db := openDB(t)
+	d, err := NewDispatcher(db, []Subscription{
+		{Name: "all", URL: srv.URL, Secret: "s3cret"},
+		{Name: "alarms", URL: srv.URL, Types: []string{TypeEvent}, Events: []string{"alarm"}, Scooters: []string{"VIN1"}},
+		{Name: "gps", URL: srv.URL, Types: []string{TypeState}, StatePaths: []string{"gps.latitude"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	d.PublishEvent("VIN1", "alarm", map[string]any{"level": "high"}, now) // all + alarms
+	d.PublishEvent("VIN2", "alarm", nil, now)                             // all
+	d.PublishState("VIN1", "delta", map[string]any{"battery:0": map[string]any{"charge": "50"}}, now)
+	d.PublishState("VIN1", "delta", map[string]any{"gps": map[string]any{"latitude": "52.5"}}, now) // all + gps
+	d.PublishConnection("VIN1", "offline", now)
+	d.PublishCommandResult("VIN1", "req-1", "success", map[string]any{"ok": true}, "")
+
+	if n, err := d.Deliver(time.Now()); err != nil || n != 8 {
+		t.Fatalf("Deliver = %d, %v; want 8", n, err)
+	}
+	if len(rv.bodies) != 8 {
+		t.Fatalf("received %d requests, want 8", len(rv.bodies))
+	}
+	counts, _, err := db.WebhookStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts["all"][store.WebhookDelivered] != 6 || counts["alarms"][store.WebhookDelivered] != 1 || counts["gps"][store.WebhookDelivered] != 1 {
+		t.Errorf("counts = %v", counts)
+	}
+
+	for i, h := range rv.headers {
+		signed := h.Get(HeaderSignature) != ""
+		if signed && h.Get("X-Test-Valid") != "true" {
+			t.Errorf("request %d: bad signature", i)
+		}
+		if h.Get(HeaderEvent) != rv.bodies[i].Type || h.Get(HeaderDelivery) == "" {
+			t.Errorf("request %d: headers %v", i, h)
+		}
+	}
+	// Subscriptions are delivered one after the other; "all" comes first.
+	last := rv.bodies[5]
+	if last.Type != TypeCommand || last.Data["request_id"] != "req-1" || last.ScooterID != "VIN1" {
+		t.Errorf("command payload = %+v", last)
+	}
+}
+
+func TestRetryAndRestart(t *testing.T) {
+	rv := &receiver{status: http.StatusServiceUnavailable}
+	srv := httptest.NewServer(rv)
+	defer srv.Close()
+
+	db := openDB(t)
+	subs := []Subscription{{Name: "flaky", URL: srv.URL}}
+	d, err := NewDispatcher(db, subs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.PublishConnection("VIN1", "online", time.Now())
+
+	if n, _ := d.Deliver(time.Now()); n != 1 {
+		t.Fatalf("first attempt: %d", n)
+	}
+	if n, _ := d.Deliver(time.Now()); n != 0 {
+		t.Fatal("retried before backoff elapsed")
+	}
+	_, lastErrors, _ := db.WebhookStats()
+	if lastErrors["flaky"] != "HTTP 503" {
+		t.Errorf("last error = %q", lastErrors["flaky"])
+	}
+
+	// A new dispatcher over the same outbox delivers once the endpoint recovers.
+	rv.mu.Lock()
+	rv.status = http.StatusNoContent
+	rv.mu.Unlock()
+	d, _ = NewDispatcher(db, subs)
+	if n, _ := d.Deliver(time.Now().Add(Backoff(1))); n != 1 {
+		t.Fatal("not retried after backoff")
+	}
+	counts, _, _ := db.WebhookStats()
+	if counts["flaky"][store.WebhookDelivered] != 1 || counts["flaky"][store.WebhookPending] != 0 {
+		t.Errorf("counts = %v", counts)
+	}
+}
+
+func TestGiveUp(t *testing.T) {
+	rv := &receiver{status: http.StatusInternalServerError}
+	srv := httptest.NewServer(rv)
+	defer srv.Close()
+
+	db := openDB(t)
+	d, _ := NewDispatcher(db, []Subscription{{Name: "down", URL: srv.URL}})
+	d.PublishConnection("VIN1", "online", time.Now())
+	at := time.Now()
+	for i := 0; i < maxAttempts; i++ {
+		if n, _ := d.Deliver(at); n != 1 {
+			t.Fatalf("attempt %d not made", i+1)
+		}
+		at = at.Add(2 * maxBackoff)
+	}
+	if n, _ := d.Deliver(at); n != 0 {
+		t.Error("delivery retried after max attempts")
+	}
+	counts, _, _ := db.WebhookStats()
+	if counts["down"][store.WebhookFailed] != 1 {
+		t.Errorf("counts = %v", counts)
+	}
+}
+
+func TestSubscriptionQueues(t *testing.T) {
+	down := httptest.NewServer(&receiver{status: http.StatusInternalServerError})
+	defer down.Close()
+	up := &receiver{status: http.StatusOK}
+	srv := httptest.NewServer(up)
+	defer srv.Close()
+
+	db := openDB(t)
+	d, _ := NewDispatcher(db, []Subscription{{Name: "down", URL: down.URL}, {Name: "up", URL: srv.URL}})
+	d.PublishConnection("VIN1", "online", time.Now())
+
+	// Each subscription's worker only sees its own queue.
+	if n, err := d.deliver(&d.subs[1], time.Now()); err != nil || n != 1 || len(up.bodies) != 1 {
+		t.Fatalf("deliver up = %d, %v; %d received", n, err, len(up.bodies))
+	}
+	counts, _, _ := db.WebhookStats()
+	if counts["down"][store.WebhookPending] != 1 {
+		t.Errorf("counts = %v", counts)
+	}
+
+	// Pending deliveries of a subscription removed from the config fail.
+	d, _ = NewDispatcher(db, []Subscription{{Name: "up", URL: srv.URL}})
+	d.abandonRemoved()
+	counts, lastErrors, _ := db.WebhookStats()
+	if counts["down"][store.WebhookFailed] != 1 || lastErrors["down"] != "subscription no longer configured" {
+		t.Errorf("counts = %v, errors = %v", counts, lastErrors)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	want := []time.Duration{10 * time.Second, 20 * time.Second, 40 * time.Second}
+	for i, w := range want {
+		if got := Backoff(i + 1); got != w {
+			t.Errorf("Backoff(%d) = %v, want %v", i+1, got, w)
+		}
+	}
+	if got := Backoff(30); got != maxBackoff {
+		t.Errorf("Backoff(30) = %v, want cap %v", got, maxBackoff)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, s := range []Subscription{
+		{URL: "https://example.com"},
+		{Name: "x", URL: "ftp://example.com"},
+		{Name: "x", URL: "https://example.com", Types: []string{"alert"}},
+	} {
+		if err := s.Validate(); err == nil {
+			t.Errorf("%+v: expected error", s)
+		}
+	}
+	if _, err := NewDispatcher(nil, []Subscription{{Name: "a", URL: "http://x"}, {Name: "a", URL: "http://y"}}); err == nil {
+		t.Error("duplicate names accepted")
+	}
+}
@@ -1,15 +1,16 @@
 // Entry point: wire the UI and connect.
 
-import { getApiKey, apiRequest } from "./api.js";
+import { getApiKey } from "./api.js";
 import { store } from "./store.js";
 import { showStatus } from "./format.js";
 import { connectWebSocket } from "./ws.js";
 import { openDetail, back } from "./scooters.js";
 import { sendCommand } from "./commands.js";
 import { openHistory, reloadHistory } from "./history.js";
-import { openScootersDialog, addScooter, deleteScooter, copyToken } from "./registry.js";
+import { openScootersDialog, addScooter, editScooter, rotateToken, deleteScooter, copyToken } from "./registry.js";
+import { openBulkDialog, onBulkCommandChanged, sendBulk } from "./bulk.js";
 import { dismissEvent, clearAllEvents } from "./events.js";
-import { openAuthDialog, setAuthError, doLogin, saveKey, clearKey } from "./auth.js";
+import { openAuthDialog, setAuthError, doLogin, saveKey, clearKey, loadMe } from "./auth.js";
 
 function refreshAll() {
   store.scooters.forEach((s) => sendCommand(s.identifier, "get_state"));
@@ -17,7 +18,7 @@ function refreshAll() {
 
 async function verifyAndConnect() {
   try {
-    await apiRequest("/api/registry"); // cheap authenticated probe
+    await loadMe(); // also probes the credentials
     setAuthError(false);
     connectWebSocket();
   } catch (e) {
@@ -34,6 +35,7 @@ async function verifyAndConnect() {
 function wire() {
   // Header buttons.
   document.getElementById("scootersBtn").addEventListener("click", openScootersDialog);
+  document.getElementById("bulkBtn").addEventListener("click", openBulkDialog);
   document.getElementById("apiKeyBtn").addEventListener("click", openAuthDialog);
   document.getElementById("refreshBtn").addEventListener("click", refreshAll);
 
@@ -43,15 +45,17 @@ function wire() {
   document.getElementById("saveKeyBtn").addEventListener("click", saveKey);
   document.getElementById("clearKeyBtn").addEventListener("click", clearKey);
 
-  // Manage-scooters + history dialogs.
+  // Manage-scooters, bulk command + history dialogs.
   document.getElementById("addScooterBtn").addEventListener("click", addScooter);
+  document.getElementById("bulkCommand").addEventListener("change", onBulkCommandChanged);
+  document.getElementById("bulkSendBtn").addEventListener("click", sendBulk);
   document.getElementById("historyReloadBtn").addEventListener("click", reloadHistory);
 
   // Dialog close buttons and overlay click-to-close.
   document.querySelectorAll("[data-close]").forEach((b) =>
     b.addEventListener("click", () => document.getElementById(b.dataset.close).classList.remove("show"))
   );
-  ["apiKeyDialog", "scootersDialog", "historyDialog"].forEach((id) => {
+  ["apiKeyDialog", "scootersDialog", "bulkDialog", "historyDialog"].forEach((id) => {
     const o = document.getElementById(id);
     o.addEventListener("click", (e) => {
       if (e.target === o) o.classList.remove("show");
@@ -90,6 +94,12 @@ function wire() {
       case "clear-events":
         clearAllEvents(scooter);
         break;
+      case "edit-scooter":
+        editScooter(scooter);
+        break;
+      case "rotate-token":
+        rotateToken(scooter);
+        break;
       case "delete-scooter":
         deleteScooter(scooter);
         break;
@@ -1,6 +1,7 @@
 // Authentication dialog: username/password login and API-key entry.
 
-import { setApiKey, clearApiKey, login } from "./api.js";
+import { setApiKey, clearApiKey, login, apiRequest } from "./api.js";
+import { store } from "./store.js";
 import { showStatus } from "./format.js";
 import { connectWebSocket, closeWebSocket } from "./ws.js";
 
@@ -16,6 +17,17 @@ export function setAuthError(on) {
   document.getElementById("apiKeyBtn").classList.toggle("error", !!on);
 }
 
+// loadMe fetches the signed-in principal and hides header actions it may not
+// use: managing scooters needs a fleet-wide admin, bulk commands a fleet-wide
+// operator.
+export async function loadMe() {
+  store.me = await apiRequest("/api/me");
+  const rank = { viewer: 1, operator: 2, admin: 3 }[store.me.role] || 0;
+  const fleet = !(store.me.scooters || []).length && !(store.me.groups || []).length;
+  document.getElementById("scootersBtn").style.display = fleet && rank >= 3 ? "" : "none";
+  document.getElementById("bulkBtn").style.display = fleet && rank >= 2 ? "" : "none";
+}
+
 export async function doLogin() {
   const u = document.getElementById("loginUser").value.trim();
   const p = document.getElementById("loginPass").value;
@@ -28,6 +40,7 @@ export async function doLogin() {
     document.getElementById("loginPass").value = "";
     showStatus("apiKeyStatus", `Logged in as ${data.username}`, "success");
     setAuthError(false);
+    await loadMe().catch(() => {});
     setTimeout(() => {
       closeAuthDialog();
       connectWebSocket();
@@ -37,7 +50,7 @@ export async function doLogin() {
   }
 }
 
-export function saveKey() {
+export async function saveKey() {
   const v = document.getElementById("apiKeyInput").value.trim();
   if (!v) {
     showStatus("apiKeyStatus", "Enter an API key", "error");
@@ -46,6 +59,7 @@ export function saveKey() {
   setApiKey(v);
   showStatus("apiKeyStatus", "API key saved", "success");
   setAuthError(false);
+  await loadMe().catch(() => {});
   setTimeout(() => {
     closeAuthDialog();
     connectWebSocket();
@@ -0,0 +1,113 @@
+// Bulk commands: send one command to many scooters and follow the batch's
+// progress, which arrives as batch_progress messages over /ws/web.
+
+import { apiRequest } from "./api.js";
+import { escapeHtml, showStatus } from "./format.js";
+import { loadCatalog, getCatalog, defaultParams } from "./commands.js";
+
+// Batches shown in the dialog, by id.
+const batches = new Map();
+
+function selectedCommand() {
+  const name = document.getElementById("bulkCommand").value;
+  return getCatalog().find((c) => c.name === name);
+}
+
+export async function openBulkDialog() {
+  document.getElementById("bulkDialog").classList.add("show");
+  await loadCatalog();
+  const sel = document.getElementById("bulkCommand");
+  if (!sel.options.length) {
+    sel.innerHTML = getCatalog()
+      .map((c) => `<option value="${escapeHtml(c.name)}">${escapeHtml(c.group)} · ${escapeHtml(c.label)}</option>`)
+      .join("");
+    onBulkCommandChanged();
+  }
+  try {
+    const res = await apiRequest("/api/batches?limit=10");
+    for (const b of res.batches || []) batches.set(b.id, b);
+    renderBatches();
+  } catch (_) {}
+}
+
+// onBulkCommandChanged fills in the command's default params and enables
+// queueing only where the catalog allows it.
+export function onBulkCommandChanged() {
+  const c = selectedCommand();
+  const params = c ? defaultParams(c.params) : null;
+  document.getElementById("bulkParams").value = params ? JSON.stringify(params) : "";
+  const queue = document.getElementById("bulkQueue");
+  queue.disabled = !(c && c.queueable);
+  if (queue.disabled) queue.checked = false;
+}
+
+function listValue(id) {
+  return document.getElementById(id).value.split(",").map((s) => s.trim()).filter(Boolean);
+}
+
+export async function sendBulk() {
+  const c = selectedCommand();
+  if (!c) return;
+  let params = {};
+  const raw = document.getElementById("bulkParams").value.trim();
+  if (raw) {
+    try {
+      params = JSON.parse(raw);
+    } catch (_) {
+      showStatus("bulkStatus", "Params must be a JSON object", "error");
+      return;
+    }
+  }
+  const body = {
+    command: c.name,
+    params,
+    scooters: listValue("bulkScooters"),
+    groups: listValue("bulkGroups"),
+    tags: listValue("bulkTags"),
+    condition: document.getElementById("bulkCondition").value.trim(),
+    queue: document.getElementById("bulkQueue").checked,
+  };
+  // Without a list the batch goes to the whole fleet, which the server only
+  // accepts when asked for explicitly.
+  body.all = !body.scooters.length && !body.groups.length && !body.tags.length;
+  const what = body.all && !body.condition ? "every scooter" : "the selected scooters";
+  if (!confirm(`Send ${c.label} to ${what}?${c.confirm ? `\n\n${c.confirm}` : ""}`)) return;
+  try {
+    const b = await apiRequest("/api/batches", { method: "POST", body: JSON.stringify(body) });
+    showStatus("bulkStatus", `Sending ${c.label} to ${b.total} scooter${b.total === 1 ? "" : "s"}`, "success");
+    updateBatch(b);
+  } catch (e) {
+    showStatus("bulkStatus", e.message, "error");
+  }
+}
+
+// updateBatch applies a batch_progress message (or a created batch).
+export function updateBatch(b) {
+  batches.set(b.id, b);
+  renderBatches();
+}
+
+function batchHTML(b) {
+  const counts = b.counts || {};
+  const inProgress = ["pending", "sent", "running", "queued"].reduce((n, st) => n + (counts[st] || 0), 0);
+  const pct = b.total ? Math.round(((b.total - inProgress) / b.total) * 100) : 100;
+  const failed = (counts.failed || 0) + (counts.timed_out || 0) + (counts.expired || 0);
+  const parts = Object.entries(counts).map(([st, n]) => `${n} ${st.replace("_", " ")}`).join(" · ");
+  return `<div class="batch-row">
+    <div class="batch-head">
+      <span class="rid">#${b.id} ${escapeHtml(b.command)}</span>
+      <span class="rmeta">${b.total} scooter${b.total === 1 ? "" : "s"}${b.done ? " · done" : ""}</span>
+    </div>
+    <div class="meter"><div class="meter-fill ${failed ? "med" : ""}" style="width:${pct}%"></div></div>
+    <div class="rmeta">${escapeHtml(parts)}</div>
+  </div>`;
+}
+
+function renderBatches() {
+  const el = document.getElementById("bulkBatches");
+  if (!el) return;
+  const list = [...batches.values()].sort((a, b) => b.id - a.id).slice(0, 10);
+  el.innerHTML = list.length
+    ? `<p class="section-label" style="margin-top:14px">Recent batches</p>${list.map(batchHTML).join("")}`
+    : "";
+}
@@ -1,92 +1,82 @@
-// Command definitions (data-driven) plus send + response polling.
+// Command buttons rendered from the server's command catalog, plus send +
+// response polling.
 
 import { apiRequest } from "./api.js";
 import { escapeHtml } from "./format.js";
 
-// Quick actions shown inline on every card.
-const QUICK = [
-  { label: "Lock", cmd: "lock", variant: "primary" },
-  { label: "Unlock", cmd: "unlock" },
-  { label: "Open Seatbox", cmd: "open_seatbox" },
-  { label: "Refresh", cmd: "get_state" },
-  { label: "History", action: "history" },
-];
+// Entries of GET /api/commands/catalog, in display order.
+let catalog = [];
+let catalogLoad = null;
 
-// Collapsible command groups.
-const GROUPS = [
-  {
-    label: "Access",
-    commands: [
-      { label: "Lock + Hibernate", cmd: "lock_hibernate" },
-      { label: "Force Lock", cmd: "force_lock", variant: "danger" },
-      { label: "Handlebar Lock", cmd: "handlebar_lock" },
-      { label: "Handlebar Unlock", cmd: "handlebar_unlock" },
-    ],
-  },
-  {
-    label: "Lights",
-    commands: [
-      { label: "Blinker ←", cmd: "blinker_left" },
-      { label: "Blinker →", cmd: "blinker_right" },
-      { label: "Blinker ⚠", cmd: "blinker_both" },
-      { label: "Blinker Off", cmd: "blinker_off" },
-      { label: "Dashboard On", cmd: "dashboard_on" },
-      { label: "Dashboard Off", cmd: "dashboard_off" },
-    ],
-  },
-  {
-    label: "Alarm",
-    commands: [
-      { label: "Arm", cmd: "alarm_arm" },
-      { label: "Disarm", cmd: "alarm_disarm" },
-      { label: "Stop", cmd: "alarm_stop" },
-      { label: "Enable", cmd: "alarm_enable" },
-      { label: "Disable", cmd: "alarm_disable" },
-    ],
-  },
-  {
-    label: "Power",
-    commands: [
-      { label: "Hibernate", cmd: "hibernate", confirm: "Put scooter into hibernate mode?" },
-      { label: "Hibernate (manual)", cmd: "hibernate_manual" },
-      { label: "Reboot", cmd: "reboot", variant: "danger", confirm: "Reboot the scooter?" },
-      { label: "Engine On", cmd: "engine_on" },
-      { label: "Engine Off", cmd: "engine_off" },
-    ],
-  },
-  {
-    label: "Diagnostics",
-    commands: [
-      { label: "Get State", cmd: "get_state" },
-      { label: "Ping", cmd: "ping" },
-      // uplink-service honk reads params.duration (ms).
-      { label: "Honk 500ms", cmd: "honk", params: { duration: 500 } },
-    ],
-  },
-];
+// loadCatalog fetches the catalog once and redraws any controls rendered
+// before it arrived.
+export function loadCatalog() {
+  if (!catalogLoad) {
+    catalogLoad = apiRequest("/api/commands/catalog")
+      .then((res) => {
+        catalog = res.commands || [];
+        document.querySelectorAll(".cmd-controls").forEach((el) => {
+          el.innerHTML = controlsHTML(el.dataset.commandsFor);
+        });
+      })
+      .catch(() => {
+        catalogLoad = null; // retry on next render
+      });
+  }
+  return catalogLoad;
+}
+
+export function getCatalog() {
+  return catalog;
+}
+
+// defaultParams collects the schema defaults, e.g. honk's duration.
+export function defaultParams(schema) {
+  const params = {};
+  for (const [name, prop] of Object.entries((schema && schema.properties) || {})) {
+    if (prop.default !== undefined) params[name] = prop.default;
+  }
+  return Object.keys(params).length ? params : null;
+}
 
 function btnHTML(scooterId, c) {
+  const params = c.name ? defaultParams(c.params) : null;
   const attrs = [
     `class="cmd-btn"`,
-    c.variant ? `data-variant="${c.variant}"` : "",
+    c.variant ? `data-variant="${escapeHtml(c.variant)}"` : "",
     `data-scooter="${escapeHtml(scooterId)}"`,
-    c.action ? `data-action="${c.action}"` : `data-cmd="${c.cmd}"`,
-    c.params ? `data-params='${escapeHtml(JSON.stringify(c.params))}'` : "",
+    c.action ? `data-action="${c.action}"` : `data-cmd="${escapeHtml(c.name)}"`,
+    params ? `data-params="${escapeHtml(JSON.stringify(params))}"` : "",
     c.confirm ? `data-confirm="${escapeHtml(c.confirm)}"` : "",
+    c.description ? `title="${escapeHtml(c.description)}"` : "",
   ].filter(Boolean).join(" ");
   return `<button ${attrs}>${escapeHtml(c.label)}</button>`;
 }
 
-export function renderCommandsHTML(scooterId) {
-  const quick = QUICK.map((c) => btnHTML(scooterId, c)).join("");
-  const groups = GROUPS.map((g) => `
+function controlsHTML(scooterId) {
+  // Quick actions shown inline, then collapsible groups in catalog order.
+  const quick = catalog.filter((c) => c.quick)
+    .concat([{ label: "History", action: "history" }])
+    .map((c) => btnHTML(scooterId, c)).join("");
+  const groups = new Map();
+  for (const c of catalog) {
+    if (!groups.has(c.group)) groups.set(c.group, []);
+    groups.get(c.group).push(c);
+  }
+  const groupsHTML = [...groups].map(([label, cmds]) => `
     <details class="cmd-group">
-      <summary>${escapeHtml(g.label)}</summary>
-      <div class="cmd-buttons">${g.commands.map((c) => btnHTML(scooterId, c)).join("")}</div>
+      <summary>${escapeHtml(label)}</summary>
+      <div class="cmd-buttons">${cmds.map((c) => btnHTML(scooterId, c)).join("")}</div>
     </details>`).join("");
   return `
     <div class="cmd-quick">${quick}</div>
-    <div class="cmd-groups">${groups}</div>
+    <div class="cmd-groups">${groupsHTML}</div>`;
+}
+
+export function renderCommandsHTML(scooterId) {
+  if (!catalog.length) loadCatalog();
+  return `
+    <div class="cmd-controls" data-commands-for="${escapeHtml(scooterId)}">${controlsHTML(scooterId)}</div>
     <div id="response-${escapeHtml(scooterId)}" class="cmd-response hidden"></div>`;
 }
 
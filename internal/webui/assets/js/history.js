@@ -3,7 +3,7 @@
 // accent, flat 1px axes, with a hover readout.
 
 import { apiRequest } from "./api.js";
-import { escapeHtml, showStatus } from "./format.js";
+import { escapeHtml, formatDuration, showStatus } from "./format.js";
 
 let currentScooter = null;
 let seq = 0;
@@ -13,6 +13,7 @@ export function openHistory(id) {
   currentScooter = id;
   document.getElementById("historyTitle").textContent = `History — ${id}`;
   document.getElementById("historyCharts").innerHTML = "";
+  document.getElementById("historyTrips").innerHTML = "";
   document.getElementById("historyDialog").classList.add("show");
   loadHistory(id);
 }
@@ -29,15 +30,56 @@ async function loadHistory(id) {
   showStatus("historyStatus", "Loading…", "info");
   try {
     const data = await apiRequest(
-      `/api/scooters/${encodeURIComponent(id)}/history?from=${from.toISOString()}&to=${to.toISOString()}&limit=2000`
+      `/api/scooters/${encodeURIComponent(id)}/history?from=${from.toISOString()}&to=${to.toISOString()}&limit=2000&resolution=auto`
     );
     renderCharts(data.history || []);
-    showStatus("historyStatus", `${data.count || 0} data points`, "info");
+    const res = data.resolution && data.resolution !== "raw" ? ` (${data.resolution} averages)` : "";
+    showStatus("historyStatus", `${data.count || 0} data points${res}`, "info");
   } catch (e) {
     showStatus("historyStatus", e.message, "error");
   }
 }
 
+async function loadTrips(id, from, to) {
+  const container = document.getElementById("historyTrips");
+  try {
+    const data = await apiRequest(
+      `/api/scooters/${encodeURIComponent(id)}/trips?from=${from.toISOString()}&to=${to.toISOString()}`
+    );
+    renderTrips(data.trips || []);
+  } catch (e) {
+    container.innerHTML = `<p class="muted">Trips unavailable: ${escapeHtml(e.message)}</p>`;
+  }
+}
+
+function renderTrips(trips) {
+  const container = document.getElementById("historyTrips");
+  if (!trips.length) {
+    container.innerHTML = '<h4 class="trips-title">Trips</h4><p class="muted">No trips in this range.</p>';
+    return;
+  }
+  const dt = (ts) => new Date(ts).toLocaleString([], { month: "short", day: "numeric", hour: "2-digit", minute: "2-digit" });
+  const rows = trips
+    .map((t) => {
+      const secs = (new Date(t.end) - new Date(t.start)) / 1000;
+      const energy = t.energy_used != null ? `${t.energy_used.toFixed(0)}%` : "–";
+      const status = t.status === "open" ? " (ongoing)" : "";
+      return `<tr>
+        <td>${escapeHtml(dt(t.start))}${status}</td>
+        <td>${formatDuration(secs)}</td>
+        <td>${(t.distance_m / 1000).toFixed(2)} km</td>
+        <td>${t.avg_speed.toFixed(0)} / ${t.max_speed.toFixed(0)} km/h</td>
+        <td>${energy}</td>
+      </tr>`;
+    })
+    .join("");
+  container.innerHTML = `<h4 class="trips-title">Trips</h4>
+    <table class="trips-table">
+      <thead><tr><th>Start</th><th>Duration</th><th>Distance</th><th>Avg / max</th><th>Battery</th></tr></thead>
+      <tbody>${rows}</tbody>
+    </table>`;
+}
+
 function fieldNum(data, hash, field) {
   try {
     const f = parseFloat(data[hash][field]);
@@ -81,7 +123,10 @@ function chartSVG(title, xs, ys) {
 
   const scaled = valid.map((p) => ({ px: sx(p[0]), py: sy(p[1]), vx: p[0], vy: p[1] }));
   const d = scaled.map((p, i) => `${i ? "L" : "M"}${p.px.toFixed(1)} ${p.py.toFixed(1)}`).join(" ");
-  const tf = (ms) => new Date(ms).toLocaleTimeString([], { hour: "2-digit", minute: "2-digit" });
+  const tf = (ms) =>
+    xmax - xmin > 86400000
+      ? new Date(ms).toLocaleDateString([], { month: "short", day: "numeric" })
+      : new Date(ms).toLocaleTimeString([], { hour: "2-digit", minute: "2-digit" });
   const id = `chart-${seq++}`;
   charts.set(id, { pts: scaled, ymin, ymax });
 
@@ -1,4 +1,5 @@
-// Manage-scooters dialog: list, add (issue token), delete.
+// Manage-scooters dialog: list, add (issue token), edit groups/tags, rotate
+// token, delete.
 
 import { apiRequest } from "./api.js";
 import { escapeHtml, showStatus } from "./format.js";
@@ -19,12 +20,16 @@ export async function loadRegistry() {
   }
 }
 
+// Last listed scooters, for prefilling the edit prompts.
+let registry = [];
+
 function renderRegistry(list) {
   const el = document.getElementById("registryList");
   if (!list.length) {
     el.innerHTML = '<p class="muted">No scooters registered yet.</p>';
     return;
   }
+  registry = list;
   el.innerHTML = list
     .map(
       (s) => `<div class="registry-row">
@@ -32,8 +37,14 @@ function renderRegistry(list) {
         <span class="rid">${escapeHtml(s.identifier)}</span>
         ${s.name ? `<span class="rmeta">${escapeHtml(s.name)}</span>` : ""}
         <span class="rmeta">${s.connected ? "● online" : "○ offline"}</span>
+        ${(s.groups || []).map((g) => `<span class="rmeta">${escapeHtml(g)}</span>`).join("")}
+        ${(s.tags || []).map((t) => `<span class="rmeta">#${escapeHtml(t)}</span>`).join("")}
+      </span>
+      <span class="registry-actions">
+        <button class="cmd-btn" data-action="edit-scooter" data-scooter="${escapeHtml(s.identifier)}">Edit</button>
+        <button class="cmd-btn" data-action="rotate-token" data-scooter="${escapeHtml(s.identifier)}">Rotate token</button>
+        <button class="cmd-btn" data-action="delete-scooter" data-scooter="${escapeHtml(s.identifier)}">Remove</button>
       </span>
-      <button class="cmd-btn" data-action="delete-scooter" data-scooter="${escapeHtml(s.identifier)}">Remove</button>
     </div>`
     )
     .join("");
@@ -61,16 +72,52 @@ export async function addScooter() {
   }
 }
 
-function showNewScooterResult(res) {
+function showNewScooterResult(res, note = "") {
   const cfg = `uplink:\n  server_url: "ws://${window.location.host}/ws"\nscooter:\n  identifier: "${res.identifier}"\n  token: "${res.token}"`;
   const el = document.getElementById("newScooterResult");
   el.classList.remove("hidden");
   el.innerHTML = `
     <p class="section-label" style="margin-top:10px">Client config — token shown once, copy it now</p>
+    ${note ? `<p class="muted">${escapeHtml(note)}</p>` : ""}
     <div class="code-block" id="newTokenBlock">${escapeHtml(cfg)}</div>
     <button class="cmd-btn" data-action="copy-token">Copy client config</button>`;
 }
 
+function splitLabels(s) {
+  return s.split(",").map((l) => l.trim()).filter(Boolean);
+}
+
+export async function editScooter(id) {
+  const s = registry.find((r) => r.identifier === id) || {};
+  const groups = prompt(`Groups of ${id} (comma-separated)`, (s.groups || []).join(", "));
+  if (groups === null) return;
+  const tags = prompt(`Tags of ${id} (comma-separated)`, (s.tags || []).join(", "));
+  if (tags === null) return;
+  try {
+    await apiRequest(`/api/registry/${encodeURIComponent(id)}`, {
+      method: "PATCH",
+      body: JSON.stringify({ groups: splitLabels(groups), tags: splitLabels(tags) }),
+    });
+    loadRegistry();
+  } catch (e) {
+    showStatus("addScooterStatus", e.message, "error");
+  }
+}
+
+// rotateToken issues a new token; the scooter gets it over config_update if
+// online, and the old one keeps working for the server's grace period.
+export async function rotateToken(id) {
+  if (!confirm(`Issue a new token for ${id}? The current one keeps working for a grace period.`)) return;
+  try {
+    const res = await apiRequest(`/api/registry/${encodeURIComponent(id)}/rotate`, { method: "POST" });
+    const until = res.previous_valid_until ? ` The old token works until ${new Date(res.previous_valid_until).toLocaleString()}.` : "";
+    const sent = res.pushed ? "Pushed to the scooter (config updates are not acknowledged)." : `Not sent (${res.push_error}); update the scooter's config by hand.`;
+    showNewScooterResult(res, `${sent}${until}`);
+  } catch (e) {
+    showStatus("addScooterStatus", e.message, "error");
+  }
+}
+
 export async function deleteScooter(id) {
   if (!confirm(`Remove scooter ${id}? Its token will stop working.`)) return;
   try {
@@ -5,6 +5,7 @@ export const store = {
   states: {},   // scooterId -> merged component state map
   view: "dashboard", // "dashboard" | "detail"
   currentScooter: null, // identifier shown in detail view
+  me: null, // signed-in principal: name, role and scope
 };
 
 export function upsertScooter(info) {
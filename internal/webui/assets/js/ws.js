@@ -4,6 +4,7 @@ import { getApiKey } from "./api.js";
 import { store, upsertScooter } from "./store.js";
 import { onScootersChanged, setScooterOnline, updateConnectionStats, applyStateUpdate } from "./scooters.js";
 import { addEventToDisplay } from "./events.js";
+import { updateBatch } from "./bulk.js";
 
 let ws = null;
 let reconnectDelay = 1000;
@@ -68,5 +69,8 @@ function handleMessage(msg) {
     case "scooter_offline":
       setScooterOnline(msg.scooter_id, false);
       break;
+    case "batch_progress":
+      if (msg.batch) updateBatch(msg.batch);
+      break;
   }
 }